@@ -6,6 +6,7 @@
 #	- Package builds
 
 $(call create_folder,$(RPMS_DIR))
+$(call create_folder,$(VARIANT_RPMS_DIR))
 $(call create_folder,$(CACHED_RPMS_DIR))
 $(call create_folder,$(PKGBUILD_DIR))
 $(call create_folder,$(CHROOT_DIR))
@@ -28,14 +29,17 @@ validate-pkggen-config = $(STATUS_FLAGS_DIR)/validate-image-config-pkggen.flag
 
 # Outputs
 specs_file        = $(PKGBUILD_DIR)/specs.json
+specs_cache_file  = $(PKGBUILD_DIR)/specs_cache.json
 graph_file        = $(PKGBUILD_DIR)/graph.dot
 optimized_file    = $(PKGBUILD_DIR)/scrubbed_graph.dot
 cached_file       = $(PKGBUILD_DIR)/cached_graph.dot
 workplan          = $(PKGBUILD_DIR)/workplan.mk
+built_file        = $(PKGBUILD_DIR)/built_graph.dot
 
 logging_command = --log-file=$(LOGS_DIR)/pkggen/workplan/$(notdir $@).log --log-level=$(LOG_LEVEL)
 $(call create_folder,$(LOGS_DIR)/pkggen/workplan)
 $(call create_folder,$(LOGS_DIR)/pkggen/rpmbuilding)
+$(call create_folder,$(LOGS_DIR)/pkggen/results)
 
 .PHONY: workplan clean-workplan clean-cache graph-cache
 workplan: $(workplan)
@@ -51,12 +55,18 @@ clean-cache:
 	$(SCRIPTS_DIR)/safeunmount.sh "$(cache_working_dir)" && \
 	rm -rf $(cache_working_dir)
 
+# JSON file listing the conditional build variants (such as bootstrap builds) specreader evaluates for each SPEC.
+# Grapher uses them to break dependency cycles. Disabled if empty.
+PACKAGE_BUILD_BCOND_MATRIX ?=
+
 # Parse all specs in $(BUILD_SPECS_DIR) and generate a specs.json file encoding all dependency information
-$(specs_file): $(BUILD_SPECS_DIR) $(build_specs) $(build_spec_dirs) $(go-specreader)
+$(specs_file): $(BUILD_SPECS_DIR) $(build_specs) $(build_spec_dirs) $(go-specreader) $(depend_PACKAGE_BUILD_BCOND_MATRIX) $(PACKAGE_BUILD_BCOND_MATRIX)
 	$(go-specreader) \
 		--dir $(BUILD_SPECS_DIR) \
 		--srpm-dir $(BUILD_SRPMS_DIR) \
 		--dist-tag $(DIST_TAG) \
+		--cache-file $(specs_cache_file) \
+		$(if $(PACKAGE_BUILD_BCOND_MATRIX),--bcond-matrix $(PACKAGE_BUILD_BCOND_MATRIX)) \
 		$(logging_command) \
 		--output $@
 
@@ -146,6 +156,30 @@ $(workplan): $(cached_file) $(go-unravel) $(depend_STOP_ON_PKG_FAIL)
 
 ######## PACKAGE BUILD ########
 
+# Directory pkgworker keeps an extracted copy of the worker chroot in. Builds then run on an overlay of it instead of
+# extracting the worker chroot for every package. Disabled if empty.
+CHROOT_POOL_DIR ?=
+
+# Limits for every rpmbuild run by pkgworker: a wall-clock timeout such as 4h, a memory limit such as 16GB and a number of
+# CPUs such as 2.5. Memory and CPU limits require cgroup v2. PACKAGE_BUILD_LIMITS_FILE may override them for individual
+# SPECs. No limit is applied if empty. The memory and CPU limits are enforced in PACKAGE_BUILD_CGROUP_PARENT, a cgroup
+# relative to the root of the cgroup v2 hierarchy which has no processes and is delegated the memory and cpu controllers.
+# It is required as long as the build itself runs in the same cgroup as the package builds.
+PACKAGE_BUILD_TIMEOUT ?=
+PACKAGE_BUILD_MEMORY_LIMIT ?=
+PACKAGE_BUILD_CPU_QUOTA ?=
+PACKAGE_BUILD_CGROUP_PARENT ?=
+PACKAGE_BUILD_LIMITS_FILE ?=
+
+# Build packages in a network namespace with only a loopback interface, so SPECs which reach the network fail to build.
+PACKAGE_BUILD_ISOLATE_NETWORK ?= n
+
+# Build packages with the scheduler tool, which walks the package graph itself, instead of the makefile workplan. The
+# graph with every built package marked as up-to-date is saved to $(built_file). PACKAGE_BUILD_WORKERS sets the number
+# of packages the scheduler builds at once, the scheduler's default is used if empty.
+USE_SCHEDULER ?= n
+PACKAGE_BUILD_WORKERS ?=
+
 pkggen_archive	= $(OUT_DIR)/rpms.tar.gz
 srpms_archive  	= $(OUT_DIR)/srpms.tar.gz
 
@@ -157,12 +191,16 @@ build-packages: $(RPMS_DIR)
 clean: clean-build-packages clean-compress-rpms clean-compress-srpms
 clean-build-packages:
 	rm -rf $(RPMS_DIR)
+	rm -rf $(VARIANT_RPMS_DIR)
 	rm -rf $(LOGS_DIR)/pkggen/failures.txt
 	rm -rf $(LOGS_DIR)/pkggen/rpmbuilding
+	rm -rf $(LOGS_DIR)/pkggen/results
 	rm -rf $(STATUS_FLAGS_DIR)/build-rpms.flag
+	rm -rf $(built_file)
 	@echo Verifying no mountpoints present in $(CHROOT_DIR)
 	$(SCRIPTS_DIR)/safeunmount.sh "$(CHROOT_DIR)" && \
 	rm -rf $(CHROOT_DIR)
+	$(if $(CHROOT_POOL_DIR),rm -rf $(CHROOT_POOL_DIR))
 clean-compress-rpms:
 	rm -rf $(pkggen_archive)
 clean-compress-srpms:
@@ -177,15 +215,56 @@ $(RPMS_DIR):
 	@touch $@
 endif
 
+ifeq ($(USE_SCHEDULER),y)
+$(STATUS_FLAGS_DIR)/build-rpms.flag: $(cached_file) $(chroot_worker) $(go-pkgworker) $(go-scheduler) $(depend_STOP_ON_PKG_FAIL)
+ifeq ($(RUN_CHECK),y)
+	$(warning Make argument 'RUN_CHECK' set to 'y', running package tests. Will add the 'ca-certificates' package and enable networking for package builds.)
+endif
+	@rm -f $(LOGS_DIR)/pkggen/failures.txt && \
+	$(go-scheduler) \
+		--input=$(cached_file) \
+		--output=$(built_file) \
+		$(if $(PACKAGE_BUILD_WORKERS),--workers=$(PACKAGE_BUILD_WORKERS)) \
+		$(if $(filter y,$(STOP_ON_PKG_FAIL)),--stop-on-failure) \
+		--failures-file=$(LOGS_DIR)/pkggen/failures.txt \
+		--pkgworker=$(go-pkgworker) \
+		--build-logs-dir=$(LOGS_DIR)/pkggen/rpmbuilding \
+		--build-results-dir=$(LOGS_DIR)/pkggen/results \
+		--retry-attempts="$(PACKAGE_BUILD_RETRIES)" \
+		$(if $(filter y,$(RUN_CHECK)),--run-check) \
+		$(if $(filter y,$(PACKAGE_BUILD_ISOLATE_NETWORK)),--isolate-network) \
+		$(if $(PACKAGE_BUILD_TIMEOUT),--timeout=$(PACKAGE_BUILD_TIMEOUT)) \
+		$(if $(PACKAGE_BUILD_MEMORY_LIMIT),--memory-limit=$(PACKAGE_BUILD_MEMORY_LIMIT)) \
+		$(if $(PACKAGE_BUILD_CPU_QUOTA),--cpu-quota=$(PACKAGE_BUILD_CPU_QUOTA)) \
+		$(if $(PACKAGE_BUILD_CGROUP_PARENT),--cgroup-parent=$(PACKAGE_BUILD_CGROUP_PARENT)) \
+		$(if $(PACKAGE_BUILD_LIMITS_FILE),--limits-file=$(PACKAGE_BUILD_LIMITS_FILE)) \
+		--work-dir=$(CHROOT_DIR) \
+		--worker-tar=$(chroot_worker) \
+		$(if $(CHROOT_POOL_DIR),--chroot-pool-dir=$(CHROOT_POOL_DIR)) \
+		--variant-rpms-dir=$(VARIANT_RPMS_DIR) \
+		--repo-file=$(pkggen_local_repo) \
+		--rpms-dir=$(RPMS_DIR) \
+		--srpms-dir=$(SRPMS_DIR) \
+		--cache-dir=$(CACHED_RPMS_DIR)/cache \
+		--rpmmacros-file=$(TOOLCHAIN_MANIFESTS_DIR)/macros.override \
+		--dist-tag=$(DIST_TAG) \
+		--distro-release-version=$(RELEASE_VERSION) \
+		--distro-build-number=$(BUILD_NUMBER) \
+		$(logging_command) || \
+	{ [ ! -f $(LOGS_DIR)/pkggen/failures.txt ] || \
+		$(call print_error,Failed to build: $$(cat $(LOGS_DIR)/pkggen/failures.txt)) ; exit 1 ; } && \
+	touch $@
+else
 $(STATUS_FLAGS_DIR)/build-rpms.flag: $(workplan) $(chroot_worker) $(go-pkgworker)
 ifeq ($(RUN_CHECK),y)
 	$(warning Make argument 'RUN_CHECK' set to 'y', running package tests. Will add the 'ca-certificates' package and enable networking for package builds.)
 endif
 	@rm -f $(LOGS_DIR)/pkggen/failures.txt && \
-	$(MAKE) --silent -f $(workplan) go-pkgworker=$(go-pkgworker) CHROOT_DIR=$(CHROOT_DIR) chroot_worker=$(chroot_worker) SRPMS_DIR=$(SRPMS_DIR) RPMS_DIR=$(RPMS_DIR) pkggen_local_repo=$(pkggen_local_repo) LOGS_DIR=$(LOGS_DIR) TOOLCHAIN_MANIFESTS_DIR=$(TOOLCHAIN_MANIFESTS_DIR) GOAL_PackagesToBuild && \
+	$(MAKE) --silent -f $(workplan) go-pkgworker=$(go-pkgworker) CHROOT_DIR=$(CHROOT_DIR) CHROOT_POOL_DIR=$(CHROOT_POOL_DIR) PACKAGE_BUILD_TIMEOUT=$(PACKAGE_BUILD_TIMEOUT) PACKAGE_BUILD_MEMORY_LIMIT=$(PACKAGE_BUILD_MEMORY_LIMIT) PACKAGE_BUILD_CPU_QUOTA=$(PACKAGE_BUILD_CPU_QUOTA) PACKAGE_BUILD_CGROUP_PARENT=$(PACKAGE_BUILD_CGROUP_PARENT) PACKAGE_BUILD_LIMITS_FILE=$(PACKAGE_BUILD_LIMITS_FILE) PACKAGE_BUILD_ISOLATE_NETWORK=$(PACKAGE_BUILD_ISOLATE_NETWORK) chroot_worker=$(chroot_worker) SRPMS_DIR=$(SRPMS_DIR) RPMS_DIR=$(RPMS_DIR) VARIANT_RPMS_DIR=$(VARIANT_RPMS_DIR) pkggen_local_repo=$(pkggen_local_repo) LOGS_DIR=$(LOGS_DIR) TOOLCHAIN_MANIFESTS_DIR=$(TOOLCHAIN_MANIFESTS_DIR) GOAL_PackagesToBuild && \
 	{ [ ! -f $(LOGS_DIR)/pkggen/failures.txt ] || \
 		$(call print_error,Failed to build: $$(cat $(LOGS_DIR)/pkggen/failures.txt)); } && \
 	touch $@
+endif
 
 # use temp tarball to avoid tar warning "file changed as we read it"
 # that can sporadically occur when tarball is the dir that is compressed
@@ -13,6 +13,19 @@
 # update  - Check signatures and updating any mismatches in the signatures file
 SRPM_FILE_SIGNATURE_HANDLING ?= enforce
 
+# Hash algorithm used for new entries when updating signatures files (sha256, sha512, blake2b).
+# Existing entries keep their algorithm.
+SRPM_FILE_SIGNATURE_ALGORITHM ?= sha256
+
+# Directory the mirror-sources target places every source used by the SPECs into. It can be served by any static
+# HTTP server and used as the SOURCE_URL of later builds.
+SOURCE_MIRROR_DIR ?= $(BUILD_DIR)/source_mirror
+
+# Directory of a cache of source files keyed by their hash, shared between runs. Disabled if empty.
+# SOURCE_CACHE_SIZE is the size the cache is trimmed to after each run (e.g. 20GB), 0 keeps every file.
+SOURCE_CACHE_DIR  ?=
+SOURCE_CACHE_SIZE ?= 0
+
 local_specs = $(shell find $(SPECS_DIR)/ -type f -name '*.spec')
 local_spec_dirs = $(foreach spec,$(local_specs),$(dir $(spec)))
 local_sources = $(shell find $(SPECS_DIR)/ -name '*')
@@ -22,9 +35,33 @@ $(call create_folder,$(BUILD_SRPMS_DIR))
 $(call create_folder,$(BUILD_DIR)/SRPM_packaging)
 
 # General targets
-.PHONY: input-srpms clean-input-srpms
+.PHONY: input-srpms clean-input-srpms mirror-sources verify-signatures
 input-srpms: $(BUILD_SRPMS_DIR)
 
+# Download and verify every source used by the local SPECs into $(SOURCE_MIRROR_DIR).
+mirror-sources: $(go-srpmpacker)
+	$(go-srpmpacker) mirror \
+		--dir=$(SPECS_DIR) \
+		--output-dir=$(SOURCE_MIRROR_DIR) \
+		--source-url=$(SOURCE_URL) \
+		--dist-tag=$(DIST_TAG) \
+		--ca-cert=$(CA_CERT) \
+		--tls-cert=$(TLS_CERT) \
+		--tls-key=$(TLS_KEY) \
+		--source-cache-dir=$(SOURCE_CACHE_DIR) \
+		--source-cache-size=$(SOURCE_CACHE_SIZE) \
+		--log-file=$(LOGS_DIR)/pkggen/workplan/mirror_sources.log \
+		--log-level=$(LOG_LEVEL)
+
+# Check the signatures files of the local SPECs against their sources without packing any SRPMs.
+verify-signatures: $(go-srpmpacker)
+	$(go-srpmpacker) signatures verify \
+		--dir=$(SPECS_DIR) \
+		--dist-tag=$(DIST_TAG) \
+		--report-file=$(LOGS_DIR)/pkggen/workplan/signatures_report.json \
+		--log-file=$(LOGS_DIR)/pkggen/workplan/verify_signatures.log \
+		--log-level=$(LOG_LEVEL)
+
 clean: clean-input-srpms
 clean-input-srpms:
 	rm -rf $(BUILD_SRPMS_DIR)
@@ -95,8 +132,11 @@ $(STATUS_FLAGS_DIR)/build_srpms.flag: $(local_specs) $(local_spec_dirs) $(local_
 		--ca-cert=$(CA_CERT) \
 		--tls-cert=$(TLS_CERT) \
 		--tls-key=$(TLS_KEY) \
+		--source-cache-dir=$(SOURCE_CACHE_DIR) \
+		--source-cache-size=$(SOURCE_CACHE_SIZE) \
 		--build-dir=$(BUILD_DIR)/SRPM_packaging \
 		--signature-handling=$(SRPM_FILE_SIGNATURE_HANDLING) \
+		--signature-algorithm=$(SRPM_FILE_SIGNATURE_ALGORITHM) \
 		--log-file=$(LOGS_DIR)/pkggen/workplan/intermediate_srpms.log \
 		--log-level=$(LOG_LEVEL) && \
 	touch $@
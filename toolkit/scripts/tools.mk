@@ -15,8 +15,10 @@ $(call create_folder,$(BUILD_DIR)/tools)
 # List of go utilities in tools/ directory
 go_tool_list = \
 	boilerplate \
+	criticalpath \
 	depsearch \
 	grapher \
+	graphdiff \
 	graphoptimizer \
 	graphpkgfetcher \
 	imageconfigvalidator \
@@ -25,7 +27,9 @@ go_tool_list = \
 	isomaker \
 	liveinstaller \
 	pkgworker \
+	releasemonitor \
 	roast \
+	scheduler \
 	specreader \
 	srpmpacker \
 	unravel \
@@ -0,0 +1,360 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/graph"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"microsoft.com/pkggen/internal/exe"
+	"microsoft.com/pkggen/internal/jsonutils"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+)
+
+const (
+	defaultWorkerCount     = "1"
+	defaultDurationSeconds = "0"
+)
+
+// buildResult holds the fields of the result file pkgworker writes for every build that are needed to calculate timings.
+type buildResult struct {
+	TotalDuration float64 `json:"TotalDuration"`
+}
+
+// srpmTiming holds the scheduling information calculated for a single SRPM, or a variant build of it.
+type srpmTiming struct {
+	buildKey      string
+	duration      float64
+	earliestStart float64
+	latestStart   float64
+	dependencies  []string
+}
+
+// slack returns how long the build of the SRPM may be delayed without delaying the whole build.
+func (t *srpmTiming) slack() float64 {
+	return t.latestStart - t.earliestStart
+}
+
+var (
+	app             = kingpin.New("criticalpath", "A tool to analyze which chain of SRPMs dominates the build time of a package graph.")
+	input           = exe.InputFlag(app, "DOT(graphviz) file representing the dependency graph")
+	output          = exe.OutputFlag(app, "File which will be filled with the build time report.")
+	resultsDir      = app.Flag("results-dir", "Directory holding the result files written by pkgworker for every build, such as $(LOGS_DIR)/pkggen/results.").Required().ExistingDir()
+	defaultDuration = app.Flag("default-duration", "Build duration in seconds to assume for SRPMs without a result file.").Default(defaultDurationSeconds).Float64()
+	workers         = app.Flag("workers", "Number of concurrent build workers to calculate the minimum build time for.").Default(defaultWorkerCount).Int()
+	logFile         = exe.LogFileFlag(app)
+	logLevel        = exe.LogLevelFlag(app)
+)
+
+func main() {
+	app.Version(exe.ToolkitVersion)
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+	logger.InitBestEffort(*logFile, *logLevel)
+
+	if *workers <= 0 {
+		logger.Log.Panicf("Value in --workers must be greater than zero. Found %d", *workers)
+	}
+
+	g := pkggraph.NewPkgGraph()
+	err := pkggraph.ReadDOTGraphFile(g, *input)
+	logger.PanicOnError(err, "Failed to read graph file '%s'.", *input)
+
+	durations, err := readDurations(*resultsDir)
+	logger.PanicOnError(err, "Failed to read build results from '%s'.", *resultsDir)
+
+	timings, err := calculateTimings(g, durations)
+	logger.PanicOnError(err, "Failed to calculate build timings.")
+
+	criticalPath, buildTime := findCriticalPath(timings)
+	minimumTime := minimumBuildTime(timings, buildTime, *workers)
+
+	logger.Log.Infof("Critical path contains %d SRPMs and takes %.1fs", len(criticalPath), buildTime)
+	logger.Log.Infof("Minimum build time with %d worker(s) is %.1fs", *workers, minimumTime)
+
+	err = writeReport(*output, timings, criticalPath, buildTime, minimumTime, *workers)
+	logger.PanicOnError(err, "Failed to write report to '%s'.", *output)
+}
+
+// readDurations returns how long every build with a result file in resultsDir took, keyed by the build's name.
+// The duration covers every build attempt, since retries occupy a worker as well.
+func readDurations(resultsDir string) (durations map[string]float64, err error) {
+	const resultFileExtension = ".json"
+
+	resultFiles, err := filepath.Glob(filepath.Join(resultsDir, "*"+resultFileExtension))
+	if err != nil {
+		return
+	}
+
+	durations = make(map[string]float64)
+	for _, resultFile := range resultFiles {
+		var result buildResult
+		err = jsonutils.ReadJSONFile(resultFile, &result)
+		if err != nil {
+			err = fmt.Errorf("failed to read result file (%s): %s", resultFile, err)
+			return
+		}
+
+		durations[strings.TrimSuffix(filepath.Base(resultFile), resultFileExtension)] = result.TotalDuration
+	}
+
+	logger.Log.Infof("Read %d build results", len(durations))
+	return
+}
+
+// buildName returns the name the log and result files of a build node's build are given, see the workplan
+// generated by unravel.
+func buildName(buildNode *pkggraph.PkgNode) (name string) {
+	name = filepath.Base(buildNode.SrpmPath)
+	if buildNode.Variant != "" {
+		name = fmt.Sprintf("%s-%s", name, buildNode.Variant)
+	}
+	return
+}
+
+// calculateTimings calculates the earliest and latest start time of every SRPM with build nodes in the graph, keyed by
+// the build key of their build nodes. SRPMs which do not need to be built are treated as taking no time.
+func calculateTimings(g *pkggraph.PkgGraph, durations map[string]float64) (timings map[string]*srpmTiming, err error) {
+	timings = make(map[string]*srpmTiming)
+	buildNodesByKey := make(map[string][]*pkggraph.PkgNode)
+	for _, buildNode := range g.AllBuildNodes() {
+		buildKey := buildNode.BuildKey()
+		buildNodesByKey[buildKey] = append(buildNodesByKey[buildKey], buildNode)
+
+		timing, found := timings[buildKey]
+		if !found {
+			timing = &srpmTiming{buildKey: buildKey}
+			timings[buildKey] = timing
+		}
+
+		if buildNode.State != pkggraph.StateBuild || timing.duration != 0 {
+			continue
+		}
+
+		duration, found := durations[buildName(buildNode)]
+		if !found {
+			logger.Log.Debugf("No build result found for %s, using %.1fs", buildKey, *defaultDuration)
+			duration = *defaultDuration
+		}
+		timing.duration = duration
+	}
+
+	for buildKey, timing := range timings {
+		timing.dependencies = buildDependencies(g, buildKey, buildNodesByKey[buildKey])
+	}
+
+	order, err := topologicalOrder(timings)
+	if err != nil {
+		return
+	}
+
+	var buildTime float64
+	for _, buildKey := range order {
+		timing := timings[buildKey]
+		for _, dependency := range timing.dependencies {
+			finish := timings[dependency].earliestStart + timings[dependency].duration
+			if finish > timing.earliestStart {
+				timing.earliestStart = finish
+			}
+		}
+
+		if timing.earliestStart+timing.duration > buildTime {
+			buildTime = timing.earliestStart + timing.duration
+		}
+	}
+
+	// Walk the SRPMs in reverse so every SRPM's dependents have their latest start set first.
+	for _, timing := range timings {
+		timing.latestStart = buildTime - timing.duration
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		timing := timings[order[i]]
+		for _, dependency := range timing.dependencies {
+			dependencyTiming := timings[dependency]
+			latestStart := timing.latestStart - dependencyTiming.duration
+			if latestStart < dependencyTiming.latestStart {
+				dependencyTiming.latestStart = latestStart
+			}
+		}
+	}
+
+	return
+}
+
+// buildDependencies returns the build keys of the SRPMs which must be built before the SRPM built by buildNodes.
+// The graph is walked from the build nodes through run, remote and meta nodes, stopping at the build nodes of other SRPMs.
+func buildDependencies(g *pkggraph.PkgGraph, buildKey string, buildNodes []*pkggraph.PkgNode) (dependencies []string) {
+	visited := make(map[int64]bool)
+	dependencySet := make(map[string]bool)
+
+	pending := make([]*pkggraph.PkgNode, 0, len(buildNodes))
+	for _, buildNode := range buildNodes {
+		visited[buildNode.ID()] = true
+		pending = append(pending, buildNode)
+	}
+
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		for _, dependency := range graph.NodesOf(g.From(current.ID())) {
+			dependencyNode := dependency.(*pkggraph.PkgNode)
+			if visited[dependencyNode.ID()] {
+				continue
+			}
+			visited[dependencyNode.ID()] = true
+
+			if dependencyNode.Type != pkggraph.TypeBuild {
+				pending = append(pending, dependencyNode)
+				continue
+			}
+
+			// Other subpackages of the same SRPM are built together with it.
+			if dependencyKey := dependencyNode.BuildKey(); dependencyKey != buildKey {
+				dependencySet[dependencyKey] = true
+			}
+		}
+	}
+
+	for dependency := range dependencySet {
+		dependencies = append(dependencies, dependency)
+	}
+	sort.Strings(dependencies)
+	return
+}
+
+// topologicalOrder returns the SRPMs ordered so that every SRPM comes after all of its dependencies.
+func topologicalOrder(timings map[string]*srpmTiming) (order []string, err error) {
+	remainingDependencies := make(map[string]int)
+	dependents := make(map[string][]string)
+	var ready []string
+
+	for buildKey, timing := range timings {
+		remainingDependencies[buildKey] = len(timing.dependencies)
+		for _, dependency := range timing.dependencies {
+			dependents[dependency] = append(dependents[dependency], buildKey)
+		}
+		if len(timing.dependencies) == 0 {
+			ready = append(ready, buildKey)
+		}
+	}
+	sort.Strings(ready)
+
+	for len(ready) > 0 {
+		buildKey := ready[0]
+		ready = ready[1:]
+		order = append(order, buildKey)
+
+		for _, dependent := range dependents[buildKey] {
+			remainingDependencies[dependent]--
+			if remainingDependencies[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(order) != len(timings) {
+		var cyclic []string
+		for buildKey, remaining := range remainingDependencies {
+			if remaining > 0 {
+				cyclic = append(cyclic, filepath.Base(buildKey))
+			}
+		}
+		sort.Strings(cyclic)
+		err = fmt.Errorf("SRPMs have circular build dependencies: %v", cyclic)
+	}
+
+	return
+}
+
+// findCriticalPath returns the chain of SRPMs which determines the total build time, in build order.
+func findCriticalPath(timings map[string]*srpmTiming) (criticalPath []*srpmTiming, buildTime float64) {
+	var last *srpmTiming
+	for _, timing := range timings {
+		finish := timing.earliestStart + timing.duration
+		if last == nil || finish > buildTime || (finish == buildTime && timing.buildKey < last.buildKey) {
+			last = timing
+			buildTime = finish
+		}
+	}
+
+	for current := last; current != nil; {
+		criticalPath = append([]*srpmTiming{current}, criticalPath...)
+
+		// Nothing delays an SRPM which can start right away, even if it has dependencies which take no time.
+		if current.earliestStart == 0 {
+			break
+		}
+
+		var next *srpmTiming
+		for _, dependency := range current.dependencies {
+			dependencyTiming := timings[dependency]
+			if dependencyTiming.earliestStart+dependencyTiming.duration == current.earliestStart {
+				next = dependencyTiming
+				break
+			}
+		}
+		current = next
+	}
+
+	return
+}
+
+// minimumBuildTime returns the theoretical minimum build time with the given number of workers. The build can't be
+// faster than its critical path, nor faster than the total work split evenly across all workers.
+func minimumBuildTime(timings map[string]*srpmTiming, criticalPathTime float64, workerCount int) (minimumTime float64) {
+	var totalTime float64
+	for _, timing := range timings {
+		totalTime += timing.duration
+	}
+
+	minimumTime = totalTime / float64(workerCount)
+	if criticalPathTime > minimumTime {
+		minimumTime = criticalPathTime
+	}
+
+	return
+}
+
+// writeReport writes a human readable report of the build timings to outputFile.
+func writeReport(outputFile string, timings map[string]*srpmTiming, criticalPath []*srpmTiming, buildTime, minimumTime float64, workerCount int) (err error) {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "Critical path (%.1fs):\n", buildTime)
+	for _, timing := range criticalPath {
+		fmt.Fprintf(&builder, "\t%s: start %.1fs, duration %.1fs\n", filepath.Base(timing.buildKey), timing.earliestStart, timing.duration)
+	}
+
+	fmt.Fprintf(&builder, "\nMinimum build time with %d worker(s): %.1fs\n", workerCount, minimumTime)
+
+	sortedTimings := make([]*srpmTiming, 0, len(timings))
+	for _, timing := range timings {
+		sortedTimings = append(sortedTimings, timing)
+	}
+	sort.Slice(sortedTimings, func(i, j int) bool {
+		if sortedTimings[i].earliestStart != sortedTimings[j].earliestStart {
+			return sortedTimings[i].earliestStart < sortedTimings[j].earliestStart
+		}
+		return sortedTimings[i].buildKey < sortedTimings[j].buildKey
+	})
+
+	fmt.Fprintf(&builder, "\n%-60s %12s %12s %12s %12s\n", "SRPM", "Duration", "Earliest", "Latest", "Slack")
+	for _, timing := range sortedTimings {
+		fmt.Fprintf(&builder, "%-60s %12.1f %12.1f %12.1f %12.1f\n", filepath.Base(timing.buildKey), timing.duration, timing.earliestStart, timing.latestStart, timing.slack())
+	}
+
+	file, err := os.Create(outputFile)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(builder.String())
+	return
+}
@@ -0,0 +1,163 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+	"microsoft.com/pkggen/internal/pkgjson"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+// addTestPackage adds the run and build nodes of a local package to g the same way grapher does.
+func addTestPackage(t *testing.T, g *pkggraph.PkgGraph, name, srpmPath string, state pkggraph.NodeState) (runNode, buildNode *pkggraph.PkgNode) {
+	pkgVer := &pkgjson.PackageVer{Name: name, Version: "1.0", Condition: "="}
+	runNode, err := g.AddPkgNode(pkgVer, pkggraph.StateMeta, pkggraph.TypeRun, srpmPath, name+".spec", "SOURCES", "x86_64", "")
+	assert.NoError(t, err)
+
+	buildNode, err = g.AddPkgNode(pkgVer, state, pkggraph.TypeBuild, srpmPath, name+".spec", "SOURCES", "x86_64", "")
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(runNode, buildNode))
+	return
+}
+
+// addTestRemotePackage adds an unresolved remote node to g the same way grapher does.
+func addTestRemotePackage(t *testing.T, g *pkggraph.PkgGraph, name string) (remoteNode *pkggraph.PkgNode) {
+	pkgVer := &pkgjson.PackageVer{Name: name}
+	remoteNode, err := g.AddPkgNode(pkgVer, pkggraph.StateUnresolved, pkggraph.TypeRemote, "<NO_SRPM_PATH>", "<NO_SPEC_PATH>", "<NO_SOURCE_PATH>", "<NO_ARCHITECTURE>", "<NO_REPO>")
+	assert.NoError(t, err)
+	return
+}
+
+// criticalPathTestGraph returns a graph shaped like the output of grapher:
+//   - a.src.rpm builds a and a-devel, a-devel requires a and a BuildRequires b through a meta node,
+//   - b.src.rpm BuildRequires the remote package gcc and requires the up-to-date c,
+//   - d.src.rpm BuildRequires gcc,
+//   - an ALL goal links to every run node, including the remote one.
+func criticalPathTestGraph(t *testing.T) *pkggraph.PkgGraph {
+	g := pkggraph.NewPkgGraph()
+	aRun, aBuild := addTestPackage(t, g, "a", "a.src.rpm", pkggraph.StateBuild)
+	aDevelRun, aDevelBuild := addTestPackage(t, g, "a-devel", "a.src.rpm", pkggraph.StateBuild)
+	bRun, bBuild := addTestPackage(t, g, "b", "b.src.rpm", pkggraph.StateBuild)
+	cRun, _ := addTestPackage(t, g, "c", "c.src.rpm", pkggraph.StateUpToDate)
+	_, dBuild := addTestPackage(t, g, "d", "d.src.rpm", pkggraph.StateBuild)
+	gcc := addTestRemotePackage(t, g, "gcc")
+
+	g.SetEdge(g.NewEdge(aDevelRun, aRun))
+	g.SetEdge(g.NewEdge(aDevelBuild, aRun))
+	g.AddMetaNode([]*pkggraph.PkgNode{aBuild}, []*pkggraph.PkgNode{bRun})
+	g.SetEdge(g.NewEdge(bBuild, gcc))
+	g.SetEdge(g.NewEdge(bRun, cRun))
+	g.SetEdge(g.NewEdge(dBuild, gcc))
+
+	_, err := g.AddGoalNode("ALL", nil, false)
+	assert.NoError(t, err)
+	return g
+}
+
+func TestCalculateTimingsShouldFollowBuildEdges(t *testing.T) {
+	g := criticalPathTestGraph(t)
+	durations := map[string]float64{"a.src.rpm": 10, "b.src.rpm": 20, "c.src.rpm": 100, "d.src.rpm": 5}
+
+	timings, err := calculateTimings(g, durations)
+	assert.NoError(t, err)
+
+	// Goal, remote and run nodes must not show up as SRPMs.
+	assert.Len(t, timings, 4)
+	// Installing b to build a also installs c.
+	assert.Equal(t, []string{"b.src.rpm", "c.src.rpm"}, timings["a.src.rpm"].dependencies)
+	assert.Empty(t, timings["b.src.rpm"].dependencies)
+	assert.Empty(t, timings["d.src.rpm"].dependencies)
+
+	// c is up to date, so it takes no time.
+	assert.Equal(t, 0.0, timings["c.src.rpm"].duration)
+	assert.Equal(t, 20.0, timings["a.src.rpm"].earliestStart)
+	assert.Equal(t, 0.0, timings["b.src.rpm"].slack())
+	assert.Equal(t, 25.0, timings["d.src.rpm"].slack())
+
+	criticalPath, buildTime := findCriticalPath(timings)
+	assert.Equal(t, 30.0, buildTime)
+	assert.Len(t, criticalPath, 2)
+	assert.Equal(t, "b.src.rpm", criticalPath[0].buildKey)
+	assert.Equal(t, "a.src.rpm", criticalPath[1].buildKey)
+}
+
+func TestCalculateTimingsShouldKeyVariantsSeparately(t *testing.T) {
+	g := criticalPathTestGraph(t)
+
+	pkgVer := &pkgjson.PackageVer{Name: "b", Version: "1.0", Condition: "="}
+	variantRun, err := g.AddVariantPkgNode(pkgVer, pkggraph.StateMeta, pkggraph.TypeRun, "b.src.rpm", "b.spec", "SOURCES", "x86_64", "", "bootstrap", nil)
+	assert.NoError(t, err)
+	variantBuild, err := g.AddVariantPkgNode(pkgVer, pkggraph.StateBuild, pkggraph.TypeBuild, "b.src.rpm", "b.spec", "SOURCES", "x86_64", "", "bootstrap", nil)
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(variantRun, variantBuild))
+
+	// The default build of d now builds against the bootstrap variant of b.
+	dBuild, err := g.FindExactPkgNodeFromPkgForArch(&pkgjson.PackageVer{Name: "d", Version: "1.0", Condition: "="}, "x86_64")
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(dBuild.BuildNode, variantRun))
+
+	durations := map[string]float64{"b.src.rpm": 20, "b.src.rpm-bootstrap": 7}
+	timings, err := calculateTimings(g, durations)
+	assert.NoError(t, err)
+	assert.Len(t, timings, 5)
+	assert.Equal(t, []string{variantBuild.BuildKey()}, timings["d.src.rpm"].dependencies)
+	assert.Equal(t, 20.0, timings["b.src.rpm"].duration)
+	assert.Equal(t, 7.0, timings[variantBuild.BuildKey()].duration)
+}
+
+func TestCalculateTimingsShouldFailOnBuildCycle(t *testing.T) {
+	g := criticalPathTestGraph(t)
+
+	bBuild, err := g.FindExactPkgNodeFromPkgForArch(&pkgjson.PackageVer{Name: "b", Version: "1.0", Condition: "="}, "x86_64")
+	assert.NoError(t, err)
+	aRun, err := g.FindExactPkgNodeFromPkgForArch(&pkgjson.PackageVer{Name: "a", Version: "1.0", Condition: "="}, "x86_64")
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(bBuild.BuildNode, aRun.RunNode))
+
+	_, err = calculateTimings(g, nil)
+	assert.Error(t, err)
+}
+
+func TestReadDurationsShouldKeyResultFilesByBuildName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "criticalpath")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	results := map[string]string{
+		"a.src.rpm.json":           `{"SRPM": "/SRPMS/a.src.rpm", "Success": true, "TotalDuration": 12.5}`,
+		"b.src.rpm-bootstrap.json": `{"SRPM": "/SRPMS/b.src.rpm", "Success": false, "TotalDuration": 3}`,
+		"a.src.rpm.log":            `not a result file`,
+	}
+	for name, contents := range results {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), os.ModePerm)
+		assert.NoError(t, err)
+	}
+
+	durations, err := readDurations(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"a.src.rpm": 12.5, "b.src.rpm-bootstrap": 3}, durations)
+}
+
+func TestReadDurationsShouldFailOnInvalidResultFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "criticalpath")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "a.src.rpm.json"), []byte("{"), os.ModePerm)
+	assert.NoError(t, err)
+
+	_, err = readDurations(dir)
+	assert.Error(t, err)
+}
@@ -15,6 +15,15 @@ import (
 	"microsoft.com/pkggen/internal/exe"
 	"microsoft.com/pkggen/internal/logger"
 	"microsoft.com/pkggen/internal/pkggraph"
+	"microsoft.com/pkggen/unravel/formats"
+)
+
+const (
+	defaultMaxPaths = "100"
+
+	formatDOT     = "dot"
+	formatJSON    = "json"
+	formatGraphML = "graphml"
 )
 
 var (
@@ -23,11 +32,18 @@ var (
 	inputGraphFile  = exe.InputFlag(app, "Path to the DOT graph file to search.")
 	outputGraphFile = exe.OutputFlag(app, "Path to save the graph.")
 
+	legalFormats = []string{formatDOT, formatJSON, formatGraphML}
+	outputFormat = app.Flag("output-format", "Format to save the graph in.").Default(formatDOT).PlaceHolder(exe.PlaceHolderize(legalFormats)).Enum(legalFormats...)
+
 	pkgsToSearch  = app.Flag("packages", "Space seperated list of packages to search from.").String()
 	specsToSearch = app.Flag("specs", "Space seperated list of specfiles to search from.").String()
 
 	reverseSearch = app.Flag("reverse", "Reverse the search to give a traditional dependency list for the packages instead of dependants.").Bool()
 
+	whyPkg   = app.Flag("why", "Explain why this package pulls in the package passed to --to by listing every shortest dependency path between them.").String()
+	toPkg    = app.Flag("to", "The package to explain the dependency on, used with --why.").String()
+	maxPaths = app.Flag("max-paths", "Maximum number of paths to print with --why.").Default(defaultMaxPaths).Int()
+
 	logFile  = exe.LogFileFlag(app)
 	logLevel = exe.LogLevelFlag(app)
 )
@@ -47,6 +63,23 @@ func main() {
 		logger.Log.Panicf("Failed to read DOT graph with error: %s", err)
 	}
 
+	if *whyPkg != "" || *toPkg != "" {
+		if *whyPkg == "" || *toPkg == "" {
+			logger.Log.Panicf("Both --why and --to must be set to explain a dependency")
+		}
+
+		outputGraph, err = explainWhy(graph, *whyPkg, *toPkg, *maxPaths)
+		if err != nil {
+			logger.Log.Panicf("Failed to explain why '%s' depends on '%s': %s", *whyPkg, *toPkg, err)
+		}
+
+		err = writeGraph(outputGraph, *outputGraphFile, *outputFormat)
+		if err != nil {
+			logger.Log.Panicf("Failed to write graph with error: %s", err)
+		}
+		return
+	}
+
 	pkgSearchList := exe.ParseListArgument(*pkgsToSearch)
 	specSearchList := exe.ParseListArgument(*specsToSearch)
 
@@ -71,7 +104,34 @@ func main() {
 
 	printSpecs(outputGraph)
 
-	pkggraph.WriteDOTGraphFile(outputGraph, *outputGraphFile)
+	err = writeGraph(outputGraph, *outputGraphFile, *outputFormat)
+	if err != nil {
+		logger.Log.Panicf("Failed to write graph with error: %s", err)
+	}
+}
+
+// writeGraph saves the graph to outputFile using the requested format.
+func writeGraph(g *pkggraph.PkgGraph, outputFile, format string) (err error) {
+	var u formats.Unravel
+
+	switch format {
+	case formatDOT:
+		return pkggraph.WriteDOTGraphFile(g, outputFile)
+	case formatJSON:
+		u = formats.NewJSON(g)
+	case formatGraphML:
+		u = formats.NewGraphML(g)
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return
+	}
+	defer out.Close()
+
+	return u.Save(out)
 }
 
 func searchForPkg(graph *pkggraph.PkgGraph, packages []string) (list []*pkggraph.PkgNode) {
@@ -167,3 +227,173 @@ func printSpecs(graph *pkggraph.PkgGraph) {
 		fmt.Printf("\t%s\n", key)
 	}
 }
+
+// explainWhy prints every shortest dependency path from the run nodes of package "from" to the run nodes of package "to".
+// Returns a graph containing only the nodes and edges on those paths.
+func explainWhy(graphIn *pkggraph.PkgGraph, from, to string, pathLimit int) (graphOut *pkggraph.PkgGraph, err error) {
+	sources := searchForPkg(graphIn, []string{from})
+	if len(sources) == 0 {
+		err = fmt.Errorf("could not find any nodes for package '%s'", from)
+		return
+	}
+
+	targets := searchForPkg(graphIn, []string{to})
+	if len(targets) == 0 {
+		err = fmt.Errorf("could not find any nodes for package '%s'", to)
+		return
+	}
+
+	predecessors, reachedTargets := shortestPathPredecessors(graphIn, sources, targets)
+	if len(reachedTargets) == 0 {
+		logger.Log.Infof("'%s' does not depend on '%s'", from, to)
+		graphOut = pkggraph.NewPkgGraph()
+		return
+	}
+
+	var paths [][]*pkggraph.PkgNode
+	for _, target := range reachedTargets {
+		paths = append(paths, expandPaths(predecessors, target, pathLimit-len(paths))...)
+		if len(paths) >= pathLimit {
+			logger.Log.Warnf("Reached the limit of %d paths, some shortest paths may not be printed", pathLimit)
+			break
+		}
+	}
+
+	printPaths(paths)
+
+	graphOut, err = pathsGraph(graphIn, paths)
+	return
+}
+
+// shortestPathPredecessors runs a breadth first search from all sources, stopping at the depth the first target is found.
+// Returns every predecessor of each visited node which lies on a shortest path, and the targets found at that depth.
+func shortestPathPredecessors(g *pkggraph.PkgGraph, sources, targets []*pkggraph.PkgNode) (predecessors map[int64][]*pkggraph.PkgNode, reachedTargets []*pkggraph.PkgNode) {
+	depth := make(map[int64]int)
+	predecessors = make(map[int64][]*pkggraph.PkgNode)
+
+	isTarget := make(map[int64]bool)
+	for _, target := range targets {
+		isTarget[target.ID()] = true
+	}
+
+	currentLevel := sources
+	for _, source := range sources {
+		depth[source.ID()] = 0
+		if isTarget[source.ID()] {
+			reachedTargets = append(reachedTargets, source)
+		}
+	}
+
+	for level := 1; len(currentLevel) > 0 && len(reachedTargets) == 0; level++ {
+		var nextLevel []*pkggraph.PkgNode
+		for _, n := range currentLevel {
+			for _, dependency := range graph.NodesOf(g.From(n.ID())) {
+				dependencyNode := dependency.(*pkggraph.PkgNode)
+
+				dependencyDepth, visited := depth[dependencyNode.ID()]
+				if !visited {
+					depth[dependencyNode.ID()] = level
+					nextLevel = append(nextLevel, dependencyNode)
+					if isTarget[dependencyNode.ID()] {
+						reachedTargets = append(reachedTargets, dependencyNode)
+					}
+				} else if dependencyDepth != level {
+					continue
+				}
+
+				predecessors[dependencyNode.ID()] = append(predecessors[dependencyNode.ID()], n)
+			}
+		}
+		currentLevel = nextLevel
+	}
+
+	return
+}
+
+// expandPaths returns up to pathLimit paths ending at node, built by walking the shortest path predecessors back to a source.
+func expandPaths(predecessors map[int64][]*pkggraph.PkgNode, node *pkggraph.PkgNode, pathLimit int) (paths [][]*pkggraph.PkgNode) {
+	if pathLimit <= 0 {
+		return
+	}
+
+	nodePredecessors := predecessors[node.ID()]
+	if len(nodePredecessors) == 0 {
+		paths = append(paths, []*pkggraph.PkgNode{node})
+		return
+	}
+
+	for _, predecessor := range nodePredecessors {
+		for _, path := range expandPaths(predecessors, predecessor, pathLimit-len(paths)) {
+			paths = append(paths, append(path, node))
+		}
+		if len(paths) >= pathLimit {
+			break
+		}
+	}
+
+	return
+}
+
+// edgeKind describes the dependency an edge represents, based on the types of the nodes it connects.
+func edgeKind(from, to *pkggraph.PkgNode) string {
+	switch from.Type {
+	case pkggraph.TypeBuild:
+		return "BuildRequires"
+	case pkggraph.TypeRun:
+		if to.Type == pkggraph.TypeBuild {
+			return "BuiltBy"
+		}
+		return "Requires"
+	default:
+		return from.Type.String()
+	}
+}
+
+func printPaths(paths [][]*pkggraph.PkgNode) {
+	for i, path := range paths {
+		fmt.Printf("Path %d:\n", i+1)
+		fmt.Printf("\t%s\n", path[0].FriendlyName())
+		for j := 1; j < len(path); j++ {
+			fmt.Printf("\t  --%s--> %s\n", edgeKind(path[j-1], path[j]), path[j].FriendlyName())
+		}
+	}
+}
+
+// pathsGraph returns a copy of graphIn containing only the nodes and edges found on paths.
+func pathsGraph(graphIn *pkggraph.PkgGraph, paths [][]*pkggraph.PkgNode) (graphOut *pkggraph.PkgGraph, err error) {
+	graphOut, err = graphIn.DeepCopy()
+	if err != nil {
+		return
+	}
+
+	pathEdges := make(map[int64]map[int64]bool)
+	for _, path := range paths {
+		for i, n := range path {
+			if pathEdges[n.ID()] == nil {
+				pathEdges[n.ID()] = make(map[int64]bool)
+			}
+			if i > 0 {
+				pathEdges[path[i-1].ID()][n.ID()] = true
+			}
+		}
+	}
+
+	// Remove build nodes first so their run nodes don't leave them orphaned in the lookup table.
+	allNodes := graphOut.AllNodes()
+	for _, removeBuildNodes := range []bool{true, false} {
+		for _, n := range allNodes {
+			if _, onPath := pathEdges[n.ID()]; onPath || (n.Type == pkggraph.TypeBuild) != removeBuildNodes {
+				continue
+			}
+			graphOut.RemovePkgNode(n)
+		}
+	}
+
+	for _, edge := range graph.EdgesOf(graphOut.Edges()) {
+		if !pathEdges[edge.From().ID()][edge.To().ID()] {
+			graphOut.RemoveEdge(edge.From().ID(), edge.To().ID())
+		}
+	}
+
+	return
+}
@@ -0,0 +1,130 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/graph"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+	"microsoft.com/pkggen/internal/pkgjson"
+)
+
+const testPathLimit = 100
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+// addTestPackage adds the run and build nodes of a local package to g.
+func addTestPackage(t *testing.T, g *pkggraph.PkgGraph, name string) (runNode *pkggraph.PkgNode) {
+	pkgVer := &pkgjson.PackageVer{Name: name, Version: "1.0", Condition: "="}
+	runNode, err := g.AddPkgNode(pkgVer, pkggraph.StateMeta, pkggraph.TypeRun, name+".src.rpm", name+".spec", "SOURCES", "x86_64", "")
+	assert.NoError(t, err)
+
+	buildNode, err := g.AddPkgNode(pkgVer, pkggraph.StateBuild, pkggraph.TypeBuild, name+".src.rpm", name+".spec", "SOURCES", "x86_64", "")
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(runNode, buildNode))
+	return
+}
+
+// whyTestGraph returns a graph where a requires b, b requires c, and d is unrelated.
+func whyTestGraph(t *testing.T) *pkggraph.PkgGraph {
+	g := pkggraph.NewPkgGraph()
+	a := addTestPackage(t, g, "a")
+	b := addTestPackage(t, g, "b")
+	c := addTestPackage(t, g, "c")
+	addTestPackage(t, g, "d")
+
+	g.SetEdge(g.NewEdge(a, b))
+	g.SetEdge(g.NewEdge(b, c))
+	return g
+}
+
+// describePaths returns the sorted nodes and edges of a graph returned by explainWhy.
+func describePaths(g *pkggraph.PkgGraph) (nodes, edges []string) {
+	for _, n := range g.AllNodes() {
+		nodes = append(nodes, n.FriendlyName())
+	}
+	for _, edge := range graph.EdgesOf(g.Edges()) {
+		from := edge.From().(*pkggraph.PkgNode)
+		to := edge.To().(*pkggraph.PkgNode)
+		edges = append(edges, from.FriendlyName()+" -> "+to.FriendlyName())
+	}
+
+	sort.Strings(nodes)
+	sort.Strings(edges)
+	return
+}
+
+func TestExplainWhyShouldFindDirectDependency(t *testing.T) {
+	g := whyTestGraph(t)
+	a := searchForPkg(g, []string{"a"})[0]
+	b := searchForPkg(g, []string{"b"})[0]
+
+	pathGraph, err := explainWhy(g, "a", "b", testPathLimit)
+	assert.NoError(t, err)
+
+	nodes, edges := describePaths(pathGraph)
+	assert.Equal(t, []string{a.FriendlyName(), b.FriendlyName()}, nodes)
+	assert.Equal(t, []string{a.FriendlyName() + " -> " + b.FriendlyName()}, edges)
+}
+
+func TestExplainWhyShouldFindTransitiveDependency(t *testing.T) {
+	g := whyTestGraph(t)
+	a := searchForPkg(g, []string{"a"})[0]
+	b := searchForPkg(g, []string{"b"})[0]
+	c := searchForPkg(g, []string{"c"})[0]
+
+	pathGraph, err := explainWhy(g, "a", "c", testPathLimit)
+	assert.NoError(t, err)
+
+	nodes, edges := describePaths(pathGraph)
+	assert.Equal(t, []string{a.FriendlyName(), b.FriendlyName(), c.FriendlyName()}, nodes)
+	assert.Equal(t, []string{
+		a.FriendlyName() + " -> " + b.FriendlyName(),
+		b.FriendlyName() + " -> " + c.FriendlyName(),
+	}, edges)
+}
+
+func TestExplainWhyShouldFindEveryShortestPath(t *testing.T) {
+	g := whyTestGraph(t)
+	a := searchForPkg(g, []string{"a"})[0]
+	c := searchForPkg(g, []string{"c"})[0]
+	d := searchForPkg(g, []string{"d"})[0]
+
+	// a now reaches c both through b and through d, both paths have the same length.
+	g.SetEdge(g.NewEdge(a, d))
+	g.SetEdge(g.NewEdge(d, c))
+
+	predecessors, reachedTargets := shortestPathPredecessors(g, []*pkggraph.PkgNode{a}, []*pkggraph.PkgNode{c})
+	assert.Equal(t, []*pkggraph.PkgNode{c}, reachedTargets)
+	assert.Len(t, expandPaths(predecessors, c, testPathLimit), 2)
+	assert.Len(t, expandPaths(predecessors, c, 1), 1)
+}
+
+func TestExplainWhyShouldReturnEmptyGraphWithoutDependency(t *testing.T) {
+	g := whyTestGraph(t)
+
+	for _, from := range []string{"c", "d"} {
+		pathGraph, err := explainWhy(g, from, "a", testPathLimit)
+		assert.NoError(t, err)
+		assert.Empty(t, pathGraph.AllNodes(), from)
+	}
+}
+
+func TestExplainWhyShouldFailForUnknownPackage(t *testing.T) {
+	g := whyTestGraph(t)
+
+	_, err := explainWhy(g, "missing", "a", testPathLimit)
+	assert.Error(t, err)
+
+	_, err = explainWhy(g, "a", "missing", testPathLimit)
+	assert.Error(t, err)
+}
@@ -0,0 +1,343 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/graph"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"microsoft.com/pkggen/internal/exe"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+	"microsoft.com/pkggen/internal/pkgjson"
+)
+
+// graphDiff holds the differences found between two package graphs. Every list is sorted.
+type graphDiff struct {
+	addedPackages    []string
+	removedPackages  []string
+	versionChanges   []string
+	stateChanges     []string
+	modifiedNodes    []string
+	addedEdges       []string
+	removedEdges     []string
+	addedWeakEdges   []string
+	removedWeakEdges []string
+}
+
+var (
+	app = kingpin.New("graphdiff", "A tool to report the differences between two package graphs")
+
+	oldGraphFile = app.Flag("old", "DOT graph file to compare from.").Required().ExistingFile()
+	newGraphFile = app.Flag("new", "DOT graph file to compare to.").Required().ExistingFile()
+	outputFile   = app.Flag("output", "Optional file to write the report to, defaults to stdout.").String()
+
+	logFile  = exe.LogFileFlag(app)
+	logLevel = exe.LogLevelFlag(app)
+)
+
+func main() {
+	app.Version(exe.ToolkitVersion)
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+	logger.InitBestEffort(*logFile, *logLevel)
+
+	oldGraph := pkggraph.NewPkgGraph()
+	err := pkggraph.ReadDOTGraphFile(oldGraph, *oldGraphFile)
+	logger.PanicOnError(err, "Failed to read graph file '%s'.", *oldGraphFile)
+
+	newGraph := pkggraph.NewPkgGraph()
+	err = pkggraph.ReadDOTGraphFile(newGraph, *newGraphFile)
+	logger.PanicOnError(err, "Failed to read graph file '%s'.", *newGraphFile)
+
+	diff, err := diffGraphs(oldGraph, newGraph)
+	logger.PanicOnError(err, "Failed to compare graph files '%s' and '%s'.", *oldGraphFile, *newGraphFile)
+
+	report := diff.String()
+
+	if *outputFile == "" {
+		fmt.Print(report)
+	} else {
+		err = file.Write(report, *outputFile)
+		logger.PanicOnError(err, "Failed to write report to '%s'.", *outputFile)
+	}
+}
+
+// diffGraphs compares two graphs. Nodes are matched between the graphs using their type, name, version, architecture
+// and variant since node IDs are not stable across graph generations.
+func diffGraphs(oldGraph, newGraph *pkggraph.PkgGraph) (diff graphDiff, err error) {
+	oldNodes, err := nodesByKey(oldGraph)
+	if err != nil {
+		return
+	}
+
+	newNodes, err := nodesByKey(newGraph)
+	if err != nil {
+		return
+	}
+
+	diff.addedPackages, diff.removedPackages, diff.versionChanges = diffPackages(oldGraph, newGraph)
+
+	for key, oldNode := range oldNodes {
+		newNode, found := newNodes[key]
+		if !found || oldNode.Equal(newNode) {
+			continue
+		}
+
+		if oldNode.State != newNode.State {
+			diff.stateChanges = append(diff.stateChanges, fmt.Sprintf("%s: %s -> %s", key, oldNode.State, newNode.State))
+		}
+
+		changes := fieldChanges(oldNode, newNode)
+		if len(changes) > 0 {
+			diff.modifiedNodes = append(diff.modifiedNodes, fmt.Sprintf("%s: %s", key, strings.Join(changes, ", ")))
+		}
+	}
+
+	oldEdges := edgesByKey(oldGraph, oldNodes)
+	newEdges := edgesByKey(newGraph, newNodes)
+	for edge := range newEdges {
+		if !oldEdges[edge] {
+			diff.addedEdges = append(diff.addedEdges, edge)
+		}
+	}
+	for edge := range oldEdges {
+		if !newEdges[edge] {
+			diff.removedEdges = append(diff.removedEdges, edge)
+		}
+	}
+
+	oldWeakEdges := weakEdgesByKey(oldGraph, oldNodes)
+	newWeakEdges := weakEdgesByKey(newGraph, newNodes)
+	for edge := range newWeakEdges {
+		if !oldWeakEdges[edge] {
+			diff.addedWeakEdges = append(diff.addedWeakEdges, edge)
+		}
+	}
+	for edge := range oldWeakEdges {
+		if !newWeakEdges[edge] {
+			diff.removedWeakEdges = append(diff.removedWeakEdges, edge)
+		}
+	}
+
+	for _, list := range [][]string{diff.stateChanges, diff.modifiedNodes, diff.addedEdges, diff.removedEdges, diff.addedWeakEdges, diff.removedWeakEdges} {
+		sort.Strings(list)
+	}
+
+	return
+}
+
+// diffPackages compares the packages provided by the run and remote nodes of both graphs. A package whose only
+// version was replaced by a different version is reported as a version change instead of an addition and a removal.
+func diffPackages(oldGraph, newGraph *pkggraph.PkgGraph) (added, removed, versionChanges []string) {
+	oldVersions := packageVersions(oldGraph)
+	newVersions := packageVersions(newGraph)
+
+	names := make(map[string]bool)
+	for name := range oldVersions {
+		names[name] = true
+	}
+	for name := range newVersions {
+		names[name] = true
+	}
+
+	for name := range names {
+		onlyOld := versionsMissingFrom(oldVersions[name], newVersions[name])
+		onlyNew := versionsMissingFrom(newVersions[name], oldVersions[name])
+
+		if len(onlyOld) == 1 && len(onlyNew) == 1 {
+			versionChanges = append(versionChanges, fmt.Sprintf("%s: %s -> %s", name, onlyOld[0], onlyNew[0]))
+			continue
+		}
+
+		for _, version := range onlyOld {
+			removed = append(removed, packageString(name, version))
+		}
+		for _, version := range onlyNew {
+			added = append(added, packageString(name, version))
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(versionChanges)
+	return
+}
+
+// packageVersions returns the versions of every package in the graph, keyed by package name.
+// Remote packages are marked so they are not confused with local packages of the same version.
+func packageVersions(g *pkggraph.PkgGraph) (versions map[string]map[string]bool) {
+	versions = make(map[string]map[string]bool)
+	for _, n := range g.AllRunNodes() {
+		name := n.VersionedPkg.Name
+		if versions[name] == nil {
+			versions[name] = make(map[string]bool)
+		}
+
+		version := versionString(n)
+		if n.Type == pkggraph.TypeRemote {
+			version = strings.TrimSpace(fmt.Sprintf("%s (remote)", version))
+		}
+		versions[name][version] = true
+	}
+	return
+}
+
+// versionsMissingFrom returns the sorted versions in versions which are not in otherVersions.
+func versionsMissingFrom(versions, otherVersions map[string]bool) (missing []string) {
+	for version := range versions {
+		if !otherVersions[version] {
+			missing = append(missing, version)
+		}
+	}
+	sort.Strings(missing)
+	return
+}
+
+func packageString(name, version string) string {
+	if version == "" {
+		return name
+	}
+	return fmt.Sprintf("%s %s", name, version)
+}
+
+// versionString returns the version constraints of a node in a human readable form.
+func versionString(n *pkggraph.PkgNode) (version string) {
+	return pkgVerVersionString(n.VersionedPkg)
+}
+
+// pkgVerVersionString returns the version constraints of a package version in a human readable form.
+func pkgVerVersionString(pkgVer *pkgjson.PackageVer) (version string) {
+	if pkgVer == nil {
+		return
+	}
+
+	version = fmt.Sprintf("%s%s", pkgVer.Condition, pkgVer.Version)
+	if pkgVer.SCondition != "" || pkgVer.SVersion != "" {
+		version = fmt.Sprintf("%s,%s%s", version, pkgVer.SCondition, pkgVer.SVersion)
+	}
+	return
+}
+
+// nodeKey returns a string identifying a node which is stable across graph generations.
+// Meta nodes have no package of their own and are identified by the nodes depending on them.
+func nodeKey(g *pkggraph.PkgGraph, n *pkggraph.PkgNode) string {
+	switch n.Type {
+	case pkggraph.TypeGoal:
+		return fmt.Sprintf("Goal(%s)", n.GoalName)
+	case pkggraph.TypePureMeta:
+		var members []string
+		for _, member := range graph.NodesOf(g.To(n.ID())) {
+			members = append(members, nodeKey(g, member.(*pkggraph.PkgNode)))
+		}
+		sort.Strings(members)
+		return fmt.Sprintf("Meta(%s)", strings.Join(members, ", "))
+	default:
+		fields := []string{packageString(n.VersionedPkg.Name, versionString(n))}
+		if n.Architecture != "" {
+			fields = append(fields, n.Architecture)
+		}
+		if n.Variant != "" {
+			fields = append(fields, fmt.Sprintf("variant %s", n.Variant))
+		}
+		return fmt.Sprintf("%s(%s)", n.Type, strings.Join(fields, ", "))
+	}
+}
+
+// nodesByKey returns all nodes of a graph, indexed by their key.
+func nodesByKey(g *pkggraph.PkgGraph) (nodes map[string]*pkggraph.PkgNode, err error) {
+	nodes = make(map[string]*pkggraph.PkgNode)
+	for _, n := range g.AllNodes() {
+		key := nodeKey(g, n)
+		if existingNode, exists := nodes[key]; exists {
+			err = fmt.Errorf("nodes %s and %s share the key %s", existingNode.FriendlyName(), n.FriendlyName(), key)
+			return
+		}
+		nodes[key] = n
+	}
+	return
+}
+
+// edgesByKey returns all edges of a graph as "from -> to" strings built from the node keys.
+func edgesByKey(g *pkggraph.PkgGraph, nodes map[string]*pkggraph.PkgNode) (edges map[string]bool) {
+	keys := make(map[int64]string, len(nodes))
+	for key, n := range nodes {
+		keys[n.ID()] = key
+	}
+
+	edges = make(map[string]bool)
+	for _, edge := range graph.EdgesOf(g.Edges()) {
+		edges[fmt.Sprintf("%s -> %s", keys[edge.From().ID()], keys[edge.To().ID()])] = true
+	}
+	return
+}
+
+// weakEdgesByKey returns the weak edges of a graph as "from -Kind-> to" strings built from the node keys.
+func weakEdgesByKey(g *pkggraph.PkgGraph, nodes map[string]*pkggraph.PkgNode) (edges map[string]bool) {
+	keys := make(map[int64]string, len(nodes))
+	for key, n := range nodes {
+		keys[n.ID()] = key
+	}
+
+	edges = make(map[string]bool)
+	for _, edge := range g.AllWeakEdges() {
+		edges[fmt.Sprintf("%s -%s-> %s", keys[edge.From.ID()], edge.Kind, keys[edge.To.ID()])] = true
+	}
+	return
+}
+
+// fieldChanges describes the changes to a node's attributes, other than its state.
+func fieldChanges(oldNode, newNode *pkggraph.PkgNode) (changes []string) {
+	fields := []struct {
+		name     string
+		oldValue string
+		newValue string
+	}{
+		{"SRPM", oldNode.SrpmPath, newNode.SrpmPath},
+		{"SPEC", oldNode.SpecPath, newNode.SpecPath},
+		{"SourceDir", oldNode.SourceDir, newNode.SourceDir},
+		{"Architecture", oldNode.Architecture, newNode.Architecture},
+		{"SourceRepo", oldNode.SourceRepo, newNode.SourceRepo},
+	}
+
+	for _, field := range fields {
+		if field.oldValue != field.newValue {
+			changes = append(changes, fmt.Sprintf("%s %s -> %s", field.name, field.oldValue, field.newValue))
+		}
+	}
+	return
+}
+
+// String formats the differences as a human readable report.
+func (d graphDiff) String() string {
+	var builder strings.Builder
+
+	sections := []struct {
+		title string
+		lines []string
+	}{
+		{"Added packages", d.addedPackages},
+		{"Removed packages", d.removedPackages},
+		{"Version changes", d.versionChanges},
+		{"State changes", d.stateChanges},
+		{"Modified nodes", d.modifiedNodes},
+		{"Added dependencies", d.addedEdges},
+		{"Removed dependencies", d.removedEdges},
+		{"Added weak dependencies", d.addedWeakEdges},
+		{"Removed weak dependencies", d.removedWeakEdges},
+	}
+
+	for _, section := range sections {
+		fmt.Fprintf(&builder, "%s (%d):\n", section.title, len(section.lines))
+		for _, line := range section.lines {
+			fmt.Fprintf(&builder, "\t%s\n", line)
+		}
+	}
+
+	return builder.String()
+}
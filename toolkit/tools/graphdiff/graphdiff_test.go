@@ -0,0 +1,135 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+	"microsoft.com/pkggen/internal/pkgjson"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+// addTestRunNode adds a run node for a local package to g.
+func addTestRunNode(t *testing.T, g *pkggraph.PkgGraph, name, version, arch, variant string, state pkggraph.NodeState) (n *pkggraph.PkgNode) {
+	pkgVer := &pkgjson.PackageVer{Name: name, Version: version, Condition: "="}
+	n, err := g.AddVariantPkgNode(pkgVer, state, pkggraph.TypeRun, name+".src.rpm", name+".spec", "SOURCES", arch, "", variant, nil)
+	assert.NoError(t, err)
+	return
+}
+
+func TestDiffGraphsShouldReportNoChangesForEqualGraphs(t *testing.T) {
+	oldGraph := pkggraph.NewPkgGraph()
+	newGraph := pkggraph.NewPkgGraph()
+	for _, g := range []*pkggraph.PkgGraph{oldGraph, newGraph} {
+		a := addTestRunNode(t, g, "a", "1.0", "x86_64", "", pkggraph.StateMeta)
+		b := addTestRunNode(t, g, "b", "1.0", "x86_64", "", pkggraph.StateMeta)
+		g.SetEdge(g.NewEdge(a, b))
+	}
+
+	diff, err := diffGraphs(oldGraph, newGraph)
+	assert.NoError(t, err)
+	assert.Equal(t, graphDiff{}, diff)
+}
+
+func TestDiffGraphsShouldMatchNodesByArchitectureAndVariant(t *testing.T) {
+	oldGraph := pkggraph.NewPkgGraph()
+	addTestRunNode(t, oldGraph, "a", "1.0", "x86_64", "", pkggraph.StateMeta)
+	addTestRunNode(t, oldGraph, "a", "1.0", "aarch64", "", pkggraph.StateMeta)
+	addTestRunNode(t, oldGraph, "a", "1.0", "x86_64", "bootstrap", pkggraph.StateMeta)
+
+	newGraph := pkggraph.NewPkgGraph()
+	addTestRunNode(t, newGraph, "a", "1.0", "x86_64", "", pkggraph.StateMeta)
+	addTestRunNode(t, newGraph, "a", "1.0", "aarch64", "", pkggraph.StateUpToDate)
+	addTestRunNode(t, newGraph, "a", "1.0", "x86_64", "bootstrap", pkggraph.StateBuild)
+
+	diff, err := diffGraphs(oldGraph, newGraph)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"Run(a =1.0, aarch64): Meta -> UpToDate",
+		"Run(a =1.0, x86_64, variant bootstrap): Meta -> Build",
+	}, diff.stateChanges)
+	assert.Empty(t, diff.modifiedNodes)
+}
+
+func TestDiffGraphsShouldReportEdgeChanges(t *testing.T) {
+	oldGraph := pkggraph.NewPkgGraph()
+	a := addTestRunNode(t, oldGraph, "a", "1.0", "x86_64", "", pkggraph.StateMeta)
+	b := addTestRunNode(t, oldGraph, "b", "1.0", "x86_64", "", pkggraph.StateMeta)
+	addTestRunNode(t, oldGraph, "c", "1.0", "x86_64", "", pkggraph.StateMeta)
+	oldGraph.SetEdge(oldGraph.NewEdge(a, b))
+
+	newGraph := pkggraph.NewPkgGraph()
+	a = addTestRunNode(t, newGraph, "a", "1.0", "x86_64", "", pkggraph.StateMeta)
+	addTestRunNode(t, newGraph, "b", "1.0", "x86_64", "", pkggraph.StateMeta)
+	c := addTestRunNode(t, newGraph, "c", "2.0", "x86_64", "", pkggraph.StateMeta)
+	newGraph.SetEdge(newGraph.NewEdge(a, c))
+
+	diff, err := diffGraphs(oldGraph, newGraph)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c: =1.0 -> =2.0"}, diff.versionChanges)
+	assert.Equal(t, []string{"Run(a =1.0, x86_64) -> Run(c =2.0, x86_64)"}, diff.addedEdges)
+	assert.Equal(t, []string{"Run(a =1.0, x86_64) -> Run(b =1.0, x86_64)"}, diff.removedEdges)
+}
+
+func TestDiffGraphsShouldIdentifyMetaNodesByMembers(t *testing.T) {
+	oldGraph := pkggraph.NewPkgGraph()
+	a := addTestRunNode(t, oldGraph, "a", "1.0", "x86_64", "", pkggraph.StateMeta)
+	b := addTestRunNode(t, oldGraph, "b", "1.0", "x86_64", "", pkggraph.StateMeta)
+	oldGraph.AddMetaNode([]*pkggraph.PkgNode{a}, nil)
+	oldGraph.AddMetaNode([]*pkggraph.PkgNode{b}, nil)
+
+	newGraph := pkggraph.NewPkgGraph()
+	a = addTestRunNode(t, newGraph, "a", "1.0", "x86_64", "", pkggraph.StateMeta)
+	addTestRunNode(t, newGraph, "b", "1.0", "x86_64", "", pkggraph.StateMeta)
+	newGraph.AddMetaNode([]*pkggraph.PkgNode{a}, nil)
+
+	diff, err := diffGraphs(oldGraph, newGraph)
+	assert.NoError(t, err)
+	assert.Empty(t, diff.addedEdges)
+	assert.Equal(t, []string{"Run(b =1.0, x86_64) -> Meta(Run(b =1.0, x86_64))"}, diff.removedEdges)
+}
+
+func TestDiffGraphsShouldFailOnDuplicateKeys(t *testing.T) {
+	oldGraph := pkggraph.NewPkgGraph()
+	oldGraph.AddMetaNode(nil, nil)
+	oldGraph.AddMetaNode(nil, nil)
+
+	_, err := diffGraphs(oldGraph, pkggraph.NewPkgGraph())
+	assert.Error(t, err)
+}
+
+func TestDiffGraphsShouldReportWeakEdgeChanges(t *testing.T) {
+	oldGraph := pkggraph.NewPkgGraph()
+	a := addTestRunNode(t, oldGraph, "a", "1.0", "x86_64", "", pkggraph.StateMeta)
+	b := addTestRunNode(t, oldGraph, "b", "1.0", "x86_64", "", pkggraph.StateMeta)
+	addTestRunNode(t, oldGraph, "c", "1.0", "x86_64", "", pkggraph.StateMeta)
+	oldGraph.AddWeakEdge(a, b, pkggraph.WeakSuggests)
+
+	// Weak edges are not dependency edges, changing them leaves the nodes and dependency edges untouched.
+	newGraph := pkggraph.NewPkgGraph()
+	a = addTestRunNode(t, newGraph, "a", "1.0", "x86_64", "", pkggraph.StateMeta)
+	b = addTestRunNode(t, newGraph, "b", "1.0", "x86_64", "", pkggraph.StateMeta)
+	c := addTestRunNode(t, newGraph, "c", "1.0", "x86_64", "", pkggraph.StateMeta)
+	newGraph.AddWeakEdge(a, b, pkggraph.WeakRecommends)
+	newGraph.AddWeakEdge(a, c, pkggraph.WeakSupplements)
+
+	diff, err := diffGraphs(oldGraph, newGraph)
+	assert.NoError(t, err)
+	assert.Empty(t, diff.modifiedNodes)
+	assert.Empty(t, diff.addedEdges)
+	assert.Empty(t, diff.removedEdges)
+	assert.Equal(t, []string{
+		"Run(a =1.0, x86_64) -Recommends-> Run(b =1.0, x86_64)",
+		"Run(a =1.0, x86_64) -Supplements-> Run(c =1.0, x86_64)",
+	}, diff.addedWeakEdges)
+	assert.Equal(t, []string{"Run(a =1.0, x86_64) -Suggests-> Run(b =1.0, x86_64)"}, diff.removedWeakEdges)
+}
@@ -0,0 +1,159 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/topo"
+	"microsoft.com/pkggen/internal/jsonutils"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+)
+
+// Dependency types a cycle break may apply to
+const (
+	breakBuildRequires = "BuildRequires"
+	breakRequires      = "Requires"
+)
+
+// cycleBreak describes a known bootstrap dependency which may be removed when it is part of a cycle.
+// The package "From" is then built (or installed) using a copy of "To" which is already available, such as a toolchain RPM.
+type cycleBreak struct {
+	From   string `json:"From"`
+	To     string `json:"To"`
+	Type   string `json:"Type"`
+	Reason string `json:"Reason"`
+}
+
+// cyclePolicy holds the list of dependencies grapher may remove to break cycles, applied in order.
+type cyclePolicy struct {
+	BreakEdges []cycleBreak `json:"BreakEdges"`
+}
+
+// readCyclePolicy reads and validates a cycle policy file.
+func readCyclePolicy(path string) (policy *cyclePolicy, err error) {
+	policy = &cyclePolicy{}
+	err = jsonutils.ReadJSONFile(path, policy)
+	if err != nil {
+		return
+	}
+
+	for i := range policy.BreakEdges {
+		edge := &policy.BreakEdges[i]
+		if edge.Type == "" {
+			edge.Type = breakBuildRequires
+		}
+
+		if edge.From == "" || edge.To == "" {
+			err = fmt.Errorf("cycle policy entry %d must set both From and To", i)
+			return
+		}
+
+		if edge.Type != breakBuildRequires && edge.Type != breakRequires {
+			err = fmt.Errorf("cycle policy entry %d has an invalid type (%s), must be %s or %s", i, edge.Type, breakBuildRequires, breakRequires)
+			return
+		}
+	}
+
+	return
+}
+
+// cycleComponents maps the ID of every node which is part of a cycle to a non-zero identifier of its
+// strongly connected component. Nodes outside of cycles are not in the map.
+func cycleComponents(g *pkggraph.PkgGraph) (componentOf map[int64]int) {
+	componentOf = make(map[int64]int)
+	for i, component := range topo.TarjanSCC(g) {
+		if len(component) < 2 {
+			continue
+		}
+		for _, n := range component {
+			componentOf[n.ID()] = i + 1
+		}
+	}
+
+	return
+}
+
+// applyCyclePolicy removes every dependency listed in the policy which is part of a cycle. Dependencies outside of
+// cycles are kept so a policy entry never changes the build order of packages which can be built normally.
+// Entries are applied in order, an entry only applies to the cycles left by the entries before it.
+func applyCyclePolicy(g *pkggraph.PkgGraph, policy *cyclePolicy) {
+	if policy == nil {
+		return
+	}
+
+	componentOf := cycleComponents(g)
+	for _, edge := range policy.BreakEdges {
+		fromType := pkggraph.TypeBuild
+		if edge.Type == breakRequires {
+			fromType = pkggraph.TypeRun
+		}
+
+		removedEdges := 0
+		for _, fromNode := range g.AllNodes() {
+			if fromNode.Type != fromType || fromNode.VersionedPkg.Name != edge.From || componentOf[fromNode.ID()] == 0 {
+				continue
+			}
+
+			for _, to := range graph.NodesOf(g.From(fromNode.ID())) {
+				toNode := to.(*pkggraph.PkgNode)
+				if toNode.VersionedPkg == nil || toNode.VersionedPkg.Name != edge.To || componentOf[toNode.ID()] != componentOf[fromNode.ID()] {
+					continue
+				}
+
+				logger.Log.Infof("Breaking cycle by removing %s from %s to %s (%s)", edge.Type, fromNode.FriendlyName(), toNode.FriendlyName(), edge.Reason)
+				g.RemoveEdge(fromNode.ID(), toNode.ID())
+				removedEdges++
+			}
+		}
+
+		if removedEdges == 0 {
+			logger.Log.Debugf("Cycle policy %s from %s to %s did not match any cycle", edge.Type, edge.From, edge.To)
+			continue
+		}
+
+		// Removing an edge may have broken or split a cycle.
+		componentOf = cycleComponents(g)
+	}
+}
+
+// reportCycles logs every strongly connected component of the graph along with the SPEC files involved.
+// Returns the number of components found.
+func reportCycles(g *pkggraph.PkgGraph, logFunc func(format string, args ...interface{})) (componentCount int) {
+	for _, component := range topo.TarjanSCC(g) {
+		if len(component) < 2 {
+			continue
+		}
+		componentCount++
+
+		specSet := make(map[string]bool)
+		nodeNames := make([]string, 0, len(component))
+		for _, n := range component {
+			pkgNode := n.(*pkggraph.PkgNode)
+			nodeNames = append(nodeNames, pkgNode.FriendlyName())
+			if pkgNode.SpecPath != "" && pkgNode.SpecPath != "<NO_SPEC_PATH>" {
+				specSet[filepath.Base(pkgNode.SpecPath)] = true
+			}
+		}
+
+		specs := make([]string, 0, len(specSet))
+		for spec := range specSet {
+			specs = append(specs, spec)
+		}
+		sort.Strings(specs)
+		sort.Strings(nodeNames)
+
+		logFunc("Cycle group %d contains %d nodes from SPECs: %s", componentCount, len(component), strings.Join(specs, ", "))
+		for _, name := range nodeNames {
+			logFunc("\t%s", name)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,75 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"microsoft.com/pkggen/internal/pkggraph"
+	"microsoft.com/pkggen/internal/pkgjson"
+)
+
+// findTestNode returns the local node of the given type for the default build of a package.
+func findTestNode(t *testing.T, g *pkggraph.PkgGraph, name string, nodeType pkggraph.NodeType) *pkggraph.PkgNode {
+	return findTestVariantNode(t, g, name, "", nodeType)
+}
+
+// findTestVariantNode returns the local node of the given type for a variant of a package.
+func findTestVariantNode(t *testing.T, g *pkggraph.PkgGraph, name, variant string, nodeType pkggraph.NodeType) (found *pkggraph.PkgNode) {
+	for _, n := range g.AllNodes() {
+		if n.Type == nodeType && n.VersionedPkg != nil && n.VersionedPkg.Name == name && n.Variant == variant {
+			found = n
+			break
+		}
+	}
+
+	assert.NotNil(t, found, "missing node for %s", name)
+	return
+}
+
+func TestApplyCyclePolicyShouldOnlyApplyToRemainingCycles(t *testing.T) {
+	// a-BUILD -> b-RUN -> a-RUN -> a-BUILD
+	packages := []*pkgjson.Package{
+		newTestPackage("a", "1.0", "a.src.rpm", nil, []string{"b"}),
+		newTestPackage("b", "1.0", "b.src.rpm", []string{"a"}, nil),
+	}
+	policy := &cyclePolicy{BreakEdges: []cycleBreak{
+		{From: "a", To: "b", Type: breakBuildRequires},
+		{From: "b", To: "a", Type: breakRequires},
+	}}
+
+	g := pkggraph.NewPkgGraph()
+	err := populateGraph(g, &pkgjson.PackageRepo{Repo: packages})
+	assert.NoError(t, err)
+
+	applyCyclePolicy(g, policy)
+
+	aBuild := findTestNode(t, g, "a", pkggraph.TypeBuild)
+	aRun := findTestNode(t, g, "a", pkggraph.TypeRun)
+	bRun := findTestNode(t, g, "b", pkggraph.TypeRun)
+
+	// The first entry breaks the cycle, the second one no longer matches a cycle and must keep its edge.
+	assert.False(t, g.HasEdgeFromTo(aBuild.ID(), bRun.ID()))
+	assert.True(t, g.HasEdgeFromTo(bRun.ID(), aRun.ID()))
+	assert.Equal(t, 0, reportCycles(g, t.Logf))
+}
+
+func TestApplyCyclePolicyShouldKeepEdgesOutsideOfCycles(t *testing.T) {
+	packages := []*pkgjson.Package{
+		newTestPackage("a", "1.0", "a.src.rpm", nil, []string{"b"}),
+		newTestPackage("b", "1.0", "b.src.rpm", nil, nil),
+	}
+	policy := &cyclePolicy{BreakEdges: []cycleBreak{{From: "a", To: "b", Type: breakBuildRequires}}}
+
+	g := pkggraph.NewPkgGraph()
+	err := populateGraph(g, &pkgjson.PackageRepo{Repo: packages})
+	assert.NoError(t, err)
+
+	applyCyclePolicy(g, policy)
+
+	aBuild := findTestNode(t, g, "a", pkggraph.TypeBuild)
+	bRun := findTestNode(t, g, "b", pkggraph.TypeRun)
+	assert.True(t, g.HasEdgeFromTo(aBuild.ID(), bRun.ID()))
+}
@@ -4,8 +4,11 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
 	"strings"
 
 	"gonum.org/v1/gonum/graph"
@@ -26,6 +29,8 @@ var (
 	logLevel         = exe.LogLevelFlag(app)
 	strictGoals      = app.Flag("strict-goals", "Don't allow missing goal packages").Bool()
 	strictUnresolved = app.Flag("strict-unresolved", "Don't allow missing unresolved packages").Bool()
+	previousGraph    = app.Flag("previous-graph", "Optional graph from a previous run. Only packages which changed since will be updated.").ExistingFile()
+	cyclePolicyFile  = app.Flag("cycle-policy", "Optional JSON file listing known bootstrap dependencies which may be removed to break cycles.").ExistingFile()
 
 	depGraph = pkggraph.NewPkgGraph()
 )
@@ -37,18 +42,35 @@ func main() {
 	var err error
 	logger.InitBestEffort(*logFile, *logLevel)
 
+	var policy *cyclePolicy
+	if *cyclePolicyFile != "" {
+		policy, err = readCyclePolicy(*cyclePolicyFile)
+		if err != nil {
+			logger.Log.Panicf("Failed to read cycle policy file (%s): %s", *cyclePolicyFile, err)
+		}
+	}
+
 	localPackages := pkgjson.PackageRepo{}
 	err = localPackages.ParsePackageJSON(*input)
 	if err != nil {
 		logger.Log.Panic(err)
 	}
 
-	err = populateGraph(depGraph, &localPackages)
+	if *previousGraph != "" {
+		err = pkggraph.ReadDOTGraphFile(depGraph, *previousGraph)
+		if err != nil {
+			logger.Log.Panic(err)
+		}
+
+		err = refreshGraph(depGraph, &localPackages, policy)
+	} else {
+		err = populateGraph(depGraph, &localPackages)
+	}
 	if err != nil {
 		logger.Log.Panic(err)
 	}
 
-	err = validateGraph(depGraph)
+	err = validateGraph(depGraph, policy)
 	if err != nil {
 		logger.Log.Panic(err)
 	}
@@ -68,16 +90,16 @@ func main() {
 }
 
 // addUnresolvedPackage adds an unresolved node to the graph representing the
-// packged described in the PackgetVer structure. Returns an error if the node
-// could not be created.
-func addUnresolvedPackage(g *pkggraph.PkgGraph, pkgVer *pkgjson.PackageVer) (newRunNode *pkggraph.PkgNode, err error) {
+// packged described in the PackgetVer structure, as required by a package built
+// for the given architecture. Returns an error if the node could not be created.
+func addUnresolvedPackage(g *pkggraph.PkgGraph, pkgVer *pkgjson.PackageVer, arch string) (newRunNode *pkggraph.PkgNode, err error) {
 	logger.Log.Debugf("Adding unresolved %s", pkgVer)
 	if *strictUnresolved {
 		err = fmt.Errorf("strict-unresolved does not allow unresolved packages, attempting to add %s", pkgVer)
 		return
 	}
 
-	nodes, err := g.FindBestPkgNode(pkgVer)
+	nodes, err := g.FindBestPkgNodeForArch(pkgVer, arch)
 	if err != nil {
 		return
 	}
@@ -101,7 +123,12 @@ func addUnresolvedPackage(g *pkggraph.PkgGraph, pkgVer *pkgjson.PackageVer) (new
 // in the PackageVer structure. Returns pointers to the build and run Nodes
 // created, or an error if one of the nodes could not be created.
 func addNodesForPackage(g *pkggraph.PkgGraph, pkgVer *pkgjson.PackageVer, pkg *pkgjson.Package) (newRunNode *pkggraph.PkgNode, newBuildNode *pkggraph.PkgNode, err error) {
-	nodes, err := g.FindExactPkgNodeFromPkg(pkgVer)
+	hash, err := packageHash(pkg)
+	if err != nil {
+		return
+	}
+
+	nodes, err := g.FindExactPkgNodeFromPkgForVariant(pkgVer, pkg.Architecture, pkg.Variant)
 	if err != nil {
 		return
 	}
@@ -118,20 +145,22 @@ func addNodesForPackage(g *pkggraph.PkgGraph, pkgVer *pkgjson.PackageVer, pkg *p
 
 	if newRunNode == nil {
 		// Add "Run" node
-		newRunNode, err = g.AddPkgNode(pkgVer, pkggraph.StateMeta, pkggraph.TypeRun, pkg.SrpmPath, pkg.SpecPath, pkg.SourceDir, pkg.Architecture, "<LOCAL>")
+		newRunNode, err = g.AddVariantPkgNode(pkgVer, pkggraph.StateMeta, pkggraph.TypeRun, pkg.SrpmPath, pkg.SpecPath, pkg.SourceDir, pkg.Architecture, "<LOCAL>", pkg.Variant, pkg.VariantDefines)
 		logger.Log.Debugf("Adding run node %s with id %d\n", newRunNode.FriendlyName(), newRunNode.ID())
 		if err != nil {
 			return
 		}
+		newRunNode.PackageHash = hash
 	}
 
 	if newBuildNode == nil {
 		// Add "Build" node
-		newBuildNode, err = g.AddPkgNode(pkgVer, pkggraph.StateBuild, pkggraph.TypeBuild, pkg.SrpmPath, pkg.SpecPath, pkg.SourceDir, pkg.Architecture, "<LOCAL>")
+		newBuildNode, err = g.AddVariantPkgNode(pkgVer, pkggraph.StateBuild, pkggraph.TypeBuild, pkg.SrpmPath, pkg.SpecPath, pkg.SourceDir, pkg.Architecture, "<LOCAL>", pkg.Variant, pkg.VariantDefines)
 		logger.Log.Debugf("Adding build node %s with id %d\n", newBuildNode.FriendlyName(), newBuildNode.ID())
 		if err != nil {
 			return
 		}
+		newBuildNode.PackageHash = hash
 	}
 
 	// A "run" node has an implicit dependency on its coresponding "build" node, encode that here.
@@ -147,20 +176,83 @@ func addNodesForPackage(g *pkggraph.PkgGraph, pkgVer *pkgjson.PackageVer, pkg *p
 	return
 }
 
+// expandRichDependencies replaces every rich dependency in the list with the plain packages it requires, see
+// pkgjson.PackageVer.RequiredPackages. The condition of an "if" or "unless" clause is considered installed when it is
+// satisfied by one of the plain dependencies in the same list, and "or" options provided by local packages are preferred.
+func expandRichDependencies(g *pkggraph.PkgGraph, dependencies []*pkgjson.PackageVer, arch string) (expanded []*pkgjson.PackageVer) {
+	var plainDependencies []*pkgjson.PackageVer
+	for _, dependency := range dependencies {
+		if !dependency.IsRich() {
+			plainDependencies = append(plainDependencies, dependency)
+		}
+	}
+
+	installed := func(condition *pkgjson.PackageVer) bool {
+		conditionInterval, err := condition.Interval()
+		if err != nil {
+			return false
+		}
+
+		for _, dependency := range plainDependencies {
+			if dependency.Name != condition.Name {
+				continue
+			}
+			interval, err := dependency.Interval()
+			if err == nil && interval.Satisfies(&conditionInterval) {
+				return true
+			}
+		}
+		return false
+	}
+
+	available := func(pkgVer *pkgjson.PackageVer) bool {
+		nodes, err := g.FindBestPkgNodeForArch(pkgVer, arch)
+		return err == nil && nodes != nil && nodes.RunNode.Type == pkggraph.TypeRun
+	}
+
+	for _, dependency := range dependencies {
+		if !dependency.IsRich() {
+			expanded = append(expanded, dependency)
+			continue
+		}
+
+		required := dependency.RequiredPackages(installed, available)
+		logger.Log.Debugf("Rich dependency %s requires %v", dependency.Name, required)
+		expanded = append(expanded, required...)
+	}
+
+	return
+}
+
+// findDependencyNodes returns the nodes of the package which best satisfies a dependency of packageNode, see
+// FindBestPkgNodeForArch. The subpackages of a conditional build variant are installed together, so the run-time
+// dependencies of a variant are satisfied by the packages built by the same variant first.
+func findDependencyNodes(g *pkggraph.PkgGraph, packageNode *pkggraph.PkgNode, dependency *pkgjson.PackageVer) (nodes *pkggraph.LookupNode, err error) {
+	if packageNode.Variant != "" && packageNode.Type == pkggraph.TypeRun {
+		nodes, err = g.FindBestPkgNodeFromSameBuild(dependency, packageNode)
+		if err != nil || nodes != nil {
+			return
+		}
+	}
+
+	return g.FindBestPkgNodeForArch(dependency, packageNode.Architecture)
+}
+
 // addSingleDependency will add an edge between packageNode and the "Run" node for the
-// dependency described in the PackageVer structure. Returns an error if the
+// dependency described in the PackageVer structure. Providers built for the same
+// architecture as packageNode are preferred over noarch ones. Returns an error if the
 // addition failed.
 func addSingleDependency(g *pkggraph.PkgGraph, packageNode *pkggraph.PkgNode, dependency *pkgjson.PackageVer) error {
 	var dependentNode *pkggraph.PkgNode
 	logger.Log.Tracef("Adding a dependency from %+v to %+v", packageNode.VersionedPkg, dependency)
-	nodes, err := g.FindBestPkgNode(dependency)
+	nodes, err := findDependencyNodes(g, packageNode, dependency)
 	if err != nil {
 		logger.Log.Errorf("Unable to check lookup list for %+v (%s)", dependency, err)
 		return err
 	}
 
 	if nodes == nil {
-		dependentNode, err = addUnresolvedPackage(g, dependency)
+		dependentNode, err = addUnresolvedPackage(g, dependency, packageNode.Architecture)
 		if err != nil {
 			logger.Log.Errorf(`Could not add a package "%s"`, dependency.Name)
 			return err
@@ -203,7 +295,7 @@ func addPkgDependencies(g *pkggraph.PkgGraph, pkg *pkgjson.Package) (dependencie
 
 	// Find the current node in the lookup list.
 	logger.Log.Debugf("Adding dependencies for package %s", pkg.SrpmPath)
-	nodes, err := g.FindExactPkgNodeFromPkg(provide)
+	nodes, err := g.FindExactPkgNodeFromPkgForVariant(provide, pkg.Architecture, pkg.Variant)
 	if err != nil {
 		return
 	}
@@ -215,7 +307,7 @@ func addPkgDependencies(g *pkggraph.PkgGraph, pkg *pkgjson.Package) (dependencie
 
 	// For each run time and build time dependency, add the edges
 	logger.Log.Tracef("Adding run dependencies")
-	for _, dependency := range runDependencies {
+	for _, dependency := range expandRichDependencies(g, runDependencies, runNode.Architecture) {
 		err = addSingleDependency(g, runNode, dependency)
 		if err != nil {
 			logger.Log.Errorf("Unable to add run-time dependencies for %+v", pkg)
@@ -225,7 +317,7 @@ func addPkgDependencies(g *pkggraph.PkgGraph, pkg *pkgjson.Package) (dependencie
 	}
 
 	logger.Log.Tracef("Adding build dependencies")
-	for _, dependency := range buildDependencies {
+	for _, dependency := range expandRichDependencies(g, buildDependencies, buildNode.Architecture) {
 		err = addSingleDependency(g, buildNode, dependency)
 		if err != nil {
 			logger.Log.Errorf("Unable to add build-time dependencies for %+v", pkg)
@@ -269,9 +361,484 @@ func populateGraph(g *pkggraph.PkgGraph, repo *pkgjson.PackageRepo) (err error)
 	}
 	logger.Log.Infof("\tAdded %d dependencies", dependenciesAdded)
 
+	err = addWeakDependencies(g, packages)
 	return err
 }
 
+// addWeakDependencies replaces the weak edges of the graph with the resolved weak dependencies of every local package.
+// Weak dependencies only resolve to packages present in the graph, so this must run once every package and its
+// dependencies were added.
+func addWeakDependencies(g *pkggraph.PkgGraph, packages []*pkgjson.Package) (err error) {
+	g.ClearWeakEdges()
+	for _, pkg := range packages {
+		var nodes *pkggraph.LookupNode
+
+		nodes, err = g.FindExactPkgNodeFromPkgForVariant(pkg.Provides, pkg.Architecture, pkg.Variant)
+		if err != nil {
+			return
+		}
+		if nodes == nil {
+			return fmt.Errorf("can't add weak dependencies to a missing package %+v", pkg)
+		}
+
+		err = g.AddWeakDependencies(nodes.RunNode, pkggraph.WeakDependenciesFromPackage(pkg))
+		if err != nil {
+			logger.Log.Errorf("Failed to add weak dependencies of %+v", pkg)
+			return
+		}
+	}
+	logger.Log.Infof("\tAdded %d weak dependencies", len(g.AllWeakEdges()))
+
+	return
+}
+
+// packageKey returns a string which uniquely identifies the version information, architecture and build variant of a package.
+func packageKey(pkgVer *pkgjson.PackageVer, arch, variant string) (key string, err error) {
+	interval, err := pkgVer.Interval()
+	if err != nil {
+		return
+	}
+
+	key = fmt.Sprintf("%s:%s:%s:%s", pkgVer.Name, interval.String(), arch, variant)
+	return
+}
+
+// refreshGraph updates a graph generated by a previous run so it matches the data contained in the PackageRepo structure.
+// Nodes for packages which are unchanged keep their IDs and their edges. Dependencies are only resolved again for
+// packages which are new or changed, packages which depend on a package which was added or removed, and packages
+// whose edges were rewritten to break a cycle. Goal and cycle meta nodes are removed since they will be regenerated.
+// Weak dependencies are resolved again for every package, as they may point to any package of the graph.
+func refreshGraph(g *pkggraph.PkgGraph, repo *pkgjson.PackageRepo, policy *cyclePolicy) (err error) {
+	packages := repo.Repo
+
+	logger.Log.Infof("Refreshing graph with all packages from %s", *input)
+
+	// Edges rewritten to break cycles must be restored, the cycles may be gone or need to be broken differently.
+	rewrittenNodes := nodesWithRewrittenEdges(g)
+
+	// Goals and cycle fixes are regenerated after the refresh. Removing the meta nodes created for cycles will
+	// drop the edges of the nodes in the cycle, they will be restored when rewiring.
+	for _, n := range g.AllNodes() {
+		if n.Type == pkggraph.TypeGoal || n.Type == pkggraph.TypePureMeta {
+			g.RemovePkgNode(n)
+		}
+	}
+
+	// Names of the packages which were added or removed, any package depending on them must be resolved again.
+	changedProvides := make(map[string]bool)
+
+	removed, err := removeStalePackages(g, packages, changedProvides)
+	if err != nil {
+		return
+	}
+	logger.Log.Infof("\tRemoved %d packages", removed)
+
+	added, updated, changedPackages, err := refreshLocalPackages(g, packages, changedProvides)
+	if err != nil {
+		return
+	}
+	logger.Log.Infof("\tAdded %d packages, updated %d packages", added, updated)
+
+	resolvePackages, err := packagesToResolve(g, packages, changedPackages, changedProvides, rewrittenNodes, policy)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Rewiring the dependencies of %d packages from %s", len(resolvePackages), *input)
+	desiredEdges := make(map[int64]map[int64]bool)
+	rewire := make(map[int64]bool)
+	for _, pkg := range resolvePackages {
+		var nodes *pkggraph.LookupNode
+
+		nodes, err = g.FindExactPkgNodeFromPkgForVariant(pkg.Provides, pkg.Architecture, pkg.Variant)
+		if err != nil {
+			return
+		}
+		if nodes == nil {
+			return fmt.Errorf("can't add dependencies to a missing package %+v", pkg)
+		}
+		rewire[nodes.RunNode.ID()] = true
+		rewire[nodes.BuildNode.ID()] = true
+
+		err = addDesiredPkgDependencies(g, pkg, desiredEdges)
+		if err != nil {
+			logger.Log.Errorf("Failed to add dependency %+v", pkg)
+			return
+		}
+	}
+
+	edgesAdded, edgesRemoved := rewireLocalPackages(g, rewire, desiredEdges)
+	logger.Log.Infof("\tAdded %d dependencies, removed %d dependencies", edgesAdded, edgesRemoved)
+
+	// Unresolved packages nobody depends on anymore would not have been created by a full run.
+	orphans := 0
+	for _, n := range g.AllRunNodes() {
+		if n.Type == pkggraph.TypeRemote && g.To(n.ID()).Len() == 0 {
+			logger.Log.Debugf("Removing unused unresolved node %s", n.FriendlyName())
+			g.RemovePkgNode(n)
+			orphans++
+		}
+	}
+	logger.Log.Infof("\tRemoved %d unused unresolved packages", orphans)
+
+	err = addWeakDependencies(g, packages)
+	return
+}
+
+// nodesWithRewrittenEdges returns the IDs of the nodes whose edges were changed by validateGraph in a previous run:
+// members of fixed cycles, which depend on a cycle meta node, and packages built against a conditional build variant.
+func nodesWithRewrittenEdges(g *pkggraph.PkgGraph) (rewritten map[int64]bool) {
+	rewritten = make(map[int64]bool)
+	for _, n := range g.AllNodes() {
+		isCycleMeta := n.Type == pkggraph.TypePureMeta
+		isVariant := n.Type == pkggraph.TypeRun && n.Variant != ""
+		if !isCycleMeta && !isVariant {
+			continue
+		}
+
+		for _, dependent := range graph.NodesOf(g.To(n.ID())) {
+			rewritten[dependent.ID()] = true
+		}
+	}
+
+	return
+}
+
+// packagesToResolve returns the packages whose dependencies must be resolved again during a refresh.
+// changedPackages holds the keys of new and changed packages, see packageKey. changedProvides holds the names of
+// packages which were added or removed, rewrittenNodes the nodes whose edges can't be trusted.
+// Dependencies removed by a cycle policy are restored by resolving the packages they belong to again.
+func packagesToResolve(g *pkggraph.PkgGraph, packages []*pkgjson.Package, changedPackages, changedProvides map[string]bool, rewrittenNodes map[int64]bool, policy *cyclePolicy) (resolve []*pkgjson.Package, err error) {
+	policyNames := make(map[string]bool)
+	if policy != nil {
+		for _, edge := range policy.BreakEdges {
+			policyNames[edge.From] = true
+		}
+	}
+
+	// Duplicate packages share their nodes, resolve either all of them or none.
+	resolveKeys := make(map[string]bool)
+	for _, pkg := range packages {
+		var (
+			key   string
+			nodes *pkggraph.LookupNode
+		)
+
+		key, err = packageKey(pkg.Provides, pkg.Architecture, pkg.Variant)
+		if err != nil {
+			return
+		}
+
+		nodes, err = g.FindExactPkgNodeFromPkgForVariant(pkg.Provides, pkg.Architecture, pkg.Variant)
+		if err != nil {
+			return
+		}
+
+		switch {
+		case changedPackages[key], policyNames[pkg.Provides.Name]:
+			resolveKeys[key] = true
+		case nodes != nil && (rewrittenNodes[nodes.RunNode.ID()] || rewrittenNodes[nodes.BuildNode.ID()]):
+			resolveKeys[key] = true
+		case dependsOnAny(pkg, changedProvides):
+			resolveKeys[key] = true
+		}
+	}
+
+	for _, pkg := range packages {
+		var key string
+
+		key, err = packageKey(pkg.Provides, pkg.Architecture, pkg.Variant)
+		if err != nil {
+			return
+		}
+		if resolveKeys[key] {
+			resolve = append(resolve, pkg)
+		}
+	}
+
+	return
+}
+
+// dependsOnAny returns true if any run or build dependency of the package, including the packages named in
+// rich dependencies, is in names.
+func dependsOnAny(pkg *pkgjson.Package, names map[string]bool) bool {
+	if len(names) == 0 {
+		return false
+	}
+
+	var mentions func(dependency *pkgjson.PackageVer) bool
+	mentions = func(dependency *pkgjson.PackageVer) bool {
+		if !dependency.IsRich() {
+			return names[dependency.Name]
+		}
+		for _, operand := range dependency.Operands {
+			if mentions(operand) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, dependencies := range [][]*pkgjson.PackageVer{pkg.Requires, pkg.BuildRequires} {
+		for _, dependency := range dependencies {
+			if mentions(dependency) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// packageHash returns a hash of all the information of a package, used to find packages which changed since a
+// previous graph was generated.
+func packageHash(pkg *pkgjson.Package) (hash string, err error) {
+	encoded, err := json.Marshal(pkg)
+	if err != nil {
+		return
+	}
+
+	hash = fmt.Sprintf("%x", sha256.Sum256(encoded))
+	return
+}
+
+// removeStalePackages removes the run and build nodes of all local packages no longer present in the package list.
+// The names of removed default builds are added to removedProvides, variants are never picked to satisfy a dependency.
+func removeStalePackages(g *pkggraph.PkgGraph, packages []*pkgjson.Package, removedProvides map[string]bool) (removed int, err error) {
+	currentPackages := make(map[string]bool)
+	for _, pkg := range packages {
+		var key string
+		key, err = packageKey(pkg.Provides, pkg.Architecture, pkg.Variant)
+		if err != nil {
+			return
+		}
+		currentPackages[key] = true
+	}
+
+	for _, runNode := range g.AllRunNodes() {
+		var (
+			key   string
+			nodes *pkggraph.LookupNode
+		)
+
+		if runNode.Type != pkggraph.TypeRun {
+			continue
+		}
+
+		key, err = packageKey(runNode.VersionedPkg, runNode.Architecture, runNode.Variant)
+		if err != nil {
+			return
+		}
+		if currentPackages[key] {
+			continue
+		}
+
+		nodes, err = g.FindExactPkgNodeFromPkgForVariant(runNode.VersionedPkg, runNode.Architecture, runNode.Variant)
+		if err != nil {
+			return
+		}
+
+		logger.Log.Debugf("Removing stale package %s", runNode.FriendlyName())
+		if runNode.Variant == "" {
+			removedProvides[runNode.VersionedPkg.Name] = true
+		}
+		if nodes.BuildNode != nil {
+			g.RemovePkgNode(nodes.BuildNode)
+		}
+		g.RemovePkgNode(nodes.RunNode)
+		removed++
+	}
+
+	return
+}
+
+// refreshLocalPackages adds nodes for packages which are new, and updates the attributes of existing nodes whose
+// package information changed. Returns the keys of new and changed packages, see packageKey. The names of new
+// default builds are added to addedProvides.
+func refreshLocalPackages(g *pkggraph.PkgGraph, packages []*pkgjson.Package, addedProvides map[string]bool) (added, updated int, changedPackages map[string]bool, err error) {
+	// Duplicate packages reuse the nodes of the first package seen, don't let later duplicates update them.
+	refreshedPackages := make(map[string]bool)
+	changedPackages = make(map[string]bool)
+
+	for _, pkg := range packages {
+		var (
+			key   string
+			hash  string
+			nodes *pkggraph.LookupNode
+		)
+
+		key, err = packageKey(pkg.Provides, pkg.Architecture, pkg.Variant)
+		if err != nil {
+			return
+		}
+
+		hash, err = packageHash(pkg)
+		if err != nil {
+			return
+		}
+
+		nodes, err = g.FindExactPkgNodeFromPkgForVariant(pkg.Provides, pkg.Architecture, pkg.Variant)
+		if err != nil {
+			return
+		}
+
+		switch {
+		case nodes != nil:
+			if refreshedPackages[key] {
+				break
+			}
+			changed := updateLocalNode(nodes.RunNode, pkg, hash)
+			if nodes.BuildNode != nil {
+				changed = updateLocalNode(nodes.BuildNode, pkg, hash) || changed
+			}
+			if changed {
+				logger.Log.Debugf("Updating package %s", nodes.RunNode.FriendlyName())
+				changedPackages[key] = true
+				updated++
+			}
+		default:
+			var unresolvedNodes *pkggraph.LookupNode
+			unresolvedNodes, err = g.FindExactPkgNodeFromPkgForArch(pkg.Provides, "<NO_ARCHITECTURE>")
+			if err != nil {
+				return
+			}
+			if unresolvedNodes != nil && pkg.Variant == "" {
+				// A package which was previously unresolved is now provided locally
+				logger.Log.Debugf("Replacing unresolved node %s with a local package", unresolvedNodes.RunNode.FriendlyName())
+				g.RemovePkgNode(unresolvedNodes.RunNode)
+			}
+
+			err = addLocalPackage(g, pkg)
+			if err != nil {
+				logger.Log.Errorf("Failed to add local package %+v", pkg)
+				return
+			}
+			if pkg.Variant == "" {
+				addedProvides[pkg.Provides.Name] = true
+			}
+			changedPackages[key] = true
+			added++
+		}
+
+		refreshedPackages[key] = true
+	}
+
+	return
+}
+
+// updateLocalNode updates the package information of a local node. Returns true if any information changed,
+// including the dependencies recorded in the package hash.
+func updateLocalNode(n *pkggraph.PkgNode, pkg *pkgjson.Package, hash string) (changed bool) {
+	changed = n.SrpmPath != pkg.SrpmPath ||
+		n.SpecPath != pkg.SpecPath ||
+		n.SourceDir != pkg.SourceDir ||
+		n.Architecture != pkg.Architecture ||
+		n.PackageHash != hash ||
+		!reflect.DeepEqual(n.VariantDefines, pkg.VariantDefines)
+
+	n.SrpmPath = pkg.SrpmPath
+	n.SpecPath = pkg.SpecPath
+	n.SourceDir = pkg.SourceDir
+	n.Architecture = pkg.Architecture
+	n.PackageHash = hash
+	n.VariantDefines = pkg.VariantDefines
+
+	return
+}
+
+// addDesiredPkgDependencies records the edges the run and build nodes of a package should have, creating unresolved
+// nodes for any dependency which can't be satisfied locally.
+func addDesiredPkgDependencies(g *pkggraph.PkgGraph, pkg *pkgjson.Package, desiredEdges map[int64]map[int64]bool) (err error) {
+	nodes, err := g.FindExactPkgNodeFromPkgForVariant(pkg.Provides, pkg.Architecture, pkg.Variant)
+	if err != nil {
+		return
+	}
+	if nodes == nil {
+		return fmt.Errorf("can't add dependencies to a missing package %+v", pkg)
+	}
+
+	addDesiredEdge := func(from, to *pkggraph.PkgNode) {
+		if desiredEdges[from.ID()] == nil {
+			desiredEdges[from.ID()] = make(map[int64]bool)
+		}
+		desiredEdges[from.ID()][to.ID()] = true
+	}
+
+	// A "run" node has an implicit dependency on its coresponding "build" node.
+	addDesiredEdge(nodes.RunNode, nodes.BuildNode)
+
+	dependencySets := []struct {
+		node         *pkggraph.PkgNode
+		dependencies []*pkgjson.PackageVer
+	}{
+		{nodes.RunNode, pkg.Requires},
+		{nodes.BuildNode, pkg.BuildRequires},
+	}
+
+	for _, dependencySet := range dependencySets {
+		for _, dependency := range expandRichDependencies(g, dependencySet.dependencies, dependencySet.node.Architecture) {
+			var (
+				dependentNode *pkggraph.PkgNode
+				depNodes      *pkggraph.LookupNode
+			)
+
+			depNodes, err = findDependencyNodes(g, dependencySet.node, dependency)
+			if err != nil {
+				logger.Log.Errorf("Unable to check lookup list for %+v (%s)", dependency, err)
+				return
+			}
+
+			if depNodes == nil {
+				dependentNode, err = addUnresolvedPackage(g, dependency, dependencySet.node.Architecture)
+				if err != nil {
+					logger.Log.Errorf(`Could not add a package "%s"`, dependency.Name)
+					return
+				}
+			} else {
+				// All dependencies are assumed to be "Run" dependencies
+				dependentNode = depNodes.RunNode
+			}
+
+			if dependentNode.ID() == dependencySet.node.ID() {
+				logger.Log.Warnf("Package %+v requires itself!", dependencySet.node)
+				continue
+			}
+
+			addDesiredEdge(dependencySet.node, dependentNode)
+		}
+	}
+
+	return
+}
+
+// rewireLocalPackages updates the edges of the nodes in rewire to match desiredEdges.
+func rewireLocalPackages(g *pkggraph.PkgGraph, rewire map[int64]bool, desiredEdges map[int64]map[int64]bool) (edgesAdded, edgesRemoved int) {
+	for _, n := range g.AllNodes() {
+		if !rewire[n.ID()] {
+			continue
+		}
+
+		desired := desiredEdges[n.ID()]
+		for _, dependency := range graph.NodesOf(g.From(n.ID())) {
+			if !desired[dependency.ID()] {
+				logger.Log.Tracef("Removing dependency from %s to %s", n.FriendlyName(), dependency.(*pkggraph.PkgNode).FriendlyName())
+				g.RemoveEdge(n.ID(), dependency.ID())
+				edgesRemoved++
+			}
+		}
+
+		for id := range desired {
+			if !g.HasEdgeFromTo(n.ID(), id) {
+				logger.Log.Tracef("Adding dependency from %s to %s", n.FriendlyName(), g.Node(id).(*pkggraph.PkgNode).FriendlyName())
+				g.SetEdge(g.NewEdge(n, g.Node(id)))
+				edgesAdded++
+			}
+		}
+	}
+
+	return
+}
+
 // fixCycle attempts to fix a cycle. Cycles may be acceptable if all nodes are from the same spec file.
 // If a cycle can be fixed an additional meta node will be added to represent the interdependencies of the cycle.
 func fixCycle(g *pkggraph.PkgGraph, cycle []*pkggraph.PkgNode) (err error) {
@@ -315,8 +882,23 @@ func fixCycle(g *pkggraph.PkgGraph, cycle []*pkggraph.PkgNode) (err error) {
 	return
 }
 
-// validateGraph makes sure the graph is a directed acyclic graph (DAG)
-func validateGraph(g *pkggraph.PkgGraph) (err error) {
+// validateGraph makes sure the graph is a directed acyclic graph (DAG). Build dependencies which are part of a
+// cycle are first satisfied by conditional build variants (such as bootstrap builds) where possible, and any variants
+// which are not needed are removed from the graph. Dependencies listed in the cycle policy are removed next, then
+// any remaining cycles are fixed if possible.
+func validateGraph(g *pkggraph.PkgGraph, policy *cyclePolicy) (err error) {
+	err = useVariantsForCycles(g)
+	if err != nil {
+		return
+	}
+	removeUnusedVariants(g)
+
+	applyCyclePolicy(g, policy)
+
+	if reportCycles(g, logger.Log.Infof) > 0 {
+		logger.Log.Info("Attempting to fix the cycles above")
+	}
+
 	cycles := topo.DirectedCyclesIn(g)
 
 	// Try to fix the cycles if we can before reporting them
@@ -346,6 +928,7 @@ func validateGraph(g *pkggraph.PkgGraph) (err error) {
 		}
 
 		if unfixableCycleCount > 0 {
+			reportCycles(g, logger.Log.Errorf)
 			err = fmt.Errorf("cycles detected in dependency graph")
 			return err
 		}
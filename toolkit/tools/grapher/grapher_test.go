@@ -0,0 +1,307 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gonum.org/v1/gonum/graph"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+	"microsoft.com/pkggen/internal/pkgjson"
+)
+
+const testArch = "x86_64"
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+// newTestPackage returns a local package built from srpm. Dependencies are package names.
+func newTestPackage(name, version, srpm string, requires, buildRequires []string) *pkgjson.Package {
+	toPackageVers := func(names []string) (pkgVers []*pkgjson.PackageVer) {
+		for _, name := range names {
+			pkgVers = append(pkgVers, &pkgjson.PackageVer{Name: name})
+		}
+		return
+	}
+
+	return &pkgjson.Package{
+		Provides:      &pkgjson.PackageVer{Name: name, Version: version, Condition: "="},
+		SrpmPath:      srpm,
+		SpecPath:      strings.TrimSuffix(srpm, ".src.rpm") + ".spec",
+		SourceDir:     "SOURCES",
+		Architecture:  testArch,
+		Requires:      toPackageVers(requires),
+		BuildRequires: toPackageVers(buildRequires),
+	}
+}
+
+// basePackages returns a set of packages with a local dependency, an unresolved dependency and a run-time cycle
+// between two packages of the same SRPM.
+func basePackages() []*pkgjson.Package {
+	return []*pkgjson.Package{
+		newTestPackage("a", "1.0", "a.src.rpm", []string{"b"}, []string{"c"}),
+		newTestPackage("b", "1.0", "b.src.rpm", nil, nil),
+		newTestPackage("c", "1.0", "c.src.rpm", nil, []string{"d"}),
+		newTestPackage("e", "1.0", "ef.src.rpm", []string{"f"}, nil),
+		newTestPackage("f", "1.0", "ef.src.rpm", []string{"e"}, nil),
+	}
+}
+
+// buildTestGraph generates a full graph for packages, the way grapher does without a previous graph.
+func buildTestGraph(t *testing.T, packages []*pkgjson.Package) *pkggraph.PkgGraph {
+	g := pkggraph.NewPkgGraph()
+	err := populateGraph(g, &pkgjson.PackageRepo{Repo: packages})
+	assert.NoError(t, err)
+
+	err = validateGraph(g, nil)
+	assert.NoError(t, err)
+
+	return g
+}
+
+// refreshTestGraph refreshes a graph written by a previous run with packages.
+func refreshTestGraph(t *testing.T, previous *pkggraph.PkgGraph, packages []*pkgjson.Package, policy *cyclePolicy) *pkggraph.PkgGraph {
+	var buf bytes.Buffer
+	err := pkggraph.WriteDOTGraph(previous, &buf)
+	assert.NoError(t, err)
+
+	g := pkggraph.NewPkgGraph()
+	err = pkggraph.ReadDOTGraph(g, &buf)
+	assert.NoError(t, err)
+
+	err = refreshGraph(g, &pkgjson.PackageRepo{Repo: packages}, policy)
+	assert.NoError(t, err)
+
+	err = validateGraph(g, policy)
+	assert.NoError(t, err)
+
+	return g
+}
+
+// describeGraph returns the sorted nodes and edges, including weak edges, of a graph independently of node IDs.
+// Meta nodes are described by the nodes depending on them.
+func describeGraph(g *pkggraph.PkgGraph) (nodes, edges []string) {
+	nodeName := func(n *pkggraph.PkgNode) string {
+		if n.Type != pkggraph.TypePureMeta {
+			return n.FriendlyName()
+		}
+
+		var members []string
+		for _, member := range graph.NodesOf(g.To(n.ID())) {
+			members = append(members, member.(*pkggraph.PkgNode).FriendlyName())
+		}
+		sort.Strings(members)
+		return fmt.Sprintf("Meta{%s}", strings.Join(members, ","))
+	}
+
+	for _, n := range g.AllNodes() {
+		nodes = append(nodes, nodeName(n))
+		for _, to := range graph.NodesOf(g.From(n.ID())) {
+			edges = append(edges, fmt.Sprintf("%s -> %s", nodeName(n), nodeName(to.(*pkggraph.PkgNode))))
+		}
+	}
+
+	for _, edge := range g.AllWeakEdges() {
+		edges = append(edges, fmt.Sprintf("%s -%s-> %s", nodeName(edge.From), edge.Kind, nodeName(edge.To)))
+	}
+
+	sort.Strings(nodes)
+	sort.Strings(edges)
+	return
+}
+
+func TestRefreshGraphShouldMatchFullBuild(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(packages []*pkgjson.Package) []*pkgjson.Package
+	}{
+		{
+			name: "unchanged",
+			modify: func(packages []*pkgjson.Package) []*pkgjson.Package {
+				return packages
+			},
+		},
+		{
+			name: "changed requires",
+			modify: func(packages []*pkgjson.Package) []*pkgjson.Package {
+				packages[0].Requires = append(packages[0].Requires, &pkgjson.PackageVer{Name: "c"})
+				return packages
+			},
+		},
+		{
+			name: "unresolved package added",
+			modify: func(packages []*pkgjson.Package) []*pkgjson.Package {
+				return append(packages, newTestPackage("d", "1.0", "d.src.rpm", nil, nil))
+			},
+		},
+		{
+			name: "package removed",
+			modify: func(packages []*pkgjson.Package) []*pkgjson.Package {
+				return append(packages[:1], packages[2:]...)
+			},
+		},
+		{
+			name: "version bump",
+			modify: func(packages []*pkgjson.Package) []*pkgjson.Package {
+				packages[1] = newTestPackage("b", "2.0", "b.src.rpm", nil, nil)
+				return packages
+			},
+		},
+		{
+			name: "weak dependency added",
+			modify: func(packages []*pkgjson.Package) []*pkgjson.Package {
+				packages[1].Recommends = []*pkgjson.PackageVer{{Name: "a"}}
+				return packages
+			},
+		},
+		{
+			name: "cycle removed",
+			modify: func(packages []*pkgjson.Package) []*pkgjson.Package {
+				packages[4].Requires = nil
+				return packages
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			previous := buildTestGraph(t, basePackages())
+
+			refreshed := refreshTestGraph(t, previous, test.modify(basePackages()), nil)
+			fresh := buildTestGraph(t, test.modify(basePackages()))
+
+			refreshedNodes, refreshedEdges := describeGraph(refreshed)
+			freshNodes, freshEdges := describeGraph(fresh)
+			assert.Equal(t, freshNodes, refreshedNodes)
+			assert.Equal(t, freshEdges, refreshedEdges)
+		})
+	}
+}
+
+func TestWeakDependenciesShouldNotCreateCycles(t *testing.T) {
+	packages := basePackages()
+	packages[1].Recommends = []*pkgjson.PackageVer{{Name: "a"}}
+	packages[2].Supplements = []*pkgjson.PackageVer{{Name: "b"}}
+
+	// b recommending a would close a cycle with a requiring b if it were a dependency edge.
+	g := buildTestGraph(t, packages)
+	_, edges := describeGraph(g)
+	assert.Contains(t, edges, "b-1.0-RUN<Meta> -Recommends-> a-1.0-RUN<Meta>")
+	assert.Contains(t, edges, "b-1.0-RUN<Meta> -Supplements-> c-1.0-RUN<Meta>")
+	assert.Len(t, g.AllWeakEdges(), 2)
+}
+
+func TestRefreshGraphShouldRestoreEdgesRemovedByCyclePolicy(t *testing.T) {
+	packages := []*pkgjson.Package{
+		newTestPackage("a", "1.0", "a.src.rpm", nil, []string{"b"}),
+		newTestPackage("b", "1.0", "b.src.rpm", []string{"a"}, nil),
+	}
+	policy := &cyclePolicy{BreakEdges: []cycleBreak{{From: "a", To: "b", Type: breakBuildRequires}}}
+
+	previous := pkggraph.NewPkgGraph()
+	err := populateGraph(previous, &pkgjson.PackageRepo{Repo: packages})
+	assert.NoError(t, err)
+	err = validateGraph(previous, policy)
+	assert.NoError(t, err)
+
+	// Without the policy the cycle is unfixable, so the removed edge must have been restored.
+	var buf bytes.Buffer
+	err = pkggraph.WriteDOTGraph(previous, &buf)
+	assert.NoError(t, err)
+
+	g := pkggraph.NewPkgGraph()
+	err = pkggraph.ReadDOTGraph(g, &buf)
+	assert.NoError(t, err)
+
+	err = refreshGraph(g, &pkgjson.PackageRepo{Repo: packages}, policy)
+	assert.NoError(t, err)
+
+	fresh := pkggraph.NewPkgGraph()
+	err = populateGraph(fresh, &pkgjson.PackageRepo{Repo: packages})
+	assert.NoError(t, err)
+
+	refreshedNodes, refreshedEdges := describeGraph(g)
+	freshNodes, freshEdges := describeGraph(fresh)
+	assert.Equal(t, freshNodes, refreshedNodes)
+	assert.Equal(t, freshEdges, refreshedEdges)
+}
+
+func TestPackagesToResolveShouldOnlyIncludeAffectedPackages(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(packages []*pkgjson.Package) []*pkgjson.Package
+		expected []string
+	}{
+		{
+			name: "unchanged",
+			modify: func(packages []*pkgjson.Package) []*pkgjson.Package {
+				return packages
+			},
+			expected: []string{"e", "f"},
+		},
+		{
+			name: "changed requires",
+			modify: func(packages []*pkgjson.Package) []*pkgjson.Package {
+				packages[1].Requires = []*pkgjson.PackageVer{{Name: "z"}}
+				return packages
+			},
+			expected: []string{"b", "e", "f"},
+		},
+		{
+			name: "unresolved package added",
+			modify: func(packages []*pkgjson.Package) []*pkgjson.Package {
+				return append(packages, newTestPackage("d", "1.0", "d.src.rpm", nil, nil))
+			},
+			expected: []string{"c", "d", "e", "f"},
+		},
+		{
+			name: "package removed",
+			modify: func(packages []*pkgjson.Package) []*pkgjson.Package {
+				return append(packages[:1], packages[2:]...)
+			},
+			expected: []string{"a", "e", "f"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := buildTestGraph(t, basePackages())
+			packages := test.modify(basePackages())
+
+			// e and f form a fixed cycle, their edges were moved to a meta node and are always restored.
+			rewrittenNodes := nodesWithRewrittenEdges(g)
+			for _, n := range g.AllNodes() {
+				if n.Type == pkggraph.TypePureMeta {
+					g.RemovePkgNode(n)
+				}
+			}
+
+			changedProvides := make(map[string]bool)
+			_, err := removeStalePackages(g, packages, changedProvides)
+			assert.NoError(t, err)
+
+			_, _, changedPackages, err := refreshLocalPackages(g, packages, changedProvides)
+			assert.NoError(t, err)
+
+			resolve, err := packagesToResolve(g, packages, changedPackages, changedProvides, rewrittenNodes, nil)
+			assert.NoError(t, err)
+
+			var names []string
+			for _, pkg := range resolve {
+				names = append(names, pkg.Provides.Name)
+			}
+			sort.Strings(names)
+			assert.Equal(t, test.expected, names)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"sort"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/topo"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+)
+
+// variantEdge is a build dependency which is part of a cycle, along with the run node of a conditional build variant
+// which can satisfy it instead.
+type variantEdge struct {
+	buildNode  *pkggraph.PkgNode
+	runNode    *pkggraph.PkgNode
+	variantRun *pkggraph.PkgNode
+}
+
+// useVariantsForCycles breaks cycles by building packages against conditional build variants of their dependencies,
+// such as a bootstrap build of the same SPEC. A build dependency which is part of a cycle is redirected to a variant
+// of the dependency if the variant does not itself depend on any package in the cycle. One dependency is redirected
+// at a time, in a stable order, until no more cycles can be broken this way.
+func useVariantsForCycles(g *pkggraph.PkgGraph) (err error) {
+	for {
+		var (
+			edge  *variantEdge
+			found bool
+		)
+
+		edge, found, err = findVariantEdge(g)
+		if err != nil || !found {
+			return
+		}
+
+		logger.Log.Infof("Breaking cycle by building %s with the (%s) variant of %s", edge.buildNode.FriendlyName(), edge.variantRun.Variant, edge.runNode.FriendlyName())
+		g.RemoveEdge(edge.buildNode.ID(), edge.runNode.ID())
+		g.SetEdge(g.NewEdge(edge.buildNode, edge.variantRun))
+	}
+}
+
+// findVariantEdge returns the first build dependency in a cycle which can be satisfied by a variant instead.
+func findVariantEdge(g *pkggraph.PkgGraph) (edge *variantEdge, found bool, err error) {
+	for _, component := range sortedComponents(g) {
+		componentIDs := make(map[int64]bool)
+		for _, n := range component {
+			componentIDs[n.ID()] = true
+		}
+
+		for _, buildNode := range component {
+			if buildNode.Type != pkggraph.TypeBuild {
+				continue
+			}
+
+			for _, runNode := range sortedNodes(graph.NodesOf(g.From(buildNode.ID()))) {
+				var variants []*pkggraph.LookupNode
+
+				if runNode.Type != pkggraph.TypeRun || runNode.Variant != "" || !componentIDs[runNode.ID()] {
+					continue
+				}
+
+				variants, err = g.FindVariantsOf(runNode)
+				if err != nil {
+					return
+				}
+
+				for _, variant := range variants {
+					if !reachesAny(g, variant.RunNode, componentIDs) {
+						edge = &variantEdge{buildNode: buildNode, runNode: runNode, variantRun: variant.RunNode}
+						found = true
+						return
+					}
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// removeUnusedVariants removes the nodes of every conditional build variant which no package depends on.
+func removeUnusedVariants(g *pkggraph.PkgGraph) {
+	removed := 0
+	for _, n := range g.AllRunNodes() {
+		if n.Variant == "" || g.To(n.ID()).Len() != 0 {
+			continue
+		}
+
+		nodes, err := g.FindExactPkgNodeFromPkgForVariant(n.VersionedPkg, n.Architecture, n.Variant)
+		if err != nil || nodes == nil {
+			logger.Log.Warnf("Unable to find the nodes of variant %s: %v", n.FriendlyName(), err)
+			continue
+		}
+
+		logger.Log.Debugf("Removing unused variant %s", n.FriendlyName())
+		if nodes.BuildNode != nil {
+			g.RemovePkgNode(nodes.BuildNode)
+		}
+		g.RemovePkgNode(nodes.RunNode)
+		removed++
+	}
+
+	if removed > 0 {
+		logger.Log.Infof("Removed %d unused build variants", removed)
+	}
+}
+
+// reachesAny returns true if any of the nodes in targets can be reached from start.
+func reachesAny(g *pkggraph.PkgGraph, start *pkggraph.PkgNode, targets map[int64]bool) bool {
+	for _, n := range g.AllNodesFrom(start) {
+		if targets[n.ID()] {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedComponents returns every strongly connected component of the graph with more than one node. The nodes of
+// each component, and the components themselves, are ordered by node ID.
+func sortedComponents(g *pkggraph.PkgGraph) (components [][]*pkggraph.PkgNode) {
+	for _, component := range topo.TarjanSCC(g) {
+		if len(component) < 2 {
+			continue
+		}
+		components = append(components, sortedNodes(component))
+	}
+
+	sort.Slice(components, func(i, j int) bool {
+		return components[i][0].ID() < components[j][0].ID()
+	})
+	return
+}
+
+// sortedNodes converts a list of graph nodes into package nodes ordered by ID.
+func sortedNodes(nodes []graph.Node) (pkgNodes []*pkggraph.PkgNode) {
+	pkgNodes = make([]*pkggraph.PkgNode, 0, len(nodes))
+	for _, n := range nodes {
+		pkgNodes = append(pkgNodes, n.(*pkggraph.PkgNode).This)
+	}
+
+	sort.Slice(pkgNodes, func(i, j int) bool {
+		return pkgNodes[i].ID() < pkgNodes[j].ID()
+	})
+	return
+}
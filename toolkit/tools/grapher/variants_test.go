@@ -0,0 +1,182 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"microsoft.com/pkggen/internal/pkggraph"
+	"microsoft.com/pkggen/internal/pkgjson"
+)
+
+// newTestVariant returns the package built by the bootstrap variant of a SPEC.
+func newTestVariant(name, version, srpm string, requires, buildRequires []string) *pkgjson.Package {
+	pkg := newTestPackage(name, version, srpm, requires, buildRequires)
+	pkg.Variant = "bootstrap"
+	pkg.VariantDefines = map[string]string{"_with_bootstrap": "--with-bootstrap"}
+	return pkg
+}
+
+// variantTestGraph returns a graph with a build cycle between a and b: a-BUILD -> b-RUN -> b-BUILD -> a-RUN -> a-BUILD.
+func variantTestGraph(t *testing.T, variant *pkgjson.Package) *pkggraph.PkgGraph {
+	packages := []*pkgjson.Package{
+		newTestPackage("a", "1.0", "a.src.rpm", nil, []string{"b"}),
+		newTestPackage("b", "1.0", "b.src.rpm", nil, []string{"a"}),
+	}
+	if variant != nil {
+		packages = append(packages, variant)
+	}
+
+	g := pkggraph.NewPkgGraph()
+	err := populateGraph(g, &pkgjson.PackageRepo{Repo: packages})
+	assert.NoError(t, err)
+	return g
+}
+
+func TestUseVariantsForCyclesShouldBuildAgainstVariant(t *testing.T) {
+	g := variantTestGraph(t, newTestVariant("b", "1.0", "b.src.rpm", nil, nil))
+
+	err := useVariantsForCycles(g)
+	assert.NoError(t, err)
+
+	aBuild := findTestNode(t, g, "a", pkggraph.TypeBuild)
+	aRun := findTestNode(t, g, "a", pkggraph.TypeRun)
+	bBuild := findTestNode(t, g, "b", pkggraph.TypeBuild)
+	bRun := findTestNode(t, g, "b", pkggraph.TypeRun)
+	bootstrapRun := findTestVariantNode(t, g, "b", "bootstrap", pkggraph.TypeRun)
+	bootstrapBuild := findTestVariantNode(t, g, "b", "bootstrap", pkggraph.TypeBuild)
+
+	// a is built against the bootstrap build of b, the default build of b still needs a.
+	assert.False(t, g.HasEdgeFromTo(aBuild.ID(), bRun.ID()))
+	assert.True(t, g.HasEdgeFromTo(aBuild.ID(), bootstrapRun.ID()))
+	assert.True(t, g.HasEdgeFromTo(bootstrapRun.ID(), bootstrapBuild.ID()))
+	assert.True(t, g.HasEdgeFromTo(bBuild.ID(), aRun.ID()))
+	assert.Equal(t, 0, reportCycles(g, t.Logf))
+
+	// The variant is in use and must be kept.
+	removeUnusedVariants(g)
+	assert.NotNil(t, findTestVariantNode(t, g, "b", "bootstrap", pkggraph.TypeBuild))
+}
+
+func TestUseVariantsForCyclesShouldIgnoreVariantsInCycle(t *testing.T) {
+	// The bootstrap build of b still needs a, so it can not break the cycle.
+	g := variantTestGraph(t, newTestVariant("b", "1.0", "b.src.rpm", nil, []string{"a"}))
+
+	err := useVariantsForCycles(g)
+	assert.NoError(t, err)
+
+	aBuild := findTestNode(t, g, "a", pkggraph.TypeBuild)
+	bRun := findTestNode(t, g, "b", pkggraph.TypeRun)
+	assert.True(t, g.HasEdgeFromTo(aBuild.ID(), bRun.ID()))
+	assert.Equal(t, 1, reportCycles(g, t.Logf))
+}
+
+func TestRemoveUnusedVariantsShouldRemoveVariantNodes(t *testing.T) {
+	packages := []*pkgjson.Package{
+		newTestPackage("a", "1.0", "a.src.rpm", nil, []string{"b"}),
+		newTestPackage("b", "1.0", "b.src.rpm", nil, nil),
+		newTestVariant("b", "1.0", "b.src.rpm", nil, nil),
+	}
+
+	g := pkggraph.NewPkgGraph()
+	err := populateGraph(g, &pkgjson.PackageRepo{Repo: packages})
+	assert.NoError(t, err)
+
+	// Without a cycle the variant is never used.
+	err = useVariantsForCycles(g)
+	assert.NoError(t, err)
+	removeUnusedVariants(g)
+
+	for _, n := range g.AllNodes() {
+		assert.Empty(t, n.Variant, "unexpected variant node %s", n.FriendlyName())
+	}
+
+	lookup, err := g.FindExactPkgNodeFromPkgForVariant(packages[2].Provides, testArch, "bootstrap")
+	assert.NoError(t, err)
+	assert.Nil(t, lookup)
+
+	aBuild := findTestNode(t, g, "a", pkggraph.TypeBuild)
+	bRun := findTestNode(t, g, "b", pkggraph.TypeRun)
+	assert.True(t, g.HasEdgeFromTo(aBuild.ID(), bRun.ID()))
+	assert.NotNil(t, findTestNode(t, g, "b", pkggraph.TypeBuild))
+}
+
+func TestValidateGraphShouldBreakCyclesWithVariants(t *testing.T) {
+	g := variantTestGraph(t, newTestVariant("b", "1.0", "b.src.rpm", nil, nil))
+
+	err := validateGraph(g, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reportCycles(g, t.Logf))
+}
+
+func TestValidateGraphShouldFailOnUnbreakableBuildCycle(t *testing.T) {
+	g := variantTestGraph(t, nil)
+
+	err := validateGraph(g, nil)
+	assert.Error(t, err)
+}
+
+// subpackageVariantPackages returns a build cycle through the subpackages of a: a-BUILD -> b-RUN -> b-BUILD ->
+// a-devel-RUN -> a-RUN -> a-BUILD, along with a bootstrap variant of a whose a-devel requires its sibling a.
+func subpackageVariantPackages() []*pkgjson.Package {
+	return []*pkgjson.Package{
+		newTestPackage("a", "1.0", "a.src.rpm", nil, []string{"b"}),
+		newTestPackage("a-devel", "1.0", "a.src.rpm", []string{"a"}, []string{"b"}),
+		newTestPackage("b", "1.0", "b.src.rpm", nil, []string{"a-devel"}),
+		newTestVariant("a", "1.0", "a.src.rpm", nil, nil),
+		newTestVariant("a-devel", "1.0", "a.src.rpm", []string{"a"}, nil),
+	}
+}
+
+func TestVariantRequiresShouldResolveToSameVariant(t *testing.T) {
+	g := buildTestGraph(t, subpackageVariantPackages())
+
+	aRun := findTestNode(t, g, "a", pkggraph.TypeRun)
+	aDevelRun := findTestNode(t, g, "a-devel", pkggraph.TypeRun)
+	bootstrapRun := findTestVariantNode(t, g, "a", "bootstrap", pkggraph.TypeRun)
+	bootstrapDevelRun := findTestVariantNode(t, g, "a-devel", "bootstrap", pkggraph.TypeRun)
+
+	// Each build of a-devel requires the a built alongside it.
+	assert.True(t, g.HasEdgeFromTo(aDevelRun.ID(), aRun.ID()))
+	assert.False(t, g.HasEdgeFromTo(aDevelRun.ID(), bootstrapRun.ID()))
+	assert.True(t, g.HasEdgeFromTo(bootstrapDevelRun.ID(), bootstrapRun.ID()))
+	assert.False(t, g.HasEdgeFromTo(bootstrapDevelRun.ID(), aRun.ID()))
+
+	// The cycle is broken by building b against the bootstrap build of a.
+	assert.Equal(t, 0, reportCycles(g, t.Logf))
+	bBuild := findTestNode(t, g, "b", pkggraph.TypeBuild)
+	assert.True(t, g.HasEdgeFromTo(bBuild.ID(), bootstrapDevelRun.ID()))
+}
+
+func TestVariantRequiresShouldFallBackToDefaultBuild(t *testing.T) {
+	packages := subpackageVariantPackages()
+	packages[4] = newTestVariant("a-devel", "1.0", "a.src.rpm", []string{"b"}, nil)
+
+	g := pkggraph.NewPkgGraph()
+	err := populateGraph(g, &pkgjson.PackageRepo{Repo: packages})
+	assert.NoError(t, err)
+
+	bRun := findTestNode(t, g, "b", pkggraph.TypeRun)
+	bootstrapDevelRun := findTestVariantNode(t, g, "a-devel", "bootstrap", pkggraph.TypeRun)
+	assert.True(t, g.HasEdgeFromTo(bootstrapDevelRun.ID(), bRun.ID()))
+}
+
+func TestRefreshGraphShouldResolveVariantRequiresToSameVariant(t *testing.T) {
+	packages := subpackageVariantPackages()
+	packages[4] = newTestVariant("a-devel", "1.0", "a.src.rpm", nil, nil)
+	previous := buildTestGraph(t, packages)
+
+	refreshed := refreshTestGraph(t, previous, subpackageVariantPackages(), nil)
+	fresh := buildTestGraph(t, subpackageVariantPackages())
+
+	refreshedNodes, refreshedEdges := describeGraph(refreshed)
+	freshNodes, freshEdges := describeGraph(fresh)
+	assert.Equal(t, freshNodes, refreshedNodes)
+	assert.Equal(t, freshEdges, refreshedEdges)
+
+	bootstrapRun := findTestVariantNode(t, refreshed, "a", "bootstrap", pkggraph.TypeRun)
+	bootstrapDevelRun := findTestVariantNode(t, refreshed, "a-devel", "bootstrap", pkggraph.TypeRun)
+	assert.True(t, refreshed.HasEdgeFromTo(bootstrapDevelRun.ID(), bootstrapRun.ID()))
+}
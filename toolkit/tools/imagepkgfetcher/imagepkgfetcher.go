@@ -7,6 +7,7 @@ import (
 	"os"
 	"strings"
 
+	"gonum.org/v1/gonum/graph"
 	"gopkg.in/alecthomas/kingpin.v2"
 	"microsoft.com/pkggen/imagegen/configuration"
 	"microsoft.com/pkggen/imagegen/installutils"
@@ -38,8 +39,9 @@ var (
 	tlsClientCert = app.Flag("tls-cert", "TLS client certificate to use when downloading files.").String()
 	tlsClientKey  = app.Flag("tls-key", "TLS client key to use when downloading files.").String()
 
-	externalOnly = app.Flag("external-only", "Only clone packages not provided locally.").Bool()
-	inputGraph   = app.Flag("package-graph", "Path to the graph file to read, only needed if external-only is set.").ExistingFile()
+	externalOnly    = app.Flag("external-only", "Only clone packages not provided locally.").Bool()
+	includeWeakDeps = app.Flag("include-weak-deps", "Also clone the Recommends and Supplements of the packages in the image, as tdnf would install them.").Bool()
+	inputGraph      = app.Flag("package-graph", "Path to the graph file to read, only needed if external-only or include-weak-deps is set.").ExistingFile()
 
 	inputSummaryFile  = app.Flag("input-summary-file", "Path to a file with the summary of packages cloned to be restored").String()
 	outputSummaryFile = app.Flag("output-summary-file", "Path to save the summary of packages cloned").String()
@@ -57,6 +59,10 @@ func main() {
 		logger.Log.Fatal("input-graph must be provided if external-only is set.")
 	}
 
+	if *includeWeakDeps && strings.TrimSpace(*inputGraph) == "" {
+		logger.Log.Fatal("input-graph must be provided if include-weak-deps is set.")
+	}
+
 	cloner := rpmrepocloner.New()
 	err := cloner.Initialize(*outDir, *tmpDir, *workertar, *existingRpmDir, *useUpdateRepo, *usePreviewRepo, *repoFiles)
 	if err != nil {
@@ -76,7 +82,7 @@ func main() {
 		// If an input summary file was provided, simply restore the cache using the file.
 		err = repoutils.RestoreClonedRepoContents(cloner, *inputSummaryFile)
 	} else {
-		err = cloneSystemConfigs(cloner, *configFile, *baseDirPath, *externalOnly, *inputGraph)
+		err = cloneSystemConfigs(cloner, *configFile, *baseDirPath, *externalOnly, *includeWeakDeps, *inputGraph)
 	}
 
 	if err != nil {
@@ -95,7 +101,7 @@ func main() {
 	}
 }
 
-func cloneSystemConfigs(cloner repocloner.RepoCloner, configFile, baseDirPath string, externalOnly bool, inputGraph string) (err error) {
+func cloneSystemConfigs(cloner repocloner.RepoCloner, configFile, baseDirPath string, externalOnly, includeWeakDeps bool, inputGraph string) (err error) {
 	const cloneDeps = true
 
 	cfg, err := configuration.LoadWithAbsolutePaths(configFile, baseDirPath)
@@ -111,6 +117,13 @@ func cloneSystemConfigs(cloner repocloner.RepoCloner, configFile, baseDirPath st
 	// Add kernel packages from KernelOptions
 	packageVersionsInConfig = append(packageVersionsInConfig, installutils.KernelPackages(cfg)...)
 
+	if includeWeakDeps {
+		packageVersionsInConfig, err = addWeakDependencies(packageVersionsInConfig, inputGraph)
+		if err != nil {
+			return
+		}
+	}
+
 	if externalOnly {
 		packageVersionsInConfig, err = filterExternalPackagesOnly(packageVersionsInConfig, inputGraph)
 		if err != nil {
@@ -149,3 +162,62 @@ func filterExternalPackagesOnly(packageVersionsInConfig []*pkgjson.PackageVer, i
 
 	return
 }
+
+// addWeakDependencies returns packageVersionsInConfig along with the weak dependencies tdnf would install with them
+// (Recommends and Supplements). Weak dependencies of every package installed as a run-time dependency are also included.
+func addWeakDependencies(packageVersionsInConfig []*pkgjson.PackageVer, inputGraph string) (packagesWithWeakDeps []*pkgjson.PackageVer, err error) {
+	dependencyGraph := pkggraph.NewPkgGraph()
+	err = pkggraph.ReadDOTGraphFile(dependencyGraph, inputGraph)
+	if err != nil {
+		return
+	}
+
+	var queue []*pkggraph.PkgNode
+	visited := make(map[int64]bool)
+	visit := func(n *pkggraph.PkgNode) {
+		if !visited[n.ID()] {
+			visited[n.ID()] = true
+			queue = append(queue, n)
+		}
+	}
+
+	packagesWithWeakDeps = packageVersionsInConfig
+	for _, pkgVer := range packageVersionsInConfig {
+		var pkgNode *pkggraph.LookupNode
+
+		pkgNode, err = dependencyGraph.FindBestPkgNode(pkgVer)
+		if err != nil {
+			logger.Log.Errorf("Failed to find %s in the package graph", pkgVer.Name)
+			return
+		}
+		if pkgNode != nil {
+			visit(pkgNode.RunNode)
+		}
+	}
+
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+
+		// Follow run-time dependencies only, a run node also depends on its own build node.
+		for _, dependency := range graph.NodesOf(dependencyGraph.From(n.ID())) {
+			dependencyNode := dependency.(*pkggraph.PkgNode)
+			if dependencyNode.Type == pkggraph.TypeRun || dependencyNode.Type == pkggraph.TypeRemote {
+				visit(dependencyNode)
+			}
+		}
+
+		// Supplements are reversed, so they start at the package they supplement.
+		for _, edge := range dependencyGraph.WeakEdgesFrom(n) {
+			if !edge.Kind.IsInstalledByDefault() || visited[edge.To.ID()] {
+				continue
+			}
+
+			logger.Log.Infof("Including %s as it is a weak dependency (%s) of %s", edge.To.VersionedPkg.Name, edge.Kind, n.VersionedPkg.Name)
+			packagesWithWeakDeps = append(packagesWithWeakDeps, &pkgjson.PackageVer{Name: edge.To.VersionedPkg.Name})
+			visit(edge.To)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,392 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/retry"
+	"microsoft.com/pkggen/internal/shell"
+	"microsoft.com/pkggen/internal/sliceutils"
+)
+
+const (
+	// hierarchyRoot is where the cgroup v2 hierarchy is mounted.
+	hierarchyRoot = "/sys/fs/cgroup"
+
+	// selfCgroupFile lists the cgroups of the current process.
+	selfCgroupFile = "/proc/self/cgroup"
+
+	// unifiedHierarchyPrefix starts the entry of the cgroup v2 hierarchy in a /proc/<pid>/cgroup file.
+	unifiedHierarchyPrefix = "0::"
+
+	// leafPrefix starts the name of the cgroup the current process moves itself into, followed by its PID.
+	leafPrefix = "pkgworker-"
+
+	// procsFD is the file descriptor a wrapped command uses to add itself to the cgroup.
+	procsFD = 3
+
+	// cpuPeriod is the period, in microseconds, over which the CPU quota of a cgroup is enforced.
+	cpuPeriod = 100000
+
+	// unlimited is the value of an interface file for a resource without a limit.
+	unlimited = "max"
+
+	controllersFile     = "cgroup.controllers"
+	cpuMaxFile          = "cpu.max"
+	killFile            = "cgroup.kill"
+	memoryEventsFile    = "memory.events"
+	memoryMaxFile       = "memory.max"
+	memorySwapMaxFile   = "memory.swap.max"
+	procsFile           = "cgroup.procs"
+	subtreeControlFile  = "cgroup.subtree_control"
+	cpuController       = "cpu"
+	memoryController    = "memory"
+	oomKillEvent        = "oom_kill"
+	interfaceFileMode   = 0644
+	removeRetryAttempts = 5
+	removeRetryDuration = 100 * time.Millisecond
+)
+
+// Cgroup is a cgroup v2 limiting the memory and CPU time of the processes added to it.
+type Cgroup struct {
+	path  string
+	procs *os.File
+}
+
+// New creates a cgroup called name inside the parent cgroup, which is created if needed. parent is relative to the
+// root of the cgroup v2 hierarchy. It must not have processes of its own, and its ancestors must delegate the memory
+// and cpu controllers to it.
+// If parent is empty the cgroup is created inside the cgroup of the current process instead, after moving the current
+// process into a leaf cgroup of its own. This requires the current process to be the only one in its cgroup.
+// memoryLimit is in bytes and cpuQuota is a number of CPUs, 0 or less means no limit.
+// The cgroup must be removed with Close.
+func New(parent, name string, memoryLimit int64, cpuQuota float64) (c *Cgroup, err error) {
+	exists, err := file.PathExists(filepath.Join(hierarchyRoot, controllersFile))
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = fmt.Errorf("no cgroup v2 hierarchy is mounted at (%s)", hierarchyRoot)
+		return
+	}
+
+	var controllers []string
+	if memoryLimit > 0 {
+		controllers = append(controllers, memoryController)
+	}
+	if cpuQuota > 0 {
+		controllers = append(controllers, cpuController)
+	}
+
+	var parentPath string
+	if parent != "" {
+		parentPath = filepath.Join(hierarchyRoot, parent)
+		err = os.MkdirAll(parentPath, os.ModePerm)
+	} else {
+		parentPath, err = moveToLeaf()
+	}
+	if err != nil {
+		return
+	}
+
+	// A cgroup may only enable controllers for its children once it has no processes of its own. The ancestors of
+	// the parent are not ours to change, they must already delegate the controllers to it.
+	err = enableControllers(parentPath, controllers)
+	if err != nil {
+		return
+	}
+
+	path := filepath.Join(parentPath, name)
+	err = os.Mkdir(path, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	c = &Cgroup{path: path}
+	defer func() {
+		if err != nil {
+			closeErr := c.Close()
+			if closeErr != nil {
+				logger.Log.Warnf("Failed to remove cgroup (%s) during failed creation. Error: %s", path, closeErr)
+			}
+			c = nil
+		}
+	}()
+
+	if memoryLimit > 0 {
+		err = c.writeInterfaceFile(memoryMaxFile, memoryMax(memoryLimit))
+		if err != nil {
+			return
+		}
+
+		// Without disabling swap the processes would swap instead of being stopped at the memory limit.
+		// Not every kernel supports swap accounting, in which case there is nothing to disable.
+		err = c.writeInterfaceFile(memorySwapMaxFile, "0")
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	if cpuQuota > 0 {
+		err = c.writeInterfaceFile(cpuMaxFile, cpuMax(cpuQuota))
+		if err != nil {
+			return
+		}
+	}
+
+	// Keep cgroup.procs open so processes can still be added once the caller has entered a chroot.
+	c.procs, err = os.OpenFile(filepath.Join(path, procsFile), os.O_WRONLY, interfaceFileMode)
+	return
+}
+
+// WrapCommand returns a command which adds itself to the cgroup before executing program, so neither program nor
+// anything it starts ever runs outside of the cgroup. extraFiles must be passed on to the command, starting at file
+// descriptor 3. The command may be run from inside a chroot.
+func (c *Cgroup) WrapCommand(program string, args ...string) (wrappedProgram string, wrappedArgs []string, extraFiles []*os.File) {
+	script := fmt.Sprintf(`echo $$ >&%d && exec "$0" "$@"`, procsFD)
+
+	wrappedProgram = shell.ShellProgram
+	wrappedArgs = append([]string{"-c", script, program}, args...)
+	extraFiles = []*os.File{c.procs}
+	return
+}
+
+// OOMKilled returns true if a process of the cgroup was killed for exceeding the memory limit.
+func (c *Cgroup) OOMKilled() (oomKilled bool, err error) {
+	content, err := ioutil.ReadFile(filepath.Join(c.path, memoryEventsFile))
+	if err != nil {
+		// The memory controller is not enabled if the cgroup has no memory limit.
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	oomKills, err := parseEventCount(string(content), oomKillEvent)
+	oomKilled = oomKills > 0
+	return
+}
+
+// Close stops every process left in the cgroup and removes it.
+func (c *Cgroup) Close() (err error) {
+	if c.procs != nil {
+		c.procs.Close()
+		c.procs = nil
+	}
+
+	err = c.killAll()
+	if err != nil {
+		return
+	}
+
+	// The processes may take a moment to exit after being killed.
+	return retry.Run(func() error {
+		removeErr := os.Remove(c.path)
+		if os.IsNotExist(removeErr) {
+			return nil
+		}
+		return removeErr
+	}, removeRetryAttempts, removeRetryDuration)
+}
+
+// killAll sends a SIGKILL to every process in the cgroup.
+func (c *Cgroup) killAll() (err error) {
+	// cgroup.kill is only available on newer kernels, fall back to killing every process found in cgroup.procs.
+	err = c.writeInterfaceFile(killFile, "1")
+	if !os.IsNotExist(err) {
+		return
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(c.path, procsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	for _, field := range strings.Fields(string(content)) {
+		pid, convErr := strconv.Atoi(field)
+		if convErr != nil {
+			continue
+		}
+
+		process, findErr := os.FindProcess(pid)
+		if findErr == nil {
+			process.Kill()
+		}
+	}
+
+	return nil
+}
+
+// moveToLeaf moves the current process out of its cgroup into a new child cgroup, so the controllers of its cgroup
+// can be enabled for its children. Returns the path of the cgroup the current process was in. Leaf cgroups left
+// behind by processes which have exited are removed.
+func moveToLeaf() (ownPath string, err error) {
+	content, err := ioutil.ReadFile(selfCgroupFile)
+	if err != nil {
+		return
+	}
+
+	ownCgroup, err := parseUnifiedCgroup(string(content))
+	if err != nil {
+		return
+	}
+
+	ownPath = filepath.Join(hierarchyRoot, ownCgroup)
+	leafName := fmt.Sprintf("%s%d", leafPrefix, os.Getpid())
+
+	// The process was already moved by an earlier call.
+	if filepath.Base(ownPath) == leafName {
+		ownPath = filepath.Dir(ownPath)
+		return
+	}
+
+	removeStaleLeaves(ownPath)
+
+	leafPath := filepath.Join(ownPath, leafName)
+	err = os.Mkdir(leafPath, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Debugf("Moving process into cgroup (%s)", leafPath)
+	err = writeFile(filepath.Join(leafPath, procsFile), strconv.Itoa(os.Getpid()))
+	return
+}
+
+// removeStaleLeaves removes the leaf cgroups inside the cgroup at dir whose process no longer exists.
+func removeStaleLeaves(dir string) {
+	leaves, err := filepath.Glob(filepath.Join(dir, leafPrefix+"*"))
+	if err != nil {
+		return
+	}
+
+	for _, leaf := range leaves {
+		pid, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(leaf), leafPrefix))
+		if err != nil || unix.Kill(pid, 0) != unix.ESRCH {
+			continue
+		}
+
+		logger.Log.Debugf("Removing stale cgroup (%s)", leaf)
+		err = os.Remove(leaf)
+		if err != nil {
+			logger.Log.Warnf("Failed to remove stale cgroup (%s): %s", leaf, err)
+		}
+	}
+}
+
+// writeInterfaceFile writes value to an interface file of the cgroup.
+func (c *Cgroup) writeInterfaceFile(name, value string) (err error) {
+	return writeFile(filepath.Join(c.path, name), value)
+}
+
+// enableControllers enables the given controllers for the children of the cgroup at dir.
+// Controllers which are already enabled are left as is.
+func enableControllers(dir string, controllers []string) (err error) {
+	if len(controllers) == 0 {
+		return
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, subtreeControlFile))
+	if err != nil {
+		return
+	}
+
+	enabled := strings.Fields(string(content))
+	var enable []string
+	for _, controller := range controllers {
+		if sliceutils.Find(enabled, controller) == -1 {
+			enable = append(enable, "+"+controller)
+		}
+	}
+
+	if len(enable) == 0 {
+		return
+	}
+
+	err = writeFile(filepath.Join(dir, subtreeControlFile), strings.Join(enable, " "))
+	if err == unix.EBUSY {
+		err = fmt.Errorf("unable to enable controllers (%s) of cgroup (%s) as it has processes of its own, pass an empty delegated cgroup to create build cgroups in instead: %v", strings.Join(enable, " "), dir, err)
+	}
+
+	return
+}
+
+// writeFile writes value to an existing cgroup interface file. Interface files may not be truncated or created.
+func writeFile(path, value string) (err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, interfaceFileMode)
+	if err != nil {
+		return
+	}
+
+	_, err = f.WriteString(value)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return
+}
+
+// memoryMax returns the memory.max value for a memory limit in bytes.
+func memoryMax(memoryLimit int64) string {
+	if memoryLimit <= 0 {
+		return unlimited
+	}
+	return strconv.FormatInt(memoryLimit, 10)
+}
+
+// cpuMax returns the cpu.max value for a quota of CPUs.
+func cpuMax(cpuQuota float64) string {
+	if cpuQuota <= 0 {
+		return fmt.Sprintf("%s %d", unlimited, cpuPeriod)
+	}
+	return fmt.Sprintf("%d %d", int64(cpuQuota*cpuPeriod), cpuPeriod)
+}
+
+// parseUnifiedCgroup returns the path of the cgroup v2 hierarchy listed in the content of a /proc/<pid>/cgroup file.
+// The path is relative to the root of the hierarchy.
+func parseUnifiedCgroup(content string) (path string, err error) {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.HasPrefix(line, unifiedHierarchyPrefix) {
+			path = strings.TrimPrefix(line, unifiedHierarchyPrefix)
+			return
+		}
+	}
+
+	err = fmt.Errorf("the process does not belong to a cgroup v2 hierarchy")
+	return
+}
+
+// parseEventCount returns the count of an event from the content of an events file such as memory.events.
+// Returns 0 if the event is not listed.
+func parseEventCount(content, event string) (count int64, err error) {
+	const expectedFieldsLen = 2
+
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != expectedFieldsLen || fields[0] != event {
+			continue
+		}
+
+		return strconv.ParseInt(fields[1], 10, 64)
+	}
+
+	return
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func TestMemoryMaxShouldFormatLimit(t *testing.T) {
+	assert.Equal(t, "1073741824", memoryMax(1073741824))
+}
+
+func TestMemoryMaxShouldBeUnlimitedWithoutLimit(t *testing.T) {
+	assert.Equal(t, "max", memoryMax(0))
+}
+
+func TestCPUMaxShouldFormatFractionalQuota(t *testing.T) {
+	assert.Equal(t, "250000 100000", cpuMax(2.5))
+}
+
+func TestCPUMaxShouldBeUnlimitedWithoutQuota(t *testing.T) {
+	assert.Equal(t, "max 100000", cpuMax(0))
+}
+
+func TestParseEventCountShouldFindEvent(t *testing.T) {
+	const memoryEvents = "low 0\nhigh 0\nmax 12\noom 2\noom_kill 1\noom_group_kill 0\n"
+
+	count, err := parseEventCount(memoryEvents, oomKillEvent)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestParseEventCountShouldReturnZeroForMissingEvent(t *testing.T) {
+	count, err := parseEventCount("low 0\nhigh 0\n", oomKillEvent)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestParseEventCountShouldFailOnInvalidCount(t *testing.T) {
+	_, err := parseEventCount("oom_kill many\n", oomKillEvent)
+	assert.Error(t, err)
+}
+
+func TestParseUnifiedCgroupShouldFindHybridEntry(t *testing.T) {
+	const selfCgroup = "12:memory:/docker/abc\n1:name=systemd:/docker/abc\n0::/docker/abc\n"
+
+	path, err := parseUnifiedCgroup(selfCgroup)
+	assert.NoError(t, err)
+	assert.Equal(t, "/docker/abc", path)
+}
+
+func TestParseUnifiedCgroupShouldFindNamespaceRoot(t *testing.T) {
+	path, err := parseUnifiedCgroup("0::/\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "/", path)
+}
+
+func TestParseUnifiedCgroupShouldFailWithoutUnifiedHierarchy(t *testing.T) {
+	_, err := parseUnifiedCgroup("4:memory:/docker/abc\n1:name=systemd:/docker/abc\n")
+	assert.Error(t, err)
+}
+
+func TestWrapCommandShouldJoinCgroupBeforeExec(t *testing.T) {
+	const (
+		noTimeout    = 0
+		squashErrors = true
+	)
+
+	dir, err := ioutil.TempDir("", "cgroup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A regular file stands in for cgroup.procs, the command must write its own PID to it before running.
+	procs, err := os.Create(filepath.Join(dir, procsFile))
+	assert.NoError(t, err)
+	c := &Cgroup{path: dir, procs: procs}
+	defer procs.Close()
+
+	pidFile := filepath.Join(dir, "pid")
+	program, args, extraFiles := c.WrapCommand(shell.ShellProgram, "-c", "echo $$ > "+pidFile)
+	err = shell.ExecuteLiveWithTimeout(squashErrors, noTimeout, extraFiles, program, args...)
+	assert.NoError(t, err)
+
+	addedPID, err := ioutil.ReadFile(procs.Name())
+	assert.NoError(t, err)
+	commandPID, err := ioutil.ReadFile(pidFile)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, strings.TrimSpace(string(addedPID)))
+	assert.Equal(t, string(commandPID), string(addedPID))
+}
+
+func TestRemoveStaleLeavesShouldOnlyRemoveLeavesOfExitedProcesses(t *testing.T) {
+	// PIDs never reach the maximum value of pid_max, so no process can have this PID.
+	const exitedPID = 4194304
+
+	dir, err := ioutil.TempDir("", "cgroup")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ownLeaf := filepath.Join(dir, fmt.Sprintf("%s%d", leafPrefix, os.Getpid()))
+	staleLeaf := filepath.Join(dir, fmt.Sprintf("%s%d", leafPrefix, exitedPID))
+	otherCgroup := filepath.Join(dir, "build-1234")
+	for _, cgroupDir := range []string{ownLeaf, staleLeaf, otherCgroup} {
+		assert.NoError(t, os.Mkdir(cgroupDir, os.ModePerm))
+	}
+
+	removeStaleLeaves(dir)
+
+	assert.DirExists(t, ownLeaf)
+	assert.DirExists(t, otherCgroup)
+	_, err = os.Stat(staleLeaf)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -7,12 +7,15 @@ import (
 	"bufio"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
 
+	"golang.org/x/crypto/blake2b"
 	"microsoft.com/pkggen/internal/logger"
 	"microsoft.com/pkggen/internal/shell"
 )
@@ -161,26 +164,61 @@ func GenerateSHA1(path string) (hash string, err error) {
 	return
 }
 
+// Hash algorithms supported by GenerateHash
+const (
+	HashSHA256  = "sha256"
+	HashSHA512  = "sha512"
+	HashBLAKE2b = "blake2b"
+)
+
+// HashAlgorithms lists every hash algorithm supported by GenerateHash.
+var HashAlgorithms = []string{HashSHA256, HashSHA512, HashBLAKE2b}
+
 // GenerateSHA256 calculates a sha256 of a file
 func GenerateSHA256(path string) (hash string, err error) {
+	return GenerateHash(path, HashSHA256)
+}
+
+// GenerateHash calculates the hash of a file with one of HashAlgorithms. BLAKE2b produces a 512 bit hash.
+func GenerateHash(path, algorithm string) (hash string, err error) {
+	generator, err := newHashGenerator(algorithm)
+	if err != nil {
+		return
+	}
+
 	file, err := os.Open(path)
 	if err != nil {
 		return
 	}
 	defer file.Close()
 
-	sha256Generator := sha256.New()
-	_, err = io.Copy(sha256Generator, file)
+	_, err = io.Copy(generator, file)
 	if err != nil {
 		return
 	}
 
-	rawHash := sha256Generator.Sum(nil)
+	rawHash := generator.Sum(nil)
 	hash = hex.EncodeToString(rawHash)
 
 	return
 }
 
+// newHashGenerator returns a hash generator for one of HashAlgorithms.
+func newHashGenerator(algorithm string) (generator hash.Hash, err error) {
+	switch algorithm {
+	case HashSHA256:
+		generator = sha256.New()
+	case HashSHA512:
+		generator = sha512.New()
+	case HashBLAKE2b:
+		generator, err = blake2b.New512(nil)
+	default:
+		err = fmt.Errorf("unsupported hash algorithm (%s), supported algorithms are %v", algorithm, HashAlgorithms)
+	}
+
+	return
+}
+
 // DirExists returns true if the directory exists,
 // false otherwise.
 func DirExists(path string) (exists bool, err error) {
@@ -0,0 +1,51 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func TestGenerateHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "source.tar.gz")
+	err = ioutil.WriteFile(path, []byte("contents"), 0644)
+	assert.NoError(t, err)
+
+	expectedHashes := map[string]string{
+		HashSHA256:  "d1b2a59fbea7e20077af9f91b27e95e865061b270be03ff539ab3b73587882e8",
+		HashSHA512:  "ac98d72fccae58536b132637d9f2220af6e87667db65f3744b7552fb9dfb1c67e3ececb7291bd287bc4a860dca2f7abf417bc89d7ab873cc028f07a24f9f6772",
+		HashBLAKE2b: "9063990e5c5b2184877f92adace7c801a549b00c39cd7549877f06d5dd0d3a6ca6eee42d5896bdac64831c8114c55cee664078bd105dc691270c92644ccb2ce7",
+	}
+	assert.Len(t, expectedHashes, len(HashAlgorithms))
+
+	for _, algorithm := range HashAlgorithms {
+		hash, err := GenerateHash(path, algorithm)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedHashes[algorithm], hash, algorithm)
+	}
+
+	sha256Hash, err := GenerateSHA256(path)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedHashes[HashSHA256], sha256Hash)
+}
+
+func TestGenerateHashUnsupportedAlgorithm(t *testing.T) {
+	_, err := GenerateHash("/does/not/matter", "md5")
+	assert.Error(t, err)
+}
@@ -55,12 +55,32 @@ const (
 
 // Dot encoding/decoding keys
 const (
-	dotKeyNodeInBase64 = "NodeInBase64"
-	dotKeySRPM         = "SRPM"
-	dotKeyColor        = "fillcolor"
-	dotKeyFill         = "style"
+	dotKeyNodeInBase64    = "NodeInBase64" // Legacy encoding, the entire node gob encoded in base64
+	dotKeyEncodingVersion = "EncodingVersion"
+	dotKeyName            = "Name"
+	dotKeyVersion         = "Version"
+	dotKeyCondition       = "Condition"
+	dotKeySVersion        = "SVersion"
+	dotKeySCondition      = "SCondition"
+	dotKeyState           = "State"
+	dotKeyType            = "Type"
+	dotKeySRPM            = "SRPM"
+	dotKeySpec            = "Spec"
+	dotKeySourceDir       = "SourceDir"
+	dotKeyArch            = "Arch"
+	dotKeyRepo            = "Repo"
+	dotKeyGoal            = "Goal"
+	dotKeyPackageHash     = "PackageHash"
+	dotKeyColor           = "fillcolor"
+	dotKeyFill            = "style"
 )
 
+// NoArchitecture is the architecture of packages which can be installed on any architecture
+const NoArchitecture = "noarch"
+
+// dotEncodingVersion is the version of the plain attribute DOT encoding written by Attributes()
+const dotEncodingVersion = "2"
+
 // PkgNode represents a package.
 type PkgNode struct {
 	nodeID       int64               // Unique ID for the node
@@ -73,7 +93,11 @@ type PkgNode struct {
 	Architecture string              // The architecture of the resulting package built.
 	SourceRepo   string              // The location this package was acquired from
 	GoalName     string              // Optional string for goal nodes
+	PackageHash  string              // Optional hash of the package information a local node was created from
 	This         *PkgNode            // Self reference since the graph library returns nodes by value, not reference
+
+	Variant        string            // Optional name of the conditional build variant of a local package, empty for the default build
+	VariantDefines map[string]string // The rpm defines the variant is built with, on top of the default defines
 }
 
 // ID implements the graph.Node interface, returns the node's unique ID
@@ -85,6 +109,7 @@ func (n PkgNode) ID() int64 {
 type PkgGraph struct {
 	*simple.DirectedGraph
 	nodeLookup map[string][]*LookupNode
+	weakEdges  map[int64]map[int64]WeakDependencyKind // Kinds of the weak edges, by the IDs of their from and to nodes
 }
 
 //LookupNode represents a graph node for a package in the lookup list
@@ -133,6 +158,30 @@ func (n NodeType) String() string {
 	}
 }
 
+// nodeStateFromString converts the output of NodeState.String() back into a NodeState
+func nodeStateFromString(value string) (state NodeState, err error) {
+	for state = StateUnknown + 1; state <= StateMAX; state++ {
+		if state.String() == value {
+			return
+		}
+	}
+
+	err = fmt.Errorf("unknown node state: %s", value)
+	return
+}
+
+// nodeTypeFromString converts the output of NodeType.String() back into a NodeType
+func nodeTypeFromString(value string) (nodeType NodeType, err error) {
+	for nodeType = TypeUnknown + 1; nodeType <= TypeMAX; nodeType++ {
+		if nodeType.String() == value {
+			return
+		}
+	}
+
+	err = fmt.Errorf("unknown node type: %s", value)
+	return
+}
+
 //DOTColor returns the graphviz color to set a node to
 func (n *PkgNode) DOTColor() string {
 	switch n.State {
@@ -233,7 +282,7 @@ func (g *PkgGraph) validateNodeForLookup(pkgNode *PkgNode) (valid bool, err erro
 	}
 
 	// Check for existing lookup entries which conflict
-	existingLookup, err := g.FindExactPkgNodeFromPkg(pkgNode.VersionedPkg)
+	existingLookup, err := g.FindExactPkgNodeFromPkgForVariant(pkgNode.VersionedPkg, pkgNode.Architecture, pkgNode.Variant)
 	if err != nil {
 		return
 	}
@@ -299,7 +348,7 @@ func (g *PkgGraph) addToLookup(pkgNode *PkgNode, deferSort bool) (err error) {
 	// Get the existing package lookup, or create it
 	pkgName := pkgNode.VersionedPkg.Name
 
-	existingLookup, err = g.FindExactPkgNodeFromPkg(pkgNode.VersionedPkg)
+	existingLookup, err = g.FindExactPkgNodeFromPkgForVariant(pkgNode.VersionedPkg, pkgNode.Architecture, pkgNode.Variant)
 	if err != nil {
 		return err
 	}
@@ -353,16 +402,24 @@ func (g *PkgGraph) NewNode() graph.Node {
 
 // AddPkgNode adds a new node to the package graph. Run, Build, and Unresolved nodes are recorded in the lookup table.
 func (g *PkgGraph) AddPkgNode(versionedPkg *pkgjson.PackageVer, nodestate NodeState, nodeType NodeType, srpmPath, specPath, sourceDir, architecture, sourceRepo string) (newNode *PkgNode, err error) {
+	return g.AddVariantPkgNode(versionedPkg, nodestate, nodeType, srpmPath, specPath, sourceDir, architecture, sourceRepo, "", nil)
+}
+
+// AddVariantPkgNode has the same behavior as AddPkgNode but creates a node for a conditional build variant of a
+// local package. Variant nodes are only returned by lookups which request their variant, see FindExactPkgNodeFromPkgForVariant.
+func (g *PkgGraph) AddVariantPkgNode(versionedPkg *pkgjson.PackageVer, nodestate NodeState, nodeType NodeType, srpmPath, specPath, sourceDir, architecture, sourceRepo, variant string, variantDefines map[string]string) (newNode *PkgNode, err error) {
 	newNode = &PkgNode{
-		nodeID:       g.NewNode().ID(),
-		VersionedPkg: versionedPkg,
-		State:        nodestate,
-		Type:         nodeType,
-		SrpmPath:     srpmPath,
-		SpecPath:     specPath,
-		SourceDir:    sourceDir,
-		Architecture: architecture,
-		SourceRepo:   sourceRepo,
+		nodeID:         g.NewNode().ID(),
+		VersionedPkg:   versionedPkg,
+		State:          nodestate,
+		Type:           nodeType,
+		SrpmPath:       srpmPath,
+		SpecPath:       specPath,
+		SourceDir:      sourceDir,
+		Architecture:   architecture,
+		SourceRepo:     sourceRepo,
+		Variant:        variant,
+		VariantDefines: variantDefines,
 	}
 	newNode.This = newNode
 
@@ -382,10 +439,62 @@ func (g *PkgGraph) AddPkgNode(versionedPkg *pkgjson.PackageVer, nodestate NodeSt
 	return
 }
 
+// RemovePkgNode removes a node from the package graph, along with any edges to or from it. Run, Build, and Unresolved
+// nodes are also removed from the lookup table. A build node should be removed before its partner run node, otherwise
+// the lookup table will contain an orphaned build node.
+func (g *PkgGraph) RemovePkgNode(pkgNode *PkgNode) {
+	if pkgNode.Type == TypeBuild || pkgNode.Type == TypeRun || pkgNode.Type == TypeRemote {
+		pkgName := pkgNode.VersionedPkg.Name
+		lookupEntries := g.lookupTable()[pkgName]
+
+		remainingEntries := lookupEntries[:0]
+		for _, entry := range lookupEntries {
+			if entry.BuildNode == pkgNode.This {
+				entry.BuildNode = nil
+			}
+			if entry.RunNode == pkgNode.This {
+				entry.RunNode = nil
+			}
+
+			if entry.RunNode != nil || entry.BuildNode != nil {
+				remainingEntries = append(remainingEntries, entry)
+			}
+		}
+
+		if len(remainingEntries) == 0 {
+			delete(g.lookupTable(), pkgName)
+		} else {
+			g.lookupTable()[pkgName] = remainingEntries
+		}
+	}
+
+	g.RemoveNode(pkgNode.ID())
+}
+
 // FindDoubleConditionalPkgNodeFromPkg has the same behavior as FindConditionalPkgNodeFromPkg but supports two conditionals
 func (g *PkgGraph) FindDoubleConditionalPkgNodeFromPkg(pkgVer *pkgjson.PackageVer) (lookupEntry *LookupNode, err error) {
+	return g.FindDoubleConditionalPkgNodeFromPkgForArch(pkgVer, "")
+}
+
+// FindDoubleConditionalPkgNodeFromPkgForArch has the same behavior as FindDoubleConditionalPkgNodeFromPkg but only
+// considers packages which can be installed on the requested architecture. Packages built for exactly that
+// architecture are preferred, falling back to noarch and remote packages if none satisfy the request.
+// An empty or noarch architecture accepts packages of any architecture.
+func (g *PkgGraph) FindDoubleConditionalPkgNodeFromPkgForArch(pkgVer *pkgjson.PackageVer, arch string) (lookupEntry *LookupNode, err error) {
+	// Conditional build variants are never used to resolve dependencies directly
+	isDefaultBuild := func(runNode *PkgNode) bool {
+		return runNode.Variant == ""
+	}
+
+	return g.findDoubleConditionalPkgNode(pkgVer, arch, isDefaultBuild)
+}
+
+// findDoubleConditionalPkgNode implements FindDoubleConditionalPkgNodeFromPkgForArch, only considering the packages
+// whose run node is accepted by the filter.
+func (g *PkgGraph) findDoubleConditionalPkgNode(pkgVer *pkgjson.PackageVer, arch string, filter func(runNode *PkgNode) bool) (lookupEntry *LookupNode, err error) {
 	var (
 		requestInterval, nodeInterval pkgjson.PackageVerInterval
+		fallbackEntry                 *LookupNode
 	)
 	requestInterval, err = pkgVer.Interval()
 	if err != nil {
@@ -399,16 +508,31 @@ func (g *PkgGraph) FindDoubleConditionalPkgNodeFromPkg(pkgVer *pkgjson.PackageVe
 			return
 		}
 
+		if !filter(node.RunNode) {
+			continue
+		}
+
 		nodeInterval, err = node.RunNode.VersionedPkg.Interval()
 		if err != nil {
 			return
 		}
 
-		if nodeInterval.Satisfies(&requestInterval) {
-			// Keep going, we want the highest version which satisfies both conditionals
+		if !nodeInterval.Satisfies(&requestInterval) {
+			continue
+		}
+
+		// Keep going, we want the highest version which satisfies both conditionals
+		switch {
+		case arch == "" || arch == NoArchitecture || node.RunNode.Architecture == arch:
 			lookupEntry = node
+		case node.RunNode.Architecture == NoArchitecture || node.RunNode.Type == TypeRemote:
+			fallbackEntry = node
 		}
 	}
+
+	if lookupEntry == nil {
+		lookupEntry = fallbackEntry
+	}
 	return
 }
 
@@ -416,6 +540,18 @@ func (g *PkgGraph) FindDoubleConditionalPkgNodeFromPkg(pkgVer *pkgjson.PackageVe
 // correct version information listed in the PackageVer structure. Returns nil
 // if no lookup entry is found.
 func (g *PkgGraph) FindExactPkgNodeFromPkg(pkgVer *pkgjson.PackageVer) (lookupEntry *LookupNode, err error) {
+	return g.FindExactPkgNodeFromPkgForArch(pkgVer, "")
+}
+
+// FindExactPkgNodeFromPkgForArch has the same behavior as FindExactPkgNodeFromPkg but also requires the package
+// to be built for exactly the requested architecture. An empty architecture matches any architecture.
+func (g *PkgGraph) FindExactPkgNodeFromPkgForArch(pkgVer *pkgjson.PackageVer, arch string) (lookupEntry *LookupNode, err error) {
+	return g.FindExactPkgNodeFromPkgForVariant(pkgVer, arch, "")
+}
+
+// FindExactPkgNodeFromPkgForVariant has the same behavior as FindExactPkgNodeFromPkgForArch but looks up the nodes
+// of a conditional build variant of the package. An empty variant matches the default build.
+func (g *PkgGraph) FindExactPkgNodeFromPkgForVariant(pkgVer *pkgjson.PackageVer, arch, variant string) (lookupEntry *LookupNode, err error) {
 	var (
 		requestInterval, nodeInterval pkgjson.PackageVerInterval
 	)
@@ -427,6 +563,15 @@ func (g *PkgGraph) FindExactPkgNodeFromPkg(pkgVer *pkgjson.PackageVer) (lookupEn
 	packageNodes := g.lookupTable()[pkgVer.Name]
 
 	for _, node := range packageNodes {
+		// Orphaned build nodes are only reported if they would otherwise match
+		archNode := node.RunNode
+		if archNode == nil {
+			archNode = node.BuildNode
+		}
+		if (arch != "" && archNode.Architecture != arch) || archNode.Variant != variant {
+			continue
+		}
+
 		if node.RunNode == nil {
 			err = fmt.Errorf("found orphaned build node %s for name %s", node.BuildNode, pkgVer.Name)
 			return
@@ -453,6 +598,23 @@ func (g *PkgGraph) FindBestPkgNode(pkgVer *pkgjson.PackageVer) (lookupEntry *Loo
 	return
 }
 
+// FindBestPkgNodeForArch has the same behavior as FindBestPkgNode but only considers packages which can be
+// installed on the requested architecture, preferring packages built for exactly that architecture over noarch ones.
+func (g *PkgGraph) FindBestPkgNodeForArch(pkgVer *pkgjson.PackageVer, arch string) (lookupEntry *LookupNode, err error) {
+	lookupEntry, err = g.FindDoubleConditionalPkgNodeFromPkgForArch(pkgVer, arch)
+	return
+}
+
+// FindBestPkgNodeFromSameBuild has the same behavior as FindBestPkgNodeForArch but only considers the packages built
+// by the same build as node, such as the sibling subpackages of a conditional build variant.
+func (g *PkgGraph) FindBestPkgNodeFromSameBuild(pkgVer *pkgjson.PackageVer, node *PkgNode) (lookupEntry *LookupNode, err error) {
+	isSameBuild := func(runNode *PkgNode) bool {
+		return runNode.Type == TypeRun && runNode.BuildKey() == node.BuildKey()
+	}
+
+	return g.findDoubleConditionalPkgNode(pkgVer, node.Architecture, isSameBuild)
+}
+
 // AllNodes returns a list of all nodes in the graph.
 func (g *PkgGraph) AllNodes() []*PkgNode {
 	count := g.Nodes().Len()
@@ -531,9 +693,9 @@ func (n PkgNode) SetDOTID(id string) {
 func (n *PkgNode) FriendlyName() string {
 	switch n.Type {
 	case TypeBuild:
-		return fmt.Sprintf("%s-%s-BUILD<%s>", n.VersionedPkg.Name, n.VersionedPkg.Version, n.State.String())
+		return fmt.Sprintf("%s-%s-BUILD%s<%s>", n.VersionedPkg.Name, n.VersionedPkg.Version, n.variantSuffix(), n.State.String())
 	case TypeRun:
-		return fmt.Sprintf("%s-%s-RUN<%s>", n.VersionedPkg.Name, n.VersionedPkg.Version, n.State.String())
+		return fmt.Sprintf("%s-%s-RUN%s<%s>", n.VersionedPkg.Name, n.VersionedPkg.Version, n.variantSuffix(), n.State.String())
 	case TypeRemote:
 		ver1 := fmt.Sprintf("%s%s", n.VersionedPkg.Condition, n.VersionedPkg.Version)
 		ver2 := ""
@@ -589,7 +751,10 @@ func (n *PkgNode) Equal(otherNode *PkgNode) bool {
 		n.SourceDir == otherNode.SourceDir &&
 		n.Architecture == otherNode.Architecture &&
 		n.SourceRepo == otherNode.SourceRepo &&
-		n.GoalName == otherNode.GoalName
+		n.GoalName == otherNode.GoalName &&
+		n.PackageHash == otherNode.PackageHash &&
+		n.Variant == otherNode.Variant &&
+		variantDefinesEqual(n.VariantDefines, otherNode.VariantDefines)
 }
 
 func registerTypes() {
@@ -717,84 +882,150 @@ func (n *PkgNode) UnmarshalBinary(inBuffer []byte) (err error) {
 	return
 }
 
-// SetAttribute sets a DOT attribute for the current node when parsing a DOT file
+// SetAttribute sets a DOT attribute for the current node when parsing a DOT file. Both the plain attribute encoding
+// and the legacy base64 gob encoding are supported.
 func (n *PkgNode) SetAttribute(attr encoding.Attribute) (err error) {
-	var data []byte
 	registerOnce.Do(registerTypes)
 
 	switch attr.Key {
 	case dotKeyNodeInBase64:
-		logger.Log.Trace("Decoding base 64")
-		// Encoding/decoding may not preserve the IDs, we should take the ID we were given
-		// as the truth
-		newID := n.nodeID
-		data, err = base64.StdEncoding.DecodeString(attr.Value)
-		if err != nil {
-			logger.Log.Errorf("Failed to decode base 64 encoding: %s", err.Error())
-			return
-		}
-		buffer := bytes.Buffer{}
-		_, err = buffer.Write(data)
-		if err != nil {
-			logger.Log.Errorf("Failed to read gob data: %s", err.Error())
-			return
-		}
-
-		decoder := gob.NewDecoder(&buffer)
-		err = decoder.Decode(n)
-		if err != nil {
-			logger.Log.Errorf("Failed to decode gob data: %s", err.Error())
-			return
+		err = n.setBase64Attribute(attr.Value)
+	case dotKeyEncodingVersion:
+		if attr.Value != dotEncodingVersion {
+			err = fmt.Errorf("unsupported DOT node encoding version: %s", attr.Value)
 		}
-		// Restore the ID we were given by the deserializer
-		n.nodeID = newID
+	case dotKeyName:
+		n.versionedPkg().Name = attr.Value
+	case dotKeyVersion:
+		n.versionedPkg().Version = attr.Value
+	case dotKeyCondition:
+		n.versionedPkg().Condition = attr.Value
+	case dotKeySVersion:
+		n.versionedPkg().SVersion = attr.Value
+	case dotKeySCondition:
+		n.versionedPkg().SCondition = attr.Value
+	case dotKeyState:
+		n.State, err = nodeStateFromString(attr.Value)
+	case dotKeyType:
+		n.Type, err = nodeTypeFromString(attr.Value)
 	case dotKeySRPM:
-		logger.Log.Trace("Ignoring srpm")
-		// No-op, b64encoding should totally overwrite the node.
+		n.SrpmPath = attr.Value
+	case dotKeySpec:
+		n.SpecPath = attr.Value
+	case dotKeySourceDir:
+		n.SourceDir = attr.Value
+	case dotKeyArch:
+		n.Architecture = attr.Value
+	case dotKeyRepo:
+		n.SourceRepo = attr.Value
+	case dotKeyGoal:
+		n.GoalName = attr.Value
+	case dotKeyPackageHash:
+		n.PackageHash = attr.Value
+	case dotKeyVariant:
+		n.Variant = attr.Value
+	case dotKeyVariantDefines:
+		err = n.setVariantDefinesAttribute(attr.Value)
 	case dotKeyColor:
 		logger.Log.Trace("Ignoring color")
-		// No-op, b64encoding should totally overwrite the node.
+		// No-op, the color is derived from the node's state.
 	case dotKeyFill:
 		logger.Log.Trace("Ignoring fill")
-		// No-op, b64encoding should totally overwrite the node.
+		// No-op, the fill is always the same.
 	default:
 		logger.Log.Warnf(`Unable to unmarshal an unknown key "%s".`, attr.Key)
 	}
 
+	if err != nil {
+		logger.Log.Errorf("Failed to decode DOT attribute %s=%s: %s", attr.Key, attr.Value, err.Error())
+	}
+
 	return
 }
 
-// Attributes marshals all relevent node data into a DOT graph structure. The
-// entire node is encoded using base64 and gob.
-func (n *PkgNode) Attributes() []encoding.Attribute {
-	registerOnce.Do(registerTypes)
+// versionedPkg returns the node's package version information, creating it if needed.
+func (n *PkgNode) versionedPkg() *pkgjson.PackageVer {
+	if n.VersionedPkg == nil {
+		n.VersionedPkg = &pkgjson.PackageVer{}
+	}
+	return n.VersionedPkg
+}
 
-	var buffer bytes.Buffer
-	encoder := gob.NewEncoder(&buffer)
-	err := encoder.Encode(n)
+// setBase64Attribute overwrites the node with a legacy base64 gob encoded node.
+func (n *PkgNode) setBase64Attribute(value string) (err error) {
+	logger.Log.Trace("Decoding base 64")
+	// Encoding/decoding may not preserve the IDs, we should take the ID we were given
+	// as the truth
+	newID := n.nodeID
+	data, err := base64.StdEncoding.DecodeString(value)
 	if err != nil {
-		logger.Log.Panicf("Error when encoding attributes: %s", err.Error())
+		logger.Log.Errorf("Failed to decode base 64 encoding: %s", err.Error())
+		return
+	}
+	buffer := bytes.Buffer{}
+	_, err = buffer.Write(data)
+	if err != nil {
+		logger.Log.Errorf("Failed to read gob data: %s", err.Error())
+		return
 	}
-	nodeInBase64 := base64.StdEncoding.EncodeToString(buffer.Bytes())
 
-	return []encoding.Attribute{
-		{
-			Key:   dotKeyNodeInBase64,
-			Value: nodeInBase64,
-		},
-		{
-			Key:   dotKeySRPM,
-			Value: n.SrpmPath,
-		},
-		{
-			Key:   dotKeyColor,
-			Value: n.DOTColor(),
-		},
+	decoder := gob.NewDecoder(&buffer)
+	err = decoder.Decode(n)
+	if err != nil {
+		logger.Log.Errorf("Failed to decode gob data: %s", err.Error())
+		return
+	}
+	// Restore the ID we were given by the deserializer
+	n.nodeID = newID
+	return
+}
+
+// Attributes marshals all relevent node data into a DOT graph structure. Each
+// field of the node is stored as a plain attribute, empty fields are omitted.
+func (n *PkgNode) Attributes() []encoding.Attribute {
+	attributes := []encoding.Attribute{
 		{
-			Key:   dotKeyFill,
-			Value: "filled",
+			Key:   dotKeyEncodingVersion,
+			Value: dotEncodingVersion,
 		},
 	}
+
+	addAttribute := func(key, value string) {
+		if value != "" {
+			attributes = append(attributes, encoding.Attribute{Key: key, Value: value})
+		}
+	}
+
+	if n.VersionedPkg != nil {
+		// Always record the name, its presence marks the node as having package information
+		attributes = append(attributes, encoding.Attribute{Key: dotKeyName, Value: n.VersionedPkg.Name})
+		addAttribute(dotKeyVersion, n.VersionedPkg.Version)
+		addAttribute(dotKeyCondition, n.VersionedPkg.Condition)
+		addAttribute(dotKeySVersion, n.VersionedPkg.SVersion)
+		addAttribute(dotKeySCondition, n.VersionedPkg.SCondition)
+	}
+
+	addAttribute(dotKeyState, n.State.String())
+	addAttribute(dotKeyType, n.Type.String())
+	addAttribute(dotKeySRPM, n.SrpmPath)
+	addAttribute(dotKeySpec, n.SpecPath)
+	addAttribute(dotKeySourceDir, n.SourceDir)
+	addAttribute(dotKeyArch, n.Architecture)
+	addAttribute(dotKeyRepo, n.SourceRepo)
+	addAttribute(dotKeyGoal, n.GoalName)
+	addAttribute(dotKeyPackageHash, n.PackageHash)
+	addAttribute(dotKeyVariant, n.Variant)
+
+	variantDefines, err := n.variantDefinesAttribute()
+	if err != nil {
+		logger.Log.Panicf("Failed to encode variant defines of %s: %s", n.FriendlyName(), err)
+	}
+	addAttribute(dotKeyVariantDefines, variantDefines)
+
+	addAttribute(dotKeyColor, n.DOTColor())
+	addAttribute(dotKeyFill, "filled")
+
+	return attributes
 }
 
 // FindGoalNode returns a named goal node if one exists.
@@ -889,25 +1120,21 @@ func (g *PkgGraph) AddGoalNode(goalName string, packages []*pkgjson.PackageVer,
 	goalNode.This = goalNode
 	g.AddNode(goalNode)
 
+	// Resolve every package once per architecture so all copies of a multi-architecture graph are part of the goal
+	architectures := g.runNodeArchitectures()
 	for pkg := range goalSet {
-		var existingNode *LookupNode
-		// Try to find an exact match first (to make sure we match revision number exactly, if available)
-		existingNode, err = g.FindExactPkgNodeFromPkg(pkg)
+		var runNodes []*PkgNode
+		runNodes, err = g.goalRunNodes(pkg, architectures)
 		if err != nil {
 			return
 		}
-		if existingNode == nil {
-			// Try again with a more general search
-			existingNode, err = g.FindBestPkgNode(pkg)
-			if err != nil {
-				return
-			}
-		}
 
-		if existingNode != nil {
-			logger.Log.Debugf("Found %s to satisfy %s", existingNode.RunNode, pkg)
-			goalEdge := g.NewEdge(goalNode, existingNode.RunNode)
-			g.SetEdge(goalEdge)
+		if len(runNodes) > 0 {
+			for _, runNode := range runNodes {
+				logger.Log.Debugf("Found %s to satisfy %s", runNode, pkg)
+				goalEdge := g.NewEdge(goalNode, runNode)
+				g.SetEdge(goalEdge)
+			}
 			goalSet[pkg] = false
 		} else {
 			logger.Log.Warnf("Could not goal package %+v", pkg)
@@ -921,6 +1148,56 @@ func (g *PkgGraph) AddGoalNode(goalName string, packages []*pkgjson.PackageVer,
 	return
 }
 
+// runNodeArchitectures returns the sorted architectures local packages are built for, not including noarch.
+// Returns a single empty architecture, matching any architecture, if there are none.
+func (g *PkgGraph) runNodeArchitectures() (architectures []string) {
+	archSet := make(map[string]bool)
+	for _, n := range g.AllRunNodes() {
+		if n.Type == TypeRun && n.Architecture != "" && n.Architecture != NoArchitecture {
+			archSet[n.Architecture] = true
+		}
+	}
+
+	for arch := range archSet {
+		architectures = append(architectures, arch)
+	}
+	sort.Strings(architectures)
+
+	if len(architectures) == 0 {
+		architectures = []string{""}
+	}
+	return
+}
+
+// goalRunNodes returns the run node satisfying pkg for each of the architectures. An exact match is preferred to make
+// sure the revision number is matched exactly, if available. Nodes shared by several architectures, such as noarch
+// packages, are only returned once.
+func (g *PkgGraph) goalRunNodes(pkg *pkgjson.PackageVer, architectures []string) (runNodes []*PkgNode, err error) {
+	found := make(map[int64]bool)
+	for _, arch := range architectures {
+		var existingNode *LookupNode
+		existingNode, err = g.FindExactPkgNodeFromPkgForArch(pkg, arch)
+		if err != nil {
+			return
+		}
+		if existingNode == nil {
+			// Try again with a more general search
+			existingNode, err = g.FindBestPkgNodeForArch(pkg, arch)
+			if err != nil {
+				return
+			}
+		}
+
+		if existingNode == nil || found[existingNode.RunNode.ID()] {
+			continue
+		}
+		found[existingNode.RunNode.ID()] = true
+		runNodes = append(runNodes, existingNode.RunNode)
+	}
+
+	return
+}
+
 // CreateSubGraph returns a new graph with which only contains the nodes accessible from rootNode.
 func (g *PkgGraph) CreateSubGraph(rootNode *PkgNode) (subGraph *PkgGraph, err error) {
 	search := traverse.DepthFirst{}
@@ -947,6 +1224,12 @@ func (g *PkgGraph) CreateSubGraph(rootNode *PkgNode) (subGraph *PkgGraph, err er
 		return false
 	})
 
+	for _, weakEdge := range g.AllWeakEdges() {
+		if subGraph.Node(weakEdge.From.ID()) != nil && subGraph.Node(weakEdge.To.ID()) != nil {
+			subGraph.AddWeakEdge(weakEdge.From, weakEdge.To, weakEdge.Kind)
+		}
+	}
+
 	subgraphSize := subGraph.Nodes().Len()
 	logger.Log.Debugf("Created sub graph with %d nodes rooted at \"%s\"", subgraphSize, rootNode.FriendlyName())
 
@@ -982,18 +1265,35 @@ func ReadDOTGraphFile(g graph.DirectedBuilder, filename string) (err error) {
 	return
 }
 
-// ReadDOTGraph de-serializes a graph from a DOT formatted object
+// ReadDOTGraph de-serializes a graph from a DOT formatted object. The weak edges of a PkgGraph are restored.
 func ReadDOTGraph(g graph.DirectedBuilder, input io.Reader) (err error) {
 	bytes, err := ioutil.ReadAll(input)
 	if err != nil {
 		return
 	}
-	err = dot.Unmarshal(bytes, g)
+
+	pkgGraph, isPkgGraph := g.(*PkgGraph)
+	if !isPkgGraph {
+		err = dot.Unmarshal(bytes, g)
+		return
+	}
+
+	builder := &dotBuilder{PkgGraph: pkgGraph}
+	err = dot.Unmarshal(bytes, builder)
+	if err != nil {
+		return
+	}
+	builder.splitWeakEdges()
 	return
 }
 
-// WriteDOTGraph serializes a graph into a DOT formatted object
+// WriteDOTGraph serializes a graph into a DOT formatted object. The weak edges of a PkgGraph are written as
+// attributed edges.
 func WriteDOTGraph(g graph.Directed, output io.Writer) (err error) {
+	if pkgGraph, isPkgGraph := g.(*PkgGraph); isPkgGraph {
+		g = dotGraph{pkgGraph}
+	}
+
 	bytes, err := dot.Marshal(g, "dependency_graph", "", "")
 	if err != nil {
 		return
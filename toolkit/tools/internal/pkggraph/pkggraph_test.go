@@ -12,6 +12,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/encoding"
+	"gonum.org/v1/gonum/graph/topo"
 	"microsoft.com/pkggen/internal/logger"
 	"microsoft.com/pkggen/internal/pkgjson"
 )
@@ -667,6 +669,55 @@ func TestAddDuplicateBuildNode(t *testing.T) {
 	assert.Error(t, err)
 }
 
+// Make sure removed nodes are no longer found in the lookup table
+func TestRemovePkgNode(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.NotNil(t, g)
+
+	lu, err := g.FindExactPkgNodeFromPkg(&pkgB)
+	assert.NoError(t, err)
+	assert.NotNil(t, lu)
+	runID := lu.RunNode.ID()
+
+	g.RemovePkgNode(lu.BuildNode)
+	g.RemovePkgNode(lu.RunNode)
+
+	lu, err = g.FindExactPkgNodeFromPkg(&pkgB)
+	assert.NoError(t, err)
+	assert.Nil(t, lu)
+	assert.Nil(t, g.Node(runID))
+	assert.Equal(t, len(allNodes)-2, len(g.AllNodes()))
+	assert.Equal(t, len(buildNodes)-1, len(g.AllBuildNodes()))
+
+	// Removing the node should allow it to be added again
+	_, err = addNodeToGraphHelper(g, pkgBRun)
+	assert.NoError(t, err)
+	_, err = addNodeToGraphHelper(g, pkgBBuild)
+	assert.NoError(t, err)
+}
+
+// Make sure removing one version of a package leaves the others intact
+func TestRemovePkgNodeKeepsOtherVersions(t *testing.T) {
+	g, err := buildTestGraphHelper()
+	assert.NoError(t, err)
+	assert.NotNil(t, g)
+
+	lu, err := g.FindExactPkgNodeFromPkg(&pkgD3)
+	assert.NoError(t, err)
+	assert.NotNil(t, lu)
+	g.RemovePkgNode(lu.RunNode)
+
+	lu, err = g.FindExactPkgNodeFromPkg(&pkgD3)
+	assert.NoError(t, err)
+	assert.Nil(t, lu)
+
+	lu, err = g.FindExactPkgNodeFromPkg(&pkgD4)
+	assert.NoError(t, err)
+	assert.NotNil(t, lu)
+	assert.Equal(t, len(runNodes)+len(unresolvedNodes)-1, len(g.AllRunNodes()))
+}
+
 // Make sure that we can't successfully search when we are missing run nodes
 func TestLookupWithoutRunNodes(t *testing.T) {
 	g := NewPkgGraph()
@@ -674,6 +725,321 @@ func TestLookupWithoutRunNodes(t *testing.T) {
 	assert.Error(t, err)
 }
 
+// Make sure the same package can be added for multiple architectures, and is found for the right one
+func TestArchitectureLookup(t *testing.T) {
+	g := NewPkgGraph()
+	pkg := &pkgjson.PackageVer{Name: "multiarch", Version: "1", Condition: "="}
+
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		_, err := g.AddPkgNode(pkg, StateMeta, TypeRun, "multiarch.src.rpm", "multiarch.spec", "", arch, "test_repo")
+		assert.NoError(t, err)
+		_, err = g.AddPkgNode(pkg, StateBuild, TypeBuild, "multiarch.src.rpm", "multiarch.spec", "", arch, "test_repo")
+		assert.NoError(t, err)
+	}
+
+	// The same architecture can't be added twice
+	_, err := g.AddPkgNode(pkg, StateMeta, TypeRun, "multiarch.src.rpm", "multiarch.spec", "", "x86_64", "test_repo")
+	assert.Error(t, err)
+
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		lu, err := g.FindExactPkgNodeFromPkgForArch(pkg, arch)
+		assert.NoError(t, err)
+		assert.NotNil(t, lu)
+		assert.Equal(t, arch, lu.RunNode.Architecture)
+		assert.Equal(t, arch, lu.BuildNode.Architecture)
+
+		lu, err = g.FindBestPkgNodeForArch(&pkgjson.PackageVer{Name: "multiarch"}, arch)
+		assert.NoError(t, err)
+		assert.NotNil(t, lu)
+		assert.Equal(t, arch, lu.RunNode.Architecture)
+	}
+
+	lu, err := g.FindBestPkgNodeForArch(&pkgjson.PackageVer{Name: "multiarch"}, "ppc64le")
+	assert.NoError(t, err)
+	assert.Nil(t, lu)
+
+	// Any architecture is accepted if none is requested
+	lu, err = g.FindBestPkgNodeForArch(&pkgjson.PackageVer{Name: "multiarch"}, "")
+	assert.NoError(t, err)
+	assert.NotNil(t, lu)
+}
+
+// Make sure noarch packages are used only when no package for the requested architecture is available
+func TestArchitectureLookupNoArchFallback(t *testing.T) {
+	g := NewPkgGraph()
+	pkgV1 := &pkgjson.PackageVer{Name: "fallback", Version: "1", Condition: "="}
+	pkgV2 := &pkgjson.PackageVer{Name: "fallback", Version: "2", Condition: "="}
+
+	_, err := g.AddPkgNode(pkgV1, StateMeta, TypeRun, "fallback.src.rpm", "fallback.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	_, err = g.AddPkgNode(pkgV2, StateMeta, TypeRun, "fallback.src.rpm", "fallback.spec", "", NoArchitecture, "test_repo")
+	assert.NoError(t, err)
+
+	lu, err := g.FindBestPkgNodeForArch(&pkgjson.PackageVer{Name: "fallback"}, "x86_64")
+	assert.NoError(t, err)
+	assert.NotNil(t, lu)
+	assert.Equal(t, "x86_64", lu.RunNode.Architecture)
+
+	lu, err = g.FindBestPkgNodeForArch(&pkgjson.PackageVer{Name: "fallback"}, "aarch64")
+	assert.NoError(t, err)
+	assert.NotNil(t, lu)
+	assert.Equal(t, NoArchitecture, lu.RunNode.Architecture)
+
+	// A version only available as noarch is still found
+	lu, err = g.FindBestPkgNodeForArch(&pkgjson.PackageVer{Name: "fallback", Version: "2", Condition: ">="}, "x86_64")
+	assert.NoError(t, err)
+	assert.NotNil(t, lu)
+	assert.Equal(t, NoArchitecture, lu.RunNode.Architecture)
+}
+
+// Weak dependencies should resolve into weak edges, reversed for Supplements and Enhances, without adding graph edges
+func TestWeakEdges(t *testing.T) {
+	g := NewPkgGraph()
+	base, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "base", Version: "1", Condition: "="}, StateMeta, TypeRun, "base.src.rpm", "base.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	docs, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "docs", Version: "1", Condition: "="}, StateMeta, TypeRun, "docs.src.rpm", "docs.spec", "", NoArchitecture, "test_repo")
+	assert.NoError(t, err)
+	plugin, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "plugin", Version: "1", Condition: "="}, StateMeta, TypeRun, "plugin.src.rpm", "plugin.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+
+	richRecommends, err := pkgjson.ParseRichDependency("(missing or docs)")
+	assert.NoError(t, err)
+	err = g.AddWeakDependencies(base, WeakDependenciesFromPackage(&pkgjson.Package{
+		Recommends: []*pkgjson.PackageVer{richRecommends},
+		Suggests:   []*pkgjson.PackageVer{{Name: "not-in-graph"}},
+	}))
+	assert.NoError(t, err)
+	richSupplements, err := pkgjson.ParseRichDependency("(base and not-in-graph)")
+	assert.NoError(t, err)
+	err = g.AddWeakDependencies(plugin, WeakDependenciesFromPackage(&pkgjson.Package{
+		Supplements: []*pkgjson.PackageVer{{Name: "base"}, richSupplements},
+	}))
+	assert.NoError(t, err)
+
+	edges := g.AllWeakEdges()
+	assert.Equal(t, 2, len(edges))
+	for _, edge := range edges {
+		assert.Equal(t, base.ID(), edge.From.ID())
+		switch edge.Kind {
+		case WeakRecommends:
+			assert.Equal(t, docs.ID(), edge.To.ID())
+		case WeakSupplements:
+			assert.Equal(t, plugin.ID(), edge.To.ID())
+		default:
+			assert.Fail(t, "unexpected weak edge kind", edge.Kind.String())
+		}
+	}
+	assert.Equal(t, edges, g.WeakEdgesFrom(base))
+	assert.Empty(t, g.WeakEdgesFrom(plugin))
+	assert.Equal(t, 0, g.Edges().Len())
+}
+
+// Weak edges closing a loop of dependency edges should not create a cycle or change the build order
+func TestWeakEdgesShouldNotCreateCycles(t *testing.T) {
+	g := NewPkgGraph()
+	base, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "base", Version: "1", Condition: "="}, StateMeta, TypeRun, "base.src.rpm", "base.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	plugin, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "plugin", Version: "1", Condition: "="}, StateMeta, TypeRun, "plugin.src.rpm", "plugin.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(plugin, base))
+	g.AddWeakEdge(base, plugin, WeakRecommends)
+
+	sorted, err := topo.Sort(g)
+	assert.NoError(t, err)
+	assert.Equal(t, []graph.Node{plugin, base}, sorted)
+	assert.Equal(t, 1, g.From(base.ID()).Len()+g.From(plugin.ID()).Len())
+	assert.Equal(t, 1, len(g.AllWeakEdges()))
+}
+
+// A dependency edge between two nodes should replace any weak edge between them
+func TestWeakEdgesShouldYieldToDependencyEdges(t *testing.T) {
+	g := NewPkgGraph()
+	base, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "base", Version: "1", Condition: "="}, StateMeta, TypeRun, "base.src.rpm", "base.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	docs, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "docs", Version: "1", Condition: "="}, StateMeta, TypeRun, "docs.src.rpm", "docs.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+
+	g.AddWeakEdge(base, docs, WeakSuggests)
+	g.AddWeakEdge(base, docs, WeakRecommends)
+	g.AddWeakEdge(base, docs, WeakEnhances)
+	assert.Equal(t, []WeakEdge{{From: base, To: docs, Kind: WeakRecommends}}, g.AllWeakEdges())
+
+	g.SetEdge(g.NewEdge(base, docs))
+	assert.Empty(t, g.AllWeakEdges())
+
+	g.AddWeakEdge(base, docs, WeakRecommends)
+	assert.Empty(t, g.AllWeakEdges())
+}
+
+// Removing a node should remove the weak edges to and from it
+func TestRemovePkgNodeShouldRemoveWeakEdges(t *testing.T) {
+	g := NewPkgGraph()
+	base, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "base", Version: "1", Condition: "="}, StateMeta, TypeRun, "base.src.rpm", "base.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	docs, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "docs", Version: "1", Condition: "="}, StateMeta, TypeRun, "docs.src.rpm", "docs.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	plugin, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "plugin", Version: "1", Condition: "="}, StateMeta, TypeRun, "plugin.src.rpm", "plugin.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+
+	g.AddWeakEdge(base, docs, WeakRecommends)
+	g.AddWeakEdge(plugin, base, WeakSuggests)
+	g.RemovePkgNode(base)
+
+	assert.Empty(t, g.AllWeakEdges())
+}
+
+// Weak edges should survive a round trip through the DOT encoding without becoming dependency edges
+func TestWeakEdgesEncodeDecodeDOT(t *testing.T) {
+	g := NewPkgGraph()
+	base, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "base", Version: "1", Condition: "="}, StateMeta, TypeRun, "base.src.rpm", "base.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	docs, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "docs", Version: "2", Condition: "="}, StateMeta, TypeRun, "docs.src.rpm", "docs.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	other, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "other", Version: "1", Condition: "="}, StateMeta, TypeRun, "other.src.rpm", "other.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(docs, base))
+	g.AddWeakEdge(base, docs, WeakRecommends)
+	g.AddWeakEdge(base, other, WeakEnhances)
+
+	var buf bytes.Buffer
+	err = WriteDOTGraph(g, &buf)
+	assert.NoError(t, err)
+
+	gOut := NewPkgGraph()
+	err = ReadDOTGraph(gOut, &buf)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, gOut.Edges().Len())
+	weakEdges := gOut.AllWeakEdges()
+	assert.Equal(t, 2, len(weakEdges))
+	for _, edge := range weakEdges {
+		assert.Equal(t, "base", edge.From.VersionedPkg.Name)
+		switch edge.Kind {
+		case WeakRecommends:
+			assert.True(t, docs.Equal(edge.To))
+			assert.False(t, gOut.HasEdgeFromTo(edge.From.ID(), edge.To.ID()))
+			assert.True(t, gOut.HasEdgeFromTo(edge.To.ID(), edge.From.ID()))
+		case WeakEnhances:
+			assert.True(t, other.Equal(edge.To))
+		default:
+			assert.Fail(t, "unexpected weak edge kind", edge.Kind.String())
+		}
+	}
+}
+
+// Sub graphs should keep the weak edges between the nodes they contain
+func TestCreateSubGraphShouldKeepWeakEdges(t *testing.T) {
+	g := NewPkgGraph()
+	base, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "base", Version: "1", Condition: "="}, StateMeta, TypeRun, "base.src.rpm", "base.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	lib, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "lib", Version: "1", Condition: "="}, StateMeta, TypeRun, "lib.src.rpm", "lib.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	docs, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "docs", Version: "1", Condition: "="}, StateMeta, TypeRun, "docs.src.rpm", "docs.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(base, lib))
+	g.AddWeakEdge(lib, base, WeakSupplements)
+	g.AddWeakEdge(base, docs, WeakRecommends)
+
+	subGraph, err := g.CreateSubGraph(base)
+	assert.NoError(t, err)
+	assert.Nil(t, subGraph.Node(docs.ID()))
+	assert.Equal(t, []WeakEdge{{From: lib, To: base, Kind: WeakSupplements}}, subGraph.AllWeakEdges())
+}
+
+// Conditional build variants should only be found by lookups which request them
+func TestVariantLookup(t *testing.T) {
+	g := NewPkgGraph()
+	pkgVer := &pkgjson.PackageVer{Name: "base", Version: "1", Condition: "="}
+	defaultRun, err := g.AddPkgNode(pkgVer, StateMeta, TypeRun, "base.src.rpm", "base.spec", "", "x86_64", "test_repo")
+	assert.NoError(t, err)
+	bootstrapRun, err := g.AddVariantPkgNode(pkgVer, StateMeta, TypeRun, "base.src.rpm", "base.spec", "", "x86_64", "test_repo", "bootstrap", map[string]string{"_with_bootstrap": "--with-bootstrap"})
+	assert.NoError(t, err)
+	_, err = g.AddVariantPkgNode(pkgVer, StateMeta, TypeRun, "base.src.rpm", "base.spec", "", "x86_64", "test_repo", "bootstrap", nil)
+	assert.Error(t, err)
+
+	lookup, err := g.FindBestPkgNodeForArch(&pkgjson.PackageVer{Name: "base"}, "x86_64")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultRun, lookup.RunNode)
+
+	lookup, err = g.FindExactPkgNodeFromPkgForArch(pkgVer, "x86_64")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultRun, lookup.RunNode)
+
+	lookup, err = g.FindExactPkgNodeFromPkgForVariant(pkgVer, "x86_64", "bootstrap")
+	assert.NoError(t, err)
+	assert.Equal(t, bootstrapRun, lookup.RunNode)
+
+	variants, err := g.FindVariantsOf(defaultRun)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(variants))
+	assert.Equal(t, bootstrapRun, variants[0].RunNode)
+
+	_, err = g.FindVariantsOf(bootstrapRun)
+	assert.Error(t, err)
+}
+
+// Conditional build variants should survive a round trip through the DOT encoding
+func TestVariantEncodeDecodeDOT(t *testing.T) {
+	g := NewPkgGraph()
+	n, err := g.AddVariantPkgNode(&pkgjson.PackageVer{Name: "base", Version: "1", Condition: "="}, StateMeta, TypeRun, "base.src.rpm", "base.spec", "", "x86_64", "test_repo", "bootstrap", map[string]string{"_with_bootstrap": "--with-bootstrap", "with_check": "0"})
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = WriteDOTGraph(g, &buf)
+	assert.NoError(t, err)
+
+	gOut := NewPkgGraph()
+	err = ReadDOTGraph(gOut, &buf)
+	assert.NoError(t, err)
+
+	decoded := gOut.AllNodes()
+	assert.Equal(t, 1, len(decoded))
+	assert.True(t, n.Equal(decoded[0]))
+	assert.Equal(t, "bootstrap", decoded[0].Variant)
+	assert.Equal(t, "0", decoded[0].VariantDefines["with_check"])
+}
+
+// Builds should only require the variants they, or the packages they install, depend on
+func TestVariantsRequiredBy(t *testing.T) {
+	g := NewPkgGraph()
+	addNode := func(name, variant string, nodeType NodeType) *PkgNode {
+		n, err := g.AddVariantPkgNode(&pkgjson.PackageVer{Name: name, Version: "1", Condition: "="}, StateBuild, nodeType, name+"-1-1.src.rpm", name+".spec", "", "x86_64", "test_repo", variant, nil)
+		assert.NoError(t, err)
+		return n
+	}
+	addEdge := func(from, to *PkgNode) {
+		g.SetEdge(g.NewEdge(from, to))
+	}
+
+	addNode("app", "", TypeRun)
+	appBuild := addNode("app", "", TypeBuild)
+	libRun := addNode("lib", "", TypeRun)
+	libBuild := addNode("lib", "", TypeBuild)
+	baseRun := addNode("base", "bootstrap", TypeRun)
+	baseBuild := addNode("base", "bootstrap", TypeBuild)
+	toolRun := addNode("tool", "minimal", TypeRun)
+
+	// app -> lib -> base(bootstrap), and only the build of base(bootstrap) needs tool(minimal)
+	addEdge(appBuild, libRun)
+	addEdge(libRun, libBuild)
+	addEdge(libRun, baseRun)
+	addEdge(baseRun, baseBuild)
+	addEdge(baseBuild, toolRun)
+
+	variants := g.VariantsRequiredBy([]*PkgNode{appBuild})
+	assert.Equal(t, []*PkgNode{baseRun}, variants)
+
+	variants = g.VariantsRequiredBy([]*PkgNode{baseBuild})
+	assert.Equal(t, []*PkgNode{toolRun}, variants)
+
+	assert.Empty(t, g.VariantsRequiredBy([]*PkgNode{libBuild}))
+
+	assert.Equal(t, "base-1-1.src.rpm (bootstrap)", baseBuild.BuildKey())
+	assert.Equal(t, "base-1-1-bootstrap", baseBuild.VariantBuildName())
+	assert.Equal(t, "app-1-1.src.rpm", appBuild.BuildKey())
+	assert.Equal(t, "", appBuild.VariantBuildName())
+}
+
 // Add a goal node
 func TestAddGoalToEmptyGraph(t *testing.T) {
 	g := NewPkgGraph()
@@ -725,6 +1091,37 @@ func TestGoalWithPackages(t *testing.T) {
 	assert.Equal(t, 2, len(goalNodes))
 }
 
+// Goals must link the packages of every architecture in the graph, and noarch packages only once
+func TestGoalWithMultipleArchitectures(t *testing.T) {
+	g := NewPkgGraph()
+	pkg := &pkgjson.PackageVer{Name: "multiarch", Version: "1", Condition: "="}
+	docs := &pkgjson.PackageVer{Name: "docs", Version: "1", Condition: "="}
+
+	var expectedNodes []*PkgNode
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		n, err := g.AddPkgNode(pkg, StateMeta, TypeRun, "multiarch.src.rpm", "multiarch.spec", "", arch, "test_repo")
+		assert.NoError(t, err)
+		expectedNodes = append(expectedNodes, n)
+	}
+	docsNode, err := g.AddPkgNode(docs, StateMeta, TypeRun, "docs.src.rpm", "docs.spec", "", NoArchitecture, "test_repo")
+	assert.NoError(t, err)
+
+	goalNodeIDs := func(goal *PkgNode) (ids []int64) {
+		for _, n := range graph.NodesOf(g.From(goal.ID())) {
+			ids = append(ids, n.ID())
+		}
+		return
+	}
+
+	goal, err := g.AddGoalNode("ALL", nil, true)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int64{expectedNodes[0].ID(), expectedNodes[1].ID(), docsNode.ID()}, goalNodeIDs(goal))
+
+	goal, err = g.AddGoalNode("packages", []*pkgjson.PackageVer{{Name: "multiarch"}, {Name: "docs"}}, true)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int64{expectedNodes[0].ID(), expectedNodes[1].ID(), docsNode.ID()}, goalNodeIDs(goal))
+}
+
 // Make sure we fail when trying to add an invalid node to a goal
 func TestStrictGoalNodes(t *testing.T) {
 	g := NewPkgGraph()
@@ -939,6 +1336,41 @@ func TestReferenceDOTFile(t *testing.T) {
 	assert.Equal(t, 0, bytes.Compare(bytesFromCode, bytesFromFile))
 }
 
+// Validate graphs written with the legacy base64 encoding can still be read.
+func TestLegacyReferenceDOTFile(t *testing.T) {
+	gIn := NewPkgGraph()
+	err := ReadDOTGraphFile(gIn, "test_graph_reference_v1.dot")
+	assert.NoError(t, err)
+
+	checkTestGraph(t, gIn)
+}
+
+// Validate the node attributes of a DOT file are plain text.
+func TestDOTAttributesArePlain(t *testing.T) {
+	attributes := pkgARun.Attributes()
+	attributeMap := make(map[string]string)
+	for _, attr := range attributes {
+		attributeMap[attr.Key] = attr.Value
+	}
+
+	assert.Equal(t, dotEncodingVersion, attributeMap[dotKeyEncodingVersion])
+	assert.Equal(t, "A", attributeMap[dotKeyName])
+	assert.Equal(t, "1", attributeMap[dotKeyVersion])
+	assert.Equal(t, "Meta", attributeMap[dotKeyState])
+	assert.Equal(t, "Run", attributeMap[dotKeyType])
+	assert.Equal(t, "A.src.rpm", attributeMap[dotKeySRPM])
+	assert.NotContains(t, attributeMap, dotKeyNodeInBase64)
+	assert.NotContains(t, attributeMap, dotKeyGoal)
+}
+
+// Validate unknown encoding versions and values are rejected.
+func TestDOTAttributesInvalid(t *testing.T) {
+	n := &PkgNode{}
+	assert.Error(t, n.SetAttribute(encoding.Attribute{Key: dotKeyEncodingVersion, Value: "999"}))
+	assert.Error(t, n.SetAttribute(encoding.Attribute{Key: dotKeyState, Value: "NotAState"}))
+	assert.Error(t, n.SetAttribute(encoding.Attribute{Key: dotKeyType, Value: "NotAType"}))
+}
+
 // Make sure we can extract a subgraph
 func TestSubgraph(t *testing.T) {
 	g, err := buildTestGraphHelper()
@@ -0,0 +1,166 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/graph"
+	"microsoft.com/pkggen/internal/pkgjson"
+)
+
+// Dot encoding/decoding keys for conditional build variants
+const (
+	dotKeyVariant        = "Variant"
+	dotKeyVariantDefines = "VariantDefines" // JSON map of rpm define names to values
+)
+
+// FindVariantsOf returns the lookup entries of every conditional build variant of the package represented by a
+// default run node, such as a bootstrap build of the same SPEC. The entries are sorted by variant name.
+func (g *PkgGraph) FindVariantsOf(runNode *PkgNode) (variants []*LookupNode, err error) {
+	if runNode.Variant != "" {
+		err = fmt.Errorf("%s is already a variant", runNode.FriendlyName())
+		return
+	}
+
+	requestInterval, err := runNode.VersionedPkg.Interval()
+	if err != nil {
+		return
+	}
+
+	for _, node := range g.lookupTable()[runNode.VersionedPkg.Name] {
+		var nodeInterval pkgjson.PackageVerInterval
+
+		if node.RunNode == nil || node.RunNode.Variant == "" || node.RunNode.Architecture != runNode.Architecture {
+			continue
+		}
+
+		nodeInterval, err = node.RunNode.VersionedPkg.Interval()
+		if err != nil {
+			return
+		}
+		if requestInterval.Equal(&nodeInterval) {
+			variants = append(variants, node)
+		}
+	}
+
+	sort.Slice(variants, func(i, j int) bool {
+		return variants[i].RunNode.Variant < variants[j].RunNode.Variant
+	})
+
+	return
+}
+
+// BuildKey identifies the build of the SRPM a node belongs to. Conditional build variants of an SRPM are built
+// separately from its default build, so their key also includes the variant name.
+func (n *PkgNode) BuildKey() string {
+	if n.Variant == "" {
+		return n.SrpmPath
+	}
+	return fmt.Sprintf("%s (%s)", n.SrpmPath, n.Variant)
+}
+
+// VariantBuildName returns a name for the variant build a node belongs to which is safe to use in file and make
+// target names, such as "foo-1.0-1.cm1-bootstrap". Returns an empty string for nodes of a default build.
+func (n *PkgNode) VariantBuildName() string {
+	if n.Variant == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s-%s", strings.TrimSuffix(filepath.Base(n.SrpmPath), ".src.rpm"), n.Variant)
+}
+
+// VariantsRequiredBy returns a run node for every conditional build variant whose RPMs must be available to build
+// buildNodes: variants they depend on directly, or through the run-time dependencies of the packages they install.
+// The build requirements of other builds are not followed. The nodes are sorted by build key.
+func (g *PkgGraph) VariantsRequiredBy(buildNodes []*PkgNode) (variants []*PkgNode) {
+	visited := make(map[int64]bool)
+	found := make(map[string]*PkgNode)
+
+	var toVisit []*PkgNode
+	for _, buildNode := range buildNodes {
+		for _, dependency := range graph.NodesOf(g.From(buildNode.ID())) {
+			toVisit = append(toVisit, dependency.(*PkgNode))
+		}
+	}
+
+	for len(toVisit) > 0 {
+		n := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+
+		if visited[n.ID()] || n.Type == TypeBuild {
+			continue
+		}
+		visited[n.ID()] = true
+
+		if n.Type == TypeRun && n.Variant != "" {
+			found[n.BuildKey()] = n
+		}
+
+		for _, dependency := range graph.NodesOf(g.From(n.ID())) {
+			toVisit = append(toVisit, dependency.(*PkgNode))
+		}
+	}
+
+	for _, n := range found {
+		variants = append(variants, n)
+	}
+	sort.Slice(variants, func(i, j int) bool {
+		return variants[i].BuildKey() < variants[j].BuildKey()
+	})
+
+	return
+}
+
+// variantSuffix returns the variant name to include in the friendly name of a node, if any.
+func (n *PkgNode) variantSuffix() string {
+	if n.Variant == "" {
+		return ""
+	}
+	return fmt.Sprintf("(%s)", n.Variant)
+}
+
+// variantDefinesAttribute encodes the variant defines of a node as JSON, nodes without any defines return an
+// empty string so the attribute is omitted.
+func (n *PkgNode) variantDefinesAttribute() (value string, err error) {
+	if len(n.VariantDefines) == 0 {
+		return
+	}
+
+	encoded, err := json.Marshal(n.VariantDefines)
+	if err != nil {
+		return
+	}
+
+	value = string(encoded)
+	return
+}
+
+// setVariantDefinesAttribute decodes a DOT attribute holding the variant defines of a node.
+func (n *PkgNode) setVariantDefinesAttribute(value string) (err error) {
+	err = json.Unmarshal([]byte(value), &n.VariantDefines)
+	if err != nil {
+		err = fmt.Errorf("invalid variant defines: %s", err)
+	}
+	return
+}
+
+// variantDefinesEqual returns true if both sets of defines hold the same values, nil and empty sets are equal.
+func variantDefinesEqual(defines, otherDefines map[string]string) bool {
+	if len(defines) != len(otherDefines) {
+		return false
+	}
+
+	for name, value := range defines {
+		otherValue, found := otherDefines[name]
+		if !found || otherValue != value {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,331 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkggraph
+
+import (
+	"fmt"
+	"sort"
+
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/encoding"
+	"gonum.org/v1/gonum/graph/iterator"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkgjson"
+)
+
+// WeakDependencyKind describes the type of an optional dependency
+type WeakDependencyKind int
+
+// Valid weak dependency kinds
+const (
+	WeakRecommends  WeakDependencyKind = iota // The package should be installed along with the dependent package
+	WeakSuggests    WeakDependencyKind = iota // The package may be useful along with the dependent package
+	WeakSupplements WeakDependencyKind = iota // Reverse Recommends: the dependent package should be installed along with the package
+	WeakEnhances    WeakDependencyKind = iota // Reverse Suggests: the dependent package may be useful along with the package
+)
+
+// Names of the weak dependency kinds, as used in DOT encoding
+const (
+	dotKeyRecommends  = "Recommends"
+	dotKeySuggests    = "Suggests"
+	dotKeySupplements = "Supplements"
+	dotKeyEnhances    = "Enhances"
+)
+
+// dotKeyWeakDependency marks a DOT edge as a weak edge, its value is the kind of the weak dependency
+const dotKeyWeakDependency = "WeakDependency"
+
+// WeakDependency is an optional dependency of a package, as listed in its SPEC file.
+// Use AddWeakDependencies to resolve them into weak edges of the graph.
+type WeakDependency struct {
+	Kind         WeakDependencyKind
+	VersionedPkg *pkgjson.PackageVer
+}
+
+// WeakEdge is an optional dependency of the node From on the node To.
+// Supplements and Enhances are reversed, so the edge always points from the package which pulls in the other.
+//
+// Weak edges are kept apart from the dependency edges of the graph: every graph algorithm (sorting, cycle detection,
+// reachability) only follows dependency edges, so weak dependencies never affect the build order or create cycles.
+// Use WeakEdgesFrom and AllWeakEdges to walk them.
+type WeakEdge struct {
+	From *PkgNode
+	To   *PkgNode
+	Kind WeakDependencyKind
+}
+
+func (k WeakDependencyKind) String() string {
+	switch k {
+	case WeakRecommends:
+		return dotKeyRecommends
+	case WeakSuggests:
+		return dotKeySuggests
+	case WeakSupplements:
+		return dotKeySupplements
+	case WeakEnhances:
+		return dotKeyEnhances
+	default:
+		return "UNKNOWN WEAK DEPENDENCY"
+	}
+}
+
+// weakDependencyKindFromString converts the name of a weak dependency kind back into its value.
+func weakDependencyKindFromString(value string) (kind WeakDependencyKind, err error) {
+	for _, kind = range []WeakDependencyKind{WeakRecommends, WeakSuggests, WeakSupplements, WeakEnhances} {
+		if kind.String() == value {
+			return
+		}
+	}
+
+	err = fmt.Errorf("unknown weak dependency kind %s", value)
+	return
+}
+
+// IsReverse returns true if the dependency is declared by the package which gets pulled in (Supplements and Enhances).
+func (k WeakDependencyKind) IsReverse() bool {
+	return k == WeakSupplements || k == WeakEnhances
+}
+
+// IsInstalledByDefault returns true if the package manager installs this kind of dependency by default.
+func (k WeakDependencyKind) IsInstalledByDefault() bool {
+	return k == WeakRecommends || k == WeakSupplements
+}
+
+// WeakDependenciesFromPackage returns all the weak dependencies of a package.
+func WeakDependenciesFromPackage(pkg *pkgjson.Package) (weakDependencies []*WeakDependency) {
+	dependencySets := []struct {
+		kind         WeakDependencyKind
+		dependencies []*pkgjson.PackageVer
+	}{
+		{WeakRecommends, pkg.Recommends},
+		{WeakSuggests, pkg.Suggests},
+		{WeakSupplements, pkg.Supplements},
+		{WeakEnhances, pkg.Enhances},
+	}
+
+	for _, dependencySet := range dependencySets {
+		for _, dependency := range dependencySet.dependencies {
+			weakDependencies = append(weakDependencies, &WeakDependency{Kind: dependencySet.kind, VersionedPkg: dependency})
+		}
+	}
+
+	return
+}
+
+// AddWeakDependencies resolves the weak dependencies of a node into weak edges. Dependencies which are not
+// provided by any node in the graph are skipped, as are Supplements and Enhances naming any such package.
+// Options of rich dependencies which are present in the graph are preferred. Since resolution uses the nodes present
+// in the graph at the time of the call, it should run once every package has been added.
+func (g *PkgGraph) AddWeakDependencies(n *PkgNode, weakDependencies []*WeakDependency) (err error) {
+	neverInstalled := func(*pkgjson.PackageVer) bool { return false }
+	inGraph := func(pkgVer *pkgjson.PackageVer) bool {
+		lookupNode, lookupErr := g.FindBestPkgNodeForArch(pkgVer, n.Architecture)
+		return lookupErr == nil && lookupNode != nil
+	}
+
+	for _, weakDependency := range weakDependencies {
+		var (
+			targets        []*PkgNode
+			missingTargets bool
+		)
+
+		for _, pkgVer := range weakDependency.VersionedPkg.RequiredPackages(neverInstalled, inGraph) {
+			var lookupNode *LookupNode
+
+			lookupNode, err = g.FindBestPkgNodeForArch(pkgVer, n.Architecture)
+			if err != nil {
+				return
+			}
+			if lookupNode == nil {
+				missingTargets = true
+				continue
+			}
+			targets = append(targets, lookupNode.RunNode)
+		}
+
+		// A reverse dependency is a condition on the packages being installed, it only holds if all of them are present.
+		if weakDependency.Kind.IsReverse() && missingTargets {
+			continue
+		}
+
+		for _, target := range targets {
+			if weakDependency.Kind.IsReverse() {
+				g.AddWeakEdge(target, n, weakDependency.Kind)
+			} else {
+				g.AddWeakEdge(n, target, weakDependency.Kind)
+			}
+		}
+	}
+
+	return
+}
+
+// AddWeakEdge adds a weak edge of the given kind from one node to another. Weak edges from a node to itself, or
+// between two nodes already linked by a dependency edge, are ignored. If the nodes are already linked by a weak edge,
+// the kind installed by default is kept.
+func (g *PkgGraph) AddWeakEdge(from, to *PkgNode, kind WeakDependencyKind) {
+	if from.ID() == to.ID() || g.HasEdgeFromTo(from.ID(), to.ID()) {
+		return
+	}
+
+	if g.weakEdges == nil {
+		g.weakEdges = make(map[int64]map[int64]WeakDependencyKind)
+	}
+	if g.weakEdges[from.ID()] == nil {
+		g.weakEdges[from.ID()] = make(map[int64]WeakDependencyKind)
+	}
+
+	existingKind, exists := g.weakEdges[from.ID()][to.ID()]
+	if exists && (existingKind.IsInstalledByDefault() || !kind.IsInstalledByDefault()) {
+		return
+	}
+	g.weakEdges[from.ID()][to.ID()] = kind
+}
+
+// SetEdge adds a dependency edge to the graph, replacing any weak edge between the same nodes.
+func (g *PkgGraph) SetEdge(e graph.Edge) {
+	g.RemoveWeakEdge(e.From().ID(), e.To().ID())
+	g.DirectedGraph.SetEdge(e)
+}
+
+// RemoveWeakEdge removes the weak edge between two nodes, if any.
+func (g *PkgGraph) RemoveWeakEdge(fid, tid int64) {
+	delete(g.weakEdges[fid], tid)
+	if len(g.weakEdges[fid]) == 0 {
+		delete(g.weakEdges, fid)
+	}
+}
+
+// ClearWeakEdges removes every weak edge of the graph.
+func (g *PkgGraph) ClearWeakEdges() {
+	g.weakEdges = nil
+}
+
+// RemoveNode removes a node from the graph, along with any dependency or weak edges to or from it.
+func (g *PkgGraph) RemoveNode(id int64) {
+	delete(g.weakEdges, id)
+	for fid := range g.weakEdges {
+		g.RemoveWeakEdge(fid, id)
+	}
+
+	g.DirectedGraph.RemoveNode(id)
+}
+
+// WeakEdgesFrom returns the weak edges starting at a node, sorted by the ID of the node they point to.
+func (g *PkgGraph) WeakEdgesFrom(n *PkgNode) (edges []WeakEdge) {
+	for tid, kind := range g.weakEdges[n.ID()] {
+		edges = append(edges, WeakEdge{From: n, To: g.Node(tid).(*PkgNode), Kind: kind})
+	}
+
+	sort.Slice(edges, func(i, j int) bool {
+		return edges[i].To.ID() < edges[j].To.ID()
+	})
+	return
+}
+
+// AllWeakEdges returns every weak edge of the graph, sorted by the IDs of the nodes they point from and to.
+func (g *PkgGraph) AllWeakEdges() (edges []WeakEdge) {
+	for fid := range g.weakEdges {
+		edges = append(edges, g.WeakEdgesFrom(g.Node(fid).(*PkgNode))...)
+	}
+
+	sort.SliceStable(edges, func(i, j int) bool {
+		return edges[i].From.ID() < edges[j].From.ID()
+	})
+	return
+}
+
+// dotEdge is an edge of a DOT encoded graph. Weak edges are written as edges holding a dotKeyWeakDependency attribute.
+type dotEdge struct {
+	F, T graph.Node
+	weak bool
+	kind WeakDependencyKind
+}
+
+// From implements the graph.Edge interface
+func (e *dotEdge) From() graph.Node {
+	return e.F
+}
+
+// To implements the graph.Edge interface
+func (e *dotEdge) To() graph.Node {
+	return e.T
+}
+
+// ReversedEdge implements the graph.Edge interface
+func (e *dotEdge) ReversedEdge() graph.Edge {
+	return &dotEdge{F: e.T, T: e.F, weak: e.weak, kind: e.kind}
+}
+
+// Attributes implements the encoding.Attributer interface
+func (e *dotEdge) Attributes() (attributes []encoding.Attribute) {
+	if e.weak {
+		attributes = append(attributes, encoding.Attribute{Key: dotKeyWeakDependency, Value: e.kind.String()})
+	}
+	return
+}
+
+// SetAttribute implements the encoding.AttributeSetter interface
+func (e *dotEdge) SetAttribute(attr encoding.Attribute) (err error) {
+	switch attr.Key {
+	case dotKeyWeakDependency:
+		e.kind, err = weakDependencyKindFromString(attr.Value)
+		e.weak = true
+	default:
+		logger.Log.Warnf(`Unable to unmarshal an unknown edge key "%s".`, attr.Key)
+	}
+
+	return
+}
+
+// dotGraph presents a graph to the DOT encoder with its weak edges added to its dependency edges.
+type dotGraph struct {
+	*PkgGraph
+}
+
+// From implements the graph.Graph interface, returning the nodes reached by dependency or weak edges.
+func (g dotGraph) From(id int64) graph.Nodes {
+	nodes := graph.NodesOf(g.PkgGraph.From(id))
+	for tid := range g.weakEdges[id] {
+		nodes = append(nodes, g.Node(tid))
+	}
+
+	return iterator.NewOrderedNodes(nodes)
+}
+
+// Edge implements the graph.Graph interface, returning a dotEdge for weak edges.
+func (g dotGraph) Edge(uid, vid int64) graph.Edge {
+	kind, weak := g.weakEdges[uid][vid]
+	if !weak {
+		return g.PkgGraph.Edge(uid, vid)
+	}
+
+	return &dotEdge{F: g.Node(uid), T: g.Node(vid), weak: true, kind: kind}
+}
+
+// dotBuilder decodes a DOT graph into a graph, keeping track of the decoded edges. The attributes of an edge are only
+// decoded after it was added to the graph, so weak edges are moved out of the dependency edges once decoding is done.
+type dotBuilder struct {
+	*PkgGraph
+	edges []*dotEdge
+}
+
+// NewEdge implements the graph.EdgeAdder interface
+func (b *dotBuilder) NewEdge(from, to graph.Node) graph.Edge {
+	edge := &dotEdge{F: from, T: to}
+	b.edges = append(b.edges, edge)
+	return edge
+}
+
+// splitWeakEdges replaces the decoded edges with plain dependency edges and weak edges.
+func (b *dotBuilder) splitWeakEdges() {
+	for _, edge := range b.edges {
+		if edge.weak {
+			b.DirectedGraph.RemoveEdge(edge.F.ID(), edge.T.ID())
+			b.AddWeakEdge(edge.F.(*PkgNode), edge.T.(*PkgNode), edge.kind)
+		} else {
+			b.SetEdge(b.DirectedGraph.NewEdge(edge.F, edge.T))
+		}
+	}
+}
@@ -40,6 +40,10 @@ type PackageVer struct {
 	Condition  string `json:"Condition"`  // Condition to place on the version number ("<", "=<", "=", ">=", ">")
 	SVersion   string `json:"SVersion"`   // Secondary version number to express bounded versions for dependencies
 	SCondition string `json:"SCondition"` // Secondary version condition to express bounded versions for dependencies
+
+	// Rich (boolean) dependencies, see ParseRichDependency. The Name of a rich dependency holds the full expression.
+	Operator string        `json:"Operator,omitempty"` // Operator joining the operands ("and", "or", "if", "unless", "with", "without")
+	Operands []*PackageVer `json:"Operands,omitempty"` // Terms of the expression, which may be rich dependencies themselves
 }
 
 // PackageVerInterval encodes the version interval a given PackageVer struct represents
@@ -59,6 +63,14 @@ type Package struct {
 	Architecture  string        `json:"Architecture"`  // The architecture of the package
 	Requires      []*PackageVer `json:"Requires"`      // List of targets this spec requires to install
 	BuildRequires []*PackageVer `json:"BuildRequires"` // List of targets this spec requires to build
+	Recommends    []*PackageVer `json:"Recommends"`    // List of optional targets installed along with this package by default
+	Suggests      []*PackageVer `json:"Suggests"`      // List of optional targets which may be useful along with this package
+	Supplements   []*PackageVer `json:"Supplements"`   // List of targets this package should be installed along with by default
+	Enhances      []*PackageVer `json:"Enhances"`      // List of targets this package may be useful along with
+
+	// Conditional build variants, such as a bootstrap build. Both are empty for the default build of a SPEC.
+	Variant        string            `json:"Variant,omitempty"`        // Name of the variant this package is built by
+	VariantDefines map[string]string `json:"VariantDefines,omitempty"` // The rpm defines the variant is built with, on top of the default defines
 }
 
 // ParsePackageJSON reads a package list json file
@@ -93,10 +105,10 @@ func (pkgVer *PackageVer) Interval() (interval PackageVerInterval, err error) {
 	case pkgVer.Version == pkgVer.SVersion && pkgVer.Condition == pkgVer.SCondition:
 		// Only one version set, or duplicated version data
 		if pkgVer.Version != "" {
-			v1 = versioncompare.New(pkgVer.Version)
+			v1 = versioncompare.NewRPM(pkgVer.Version)
 			c1 = pkgVer.Condition
 		} else {
-			v1 = versioncompare.New(pkgVer.SVersion)
+			v1 = versioncompare.NewRPM(pkgVer.SVersion)
 			c1 = pkgVer.SCondition
 		}
 
@@ -128,9 +140,9 @@ func (pkgVer *PackageVer) Interval() (interval PackageVerInterval, err error) {
 		}
 	case pkgVer.Version != "" && pkgVer.SVersion != "":
 		// Explicit version information for both (duplicate version data is handled above)
-		v1 = versioncompare.New(pkgVer.Version)
+		v1 = versioncompare.NewRPM(pkgVer.Version)
 		c1 = pkgVer.Condition
-		v2 = versioncompare.New(pkgVer.SVersion)
+		v2 = versioncompare.NewRPM(pkgVer.SVersion)
 		c2 = pkgVer.SCondition
 
 		if v1.Compare(v2) < 0 {
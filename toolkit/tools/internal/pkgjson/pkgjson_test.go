@@ -480,3 +480,116 @@ func TestShouldFailToConvertPackageListEntryWithWhitespacesInVersion(t *testing.
 
 	assert.Error(t, err)
 }
+
+func TestShouldParseSimpleRichDependency(t *testing.T) {
+	pkgVer, err := ParseRichDependency("(foo >= 1.0 and bar)")
+
+	assert.NoError(t, err)
+	assert.True(t, pkgVer.IsRich())
+	assert.Equal(t, RichAnd, pkgVer.Operator)
+	assert.Equal(t, "(foo >= 1.0 and bar)", pkgVer.Name)
+	assert.Equal(t, 2, len(pkgVer.Operands))
+	assert.Equal(t, PackageVer{Name: "foo", Condition: ">=", Version: "1.0"}, *pkgVer.Operands[0])
+	assert.Equal(t, PackageVer{Name: "bar"}, *pkgVer.Operands[1])
+}
+
+func TestShouldParseNestedRichDependency(t *testing.T) {
+	pkgVer, err := ParseRichDependency("((a or b) if (c and d) else e)")
+
+	assert.NoError(t, err)
+	assert.Equal(t, RichIf, pkgVer.Operator)
+	assert.Equal(t, 3, len(pkgVer.Operands))
+	assert.Equal(t, RichOr, pkgVer.Operands[0].Operator)
+	assert.Equal(t, RichAnd, pkgVer.Operands[1].Operator)
+	assert.Equal(t, "e", pkgVer.Operands[2].Name)
+	assert.Equal(t, "((a or b) if (c and d) else e)", pkgVer.Name)
+}
+
+func TestShouldUnwrapParenthesizedDependency(t *testing.T) {
+	pkgVer, err := ParseRichDependency("(foo = 2)")
+
+	assert.NoError(t, err)
+	assert.False(t, pkgVer.IsRich())
+	assert.Equal(t, PackageVer{Name: "foo", Condition: "=", Version: "2"}, *pkgVer)
+}
+
+func TestShouldFailToParseInvalidRichDependencies(t *testing.T) {
+	invalidExpressions := []string{
+		"foo and bar",
+		"(foo and bar",
+		"(foo and bar or baz)",
+		"(foo if bar if baz)",
+		"(foo else bar)",
+		"(foo >= and bar)",
+		"(and foo)",
+		"(foo bar)",
+		"(foo) bar",
+	}
+
+	for _, expression := range invalidExpressions {
+		_, err := ParseRichDependency(expression)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestRichDependencyRequiredPackages(t *testing.T) {
+	installed := func(pkgVer *PackageVer) bool { return pkgVer.Name == "installed" }
+	available := func(pkgVer *PackageVer) bool { return pkgVer.Name != "missing" && pkgVer.Name != "missing2" }
+
+	tests := []struct {
+		expression string
+		expected   []string
+	}{
+		{"(a and b)", []string{"a", "b"}},
+		{"(missing or b or c)", []string{"b"}},
+		{"(missing or missing2)", []string{"missing"}},
+		{"((missing and a) or missing2)", []string{"missing", "a"}},
+		{"(missing or (a and missing2))", []string{"missing"}},
+		{"(a without b)", []string{"a"}},
+		{"(a if installed)", []string{"a"}},
+		{"(a if other)", nil},
+		{"(a if other else b)", []string{"b"}},
+		{"(a unless installed else b)", []string{"b"}},
+		{"(a unless other)", []string{"a"}},
+		{"((a and b) if (installed or other))", []string{"a", "b"}},
+	}
+
+	for _, test := range tests {
+		pkgVer, err := ParseRichDependency(test.expression)
+		assert.NoError(t, err, test.expression)
+
+		var names []string
+		for _, required := range pkgVer.RequiredPackages(installed, available) {
+			names = append(names, required.Name)
+		}
+		assert.Equal(t, test.expected, names, test.expression)
+	}
+}
+
+func TestRichDependencyWithMergesBounds(t *testing.T) {
+	pkgVer, err := ParseRichDependency("(foo >= 1.0 with foo < 2.0)")
+	assert.NoError(t, err)
+
+	required := pkgVer.RequiredPackages(nil, nil)
+	assert.Equal(t, 1, len(required))
+	assert.Equal(t, PackageVer{Name: "foo", Condition: ">=", Version: "1.0", SCondition: "<", SVersion: "2.0"}, *required[0])
+
+	interval, err := required[0].Interval()
+	assert.NoError(t, err)
+	assert.Equal(t, "[1.0,2.0)", interval.String())
+}
+
+func TestIntervalShouldUseRPMVersionRules(t *testing.T) {
+	provider := &PackageVer{Name: "foo", Version: "1.0~rc1", Condition: "="}
+	request := &PackageVer{Name: "foo", Version: "1.0", Condition: ">="}
+	providerInterval, err := provider.Interval()
+	assert.NoError(t, err)
+	requestInterval, err := request.Interval()
+	assert.NoError(t, err)
+	assert.False(t, providerInterval.Satisfies(&requestInterval))
+
+	provider = &PackageVer{Name: "foo", Version: "1:0.5", Condition: "="}
+	providerInterval, err = provider.Interval()
+	assert.NoError(t, err)
+	assert.True(t, providerInterval.Satisfies(&requestInterval))
+}
@@ -0,0 +1,331 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package pkgjson
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operators which may be used in an RPM rich (boolean) dependency
+const (
+	RichAnd     = "and"
+	RichOr      = "or"
+	RichIf      = "if"
+	RichUnless  = "unless"
+	RichElse    = "else"
+	RichWith    = "with"
+	RichWithout = "without"
+)
+
+var (
+	richOperators = map[string]bool{
+		RichAnd:     true,
+		RichOr:      true,
+		RichIf:      true,
+		RichUnless:  true,
+		RichElse:    true,
+		RichWith:    true,
+		RichWithout: true,
+	}
+
+	versionConditions = map[string]bool{
+		"<":  true,
+		"<=": true,
+		"=":  true,
+		">=": true,
+		">":  true,
+	}
+)
+
+// richParser tokenizes and parses a single rich dependency expression.
+type richParser struct {
+	expression string
+	tokens     []string
+	pos        int
+}
+
+// ParseRichDependency parses an RPM rich dependency such as "(foo >= 1.0 with foo < 2.0)" into a PackageVer tree.
+// The returned PackageVer has its Operator set and one entry in Operands per term of the expression. For "if" and
+// "unless" expressions the operands are the dependency, the condition, and the optional "else" dependency.
+// Mixing different operators without parentheses is rejected, as it is by RPM.
+func ParseRichDependency(expression string) (pkgVer *PackageVer, err error) {
+	parser := &richParser{
+		expression: expression,
+		tokens:     strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(expression)),
+	}
+
+	if parser.peek() != "(" {
+		err = fmt.Errorf("rich dependency (%s) must be enclosed in parentheses", expression)
+		return
+	}
+
+	pkgVer, err = parser.parseOperand()
+	if err != nil {
+		return
+	}
+
+	if parser.pos != len(parser.tokens) {
+		err = fmt.Errorf("unexpected (%s) after the end of rich dependency (%s)", parser.peek(), expression)
+	}
+
+	return
+}
+
+// IsRich returns true if the PackageVer represents a rich dependency rather than a single package.
+func (pkgVer *PackageVer) IsRich() bool {
+	return pkgVer.Operator != ""
+}
+
+// RequiredPackages returns the plain packages which should be installed to satisfy the dependency.
+// A plain dependency returns itself. For rich dependencies:
+//   - "and" and "with" require every operand, "with" bounds on the same package are merged into one double conditional.
+//   - "or" requires the first operand for which available returns true for every package. If no operand is fully
+//     available the first operand is required, as rpm would when installing it.
+//   - "without" requires its first operand.
+//   - "if" and "unless" require their first operand, or their "else" operand, depending on whether their condition is met.
+//     Conditions are evaluated using installed.
+func (pkgVer *PackageVer) RequiredPackages(installed, available func(*PackageVer) bool) (required []*PackageVer) {
+	switch pkgVer.Operator {
+	case "":
+		required = append(required, pkgVer)
+	case RichAnd:
+		for _, operand := range pkgVer.Operands {
+			required = append(required, operand.RequiredPackages(installed, available)...)
+		}
+	case RichWith:
+		for _, operand := range pkgVer.Operands {
+			required = append(required, operand.RequiredPackages(installed, available)...)
+		}
+		required = mergeBounds(required)
+	case RichOr:
+		for i, operand := range pkgVer.Operands {
+			operandRequires := operand.RequiredPackages(installed, available)
+			if i == 0 {
+				required = operandRequires
+			}
+			if allAvailable(operandRequires, available) {
+				return operandRequires
+			}
+		}
+	case RichWithout:
+		required = pkgVer.Operands[0].RequiredPackages(installed, available)
+	case RichIf, RichUnless:
+		conditionMet := pkgVer.Operands[1].evaluate(installed)
+		if pkgVer.Operator == RichUnless {
+			conditionMet = !conditionMet
+		}
+
+		if conditionMet {
+			required = pkgVer.Operands[0].RequiredPackages(installed, available)
+		} else if len(pkgVer.Operands) > 2 {
+			required = pkgVer.Operands[2].RequiredPackages(installed, available)
+		}
+	}
+
+	return
+}
+
+// evaluate returns true if the dependency is satisfied, given installed reports if a single package is installed.
+func (pkgVer *PackageVer) evaluate(installed func(*PackageVer) bool) (satisfied bool) {
+	switch pkgVer.Operator {
+	case "":
+		return installed(pkgVer)
+	case RichAnd, RichWith:
+		for _, operand := range pkgVer.Operands {
+			if !operand.evaluate(installed) {
+				return false
+			}
+		}
+		return true
+	case RichOr:
+		for _, operand := range pkgVer.Operands {
+			if operand.evaluate(installed) {
+				return true
+			}
+		}
+		return false
+	case RichWithout:
+		return pkgVer.Operands[0].evaluate(installed) && !pkgVer.Operands[1].evaluate(installed)
+	case RichIf, RichUnless:
+		conditionMet := pkgVer.Operands[1].evaluate(installed)
+		if pkgVer.Operator == RichUnless {
+			conditionMet = !conditionMet
+		}
+
+		if conditionMet {
+			return pkgVer.Operands[0].evaluate(installed)
+		}
+		if len(pkgVer.Operands) > 2 {
+			return pkgVer.Operands[2].evaluate(installed)
+		}
+		return true
+	}
+
+	return
+}
+
+// richString rebuilds the canonical text of a dependency, it is used as the name of rich dependencies.
+func (pkgVer *PackageVer) richString() string {
+	if !pkgVer.IsRich() {
+		if pkgVer.Condition == "" {
+			return pkgVer.Name
+		}
+		return fmt.Sprintf("%s %s %s", pkgVer.Name, pkgVer.Condition, pkgVer.Version)
+	}
+
+	terms := make([]string, 0, len(pkgVer.Operands))
+	for _, operand := range pkgVer.Operands {
+		terms = append(terms, operand.richString())
+	}
+
+	if (pkgVer.Operator == RichIf || pkgVer.Operator == RichUnless) && len(terms) > 2 {
+		return fmt.Sprintf("(%s %s %s %s %s)", terms[0], pkgVer.Operator, terms[1], RichElse, terms[2])
+	}
+	return fmt.Sprintf("(%s)", strings.Join(terms, fmt.Sprintf(" %s ", pkgVer.Operator)))
+}
+
+// allAvailable returns true if every package in the list is available.
+func allAvailable(packages []*PackageVer, available func(*PackageVer) bool) bool {
+	for _, pkg := range packages {
+		if !available(pkg) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeBounds combines two single conditional constraints on the same package into one double conditional,
+// such as "foo >= 1.0" and "foo < 2.0" into "foo >= 1.0, < 2.0".
+func mergeBounds(packages []*PackageVer) (merged []*PackageVer) {
+	for _, pkg := range packages {
+		combined := false
+		for i, previous := range merged {
+			if previous.Name != pkg.Name || !isSingleBound(previous) || !isSingleBound(pkg) {
+				continue
+			}
+
+			merged[i] = &PackageVer{
+				Name:       previous.Name,
+				Version:    previous.Version,
+				Condition:  previous.Condition,
+				SVersion:   pkg.Version,
+				SCondition: pkg.Condition,
+			}
+			combined = true
+			break
+		}
+
+		if !combined {
+			merged = append(merged, pkg)
+		}
+	}
+	return
+}
+
+// isSingleBound returns true if the package has exactly one inequality version constraint.
+func isSingleBound(pkg *PackageVer) bool {
+	return pkg.Version != "" && pkg.SVersion == "" && pkg.Condition != "" && pkg.Condition != "="
+}
+
+// parseOperand parses either a nested expression in parentheses or a single "name [condition version]" dependency.
+func (p *richParser) parseOperand() (pkgVer *PackageVer, err error) {
+	token := p.next()
+	switch {
+	case token == "":
+		err = fmt.Errorf("rich dependency (%s) ended unexpectedly", p.expression)
+		return
+	case token == "(":
+		return p.parseExpression()
+	case token == ")" || richOperators[token] || versionConditions[token]:
+		err = fmt.Errorf("unexpected (%s) in rich dependency (%s), expected a package name", token, p.expression)
+		return
+	}
+
+	pkgVer = &PackageVer{Name: token}
+	if versionConditions[p.peek()] {
+		pkgVer.Condition = p.next()
+		pkgVer.Version = p.next()
+		if pkgVer.Version == "" || pkgVer.Version == ")" || richOperators[pkgVer.Version] {
+			err = fmt.Errorf("missing version for (%s) in rich dependency (%s)", pkgVer.Name, p.expression)
+		}
+	}
+
+	return
+}
+
+// parseExpression parses the contents of a parenthesized expression, the opening parenthesis has already been consumed.
+func (p *richParser) parseExpression() (pkgVer *PackageVer, err error) {
+	operand, err := p.parseOperand()
+	if err != nil {
+		return
+	}
+
+	pkgVer = &PackageVer{Operands: []*PackageVer{operand}}
+	for {
+		token := p.next()
+		switch {
+		case token == ")":
+			if !pkgVer.IsRich() {
+				// A single dependency wrapped in parentheses.
+				return operand, nil
+			}
+			pkgVer.Name = pkgVer.richString()
+			return
+		case token == "":
+			err = fmt.Errorf("rich dependency (%s) is missing a closing parenthesis", p.expression)
+			return
+		case !richOperators[token]:
+			err = fmt.Errorf("unexpected (%s) in rich dependency (%s), expected an operator", token, p.expression)
+			return
+		}
+
+		err = p.checkOperator(pkgVer, token)
+		if err != nil {
+			return
+		}
+		if token != RichElse {
+			pkgVer.Operator = token
+		}
+
+		operand, err = p.parseOperand()
+		if err != nil {
+			return
+		}
+		pkgVer.Operands = append(pkgVer.Operands, operand)
+	}
+}
+
+// checkOperator validates that operator may follow the terms already parsed into pkgVer.
+func (p *richParser) checkOperator(pkgVer *PackageVer, operator string) (err error) {
+	switch {
+	case operator == RichElse:
+		if (pkgVer.Operator != RichIf && pkgVer.Operator != RichUnless) || len(pkgVer.Operands) != 2 {
+			err = fmt.Errorf("(%s) must follow an if or unless clause in rich dependency (%s)", RichElse, p.expression)
+		}
+	case pkgVer.Operator == "":
+	case pkgVer.Operator == RichIf || pkgVer.Operator == RichUnless || pkgVer.Operator == RichWithout:
+		err = fmt.Errorf("(%s) can't be chained in rich dependency (%s), use parentheses", pkgVer.Operator, p.expression)
+	case pkgVer.Operator != operator:
+		err = fmt.Errorf("mixed operators (%s) and (%s) in rich dependency (%s), use parentheses", pkgVer.Operator, operator, p.expression)
+	}
+	return
+}
+
+// peek returns the next token without consuming it, or an empty string at the end of the expression.
+func (p *richParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the next token, or an empty string at the end of the expression.
+func (p *richParser) next() (token string) {
+	token = p.peek()
+	if token != "" {
+		p.pos++
+	}
+	return
+}
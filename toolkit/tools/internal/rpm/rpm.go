@@ -5,8 +5,13 @@ package rpm
 
 import (
 	"fmt"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"microsoft.com/pkggen/internal/cgroup"
 	"microsoft.com/pkggen/internal/file"
 	"microsoft.com/pkggen/internal/logger"
 	"microsoft.com/pkggen/internal/shell"
@@ -19,6 +24,9 @@ const (
 	// QueryHeaderArgument specifies the srpm argument to be used with rpm tools
 	QueryHeaderArgument = "--srpm"
 
+	// QueryPackageFileArgument specifies the argument to query a package file instead of the installed packages
+	QueryPackageFileArgument = "-p"
+
 	// DistTagDefine specifies the dist tag option for rpm tool commands
 	DistTagDefine = "dist"
 
@@ -37,10 +45,22 @@ const (
 	// WithCheckDefine specifies the with_check option for rpm tool commands
 	WithCheckDefine = "with_check"
 
+	// SourceDateEpochFromChangelogDefine specifies the option to set SOURCE_DATE_EPOCH from the latest changelog entry
+	SourceDateEpochFromChangelogDefine = "source_date_epoch_from_changelog"
+
+	// UseSourceDateEpochAsBuildtimeDefine specifies the option to use SOURCE_DATE_EPOCH as the build time of packages
+	UseSourceDateEpochAsBuildtimeDefine = "use_source_date_epoch_as_buildtime"
+
+	// ClampMtimeToSourceDateEpochDefine specifies the option to limit the modification time of packaged files to SOURCE_DATE_EPOCH
+	ClampMtimeToSourceDateEpochDefine = "clamp_mtime_to_source_date_epoch"
+
 	// NoCompatibleArchError specifies the error message when processing a SPEC written for a different architecture.
 	NoCompatibleArchError = "error: No compatible architectures found for build"
 )
 
+// sourceTagRegex matches a `SourceN:` line of an expanded SPEC file, capturing N and the source.
+var sourceTagRegex = regexp.MustCompile(`(?i)^source(\d*)\s*:\s*(\S+)`)
+
 const (
 	rpmProgram      = "rpm"
 	rpmSpecProgram  = "rpmspec"
@@ -132,6 +152,19 @@ func DefaultDefines() map[string]string {
 	}
 }
 
+// BcondDefines returns the defines equivalent to passing "--with" for every option in with, and "--without"
+// for every option in without, to rpmbuild. These toggle the SPEC's %bcond_with and %bcond_without conditionals.
+func BcondDefines(with, without []string) (defines map[string]string) {
+	defines = make(map[string]string)
+	for _, option := range with {
+		defines[fmt.Sprintf("_with_%s", option)] = fmt.Sprintf("--with-%s", option)
+	}
+	for _, option := range without {
+		defines[fmt.Sprintf("_without_%s", option)] = fmt.Sprintf("--without-%s", option)
+	}
+	return
+}
+
 // GetInstalledPackages returns a string list of all packages installed on the system
 // in the "[name]-[version]-[release].[distribution].[architecture]" format.
 // Example: tdnf-2.1.0-4.cm1.x86_64
@@ -141,6 +174,14 @@ func GetInstalledPackages() (result []string, err error) {
 	return executeRpmCommand(rpmProgram, queryArg)
 }
 
+// QueryInstalledPackages queries every package installed on the system with queryFormat.
+// Returns the output split by line and trimmed.
+func QueryInstalledPackages(queryFormat string) (result []string, err error) {
+	const queryArg = "-qa"
+
+	return executeRpmCommand(rpmProgram, queryArg, "--qf", queryFormat)
+}
+
 // QuerySPEC queries a SPEC file with queryFormat. Returns the output split by line and trimmed.
 func QuerySPEC(specFile, sourceDir, queryFormat string, defines map[string]string, extraArgs ...string) (result []string, err error) {
 	const queryArg = "-q"
@@ -166,6 +207,58 @@ func QuerySPEC(specFile, sourceDir, queryFormat string, defines map[string]strin
 	return executeRpmCommand(rpmSpecProgram, args...)
 }
 
+// ExpandSPEC returns the contents of a SPEC file with all of its macros expanded. Returns the output split by line and trimmed.
+func ExpandSPEC(specFile, sourceDir string, defines map[string]string) (result []string, err error) {
+	const parseArg = "--parse"
+
+	allDefines := make(map[string]string)
+	for k, v := range defines {
+		allDefines[k] = v
+	}
+
+	// The SPEC file may use `%include` on a source file
+	if sourceDir != "" {
+		allDefines[SourceDirDefine] = sourceDir
+	}
+
+	args := formatCommandArgs([]string{parseArg}, specFile, "", allDefines)
+	return executeRpmCommand(rpmSpecProgram, args...)
+}
+
+// QuerySPECSources returns the value of every SourceN tag of a SPEC file with all of its macros expanded, keyed by N.
+// Unlike querying the SOURCE tag, the values include the URL of remote sources. A Source tag without a number is Source0.
+func QuerySPECSources(specFile, sourceDir string, defines map[string]string) (sources map[int]string, err error) {
+	lines, err := ExpandSPEC(specFile, sourceDir, defines)
+	if err != nil {
+		return
+	}
+
+	return parseSourceTags(lines)
+}
+
+// parseSourceTags returns the value of every SourceN tag found in the lines of an expanded SPEC file, keyed by N.
+func parseSourceTags(lines []string) (sources map[int]string, err error) {
+	sources = make(map[int]string)
+	for _, line := range lines {
+		matches := sourceTagRegex.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		number := 0
+		if matches[1] != "" {
+			number, err = strconv.Atoi(matches[1])
+			if err != nil {
+				return
+			}
+		}
+
+		sources[number] = matches[2]
+	}
+
+	return
+}
+
 // QuerySPECForBuiltRPMs queries a SPEC file with queryFormat. Returns only the subpackages, which generate a .rpm file.
 func QuerySPECForBuiltRPMs(specFile, sourceDir, queryFormat string, defines map[string]string) (result []string, err error) {
 	const builtRPMsSwitch = "--builtrpms"
@@ -185,15 +278,31 @@ func QueryPackage(packageFile, queryFormat string, defines map[string]string, ex
 
 // BuildRPMFromSRPM builds an RPM from the given SRPM file
 func BuildRPMFromSRPM(srpmFile string, defines map[string]string, extraArgs ...string) (err error) {
+	const noTimeout = 0
+
+	return BuildRPMFromSRPMWithTimeout(srpmFile, defines, noTimeout, nil, extraArgs...)
+}
+
+// BuildRPMFromSRPMWithTimeout builds an RPM from the given SRPM file like BuildRPMFromSRPM. If timeout is greater
+// than zero, rpmbuild is stopped once it runs longer and shell.TimedOutError is returned. If buildCgroup is set,
+// rpmbuild is run in it from the moment it starts.
+func BuildRPMFromSRPMWithTimeout(srpmFile string, defines map[string]string, timeout time.Duration, buildCgroup *cgroup.Cgroup, extraArgs ...string) (err error) {
 	const (
 		queryFormat  = ""
 		squashErrors = true
 	)
 
+	var extraFiles []*os.File
+
 	extraArgs = append(extraArgs, "--rebuild", "--nodeps")
 
+	program := rpmBuildProgram
 	args := formatCommandArgs(extraArgs, srpmFile, queryFormat, defines)
-	return shell.ExecuteLive(squashErrors, rpmBuildProgram, args...)
+	if buildCgroup != nil {
+		program, args, extraFiles = buildCgroup.WrapCommand(program, args...)
+	}
+
+	return shell.ExecuteLiveWithTimeout(squashErrors, timeout, extraFiles, program, args...)
 }
 
 // GenerateSRPMFromSPEC generates an SRPM for the given SPEC file
@@ -0,0 +1,30 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package rpm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSourceTags(t *testing.T) {
+	lines := []string{
+		"Name: foo",
+		"Version: 1.2",
+		"Source: https://example.com/foo-1.2.tar.gz",
+		"source1 :  foo.service",
+		"SOURCE12: https://example.com/foo-data-1.2.tar.xz#/foo-data.tar.xz",
+		"Summary: Sources are listed below",
+		"Patch0: foo.patch",
+	}
+
+	sources, err := parseSourceTags(lines)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{
+		0:  "https://example.com/foo-1.2.tar.gz",
+		1:  "foo.service",
+		12: "https://example.com/foo-data-1.2.tar.xz#/foo-data.tar.xz",
+	}, sources)
+}
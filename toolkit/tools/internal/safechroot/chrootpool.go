@@ -0,0 +1,232 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package safechroot
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+const (
+	// baseChrootMode is the mode of the root directory of a base chroot.
+	baseChrootMode = 0755
+
+	// lockFileSuffix is appended to the path of a base chroot to get the lock file guarding its extraction.
+	lockFileSuffix = ".lock"
+
+	// partialPrefix is used for base chroots which are still being extracted.
+	partialPrefix = ".partial-"
+
+	// hashLength is the length of the hex encoded SHA256 hash naming a base chroot.
+	hashLength = sha256.Size * 2
+)
+
+// PrepareBaseChroot returns the path of a base chroot extracted from tarPath, to be used with InitializeFromBase.
+// Base chroots are kept in poolDir keyed by the path, size and modification time of their tar file (see
+// baseChrootKey), so a given tar file is only extracted once no matter how many Chroots are created from it.
+// Several processes may share poolDir. Base chroots of any other tar file are removed from poolDir unless a Chroot
+// still uses them.
+func PrepareBaseChroot(poolDir, tarPath string) (baseDir string, err error) {
+	hash, err := baseChrootKey(tarPath)
+	if err != nil {
+		return
+	}
+
+	baseDir = filepath.Join(poolDir, hash)
+	err = os.MkdirAll(poolDir, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	// Serialize the extraction and eviction with other processes preparing the same base chroot.
+	lockFile, err := os.OpenFile(baseDir+lockFileSuffix, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer lockFile.Close()
+
+	err = unix.Flock(int(lockFile.Fd()), unix.LOCK_EX)
+	if err != nil {
+		return
+	}
+	defer unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
+
+	evictStaleBaseChroots(poolDir, hash)
+
+	// Another process may have extracted the base chroot while waiting for the lock.
+	exists, err := file.PathExists(baseDir)
+	if err != nil || exists {
+		return
+	}
+
+	// Extract under a temporary name first so a partially extracted base chroot is never used.
+	partialDir, err := ioutil.TempDir(poolDir, partialPrefix+hash)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(partialDir)
+
+	err = os.Chmod(partialDir, baseChrootMode)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Extracting (%s) into the chroot pool (%s)", tarPath, poolDir)
+	err = extractWorkerTar(partialDir, tarPath)
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(partialDir, baseDir)
+	return
+}
+
+// baseChrootKey returns the hex encoded SHA256 hash of the absolute path, size and modification time of a tar file.
+// Hashing the contents of the tar file would read it in full before every build, while rebuilding the worker
+// chroot always rewrites it.
+func baseChrootKey(tarPath string) (key string, err error) {
+	absTarPath, err := filepath.Abs(tarPath)
+	if err != nil {
+		return
+	}
+
+	info, err := os.Stat(absTarPath)
+	if err != nil {
+		return
+	}
+
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\n%d\n%d", absTarPath, info.Size(), info.ModTime().UnixNano())))
+	key = fmt.Sprintf("%x", hash)
+	return
+}
+
+// evictStaleBaseChroots removes the base chroots, and leftover partial extractions, of every tar file other than
+// the one hashed to currentHash. Base chroots in use by a Chroot or being prepared by another process are kept.
+// Lock files are kept as other processes may still open them. Failures are only logged.
+func evictStaleBaseChroots(poolDir, currentHash string) {
+	entries, err := ioutil.ReadDir(poolDir)
+	if err != nil {
+		logger.Log.Warnf("Failed to list the chroot pool (%s). Error: %s", poolDir, err)
+		return
+	}
+
+	staleHashes := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		hash := strings.TrimPrefix(entry.Name(), partialPrefix)
+		if len(hash) < hashLength {
+			continue
+		}
+
+		hash = hash[:hashLength]
+		if hash != currentHash {
+			staleHashes[hash] = true
+		}
+	}
+
+	for hash := range staleHashes {
+		err = evictBaseChroot(poolDir, hash)
+		if err != nil {
+			logger.Log.Warnf("Failed to remove stale base chroot (%s) from the chroot pool (%s). Error: %s", hash, poolDir, err)
+		}
+	}
+}
+
+// evictBaseChroot removes the base chroot of a single hash along with its partial extractions.
+func evictBaseChroot(poolDir, hash string) (err error) {
+	baseDir := filepath.Join(poolDir, hash)
+
+	// Skip base chroots another process is extracting or evicting.
+	lockFile, err := os.OpenFile(baseDir+lockFileSuffix, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer lockFile.Close()
+
+	err = unix.Flock(int(lockFile.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		logger.Log.Debugf("Skipping base chroot (%s) locked by another process", baseDir)
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	defer unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
+
+	partialDirs, err := filepath.Glob(filepath.Join(poolDir, partialPrefix+hash+"*"))
+	if err != nil {
+		return
+	}
+
+	for _, partialDir := range partialDirs {
+		err = os.RemoveAll(partialDir)
+		if err != nil {
+			return
+		}
+	}
+
+	exists, err := file.PathExists(baseDir)
+	if err != nil || !exists {
+		return
+	}
+
+	// Chroots hold a shared lock on their base chroot for as long as it is mounted.
+	baseDirFile, err := os.Open(baseDir)
+	if err != nil {
+		return
+	}
+	defer baseDirFile.Close()
+
+	err = unix.Flock(int(baseDirFile.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		logger.Log.Debugf("Skipping base chroot (%s) in use by a chroot", baseDir)
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Removing stale base chroot (%s)", baseDir)
+	err = os.RemoveAll(baseDir)
+	return
+}
+
+// lockBaseChroot takes a shared lock on a base chroot so it is not evicted while in use.
+// The lock is held until the returned file is closed.
+func lockBaseChroot(baseDir string) (lockFile *os.File, err error) {
+	lockFile, err = os.Open(baseDir)
+	if err != nil {
+		return
+	}
+
+	err = unix.Flock(int(lockFile.Fd()), unix.LOCK_SH)
+	if err != nil {
+		lockFile.Close()
+		lockFile = nil
+		return
+	}
+
+	// The base chroot may have been evicted between opening and locking it.
+	exists, err := file.PathExists(baseDir)
+	if err == nil && !exists {
+		err = fmt.Errorf("base chroot (%s) was removed from the chroot pool", baseDir)
+	}
+	if err != nil {
+		lockFile.Close()
+		lockFile = nil
+	}
+
+	return
+}
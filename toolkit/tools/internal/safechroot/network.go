@@ -0,0 +1,88 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package safechroot
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+// loopbackInterface is the name of the loopback network interface.
+const loopbackInterface = "lo"
+
+// interfaceFlagsRequest mirrors struct ifreq as used by the SIOCGIFFLAGS and SIOCSIFFLAGS ioctls.
+// The padding covers the largest member of the union in struct ifreq.
+type interfaceFlagsRequest struct {
+	name  [unix.IFNAMSIZ]byte
+	flags uint16
+	_     [22]byte
+}
+
+// enterIsolatedNetwork moves the calling thread into a new network namespace in which only the loopback interface
+// is up. restoreNetwork moves the thread back into its original network namespace.
+// The caller must lock its goroutine to the current thread, processes started from it will inherit the namespace.
+func enterIsolatedNetwork() (restoreNetwork func() error, err error) {
+	originalNetwork, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		return
+	}
+
+	err = unix.Unshare(unix.CLONE_NEWNET)
+	if err != nil {
+		originalNetwork.Close()
+		return
+	}
+
+	restoreNetwork = func() error {
+		defer originalNetwork.Close()
+		return unix.Setns(int(originalNetwork.Fd()), unix.CLONE_NEWNET)
+	}
+
+	// Interfaces of a new network namespace start down.
+	err = setInterfaceUp(loopbackInterface)
+	if err != nil {
+		restoreErr := restoreNetwork()
+		if restoreErr != nil {
+			logger.Log.Warnf("Failed to restore the original network namespace. Error: %s", restoreErr)
+		}
+		restoreNetwork = nil
+	}
+
+	return
+}
+
+// setInterfaceUp brings up a network interface of the calling thread's network namespace.
+func setInterfaceUp(name string) (err error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return
+	}
+	defer unix.Close(fd)
+
+	request := interfaceFlagsRequest{}
+	copy(request.name[:unix.IFNAMSIZ-1], name)
+
+	err = interfaceFlagsIoctl(fd, unix.SIOCGIFFLAGS, &request)
+	if err != nil {
+		return
+	}
+
+	request.flags |= unix.IFF_UP
+	err = interfaceFlagsIoctl(fd, unix.SIOCSIFFLAGS, &request)
+	return
+}
+
+// interfaceFlagsIoctl issues an ioctl reading or writing the flags of a network interface.
+func interfaceFlagsIoctl(fd int, request uintptr, flagsRequest *interfaceFlagsRequest) (err error) {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), request, uintptr(unsafe.Pointer(flagsRequest)))
+	if errno != 0 {
+		err = errno
+	}
+
+	return
+}
@@ -5,10 +5,14 @@ package safechroot
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,6 +29,9 @@ import (
 // BindMountPointFlags is a set of flags to do a bind mount.
 const BindMountPointFlags = unix.MS_BIND | unix.MS_MGC_VAL
 
+// layerDirSuffix is appended to the root directory of a Chroot to get the directory holding its overlay layers.
+const layerDirSuffix = "-layers"
+
 // FileToCopy represents a file to copy into a chroot using AddFiles. Dest is relative to the chroot directory.
 type FileToCopy struct {
 	Src  string
@@ -48,9 +55,13 @@ type MountPoint struct {
 // and guaranteed cleanup code even on SIGTERM so long as registerSIGTERMCleanup is invoked.
 type Chroot struct {
 	rootDir     string
+	layerDir    string
+	baseDir     string
+	baseLock    *os.File
 	mountPoints []*MountPoint
 
-	isExistingDir bool
+	isExistingDir  bool
+	isolateNetwork bool
 }
 
 // inChrootMutex guards against multiple Chroots entering their respective Chroots
@@ -132,6 +143,7 @@ func NewChroot(rootDir string, isExistingDir bool) *Chroot {
 	// create new safechroot
 	c := new(Chroot)
 	c.rootDir = chrootDir
+	c.layerDir = chrootDir + layerDirSuffix
 	if buildpipeline.IsRegularBuild() {
 		c.isExistingDir = isExistingDir
 	} else {
@@ -153,6 +165,24 @@ func NewChroot(rootDir string, isExistingDir bool) *Chroot {
 // This call will block until the chroot initializes succesfully.
 // Only one Chroot will initialize at a given time.
 func (c *Chroot) Initialize(tarPath string, extraDirectories []string, extraMountPoints []*MountPoint) (err error) {
+	return c.initialize(tarPath, extraDirectories, nil, extraMountPoints)
+}
+
+// InitializeFromBase initializes a Chroot on top of a base chroot prepared by PrepareBaseChroot instead of
+// extracting a tar file into it. The base chroot is mounted as the read-only lower layer of an overlay, all
+// changes made inside the Chroot go to an upper layer in LayerDir which is discarded on Close.
+// - layerDirectories is an optional slice of additional directories that should be created inside LayerDir,
+//   such as the upper and work directories of overlays mounted inside the chroot.
+// Other arguments behave as they do for Initialize. Only supported in the regular build pipeline.
+func (c *Chroot) InitializeFromBase(baseDir string, extraDirectories, layerDirectories []string, extraMountPoints []*MountPoint) (err error) {
+	const noTarPath = ""
+
+	c.baseDir = baseDir
+	return c.initialize(noTarPath, extraDirectories, layerDirectories, extraMountPoints)
+}
+
+// initialize implements Initialize and InitializeFromBase.
+func (c *Chroot) initialize(tarPath string, extraDirectories, layerDirectories []string, extraMountPoints []*MountPoint) (err error) {
 	// On failed initialization, cleanup all chroot files
 	const leaveChrootOnDisk = false
 
@@ -161,6 +191,16 @@ func (c *Chroot) Initialize(tarPath string, extraDirectories []string, extraMoun
 	activeChrootsMutex.Lock()
 	defer activeChrootsMutex.Unlock()
 
+	// Clean up the leftovers of a previous Chroot before the failed initialization cleanup below may remove its
+	// root directory, which could still hold mounts.
+	if c.baseDir != "" && !c.isExistingDir && buildpipeline.IsRegularBuild() {
+		err = c.removeStaleLayers()
+		if err != nil {
+			logger.Log.Warnf("Could not remove stale layers of chroot (%s)", c.rootDir)
+			return
+		}
+	}
+
 	defer func() {
 		if err != nil {
 			if buildpipeline.IsRegularBuild() {
@@ -180,6 +220,11 @@ func (c *Chroot) Initialize(tarPath string, extraDirectories []string, extraMoun
 		}
 	}()
 
+	if c.baseDir != "" && !buildpipeline.IsRegularBuild() {
+		err = fmt.Errorf("chroots on top of a base chroot are only supported in the regular build pipeline")
+		return
+	}
+
 	if c.isExistingDir {
 		_, err = os.Stat(c.rootDir)
 		if os.IsNotExist(err) {
@@ -204,6 +249,17 @@ func (c *Chroot) Initialize(tarPath string, extraDirectories []string, extraMoun
 		}
 	}
 
+	// Mount the base chroot first, everything else is created on top of it
+	var baseMountPoints []*MountPoint
+	if c.baseDir != "" {
+		baseMountPoints, err = c.mountBase(layerDirectories)
+		c.mountPoints = baseMountPoints
+		if err != nil {
+			logger.Log.Warnf("Could not mount base chroot (%s)", c.baseDir)
+			return
+		}
+	}
+
 	// Extract a given tarball if necessary
 	if tarPath != "" {
 		err = extractWorkerTar(c.rootDir, tarPath)
@@ -235,7 +291,7 @@ func (c *Chroot) Initialize(tarPath string, extraDirectories []string, extraMoun
 		// e.g.: /dev/pts is unmounted and then /dev is.
 		//
 		// Sort now before checking err so that `unmountAndRemove` can be called from Initialize.
-		c.mountPoints = allMountPoints
+		c.mountPoints = append(baseMountPoints, allMountPoints...)
 		sort.Slice(c.mountPoints, func(i, j int) bool {
 			return c.mountPoints[i].target > c.mountPoints[j].target
 		})
@@ -253,6 +309,13 @@ func (c *Chroot) Initialize(tarPath string, extraDirectories []string, extraMoun
 	return
 }
 
+// SetNetworkIsolation sets whether Run and UnsafeRun execute inside a new network namespace in which only the
+// loopback interface is available, so that any attempt to reach the network fails. Processes must be started from
+// the goroutine invoking Run to be isolated. Disabled by default.
+func (c *Chroot) SetNetworkIsolation(isolate bool) {
+	c.isolateNetwork = isolate
+}
+
 // AddFiles copies each file 'Src' to the relative path chrootRootDir/'Dest' in the chroot.
 func (c *Chroot) AddFiles(filesToCopy ...FileToCopy) (err error) {
 	for _, f := range filesToCopy {
@@ -306,6 +369,31 @@ func (c *Chroot) UnsafeRun(toRun func() error) (err error) {
 	}
 	defer originalWd.Close()
 
+	// Network namespaces belong to a thread, keep the goroutine on the isolated thread until it is restored.
+	if c.isolateNetwork {
+		runtime.LockOSThread()
+
+		var restoreNetwork func() error
+		restoreNetwork, err = enterIsolatedNetwork()
+		if err != nil {
+			runtime.UnlockOSThread()
+			err = fmt.Errorf("failed to isolate the network of chroot (%s): %v", c.rootDir, err)
+			return
+		}
+		defer func() {
+			restoreErr := restoreNetwork()
+			if restoreErr != nil {
+				// Leave the thread locked, it is discarded once the goroutine exits instead of being reused.
+				logger.Log.Errorf("Failed to restore the network namespace after running in chroot (%s). Error: %s", c.rootDir, restoreErr)
+				if err == nil {
+					err = restoreErr
+				}
+				return
+			}
+			runtime.UnlockOSThread()
+		}()
+	}
+
 	err = unix.Chroot(c.rootDir)
 	if err != nil {
 		return
@@ -326,6 +414,13 @@ func (c *Chroot) RootDir() string {
 	return c.rootDir
 }
 
+// LayerDir returns the directory holding the overlay layers of a Chroot initialized with InitializeFromBase.
+// Overlays mounted inside such a Chroot must keep their upper and work directories here, since overlayfs
+// does not support another overlay as its upper layer.
+func (c *Chroot) LayerDir() string {
+	return c.layerDir
+}
+
 // Close will unmount the chroot and cleanup its files.
 // This call will block until the chroot cleanup runs.
 // Only one Chroot will close at a given time.
@@ -452,8 +547,16 @@ func (c *Chroot) unmountAndRemove(leaveOnDisk bool) (err error) {
 		}
 	}
 
+	if c.baseLock != nil {
+		c.baseLock.Close()
+		c.baseLock = nil
+	}
+
 	if !leaveOnDisk {
 		err = os.RemoveAll(c.rootDir)
+		if err == nil && c.baseDir != "" {
+			err = os.RemoveAll(c.layerDir)
+		}
 	}
 
 	return
@@ -534,6 +637,141 @@ func (c *Chroot) createMountPoints(allMountPoints []*MountPoint) (err error) {
 	return
 }
 
+// mountBase creates the layer directories of the Chroot and mounts an overlay of its base chroot at its root.
+func (c *Chroot) mountBase(layerDirectories []string) (mountPoints []*MountPoint, err error) {
+	const (
+		overlayFlags  = 0
+		overlayFsType = "overlay"
+		overlaySource = "overlay"
+		rootTarget    = "/"
+		upperDirName  = "upper"
+		workDirName   = "work"
+	)
+
+	for _, dir := range append([]string{upperDirName, workDirName}, layerDirectories...) {
+		err = os.MkdirAll(filepath.Join(c.layerDir, dir), os.ModePerm)
+		if err != nil {
+			logger.Log.Warnf("Could not create layer directory for chroot (%s)", dir)
+			return
+		}
+	}
+
+	// Keep the base chroot from being evicted from the chroot pool until it is unmounted.
+	c.baseLock, err = lockBaseChroot(c.baseDir)
+	if err != nil {
+		return
+	}
+
+	upperDir := filepath.Join(c.layerDir, upperDirName)
+	workDir := filepath.Join(c.layerDir, workDirName)
+	overlayData := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", c.baseDir, upperDir, workDir)
+
+	mountPoints = []*MountPoint{
+		NewMountPoint(overlaySource, rootTarget, overlayFsType, overlayFlags, overlayData),
+	}
+	err = c.createMountPoints(mountPoints)
+	return
+}
+
+// removeStaleLayers removes the layer directory left behind by a previous Chroot with the same root directory, either
+// closed with leaveOnDisk set or killed before it could clean up. Its upper layer would otherwise leak the changes of a
+// previous build into this one. Anything still mounted under the root or layer directory is unmounted first, and the
+// root directory is removed if it is then empty.
+func (c *Chroot) removeStaleLayers() (err error) {
+	const mountInfoFile = "/proc/self/mountinfo"
+
+	exists, err := file.PathExists(c.layerDir)
+	if err != nil || !exists {
+		return
+	}
+
+	logger.Log.Warnf("Removing stale chroot layer directory (%s)", c.layerDir)
+	absRootDir, err := filepath.Abs(c.rootDir)
+	if err != nil {
+		return
+	}
+
+	absLayerDir, err := filepath.Abs(c.layerDir)
+	if err != nil {
+		return
+	}
+
+	mountInfo, err := ioutil.ReadFile(mountInfoFile)
+	if err != nil {
+		return
+	}
+
+	for _, mountPoint := range mountPointsUnder(string(mountInfo), absRootDir, absLayerDir) {
+		logger.Log.Debugf("Unmounting stale mount (%s)", mountPoint)
+		err = unix.Unmount(mountPoint, unix.MNT_DETACH)
+		if err != nil {
+			logger.Log.Warnf("Failed to unmount stale mount (%s). Error: %s", mountPoint, err)
+			return
+		}
+	}
+
+	err = os.RemoveAll(c.layerDir)
+	if err != nil {
+		return
+	}
+
+	// A root directory which still holds files is left for the existing directory check to report.
+	entries, err := ioutil.ReadDir(c.rootDir)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err == nil && len(entries) == 0 {
+		err = os.Remove(c.rootDir)
+	}
+
+	return
+}
+
+// mountPointsUnder returns the mount points listed in mountInfo, the contents of /proc/self/mountinfo, which are at or
+// below any of dirs. They are returned in the reverse order they were mounted in, so nested mounts come first.
+func mountPointsUnder(mountInfo string, dirs ...string) (mountPoints []string) {
+	const mountPointField = 4
+
+	for _, line := range strings.Split(mountInfo, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) <= mountPointField {
+			continue
+		}
+
+		mountPoint := unescapeMountInfo(fields[mountPointField])
+		for _, dir := range dirs {
+			dir = filepath.Clean(dir)
+			if mountPoint == dir || strings.HasPrefix(mountPoint, dir+"/") {
+				mountPoints = append([]string{mountPoint}, mountPoints...)
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// unescapeMountInfo decodes the octal escapes (such as "\040" for a space) used for paths in /proc/self/mountinfo.
+func unescapeMountInfo(path string) string {
+	const escapeLength = 4
+
+	var builder strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+escapeLength <= len(path) {
+			value, err := strconv.ParseUint(path[i+1:i+escapeLength], 8, 8)
+			if err == nil {
+				builder.WriteByte(byte(value))
+				i += escapeLength - 1
+				continue
+			}
+		}
+		builder.WriteByte(path[i])
+	}
+
+	return builder.String()
+}
+
 // extractWorkerTar uses tar with gzip or pigz to setup a chroot directory using a rootfs tar
 func extractWorkerTar(chroot string, workerTar string) (err error) {
 	gzipTool, err := systemdependency.GzipTool()
@@ -5,11 +5,16 @@ package safechroot
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 	"microsoft.com/pkggen/internal/buildpipeline"
 	"microsoft.com/pkggen/internal/logger"
 )
@@ -285,3 +290,275 @@ func TestInitializeShouldCreateExtraDirectories(t *testing.T) {
 	_, err = os.Stat(fullPath)
 	assert.True(t, !os.IsNotExist(err))
 }
+
+func TestPrepareBaseChrootShouldExtractTar(t *testing.T) {
+	const expectedFile = "/test/testfile.txt"
+
+	tarPath := filepath.Join(testDir, testTar)
+	poolDir := filepath.Join(tmpDir, "TestPrepareBaseChrootShouldExtractTar")
+	defer os.RemoveAll(poolDir)
+
+	baseDir, err := PrepareBaseChroot(poolDir, tarPath)
+	assert.NoError(t, err)
+
+	fullPath := filepath.Join(baseDir, expectedFile)
+	_, err = os.Stat(fullPath)
+	assert.True(t, !os.IsNotExist(err))
+}
+
+func TestPrepareBaseChrootShouldReuseBase(t *testing.T) {
+	const markerFile = "marker.txt"
+
+	tarPath := filepath.Join(testDir, testTar)
+	poolDir := filepath.Join(tmpDir, "TestPrepareBaseChrootShouldReuseBase")
+	defer os.RemoveAll(poolDir)
+
+	baseDir, err := PrepareBaseChroot(poolDir, tarPath)
+	assert.NoError(t, err)
+
+	// A base chroot which was extracted again would lose the marker file.
+	markerPath := filepath.Join(baseDir, markerFile)
+	err = ioutil.WriteFile(markerPath, []byte{}, os.ModePerm)
+	assert.NoError(t, err)
+
+	reusedBaseDir, err := PrepareBaseChroot(poolDir, tarPath)
+	assert.NoError(t, err)
+	assert.Equal(t, baseDir, reusedBaseDir)
+
+	_, err = os.Stat(markerPath)
+	assert.True(t, !os.IsNotExist(err))
+}
+
+func TestPrepareBaseChrootShouldExtractChangedTar(t *testing.T) {
+	poolDir := filepath.Join(tmpDir, "TestPrepareBaseChrootShouldExtractChangedTar")
+	defer os.RemoveAll(poolDir)
+
+	// The pool is keyed by the tar file's path, size and modification time, use a copy so it can be touched.
+	contents, err := ioutil.ReadFile(filepath.Join(testDir, testTar))
+	assert.NoError(t, err)
+	err = os.MkdirAll(poolDir, os.ModePerm)
+	assert.NoError(t, err)
+	tarPath := filepath.Join(poolDir, testTar)
+	err = ioutil.WriteFile(tarPath, contents, os.ModePerm)
+	assert.NoError(t, err)
+
+	baseDir, err := PrepareBaseChroot(poolDir, tarPath)
+	assert.NoError(t, err)
+
+	rebuiltTime := time.Now().Add(time.Hour)
+	err = os.Chtimes(tarPath, rebuiltTime, rebuiltTime)
+	assert.NoError(t, err)
+
+	newBaseDir, err := PrepareBaseChroot(poolDir, tarPath)
+	assert.NoError(t, err)
+	assert.NotEqual(t, baseDir, newBaseDir)
+
+	_, err = os.Stat(baseDir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPrepareBaseChrootShouldRemoveStaleBases(t *testing.T) {
+	const staleHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	tarPath := filepath.Join(testDir, testTar)
+	poolDir := filepath.Join(tmpDir, "TestPrepareBaseChrootShouldRemoveStaleBases")
+	defer os.RemoveAll(poolDir)
+
+	staleBaseDir := filepath.Join(poolDir, staleHash)
+	stalePartialDir := filepath.Join(poolDir, partialPrefix+staleHash+"123")
+	for _, dir := range []string{staleBaseDir, stalePartialDir} {
+		err := os.MkdirAll(filepath.Join(dir, "test"), os.ModePerm)
+		assert.NoError(t, err)
+	}
+
+	baseDir, err := PrepareBaseChroot(poolDir, tarPath)
+	assert.NoError(t, err)
+
+	_, err = os.Stat(baseDir)
+	assert.NoError(t, err)
+
+	for _, dir := range []string{staleBaseDir, stalePartialDir} {
+		_, err = os.Stat(dir)
+		assert.True(t, os.IsNotExist(err), "stale directory (%s) was not removed", dir)
+	}
+}
+
+func TestPrepareBaseChrootShouldKeepBasesInUse(t *testing.T) {
+	const staleHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	tarPath := filepath.Join(testDir, testTar)
+	poolDir := filepath.Join(tmpDir, "TestPrepareBaseChrootShouldKeepBasesInUse")
+	defer os.RemoveAll(poolDir)
+
+	staleBaseDir := filepath.Join(poolDir, staleHash)
+	err := os.MkdirAll(staleBaseDir, os.ModePerm)
+	assert.NoError(t, err)
+
+	lockFile, err := lockBaseChroot(staleBaseDir)
+	assert.NoError(t, err)
+
+	_, err = PrepareBaseChroot(poolDir, tarPath)
+	assert.NoError(t, err)
+
+	_, err = os.Stat(staleBaseDir)
+	assert.NoError(t, err)
+
+	// Once released, the base chroot is removed the next time the pool is used.
+	lockFile.Close()
+	_, err = PrepareBaseChroot(poolDir, tarPath)
+	assert.NoError(t, err)
+
+	_, err = os.Stat(staleBaseDir)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestInitializeFromBaseShouldDiscardChanges(t *testing.T) {
+	if buildpipeline.IsRegularBuild() {
+		// this test only apply to "regular build" pipeline
+		const (
+			baseFile  = "/test/testfile.txt"
+			addedFile = "/test/added.txt"
+		)
+
+		tarPath := filepath.Join(testDir, testTar)
+		extraMountPoints := []*MountPoint{}
+		extraDirectories := []string{}
+		layerDirectories := []string{}
+
+		poolDir := filepath.Join(tmpDir, "TestInitializeFromBaseShouldDiscardChangesPool")
+		defer os.RemoveAll(poolDir)
+
+		baseDir, err := PrepareBaseChroot(poolDir, tarPath)
+		assert.NoError(t, err)
+
+		dir := filepath.Join(tmpDir, "TestInitializeFromBaseShouldDiscardChanges")
+		chroot := NewChroot(dir, isExistingDir)
+
+		err = chroot.InitializeFromBase(baseDir, extraDirectories, layerDirectories, extraMountPoints)
+		assert.NoError(t, err)
+
+		_, err = os.Stat(filepath.Join(chroot.RootDir(), baseFile))
+		assert.True(t, !os.IsNotExist(err))
+
+		err = ioutil.WriteFile(filepath.Join(chroot.RootDir(), addedFile), []byte{}, os.ModePerm)
+		assert.NoError(t, err)
+
+		err = chroot.Close(defaultLeaveOnDisk)
+		assert.NoError(t, err)
+
+		_, err = os.Stat(filepath.Join(baseDir, addedFile))
+		assert.True(t, os.IsNotExist(err))
+
+		_, err = os.Stat(chroot.LayerDir())
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestInitializeFromBaseShouldRemoveStaleLayers(t *testing.T) {
+	if buildpipeline.IsRegularBuild() {
+		// this test only apply to "regular build" pipeline
+		const (
+			staleFile = "stale.txt"
+			tmpfsType = "tmpfs"
+		)
+
+		tarPath := filepath.Join(testDir, testTar)
+		extraMountPoints := []*MountPoint{}
+		extraDirectories := []string{}
+		layerDirectories := []string{}
+
+		poolDir := filepath.Join(tmpDir, "TestInitializeFromBaseShouldRemoveStaleLayersPool")
+		defer os.RemoveAll(poolDir)
+
+		baseDir, err := PrepareBaseChroot(poolDir, tarPath)
+		assert.NoError(t, err)
+
+		// Simulate a killed Chroot: its root overlay, a mount inside it and its upper layer are all left behind.
+		dir := filepath.Join(tmpDir, "TestInitializeFromBaseShouldRemoveStaleLayers")
+		staleUpperDir := filepath.Join(dir+layerDirSuffix, "upper")
+		err = os.MkdirAll(staleUpperDir, os.ModePerm)
+		assert.NoError(t, err)
+		err = ioutil.WriteFile(filepath.Join(staleUpperDir, staleFile), []byte{}, os.ModePerm)
+		assert.NoError(t, err)
+
+		err = os.MkdirAll(dir, os.ModePerm)
+		assert.NoError(t, err)
+		err = unix.Mount(tmpfsType, dir, tmpfsType, emptyFlags, emptyPath)
+		assert.NoError(t, err)
+		err = os.MkdirAll(filepath.Join(dir, "proc"), os.ModePerm)
+		assert.NoError(t, err)
+		err = unix.Mount(tmpfsType, filepath.Join(dir, "proc"), tmpfsType, emptyFlags, emptyPath)
+		assert.NoError(t, err)
+
+		chroot := NewChroot(dir, isExistingDir)
+		err = chroot.InitializeFromBase(baseDir, extraDirectories, layerDirectories, extraMountPoints)
+		assert.NoError(t, err)
+		defer chroot.Close(defaultLeaveOnDisk)
+
+		_, err = os.Stat(filepath.Join(chroot.RootDir(), staleFile))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestMountPointsUnderShouldReturnNestedMountsFirst(t *testing.T) {
+	const mountInfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+100 22 0:50 / /work/a rw shared:50 - overlay overlay rw,lowerdir=/pool/base
+101 100 0:5 / /work/a/dev rw shared:51 - devtmpfs devtmpfs rw
+102 22 0:52 / /work/ab rw shared:52 - tmpfs tmpfs rw
+103 22 0:53 / /work/a-layers/x\040y rw shared:53 - tmpfs tmpfs rw
+`
+
+	mountPoints := mountPointsUnder(mountInfo, "/work/a", "/work/a-layers/")
+	assert.Equal(t, []string{"/work/a-layers/x y", "/work/a/dev", "/work/a"}, mountPoints)
+}
+
+func TestRunWithNetworkIsolationShouldOnlyHaveLoopback(t *testing.T) {
+	extraMountPoints := []*MountPoint{}
+	extraDirectories := []string{}
+
+	dir := filepath.Join(tmpDir, "TestRunWithNetworkIsolationShouldOnlyHaveLoopback")
+	chroot := NewChroot(dir, isExistingDir)
+	chroot.SetNetworkIsolation(true)
+
+	err := chroot.Initialize(emptyPath, extraDirectories, extraMountPoints)
+	assert.NoError(t, err)
+	defer chroot.Close(defaultLeaveOnDisk)
+
+	var interfaces []net.Interface
+	err = chroot.Run(func() (err error) {
+		interfaces, err = net.Interfaces()
+		return
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, interfaces, 1)
+	if len(interfaces) == 1 {
+		assert.Equal(t, loopbackInterface, interfaces[0].Name)
+		assert.NotZero(t, interfaces[0].Flags&net.FlagUp)
+	}
+}
+
+func TestEnterIsolatedNetworkShouldRestoreNetwork(t *testing.T) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	originalInterfaces, err := net.Interfaces()
+	assert.NoError(t, err)
+
+	restoreNetwork, err := enterIsolatedNetwork()
+	assert.NoError(t, err)
+	if err != nil {
+		return
+	}
+
+	isolatedInterfaces, err := net.Interfaces()
+	assert.NoError(t, err)
+	assert.Len(t, isolatedInterfaces, 1)
+
+	err = restoreNetwork()
+	assert.NoError(t, err)
+
+	restoredInterfaces, err := net.Interfaces()
+	assert.NoError(t, err)
+	assert.Equal(t, originalInterfaces, restoredInterfaces)
+}
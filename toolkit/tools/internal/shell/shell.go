@@ -10,7 +10,9 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 	"microsoft.com/pkggen/internal/logger"
@@ -19,6 +21,9 @@ import (
 // ShellProgram is the default shell program used by the tooling.
 const ShellProgram = "/bin/bash"
 
+// TimedOutError specifies the error message when a command was stopped for running longer than its timeout.
+const TimedOutError = "command timed out"
+
 var (
 	activeCommands = make(map[*exec.Cmd]bool)
 	// Guards activeCommands
@@ -137,10 +142,36 @@ func ExecuteLive(squashErrors bool, program string, args ...string) (err error)
 // If printOutputOnError is true, the full output of the command will be printed after completion if the command returns an error. In the event
 // the buffer becomes full the oldest buffered output is discarded.
 func ExecuteLiveWithCallback(onStdout, onStderr func(...interface{}), printOutputOnError bool, program string, args ...string) (err error) {
-	var outputChan chan string
+	const noTimeout = 0
+
+	return executeLive(onStdout, onStderr, printOutputOnError, noTimeout, nil, program, args...)
+}
+
+// ExecuteLiveWithTimeout runs a command like ExecuteLive, but stops the command and all of its children if it is still
+// running after timeout. The returned error then has the TimedOutError message. A timeout of 0 or less never stops the command.
+// extraFiles are passed on to the command as open files, starting at file descriptor 3.
+func ExecuteLiveWithTimeout(squashErrors bool, timeout time.Duration, extraFiles []*os.File, program string, args ...string) (err error) {
+	const printOutputOnError = false
+
+	onStdout := logger.Log.Debug
+	onStderr := logger.Log.Warn
+	if squashErrors {
+		onStderr = logger.Log.Debug
+	}
+
+	return executeLive(onStdout, onStderr, printOutputOnError, timeout, extraFiles, program, args...)
+}
+
+// executeLive implements ExecuteLiveWithCallback and ExecuteLiveWithTimeout.
+func executeLive(onStdout, onStderr func(...interface{}), printOutputOnError bool, timeout time.Duration, extraFiles []*os.File, program string, args ...string) (err error) {
+	var (
+		outputChan chan string
+		timedOut   int32
+	)
 	const outputChanBufferSize = 1500
 
 	cmd := exec.Command(program, args...)
+	cmd.ExtraFiles = extraFiles
 
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
@@ -163,6 +194,15 @@ func ExecuteLiveWithCallback(onStdout, onStderr func(...interface{}), printOutpu
 
 	defer untrackProcess(cmd)
 
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			logger.Log.Errorf("Stopping (%s), it is still running after %s", strings.Join(cmd.Args, " "), timeout)
+			atomic.StoreInt32(&timedOut, 1)
+			stopProcessGroup(cmd)
+		})
+		defer timer.Stop()
+	}
+
 	wg := new(sync.WaitGroup)
 	wg.Add(2)
 
@@ -174,6 +214,9 @@ func ExecuteLiveWithCallback(onStdout, onStderr func(...interface{}), printOutpu
 
 	wg.Wait()
 	err = cmd.Wait()
+	if atomic.LoadInt32(&timedOut) != 0 {
+		err = fmt.Errorf(TimedOutError)
+	}
 
 	// Optionally dump the output in the event of an error
 	if outputChan != nil {
@@ -227,6 +270,15 @@ func trackAndStartProcess(cmd *exec.Cmd) (err error) {
 	return
 }
 
+// stopProcessGroup sends a SIGKILL to the process group of a command started by trackAndStartProcess,
+// stopping the command and all of its children.
+func stopProcessGroup(cmd *exec.Cmd) {
+	err := unix.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if err != nil {
+		logger.Log.Warnf("Unable to stop (%s): %v", strings.Join(cmd.Args, " "), err)
+	}
+}
+
 func untrackProcess(cmd *exec.Cmd) {
 	activeCommandsMutex.Lock()
 	defer activeCommandsMutex.Unlock()
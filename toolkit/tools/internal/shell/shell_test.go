@@ -0,0 +1,62 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package shell
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+const squashErrors = true
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func TestExecuteLiveWithTimeoutShouldSucceed(t *testing.T) {
+	const timeout = time.Minute
+
+	err := ExecuteLiveWithTimeout(squashErrors, timeout, nil, "true")
+	assert.NoError(t, err)
+}
+
+func TestExecuteLiveWithTimeoutShouldReturnCommandError(t *testing.T) {
+	const timeout = time.Minute
+
+	err := ExecuteLiveWithTimeout(squashErrors, timeout, nil, "false")
+	assert.Error(t, err)
+	assert.NotEqual(t, TimedOutError, err.Error())
+}
+
+func TestExecuteLiveWithTimeoutShouldStopCommandAndChildren(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	err := ExecuteLiveWithTimeout(squashErrors, timeout, nil, ShellProgram, "-c", "sleep 60 & sleep 60")
+	assert.Error(t, err)
+	assert.Equal(t, TimedOutError, err.Error())
+	assert.True(t, time.Since(start) < 30*time.Second)
+}
+
+func TestExecuteLiveWithTimeoutShouldPassExtraFiles(t *testing.T) {
+	const noTimeout = 0
+
+	extraFile, err := ioutil.TempFile("", "shell")
+	assert.NoError(t, err)
+	defer os.Remove(extraFile.Name())
+	defer extraFile.Close()
+
+	err = ExecuteLiveWithTimeout(squashErrors, noTimeout, []*os.File{extraFile}, ShellProgram, "-c", "echo test >&3")
+	assert.NoError(t, err)
+
+	content, err := ioutil.ReadFile(extraFile.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "test\n", string(content))
+}
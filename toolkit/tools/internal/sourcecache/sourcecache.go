@@ -0,0 +1,260 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package sourcecache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+const (
+	// entryMode is the mode of every file in the cache. Entries are read-only since they may be hardlinked into
+	// working directories, where a write would otherwise corrupt the cache.
+	entryMode = 0444
+
+	// partialPrefix is used for files which are still being added to the cache.
+	partialPrefix = ".partial-"
+
+	// ficlone is the FICLONE ioctl, which creates a copy-on-write clone (reflink) of a file on supported filesystems.
+	ficlone = 0x40049409
+)
+
+// sha256Regex matches a hex encoded SHA256 hash.
+var sha256Regex = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// Cache is a content-addressed store of files keyed by their SHA256 hash. It can be shared by several processes.
+type Cache struct {
+	dir     string
+	maxSize int64
+}
+
+// New creates a cache in dir, creating the directory if needed. maxSize is the size in bytes the cache is trimmed
+// to by Evict, a maxSize of 0 or less means the cache is never trimmed.
+func New(dir string, maxSize int64) (c *Cache, err error) {
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	c = &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+	}
+	return
+}
+
+// Place places the file with the given SHA256 hash at dst. The file is hardlinked from the cache if possible,
+// falling back to a reflink and then to a regular copy. Returns false if the cache does not hold the file.
+func (c *Cache) Place(hash, dst string) (found bool, err error) {
+	if !sha256Regex.MatchString(hash) {
+		err = fmt.Errorf("invalid SHA256 hash (%s)", hash)
+		return
+	}
+
+	entry := c.entryPath(hash)
+
+	// Mark the entry as recently used, this also checks that it exists.
+	now := time.Now()
+	err = os.Chtimes(entry, now, now)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	err = os.Link(entry, dst)
+	if err == nil {
+		found = true
+		return
+	}
+
+	// The entry may have been evicted by another process since it was marked as used.
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	logger.Log.Debugf("Unable to hardlink (%s) to (%s), falling back to a copy. Error: %s", entry, dst, err)
+
+	err = reflink(entry, dst)
+	if err != nil {
+		logger.Log.Debugf("Unable to reflink (%s) to (%s), falling back to a copy. Error: %s", entry, dst, err)
+		err = file.Copy(entry, dst)
+	}
+
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+
+	found = err == nil
+	return
+}
+
+// Add adds a copy of the file at path to the cache. Returns the SHA256 hash the file is stored under.
+func (c *Cache) Add(path string) (hash string, err error) {
+	hash, err = file.GenerateSHA256(path)
+	if err != nil {
+		return
+	}
+
+	entry := c.entryPath(hash)
+	exists, err := file.PathExists(entry)
+	if err != nil || exists {
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(entry), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	// Copy the file under a temporary name first so other processes never see a partial entry.
+	partialFile, err := ioutil.TempFile(filepath.Dir(entry), partialPrefix)
+	if err != nil {
+		return
+	}
+	partialPath := partialFile.Name()
+	partialFile.Close()
+	defer os.Remove(partialPath)
+
+	err = file.Copy(path, partialPath)
+	if err != nil {
+		return
+	}
+
+	// The file may have changed while it was being copied.
+	copiedHash, err := file.GenerateSHA256(partialPath)
+	if err != nil {
+		return
+	}
+	if !strings.EqualFold(hash, copiedHash) {
+		err = fmt.Errorf("file (%s) changed while being added to the cache", path)
+		return
+	}
+
+	err = os.Chmod(partialPath, entryMode)
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(partialPath, entry)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Debugf("Added (%s) to the source cache as (%s)", path, hash)
+	return
+}
+
+// Evict removes the least recently used files from the cache until it is no larger than its maximum size.
+// Returns the number of files removed.
+func (c *Cache) Evict() (removed int, err error) {
+	type cacheEntry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	if c.maxSize <= 0 {
+		return
+	}
+
+	var (
+		entries   []cacheEntry
+		totalSize int64
+	)
+
+	err = filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Another process may have removed the file while walking the cache.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), partialPrefix) {
+			return nil
+		}
+
+		entries = append(entries, cacheEntry{path: path, size: info.Size(), modTime: info.ModTime()})
+		totalSize += info.Size()
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	for _, entry := range entries {
+		if totalSize <= c.maxSize {
+			break
+		}
+
+		err = os.Remove(entry.path)
+		if err != nil && !os.IsNotExist(err) {
+			return
+		}
+		err = nil
+
+		logger.Log.Debugf("Evicted (%s) from the source cache", entry.path)
+		totalSize -= entry.size
+		removed++
+	}
+
+	return
+}
+
+// entryPath returns the path a file with the given SHA256 hash is stored at. Entries are spread over
+// subdirectories named after the first two characters of the hash to keep directories small.
+func (c *Cache) entryPath(hash string) string {
+	const prefixLength = 2
+
+	hash = strings.ToLower(hash)
+	return filepath.Join(c.dir, hash[:prefixLength], hash)
+}
+
+// reflink creates dst as a copy-on-write clone of src.
+func reflink(src, dst string) (err error) {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, entryMode)
+	if err != nil {
+		return
+	}
+
+	err = unix.IoctlSetInt(int(dstFile.Fd()), ficlone, int(srcFile.Fd()))
+	closeErr := dstFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(dst)
+	}
+	return
+}
@@ -0,0 +1,129 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package sourcecache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+func writeTestFile(t *testing.T, dir, name, contents string) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, []byte(contents), 0644)
+	assert.NoError(t, err)
+	return path
+}
+
+func TestAddAndPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sourcecache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := New(filepath.Join(dir, "cache"), 0)
+	assert.NoError(t, err)
+
+	source := writeTestFile(t, dir, "source.tar.gz", "contents")
+	hash, err := cache.Add(source)
+	assert.NoError(t, err)
+	assert.Equal(t, "d1b2a59fbea7e20077af9f91b27e95e865061b270be03ff539ab3b73587882e8", hash)
+
+	// Adding the same contents again is a no-op.
+	_, err = cache.Add(source)
+	assert.NoError(t, err)
+
+	dst := filepath.Join(dir, "work", "SOURCES", "source.tar.gz")
+	found, err := cache.Place(strings.ToUpper(hash), dst)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	contents, err := ioutil.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "contents", string(contents))
+
+	// Entries are read-only so a hardlinked copy cannot corrupt the cache.
+	info, err := os.Stat(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(entryMode), info.Mode().Perm())
+}
+
+func TestPlaceMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sourcecache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := New(dir, 0)
+	assert.NoError(t, err)
+
+	found, err := cache.Place(strings.Repeat("a", 64), filepath.Join(dir, "dst"))
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	_, err = cache.Place("../../etc/passwd", filepath.Join(dir, "dst"))
+	assert.Error(t, err)
+}
+
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sourcecache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Room for two of the three 4 byte files.
+	cache, err := New(filepath.Join(dir, "cache"), 8)
+	assert.NoError(t, err)
+
+	var hashes []string
+	for i, name := range []string{"old", "mid", "new"} {
+		var hash string
+
+		hash, err = cache.Add(writeTestFile(t, dir, name, name+"!"))
+		assert.NoError(t, err)
+		hashes = append(hashes, hash)
+
+		useTime := time.Now().Add(time.Duration(i-10) * time.Hour)
+		err = os.Chtimes(cache.entryPath(hash), useTime, useTime)
+		assert.NoError(t, err)
+	}
+
+	removed, err := cache.Evict()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	found, err := cache.Place(hashes[0], filepath.Join(dir, "dst-old"))
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	for _, hash := range hashes[1:] {
+		found, err = cache.Place(hash, filepath.Join(dir, "dst-"+hash))
+		assert.NoError(t, err)
+		assert.True(t, found)
+	}
+}
+
+func TestEvictUnbounded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sourcecache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := New(dir, 0)
+	assert.NoError(t, err)
+
+	_, err = cache.Add(writeTestFile(t, dir, "file", "contents"))
+	assert.NoError(t, err)
+
+	removed, err := cache.Evict()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+}
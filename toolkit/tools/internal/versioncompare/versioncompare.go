@@ -21,6 +21,12 @@ type TolerantVersion struct {
 	isMaxVer          bool
 	isMinVer          bool
 	original          string
+
+	// RPM style epoch, version and release, see NewRPM
+	rpmCompare bool
+	epoch      string
+	version    string
+	release    string
 }
 
 // New returns new TolerantVersion
@@ -30,6 +36,16 @@ func New(versionString string) *TolerantVersion {
 	return v
 }
 
+// NewRPM returns a new TolerantVersion which is compared using the same rules as RPM's rpmvercmp.
+// The version string may include an epoch ("2:1.0") and a release ("1.0-1"). Versions missing an epoch
+// are treated as epoch 0, and releases are only compared if both versions include one.
+// A comparison between an RPM version and a version returned by New uses the RPM rules.
+func NewRPM(versionString string) *TolerantVersion {
+	v := New(versionString)
+	v.rpmCompare = true
+	return v
+}
+
 // NewMax returns a special version which is always greater than any other version
 func NewMax() *TolerantVersion {
 	return &TolerantVersion{original: "MAX_VER", isMaxVer: true}
@@ -79,6 +95,10 @@ func (v *TolerantVersion) Compare(other *TolerantVersion) int {
 		return lessThan
 	}
 
+	if v.rpmCompare || other.rpmCompare {
+		return v.compareRPM(other)
+	}
+
 	for i := range v.versionComponents {
 		if i == len(other.versionComponents) {
 			return greatherThan
@@ -120,18 +140,193 @@ func (v *TolerantVersion) String() string {
 	return v.original
 }
 
+// compareRPM compares the epoch, version and release of two versions using RPM's rules.
+func (v *TolerantVersion) compareRPM(other *TolerantVersion) int {
+	const defaultEpoch = "0"
+
+	epoch, otherEpoch := v.epoch, other.epoch
+	if epoch == "" {
+		epoch = defaultEpoch
+	}
+	if otherEpoch == "" {
+		otherEpoch = defaultEpoch
+	}
+
+	result := rpmvercmp(epoch, otherEpoch)
+	if result != 0 {
+		return result
+	}
+
+	result = rpmvercmp(v.version, other.version)
+	if result != 0 {
+		return result
+	}
+
+	// Only check the release if both versions request it.
+	if v.release != "" && other.release != "" {
+		result = rpmvercmp(v.release, other.release)
+	}
+
+	return result
+}
+
+// rpmvercmp compares two version or release strings the same way RPM does, returning -1, 0 or 1.
+// The strings are split into alternating numeric and alphabetic segments, all other characters are separators.
+// Numeric segments are newer than alphabetic ones, '~' sorts before anything (even the end of the string)
+// and '^' sorts after the end of the string but before anything else.
+func rpmvercmp(a, b string) int {
+	const (
+		lessThan     = -1
+		equalTo      = 0
+		greatherThan = 1
+	)
+
+	if a == b {
+		return equalTo
+	}
+
+	one, two := a, b
+	for len(one) > 0 || len(two) > 0 {
+		one = strings.TrimLeftFunc(one, isRPMSeparator)
+		two = strings.TrimLeftFunc(two, isRPMSeparator)
+
+		// The tilde separator sorts before everything else
+		if strings.HasPrefix(one, "~") || strings.HasPrefix(two, "~") {
+			if !strings.HasPrefix(one, "~") {
+				return greatherThan
+			}
+			if !strings.HasPrefix(two, "~") {
+				return lessThan
+			}
+			one, two = one[1:], two[1:]
+			continue
+		}
+
+		// The caret separator sorts after the end of the string, but before everything else
+		if strings.HasPrefix(one, "^") || strings.HasPrefix(two, "^") {
+			if len(one) == 0 {
+				return lessThan
+			}
+			if len(two) == 0 {
+				return greatherThan
+			}
+			if !strings.HasPrefix(one, "^") {
+				return greatherThan
+			}
+			if !strings.HasPrefix(two, "^") {
+				return lessThan
+			}
+			one, two = one[1:], two[1:]
+			continue
+		}
+
+		if len(one) == 0 || len(two) == 0 {
+			break
+		}
+
+		// Grab the next segment of the same type from both strings
+		isNumeric := isASCIIDigit(rune(one[0]))
+		segmentType := isASCIIAlpha
+		if isNumeric {
+			segmentType = isASCIIDigit
+		}
+
+		segmentOne, segmentTwo := leadingSegment(one, segmentType), leadingSegment(two, segmentType)
+		one, two = one[len(segmentOne):], two[len(segmentTwo):]
+
+		// The segments are of different types, numeric segments are newer
+		if len(segmentTwo) == 0 {
+			if isNumeric {
+				return greatherThan
+			}
+			return lessThan
+		}
+
+		if isNumeric {
+			segmentOne = strings.TrimLeft(segmentOne, "0")
+			segmentTwo = strings.TrimLeft(segmentTwo, "0")
+
+			// Whichever number has more digits wins
+			if len(segmentOne) > len(segmentTwo) {
+				return greatherThan
+			}
+			if len(segmentOne) < len(segmentTwo) {
+				return lessThan
+			}
+		}
+
+		result := strings.Compare(segmentOne, segmentTwo)
+		if result != equalTo {
+			return result
+		}
+	}
+
+	// All segments compared identically but the separators were different
+	if len(one) == 0 && len(two) == 0 {
+		return equalTo
+	}
+
+	// Whichever version still has characters left over wins
+	if len(one) == 0 {
+		return lessThan
+	}
+	return greatherThan
+}
+
+// leadingSegment returns the longest prefix of s made of runes matching segmentType.
+func leadingSegment(s string, segmentType func(rune) bool) string {
+	end := strings.IndexFunc(s, func(r rune) bool { return !segmentType(r) })
+	if end < 0 {
+		return s
+	}
+	return s[:end]
+}
+
+func isASCIIDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isASCIIAlpha(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// isRPMSeparator returns true for any character which does not belong to a version segment.
+func isRPMSeparator(r rune) bool {
+	return !isASCIIDigit(r) && !isASCIIAlpha(r) && r != '~' && r != '^'
+}
+
+// parseEVR splits an RPM style "[epoch:]version[-release]" string. The release starts after the last '-'.
+func (v *TolerantVersion) parseEVR(versionString string) {
+	remaining := versionString
+
+	epochEnd := strings.Index(remaining, ":")
+	if epochEnd > 0 && strings.IndexFunc(remaining[:epochEnd], func(r rune) bool { return !isASCIIDigit(r) }) < 0 {
+		v.epoch = remaining[:epochEnd]
+		remaining = remaining[epochEnd+1:]
+	}
+
+	releaseStart := strings.LastIndex(remaining, "-")
+	if releaseStart >= 0 {
+		v.release = remaining[releaseStart+1:]
+		remaining = remaining[:releaseStart]
+	}
+
+	v.version = remaining
+}
+
 // parse takes an arbitrary versionString and fills v with the processed version information
 func (v *TolerantVersion) parse(versionString string) {
 	var (
 		versionSubstring, releaseSubstring string
 	)
-	// Split off any release number if present. '-' is an illegal character for versions so we can split on it
-	splitString := strings.Split(versionString, "-")
-	versionSubstring = splitString[0]
-	if len(splitString) > 1 {
-		releaseSubstring = splitString[1]
-	} else {
-		releaseSubstring = ""
+
+	v.parseEVR(versionString)
+	// Split off any release number if present. The release starts after the last '-', matching parseEVR
+	versionSubstring = versionString
+	releaseStart := strings.LastIndex(versionString, "-")
+	if releaseStart >= 0 {
+		versionSubstring = versionString[:releaseStart]
+		releaseSubstring = versionString[releaseStart+1:]
 	}
 
 	rawComponents := componentRegex.FindAllString(versionSubstring, -1)
@@ -155,6 +155,16 @@ func TestMultiplePartRelease(t *testing.T) {
 	assert.Equal(t, 1, high.Compare(low))
 }
 
+func TestReleaseShouldStartAfterLastDash(t *testing.T) {
+	high := New("1.0-beta-3")
+	low := New("1.0-beta-2")
+	assert.Equal(t, -1, low.Compare(high))
+	assert.Equal(t, 1, high.Compare(low))
+
+	// Both entry points must agree on where the release starts.
+	assert.Equal(t, -1, NewRPM("1.0-beta-2").Compare(NewRPM("1.0-beta-3")))
+}
+
 func TestMaxVersion(t *testing.T) {
 	high := NewMax()
 	low := New("1")
@@ -199,3 +209,138 @@ func TestInvalidConditional(t *testing.T) {
 	_, err := low.CompareWithConditional("?", high)
 	assert.Error(t, err)
 }
+
+// Cases ported from RPM's rpmvercmp test suite (tests/rpmvercmp.at)
+func TestRPMVerCmpConformance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0", "1.0", 1},
+		{"2.0.1", "2.0.1", 0},
+		{"2.0", "2.0.1", -1},
+		{"2.0.1", "2.0", 1},
+		{"2.0.1a", "2.0.1a", 0},
+		{"2.0.1a", "2.0.1", 1},
+		{"2.0.1", "2.0.1a", -1},
+		{"5.5p1", "5.5p1", 0},
+		{"5.5p1", "5.5p2", -1},
+		{"5.5p2", "5.5p1", 1},
+		{"5.5p10", "5.5p10", 0},
+		{"5.5p1", "5.5p10", -1},
+		{"5.5p10", "5.5p1", 1},
+		{"10xyz", "10.1xyz", -1},
+		{"10.1xyz", "10xyz", 1},
+		{"xyz10", "xyz10", 0},
+		{"xyz10", "xyz10.1", -1},
+		{"xyz10.1", "xyz10", 1},
+		{"xyz.4", "xyz.4", 0},
+		{"xyz.4", "8", -1},
+		{"8", "xyz.4", 1},
+		{"xyz.4", "2", -1},
+		{"2", "xyz.4", 1},
+		{"5.5p2", "5.6p1", -1},
+		{"5.6p1", "5.5p2", 1},
+		{"5.6p1", "6.5p1", -1},
+		{"6.5p1", "5.6p1", 1},
+		{"6.0.rc1", "6.0", 1},
+		{"6.0", "6.0.rc1", -1},
+		{"10b2", "10a1", 1},
+		{"10a2", "10b2", -1},
+		{"1.0aa", "1.0aa", 0},
+		{"1.0a", "1.0aa", -1},
+		{"1.0aa", "1.0a", 1},
+		{"10.0001", "10.0001", 0},
+		{"10.0001", "10.1", 0},
+		{"10.1", "10.0001", 0},
+		{"10.0001", "10.0039", -1},
+		{"10.0039", "10.0001", 1},
+		{"4.999.9", "5.0", -1},
+		{"5.0", "4.999.9", 1},
+		{"20101121", "20101121", 0},
+		{"20101121", "20101122", -1},
+		{"20101122", "20101121", 1},
+		{"2_0", "2_0", 0},
+		{"2.0", "2_0", 0},
+		{"2_0", "2.0", 0},
+		{"a", "a", 0},
+		{"a+", "a+", 0},
+		{"a+", "a_", 0},
+		{"a_", "a+", 0},
+		{"+a", "+a", 0},
+		{"+a", "_a", 0},
+		{"_a", "+a", 0},
+		{"+_", "+_", 0},
+		{"_+", "+_", 0},
+		{"_+", "_", 0},
+		{"+", "_", 0},
+		{"1.0~rc1", "1.0~rc1", 0},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0", "1.0~rc1", 1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~rc2", "1.0~rc1", 1},
+		{"1.0~rc1~git123", "1.0~rc1~git123", 0},
+		{"1.0~rc1~git123", "1.0~rc1", -1},
+		{"1.0~rc1", "1.0~rc1~git123", 1},
+		{"1.0^", "1.0^", 0},
+		{"1.0^", "1.0", 1},
+		{"1.0", "1.0^", -1},
+		{"1.0^git1", "1.0^git1", 0},
+		{"1.0^git1", "1.0", 1},
+		{"1.0", "1.0^git1", -1},
+		{"1.0^git1", "1.0^git2", -1},
+		{"1.0^git2", "1.0^git1", 1},
+		{"1.0^git1", "1.01", -1},
+		{"1.01", "1.0^git1", 1},
+		{"1.0^20160101", "1.0^20160101", 0},
+		{"1.0^20160101", "1.0.1", -1},
+		{"1.0.1", "1.0^20160101", 1},
+		{"1.0^20160101^git1", "1.0^20160101^git1", 0},
+		{"1.0^20160102", "1.0^20160101^git1", 1},
+		{"1.0^20160101^git1", "1.0^20160102", -1},
+		{"1.0~rc1^git1", "1.0~rc1^git1", 0},
+		{"1.0~rc1^git1", "1.0~rc1", 1},
+		{"1.0~rc1", "1.0~rc1^git1", -1},
+		{"1.0^git1~pre", "1.0^git1~pre", 0},
+		{"1.0^git1", "1.0^git1~pre", 1},
+		{"1.0^git1~pre", "1.0^git1", -1},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, rpmvercmp(test.a, test.b), "rpmvercmp(%s, %s)", test.a, test.b)
+		assert.Equal(t, test.expected, NewRPM(test.a).Compare(NewRPM(test.b)), "Compare(%s, %s)", test.a, test.b)
+	}
+}
+
+func TestRPMCompareShouldUseEpoch(t *testing.T) {
+	assert.Equal(t, 1, NewRPM("1:1.0").Compare(NewRPM("2.0")))
+	assert.Equal(t, -1, NewRPM("2.0-1").Compare(NewRPM("1:1.0-1")))
+	assert.Equal(t, 0, NewRPM("0:1.0").Compare(NewRPM("1.0")))
+	assert.Equal(t, -1, NewRPM("1:2.0").Compare(NewRPM("2:1.0")))
+}
+
+func TestRPMCompareShouldOnlyCompareReleaseIfBothSet(t *testing.T) {
+	assert.Equal(t, 0, NewRPM("1.0").Compare(NewRPM("1.0-5.cm1")))
+	assert.Equal(t, -1, NewRPM("1.0-4.cm1").Compare(NewRPM("1.0-5.cm1")))
+	assert.Equal(t, 1, NewRPM("1.0-10.cm1").Compare(NewRPM("1.0-9.cm1")))
+}
+
+func TestRPMCompareShouldSplitOnLastDash(t *testing.T) {
+	v := NewRPM("3:1.0-beta-2.cm1")
+	assert.Equal(t, "3", v.epoch)
+	assert.Equal(t, "1.0-beta", v.version)
+	assert.Equal(t, "2.cm1", v.release)
+}
+
+func TestRPMCompareShouldHandleMinMax(t *testing.T) {
+	assert.Equal(t, -1, NewRPM("1.0~rc1").Compare(NewMax()))
+	assert.Equal(t, 1, NewRPM("0:0").Compare(NewMin()))
+}
+
+func TestRPMCompareShouldApplyToMixedVersions(t *testing.T) {
+	// Tolerant comparison ignores the tilde, RPM comparison sorts it first
+	assert.Equal(t, 0, New("1.0~rc1").Compare(New("1.0rc1")))
+	assert.Equal(t, -1, New("1.0~rc1").Compare(NewRPM("1.0rc1")))
+}
@@ -0,0 +1,196 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/jsonutils"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/rpm"
+)
+
+// buildEnvironmentManifestSuffix is appended to the name of an SRPM to get the name of its build environment manifest.
+const buildEnvironmentManifestSuffix = ".buildenv.json"
+
+// localRepo is a repository of a repo file served from a directory inside the chroot.
+type localRepo struct {
+	id  string
+	dir string
+}
+
+// repoRPM is an RPM file found in a local repository.
+type repoRPM struct {
+	repoID string
+	path   string
+}
+
+// buildEnvironmentPackage is a package which was installed in the chroot an SRPM was built in.
+// Repo and SHA256 are only set if the package's RPM file was found in one of the local repositories.
+type buildEnvironmentPackage struct {
+	Name    string `json:"Name"`
+	Epoch   string `json:"Epoch"`
+	Version string `json:"Version"`
+	Release string `json:"Release"`
+	Arch    string `json:"Arch"`
+	Repo    string `json:"Repo"`
+	SHA256  string `json:"SHA256"`
+}
+
+// buildEnvironmentManifest records every package present in the chroot an SRPM was built in.
+type buildEnvironmentManifest struct {
+	SRPM      string                     `json:"SRPM"`
+	BuiltRPMs []string                   `json:"BuiltRPMs"`
+	Packages  []*buildEnvironmentPackage `json:"Packages"`
+}
+
+// readLocalRepos returns the repositories of a repo file which are served from a local directory,
+// in the order they are listed.
+func readLocalRepos(repoFile string) (repos []localRepo, err error) {
+	const (
+		baseURLKey  = "baseurl"
+		localScheme = "file://"
+	)
+
+	f, err := os.Open(repoFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var currentID string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			currentID = strings.Trim(line, "[]")
+			continue
+		}
+
+		keyValue := strings.SplitN(line, "=", 2)
+		if len(keyValue) != 2 || strings.TrimSpace(keyValue[0]) != baseURLKey {
+			continue
+		}
+
+		baseURL := strings.TrimSpace(keyValue[1])
+		if currentID != "" && strings.HasPrefix(baseURL, localScheme) {
+			repos = append(repos, localRepo{id: currentID, dir: strings.TrimPrefix(baseURL, localScheme)})
+		}
+	}
+
+	err = scanner.Err()
+	return
+}
+
+// queryBuildEnvironment lists every package installed on the system. Must be run inside the chroot.
+// The RPM file of each package is looked up by name in the local repositories to find the repository it came from.
+func queryBuildEnvironment(repos []localRepo) (packages []*buildEnvironmentPackage, err error) {
+	const queryFormat = "%{NAME} %{EPOCHNUM} %{VERSION} %{RELEASE} %{ARCH}\n"
+
+	const (
+		nameIndex         = iota
+		epochIndex        = iota
+		versionIndex      = iota
+		releaseIndex      = iota
+		archIndex         = iota
+		expectedFieldsLen = iota
+	)
+
+	installedPackages, err := rpm.QueryInstalledPackages(queryFormat)
+	if err != nil {
+		return
+	}
+
+	rpmFiles, err := findRepoRPMs(repos)
+	if err != nil {
+		return
+	}
+
+	for _, installedPackage := range installedPackages {
+		fields := strings.Fields(installedPackage)
+		if len(fields) != expectedFieldsLen {
+			err = fmt.Errorf("unexpected query result for installed package (%s)", installedPackage)
+			return
+		}
+
+		pkg := &buildEnvironmentPackage{
+			Name:    fields[nameIndex],
+			Epoch:   fields[epochIndex],
+			Version: fields[versionIndex],
+			Release: fields[releaseIndex],
+			Arch:    fields[archIndex],
+		}
+
+		rpmFileName := fmt.Sprintf("%s-%s-%s.%s.rpm", pkg.Name, pkg.Version, pkg.Release, pkg.Arch)
+		if rpmFile, found := rpmFiles[rpmFileName]; found {
+			pkg.Repo = rpmFile.repoID
+			pkg.SHA256, err = file.GenerateSHA256(rpmFile.path)
+			if err != nil {
+				return
+			}
+		} else {
+			logger.Log.Debugf("Unable to find the RPM file of installed package (%s) in any local repository", rpmFileName)
+		}
+
+		packages = append(packages, pkg)
+	}
+
+	return
+}
+
+// findRepoRPMs returns the RPM files found in the local repositories keyed by file name.
+// If several repositories hold the same file, the first repository wins.
+func findRepoRPMs(repos []localRepo) (rpmFiles map[string]repoRPM, err error) {
+	const rpmExtension = ".rpm"
+
+	rpmFiles = make(map[string]repoRPM)
+	for _, repo := range repos {
+		repoID := repo.id
+		err = filepath.Walk(repo.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				// A repository of the repo file may not be mounted into the chroot.
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+
+			if !info.Mode().IsRegular() || !strings.HasSuffix(info.Name(), rpmExtension) {
+				return nil
+			}
+
+			if _, found := rpmFiles[info.Name()]; !found {
+				rpmFiles[info.Name()] = repoRPM{repoID: repoID, path: path}
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// writeBuildEnvironmentManifest writes the build environment manifest of an SRPM to outDir.
+func writeBuildEnvironmentManifest(outDir, srpmFile string, builtRPMs []string, packages []*buildEnvironmentPackage) (manifestFile string, err error) {
+	const srpmSuffix = ".src.rpm"
+
+	srpmName := strings.TrimSuffix(filepath.Base(srpmFile), srpmSuffix)
+	manifestFile = filepath.Join(outDir, srpmName+buildEnvironmentManifestSuffix)
+
+	manifest := &buildEnvironmentManifest{
+		SRPM:      filepath.Base(srpmFile),
+		BuiltRPMs: builtRPMs,
+		Packages:  packages,
+	}
+
+	err = jsonutils.WriteJSONFile(manifestFile, manifest)
+	return
+}
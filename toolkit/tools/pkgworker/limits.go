@@ -0,0 +1,103 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alecthomas/units"
+	"microsoft.com/pkggen/internal/jsonutils"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/rpm"
+)
+
+// buildLimits are the resources a single build of an SRPM may use. Zero values mean no limit.
+// cgroupParent is the cgroup to enforce the memory and CPU limits in, see cgroup.New.
+type buildLimits struct {
+	timeout      time.Duration
+	memoryLimit  int64
+	cpuQuota     float64
+	cgroupParent string
+}
+
+// specLimits overrides the build limits of a single SPEC. Fields which are not set keep the default limits,
+// "0" and 0 remove a limit. Timeout uses Go duration syntax such as "90m", MemoryLimit sizes such as "16GB".
+type specLimits struct {
+	Timeout     string   `json:"Timeout"`
+	MemoryLimit string   `json:"MemoryLimit"`
+	CPUQuota    *float64 `json:"CPUQuota"`
+}
+
+// buildLimitsFile is the content of the file passed to --limits-file, keyed by the name of the SPEC.
+type buildLimitsFile struct {
+	Specs map[string]*specLimits `json:"Specs"`
+}
+
+// usesCgroup returns true if the limits must be enforced through a cgroup.
+func (l buildLimits) usesCgroup() bool {
+	return l.memoryLimit > 0 || l.cpuQuota > 0
+}
+
+// readBuildLimits returns the limits to build srpmFile with: the default limits, overridden by the
+// entry of the SRPM's SPEC in limitsFile if there is one.
+func readBuildLimits(limitsFile, srpmFile string, defaults buildLimits) (limits buildLimits, err error) {
+	const (
+		queryFormat             = "%{NAME}"
+		nameIndex               = 0
+		expectedQueryResultsLen = 1
+	)
+
+	limits = defaults
+	if limitsFile == "" {
+		return
+	}
+
+	var allLimits buildLimitsFile
+	err = jsonutils.ReadJSONFile(limitsFile, &allLimits)
+	if err != nil {
+		return
+	}
+
+	queryResults, err := rpm.QueryPackage(srpmFile, queryFormat, nil, rpm.QueryPackageFileArgument)
+	if err != nil {
+		return
+	}
+	if len(queryResults) != expectedQueryResultsLen {
+		err = fmt.Errorf("unexpected query results, wanted (%d) results but got (%d), results: %v", expectedQueryResultsLen, len(queryResults), queryResults)
+		return
+	}
+
+	specName := queryResults[nameIndex]
+	overrides, found := allLimits.Specs[specName]
+	if !found {
+		return
+	}
+
+	logger.Log.Debugf("Using the build limits of SPEC (%s) from (%s)", specName, limitsFile)
+
+	if overrides.Timeout != "" {
+		limits.timeout, err = time.ParseDuration(overrides.Timeout)
+		if err != nil {
+			err = fmt.Errorf("invalid timeout for SPEC (%s): %v", specName, err)
+			return
+		}
+	}
+
+	if overrides.MemoryLimit != "" {
+		var memoryLimit units.Base2Bytes
+		memoryLimit, err = units.ParseBase2Bytes(overrides.MemoryLimit)
+		if err != nil {
+			err = fmt.Errorf("invalid memory limit for SPEC (%s): %v", specName, err)
+			return
+		}
+		limits.memoryLimit = int64(memoryLimit)
+	}
+
+	if overrides.CPUQuota != nil {
+		limits.cpuQuota = *overrides.CPUQuota
+	}
+
+	return
+}
@@ -16,8 +16,10 @@ import (
 
 	mapset "github.com/deckarep/golang-set"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"microsoft.com/pkggen/internal/cgroup"
 	"microsoft.com/pkggen/internal/exe"
 	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/jsonutils"
 	"microsoft.com/pkggen/internal/logger"
 	"microsoft.com/pkggen/internal/packagerepo/repomanager/rpmrepomanager"
 	"microsoft.com/pkggen/internal/retry"
@@ -34,6 +36,33 @@ const (
 	defaultRetryAttempts    = "1"
 )
 
+// phaseDurations holds how long, in seconds, each phase of a build attempt took.
+type phaseDurations struct {
+	ChrootInitialization float64 `json:"ChrootInitialization"`
+	BuildRequiresInstall float64 `json:"BuildRequiresInstall"`
+	RPMBuild             float64 `json:"RPMBuild"`
+	RPMMove              float64 `json:"RPMMove"`
+}
+
+// buildResult describes the outcome of building an SRPM. Phase durations, installed BuildRequires, built RPMs
+// and the build environment manifest are those of the last build attempt. TimedOut and OutOfMemory tell a build
+// stopped by its limits apart from a failing build.
+type buildResult struct {
+	SRPM                     string                 `json:"SRPM"`
+	Success                  bool                   `json:"Success"`
+	Error                    string                 `json:"Error"`
+	TimedOut                 bool                   `json:"TimedOut"`
+	OutOfMemory              bool                   `json:"OutOfMemory"`
+	Attempts                 int                    `json:"Attempts"`
+	Retries                  int                    `json:"Retries"`
+	TotalDuration            float64                `json:"TotalDuration"`
+	PhaseDurations           phaseDurations         `json:"PhaseDurations"`
+	InstalledBuildRequires   []string               `json:"InstalledBuildRequires"`
+	BuiltRPMs                []string               `json:"BuiltRPMs"`
+	BuildEnvironmentManifest string                 `json:"BuildEnvironmentManifest"`
+	Reproducibility          *reproducibilityReport `json:"Reproducibility,omitempty"`
+}
+
 var (
 	app                  = kingpin.New("pkgworker", "A worker for building packages locally")
 	srpmFile             = exe.InputFlag(app, "Full path to the SRPM to build")
@@ -44,12 +73,24 @@ var (
 	srpmsDirPath         = app.Flag("srpms-dir", "The output directory for source RPM packages").Required().String()
 	cacheDir             = app.Flag("cache-dir", "The cache directory containing downloaded dependency RPMS from CBL-Mariner Base").Required().ExistingDir()
 	noCleanup            = app.Flag("no-cleanup", "Whether or not to delete the choot folder after the build is done").Bool()
+	chrootPoolDir        = app.Flag("chroot-pool-dir", "Optional directory to keep pre-extracted worker chroots in. If set, the build runs on an overlay of the extracted worker chroot instead of extracting --worker-tar for every build").String()
 	distTag              = app.Flag("dist-tag", "The distribution tag the SPEC will be built with.").Required().String()
 	distroReleaseVersion = app.Flag("distro-release-version", "The distro release version that the SRPM will be built with").Required().String()
 	distroBuildNumber    = app.Flag("distro-build-number", "The distro build number that the SRPM will be built with").Required().String()
 	rpmmacrosFile        = app.Flag("rpmmacros-file", "Optional file path to an rpmmacros file for rpmbuild to use").ExistingFile()
 	retryAttempts        = app.Flag("retry-attempts", "Sets the number of times pkgworker will retry building the package").Default(defaultRetryAttempts).Int()
 	runCheck             = app.Flag("run-check", "Run the check during package build").Bool()
+	resultFile           = app.Flag("result-file", "Optional file path to write a JSON summary of the build to").String()
+	isolateNetwork       = app.Flag("isolate-network", "Build the SRPM in a network namespace with only a loopback interface, so any attempt to reach the network during the build fails").Bool()
+	verifyReproducible   = app.Flag("verify-reproducible", "Build the SRPM twice in independent chroots and fail if the RPMs of both builds differ. The differences are listed in the result file").Bool()
+	timeout              = app.Flag("timeout", "Optional wall-clock limit for rpmbuild, such as 4h. A build running longer is stopped, reported as timed out and not retried").Duration()
+	memoryLimit          = app.Flag("memory-limit", "Optional memory limit for rpmbuild, such as 16GB. Enforced through cgroup v2").Bytes()
+	cpuQuota             = app.Flag("cpu-quota", "Optional number of CPUs rpmbuild may use, such as 2.5. Enforced through cgroup v2").Float64()
+	cgroupParent         = app.Flag("cgroup-parent", "Optional cgroup v2 to enforce --memory-limit and --cpu-quota in, relative to the root of the hierarchy. It must not have processes of its own and must be delegated the memory and cpu controllers. By default pkgworker moves itself into a cgroup of its own, which requires no other process to share its cgroup").String()
+	limitsFile           = app.Flag("limits-file", "Optional JSON file overriding --timeout, --memory-limit and --cpu-quota for individual SPECs").ExistingFile()
+	extraDefines         = app.Flag("define", "Additional rpm define to build with, in the form NAME=VALUE, such as those of a conditional build variant. May be repeated.").StringMap()
+	outputRpmsDirPath    = app.Flag("output-rpms-dir", "Optional directory to submit the built RPM packages to instead of --rpms-dir, such as a directory reserved for a conditional build variant").String()
+	variantRpmsDirPaths  = app.Flag("variant-rpms-dir", "Directory holding the RPMs of a conditional build variant the SRPM depends on. Its RPMs take precedence over those of --rpms-dir, missing directories are skipped. May be repeated.").Strings()
 
 	logFile  = exe.LogFileFlag(app)
 	logLevel = exe.LogLevelFlag(app)
@@ -78,6 +119,32 @@ func main() {
 	srpmsDirAbsPath, err := filepath.Abs(*srpmsDirPath)
 	logger.PanicOnError(err, "Unable to find absolute path for SRPMs directory '%s'", *srpmsDirPath)
 
+	// Variant RPMs are layered over the local repository, so they are only visible to the builds which request them.
+	var localRpmsDirs []string
+	for _, variantRpmsDirPath := range *variantRpmsDirPaths {
+		// A variant which was built by an earlier run may have been cleaned up since, its default build is used instead.
+		exists, err := file.DirExists(variantRpmsDirPath)
+		logger.PanicOnError(err, "Unable to check variant RPMs directory '%s'", variantRpmsDirPath)
+		if !exists {
+			logger.Log.Warnf("Variant RPMs directory (%s) does not exist, using the RPMs of (%s) instead", variantRpmsDirPath, *rpmsDirPath)
+			continue
+		}
+
+		variantRpmsDirAbsPath, err := filepath.Abs(variantRpmsDirPath)
+		logger.PanicOnError(err, "Unable to find absolute path for variant RPMs directory '%s'", variantRpmsDirPath)
+		localRpmsDirs = append(localRpmsDirs, variantRpmsDirAbsPath)
+	}
+	localRpmsDirs = append(localRpmsDirs, rpmsDirAbsPath)
+
+	outputDirAbsPath := rpmsDirAbsPath
+	if *outputRpmsDirPath != "" {
+		outputDirAbsPath, err = filepath.Abs(*outputRpmsDirPath)
+		logger.PanicOnError(err, "Unable to find absolute path for output RPMs directory '%s'", *outputRpmsDirPath)
+
+		err = os.MkdirAll(outputDirAbsPath, os.ModePerm)
+		logger.PanicOnError(err, "Unable to create output RPMs directory '%s'", *outputRpmsDirPath)
+	}
+
 	srpmName := strings.TrimSuffix(filepath.Base(*srpmFile), ".src.rpm")
 	chrootDir := filepath.Join(*workDir, srpmName)
 
@@ -85,14 +152,82 @@ func main() {
 	defines[rpm.DistTagDefine] = *distTag
 	defines[rpm.DistroReleaseVersionDefine] = *distroReleaseVersion
 	defines[rpm.DistroBuildNumberDefine] = *distroBuildNumber
+	for name, value := range *extraDefines {
+		defines[name] = value
+	}
+
+	if *isolateNetwork && *runCheck {
+		logger.Log.Warn("Network isolation is enabled, package tests will run without network access")
+	}
+
+	defaultLimits := buildLimits{
+		timeout:      *timeout,
+		memoryLimit:  int64(*memoryLimit),
+		cpuQuota:     *cpuQuota,
+		cgroupParent: *cgroupParent,
+	}
+	limits, err := readBuildLimits(*limitsFile, *srpmFile, defaultLimits)
+	logger.PanicOnError(err, "Failed to read build limits file '%s'", *limitsFile)
 
+	var baseChroot string
+	if *chrootPoolDir != "" {
+		baseChroot, err = safechroot.PrepareBaseChroot(*chrootPoolDir, *workerTar)
+		logger.PanicOnError(err, "Failed to prepare worker chroot '%s' in chroot pool '%s'", *workerTar, *chrootPoolDir)
+	}
+
+	result := &buildResult{SRPM: *srpmFile}
+	buildStart := time.Now()
 	err = retry.Run(func() error {
-		err = buildSRPMInChroot(chrootDir, rpmsDirAbsPath, *workerTar, *srpmFile, *repoFile, *rpmmacrosFile, defines, *noCleanup, *runCheck)
+		// A build which hit its timeout would only hit it again.
+		if result.TimedOut {
+			return nil
+		}
+
+		result.Attempts++
+		result.OutOfMemory = false
+		result.PhaseDurations = phaseDurations{}
+		result.InstalledBuildRequires = nil
+		result.BuiltRPMs = nil
+		result.BuildEnvironmentManifest = ""
+		result.Reproducibility = nil
+
+		if *verifyReproducible {
+			err = verifyReproducibleBuild(chrootDir, localRpmsDirs, outputDirAbsPath, *workerTar, baseChroot, *srpmFile, *repoFile, *rpmmacrosFile, defines, limits, *noCleanup, *runCheck, *isolateNetwork, result)
+		} else {
+			err = buildSRPMInChroot(chrootDir, localRpmsDirs, outputDirAbsPath, *workerTar, baseChroot, *srpmFile, *repoFile, *rpmmacrosFile, defines, limits, *noCleanup, *runCheck, *isolateNetwork, result)
+		}
 		if err != nil {
 			logger.Log.Warnf("Failed package build attempt (%v), error (%v)", *srpmFile, err)
 		}
 		return err
 	}, *retryAttempts, retryDuration)
+
+	if result.TimedOut {
+		err = fmt.Errorf("rpmbuild did not finish within %s", limits.timeout)
+	}
+
+	// Differing builds are not retried, rebuilding will not make the SRPM reproducible.
+	if err == nil && result.Reproducibility != nil && !result.Reproducibility.Reproducible {
+		err = fmt.Errorf("the RPMs of two builds differ")
+	}
+
+	if *resultFile != "" {
+		result.TotalDuration = time.Since(buildStart).Seconds()
+		result.Retries = result.Attempts - 1
+		result.Success = (err == nil)
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		resultErr := jsonutils.WriteJSONFile(*resultFile, result)
+		if resultErr != nil {
+			logger.Log.Warnf("Failed to write build result file (%s): %s", *resultFile, resultErr)
+		}
+	}
+
+	if result.TimedOut {
+		logger.Log.Panicf("Timed out building SRPM '%s' after %s. For details see log file: %s.", *srpmFile, limits.timeout, *logFile)
+	}
 	logger.PanicOnError(err, "Failed to build SRPM '%s'. For details see log file: %s.", *srpmFile, *logFile)
 
 	err = copySRPMToOutput(*srpmFile, srpmsDirAbsPath)
@@ -110,7 +245,11 @@ func copySRPMToOutput(srpmFilePath, srpmOutputDirPath string) (err error) {
 	return
 }
 
-func buildSRPMInChroot(chrootDir, rpmDirPath, workerTar, srpmFile, repoFile, rpmmacrosFile string, defines map[string]string, noCleanup bool, runCheck bool) (err error) {
+// buildSRPMInChroot builds an SRPM in a new chroot. The chroot is created by extracting workerTar, or on top of
+// baseChroot if it is set. Build dependencies are installed from localRpmsDirs, the built RPMs and the build environment
+// manifest are placed in outputDir. rpmbuild is run within limits. If isolateNetwork is set, nothing run inside the
+// chroot can reach the network.
+func buildSRPMInChroot(chrootDir string, localRpmsDirs []string, outputDir, workerTar, baseChroot, srpmFile, repoFile, rpmmacrosFile string, defines map[string]string, limits buildLimits, noCleanup, runCheck, isolateNetwork bool, result *buildResult) (err error) {
 	const (
 		buildHeartbeatTimeout = 30 * time.Minute
 
@@ -148,13 +287,29 @@ func buildSRPMInChroot(chrootDir, rpmDirPath, workerTar, srpmFile, repoFile, rpm
 
 	// Create the chroot used to build the SRPM
 	chroot := safechroot.NewChroot(chrootDir, existingChrootDir)
+	chroot.SetNetworkIsolation(isolateNetwork)
+
+	// Overlays cannot be used as the upper layer of another overlay, so when building on top of a base chroot
+	// the layers of the local RPMs overlay are kept outside of the chroot.
+	overlayDir := chroot.RootDir()
+	if baseChroot != "" {
+		overlayDir = chroot.LayerDir()
+	}
 
-	overlayMount, overlayExtraDirs := safechroot.NewOverlayMountPoint(chroot.RootDir(), overlaySource, chrootLocalRpmsDir, rpmDirPath, chrootLocalRpmsDir, overlayWorkDir)
+	// Overlay lower directories are listed from the top down, the first directory takes precedence.
+	lowerDirs := strings.Join(localRpmsDirs, ":")
+	overlayMount, overlayExtraDirs := safechroot.NewOverlayMountPoint(overlayDir, overlaySource, chrootLocalRpmsDir, lowerDirs, chrootLocalRpmsDir, overlayWorkDir)
 	rpmCacheMount := safechroot.NewMountPoint(*cacheDir, chrootLocalRpmsCacheDir, "", safechroot.BindMountPointFlags, "")
 	mountPoints := []*safechroot.MountPoint{overlayMount, rpmCacheMount}
-	extraDirs := append(overlayExtraDirs, chrootLocalRpmsCacheDir)
 
-	err = chroot.Initialize(workerTar, extraDirs, mountPoints)
+	phaseStart := time.Now()
+	if baseChroot != "" {
+		extraDirs := []string{chrootLocalRpmsCacheDir}
+		err = chroot.InitializeFromBase(baseChroot, extraDirs, overlayExtraDirs, mountPoints)
+	} else {
+		extraDirs := append(overlayExtraDirs, chrootLocalRpmsCacheDir)
+		err = chroot.Initialize(workerTar, extraDirs, mountPoints)
+	}
 	if err != nil {
 		return
 	}
@@ -162,24 +317,74 @@ func buildSRPMInChroot(chrootDir, rpmDirPath, workerTar, srpmFile, repoFile, rpm
 
 	// Place extra files that will be needed to build into the chroot
 	srpmFileInChroot, err := copyFilesIntoChroot(chroot, srpmFile, repoFile, rpmmacrosFile, runCheck)
+	result.PhaseDurations.ChrootInitialization = time.Since(phaseStart).Seconds()
 	if err != nil {
 		return
 	}
 
+	// The cgroup is created outside of the chroot, rpmbuild adds itself to it from inside through an already open file.
+	var buildCgroup *cgroup.Cgroup
+	if limits.usesCgroup() {
+		cgroupName := fmt.Sprintf("%s-%d", filepath.Base(chrootDir), os.Getpid())
+		buildCgroup, err = cgroup.New(limits.cgroupParent, cgroupName, limits.memoryLimit, limits.cpuQuota)
+		if err != nil {
+			err = fmt.Errorf("failed to create cgroup to limit the build's resources: %v", err)
+			return
+		}
+		defer func() {
+			closeErr := buildCgroup.Close()
+			if closeErr != nil {
+				logger.Log.Warnf("Failed to remove the build's cgroup: %s", closeErr)
+			}
+		}()
+	}
+
 	err = chroot.Run(func() (err error) {
-		return buildRPMFromSRPMInChroot(srpmFileInChroot, runCheck, defines)
+		return buildRPMFromSRPMInChroot(srpmFileInChroot, runCheck, defines, limits.timeout, buildCgroup, result)
 	})
 	if err != nil {
+		if buildCgroup != nil {
+			oomKilled, oomErr := buildCgroup.OOMKilled()
+			if oomErr != nil {
+				logger.Log.Warnf("Unable to check whether the build ran out of memory: %s", oomErr)
+			}
+			if oomKilled {
+				logger.Log.Warnf("rpmbuild of (%s) was killed for exceeding the memory limit of %d bytes", srpmBaseName, limits.memoryLimit)
+				result.OutOfMemory = true
+			}
+		}
 		return
 	}
 
+	// Record the exact packages the SRPM was built against
+	localRepos, err := readLocalRepos(repoFile)
+	if err != nil {
+		return
+	}
+
+	var buildEnvironment []*buildEnvironmentPackage
+	err = chroot.Run(func() (err error) {
+		buildEnvironment, err = queryBuildEnvironment(localRepos)
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	phaseStart = time.Now()
 	rpmBuildOutputDir := filepath.Join(chroot.RootDir(), chrootRpmBuildRoot, rpmDirName)
-	builtRPMs, err = moveBuiltRPMs(rpmBuildOutputDir, rpmDirPath)
+	builtRPMs, err = moveBuiltRPMs(rpmBuildOutputDir, outputDir)
+	result.PhaseDurations.RPMMove = time.Since(phaseStart).Seconds()
+	result.BuiltRPMs = builtRPMs
+	if err != nil {
+		return
+	}
 
+	result.BuildEnvironmentManifest, err = writeBuildEnvironmentManifest(outputDir, srpmFile, builtRPMs, buildEnvironment)
 	return
 }
 
-func buildRPMFromSRPMInChroot(srpmFile string, runCheck bool, defines map[string]string) (err error) {
+func buildRPMFromSRPMInChroot(srpmFile string, runCheck bool, defines map[string]string, timeout time.Duration, buildCgroup *cgroup.Cgroup, result *buildResult) (err error) {
 	// Convert /localrpms into a repository that a package manager can use.
 	err = rpmrepomanager.CreateRepo(chrootLocalRpmsDir)
 	if err != nil {
@@ -193,6 +398,7 @@ func buildRPMFromSRPMInChroot(srpmFile string, runCheck bool, defines map[string
 	}
 
 	// Find build requirements still not installed on the system.
+	phaseStart := time.Now()
 	missingBuildRequires, err := findMissingBuildRequires(defines, runCheck)
 	if err != nil {
 		return
@@ -200,9 +406,11 @@ func buildRPMFromSRPMInChroot(srpmFile string, runCheck bool, defines map[string
 
 	// Install the missing build requirements for this SRPM.
 	err = installBuildRequires(missingBuildRequires)
+	result.PhaseDurations.BuildRequiresInstall = time.Since(phaseStart).Seconds()
 	if err != nil {
 		return
 	}
+	result.InstalledBuildRequires = missingBuildRequires
 
 	// Remove all libarchive files on the system before issuing a build.
 	// If the build environment has libtool archive files present, gnu configure
@@ -214,10 +422,17 @@ func buildRPMFromSRPMInChroot(srpmFile string, runCheck bool, defines map[string
 	}
 
 	// Build the SRPM
+	phaseStart = time.Now()
 	if runCheck {
-		err = rpm.BuildRPMFromSRPM(srpmFile, defines)
+		err = rpm.BuildRPMFromSRPMWithTimeout(srpmFile, defines, timeout, buildCgroup)
 	} else {
-		err = rpm.BuildRPMFromSRPM(srpmFile, defines, "--nocheck")
+		err = rpm.BuildRPMFromSRPMWithTimeout(srpmFile, defines, timeout, buildCgroup, "--nocheck")
+	}
+	result.PhaseDurations.RPMBuild = time.Since(phaseStart).Seconds()
+
+	if err != nil && err.Error() == shell.TimedOutError {
+		logger.Log.Warnf("rpmbuild of (%s) did not finish within %s", filepath.Base(srpmFile), timeout)
+		result.TimedOut = true
 	}
 
 	return
@@ -0,0 +1,458 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/rpm"
+)
+
+const (
+	// missingValue and presentValue are reported for a payload file which only one of the builds produced.
+	missingValue = "(missing)"
+	presentValue = "(present)"
+
+	// buildTimeTag is the header tag holding the time an RPM was built.
+	buildTimeTag = "BUILDTIME"
+)
+
+// comparedHeaderTags are the header tags compared between the RPMs of two builds, with the query format of each.
+var comparedHeaderTags = []struct {
+	tag         string
+	queryFormat string
+}{
+	{"NAME", "%{NAME}"},
+	{"EPOCH", "%{EPOCHNUM}"},
+	{"VERSION", "%{VERSION}"},
+	{"RELEASE", "%{RELEASE}"},
+	{"ARCH", "%{ARCH}"},
+	{"LICENSE", "%{LICENSE}"},
+	{"BUILDHOST", "%{BUILDHOST}"},
+	{buildTimeTag, "%{BUILDTIME}"},
+	{"SIZE", "%{SIZE}"},
+	{"PAYLOADDIGEST", "%{PAYLOADDIGEST}"},
+	{"PROVIDES", "[%{PROVIDENAME} %{PROVIDEFLAGS:depflags} %{PROVIDEVERSION},]"},
+	{"REQUIRES", "[%{REQUIRENAME} %{REQUIREFLAGS:depflags} %{REQUIREVERSION},]"},
+	{"CONFLICTS", "[%{CONFLICTNAME} %{CONFLICTFLAGS:depflags} %{CONFLICTVERSION},]"},
+	{"OBSOLETES", "[%{OBSOLETENAME} %{OBSOLETEFLAGS:depflags} %{OBSOLETEVERSION},]"},
+}
+
+// payloadFile holds the attributes of a file in the payload of an RPM.
+type payloadFile struct {
+	mode   string
+	owner  string
+	mtime  string
+	digest string
+}
+
+// headerDifference is a header tag which differs between the RPMs of two builds.
+type headerDifference struct {
+	Tag    string `json:"Tag"`
+	First  string `json:"First"`
+	Second string `json:"Second"`
+}
+
+// payloadDifference is an attribute of a payload file which differs between the RPMs of two builds.
+type payloadDifference struct {
+	File      string `json:"File"`
+	Attribute string `json:"Attribute"`
+	First     string `json:"First"`
+	Second    string `json:"Second"`
+}
+
+// timestampDifference is a timestamp which differs between the RPMs of two builds. Name is either the BUILDTIME
+// header tag or the path of a payload file whose modification time differs.
+type timestampDifference struct {
+	Name   string `json:"Name"`
+	First  string `json:"First"`
+	Second string `json:"Second"`
+}
+
+// rpmDifferences lists everything which differs between the two builds of a single RPM.
+type rpmDifferences struct {
+	RPM          string                `json:"RPM"`
+	HeaderTags   []headerDifference    `json:"HeaderTags,omitempty"`
+	PayloadFiles []payloadDifference   `json:"PayloadFiles,omitempty"`
+	Timestamps   []timestampDifference `json:"Timestamps,omitempty"`
+}
+
+// reproducibilityReport is the outcome of building an SRPM twice and comparing the RPMs of both builds.
+type reproducibilityReport struct {
+	SourceDateEpoch   string            `json:"SourceDateEpoch"`
+	Reproducible      bool              `json:"Reproducible"`
+	OnlyInFirstBuild  []string          `json:"OnlyInFirstBuild,omitempty"`
+	OnlyInSecondBuild []string          `json:"OnlyInSecondBuild,omitempty"`
+	Differences       []*rpmDifferences `json:"Differences,omitempty"`
+}
+
+// hasDifferences returns true if anything differs between the two builds of the RPM.
+func (d *rpmDifferences) hasDifferences() bool {
+	return len(d.HeaderTags) != 0 || len(d.PayloadFiles) != 0 || len(d.Timestamps) != 0
+}
+
+// verifyReproducibleBuild builds an SRPM twice in independent chroots and compares the RPMs of both builds.
+// SOURCE_DATE_EPOCH is derived from the SRPM's changelog for both builds. The comparison is stored in
+// result.Reproducibility, the RPMs of the first build are only placed in outputDir if both builds match.
+func verifyReproducibleBuild(chrootDir string, localRpmsDirs []string, outputDir, workerTar, baseChroot, srpmFile, repoFile, rpmmacrosFile string, defines map[string]string, limits buildLimits, noCleanup, runCheck, isolateNetwork bool, result *buildResult) (err error) {
+	const (
+		firstBuildDirName  = "first"
+		secondBuildDirName = "second"
+		secondChrootSuffix = "-rebuild"
+		scratchDirSuffix   = "-reproducibility"
+	)
+
+	report := &reproducibilityReport{}
+	report.SourceDateEpoch, err = changelogTime(srpmFile)
+	if err != nil {
+		return
+	}
+
+	if report.SourceDateEpoch == "" {
+		logger.Log.Warnf("SRPM (%s) has no changelog, SOURCE_DATE_EPOCH will not be set", srpmFile)
+	}
+
+	reproducibleDefines := make(map[string]string)
+	for name, value := range defines {
+		reproducibleDefines[name] = value
+	}
+	reproducibleDefines[rpm.SourceDateEpochFromChangelogDefine] = "1"
+	reproducibleDefines[rpm.UseSourceDateEpochAsBuildtimeDefine] = "1"
+	reproducibleDefines[rpm.ClampMtimeToSourceDateEpochDefine] = "1"
+
+	// Keep the RPMs of both builds apart from the local repository, so the second build does not see the first's.
+	scratchDir := chrootDir + scratchDirSuffix
+	err = os.RemoveAll(scratchDir)
+	if err != nil {
+		return
+	}
+	if !noCleanup {
+		defer os.RemoveAll(scratchDir)
+	}
+
+	firstBuildDir := filepath.Join(scratchDir, firstBuildDirName)
+	secondBuildDir := filepath.Join(scratchDir, secondBuildDirName)
+	for _, dir := range []string{firstBuildDir, secondBuildDir} {
+		err = os.MkdirAll(dir, os.ModePerm)
+		if err != nil {
+			return
+		}
+	}
+
+	logger.Log.Infof("Building (%s) for the first time", filepath.Base(srpmFile))
+	err = buildSRPMInChroot(chrootDir, localRpmsDirs, firstBuildDir, workerTar, baseChroot, srpmFile, repoFile, rpmmacrosFile, reproducibleDefines, limits, noCleanup, runCheck, isolateNetwork, result)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Building (%s) for the second time", filepath.Base(srpmFile))
+	secondResult := &buildResult{SRPM: srpmFile}
+	err = buildSRPMInChroot(chrootDir+secondChrootSuffix, localRpmsDirs, secondBuildDir, workerTar, baseChroot, srpmFile, repoFile, rpmmacrosFile, reproducibleDefines, limits, noCleanup, runCheck, isolateNetwork, secondResult)
+	result.TimedOut = secondResult.TimedOut
+	result.OutOfMemory = secondResult.OutOfMemory
+	if err != nil {
+		return
+	}
+
+	err = compareBuilds(firstBuildDir, secondBuildDir, report)
+	if err != nil {
+		return
+	}
+	result.Reproducibility = report
+
+	if !report.Reproducible {
+		logReproducibilityReport(srpmFile, report)
+		return
+	}
+
+	logger.Log.Infof("The RPMs of both builds of (%s) are identical", filepath.Base(srpmFile))
+	_, err = moveBuiltRPMs(firstBuildDir, outputDir)
+	if err != nil {
+		return
+	}
+
+	manifestFile := filepath.Join(outputDir, filepath.Base(result.BuildEnvironmentManifest))
+	err = file.Move(result.BuildEnvironmentManifest, manifestFile)
+	if err != nil {
+		return
+	}
+	result.BuildEnvironmentManifest = manifestFile
+
+	return
+}
+
+// changelogTime returns the time of the latest changelog entry of an SRPM, in seconds since the epoch.
+// Returns an empty string if the SRPM has no changelog.
+func changelogTime(srpmFile string) (changelogTime string, err error) {
+	const (
+		noValue     = "(none)"
+		queryFormat = "%{CHANGELOGTIME}"
+	)
+
+	results, err := rpm.QueryPackage(srpmFile, queryFormat, nil, rpm.QueryPackageFileArgument)
+	if err != nil {
+		return
+	}
+
+	if len(results) == 1 && results[0] != noValue {
+		changelogTime = results[0]
+	}
+	return
+}
+
+// compareBuilds compares the RPMs found in two build output directories and fills in report.
+func compareBuilds(firstBuildDir, secondBuildDir string, report *reproducibilityReport) (err error) {
+	firstRPMs, err := findRPMs(firstBuildDir)
+	if err != nil {
+		return
+	}
+
+	secondRPMs, err := findRPMs(secondBuildDir)
+	if err != nil {
+		return
+	}
+
+	for relPath := range firstRPMs {
+		if !secondRPMs[relPath] {
+			report.OnlyInFirstBuild = append(report.OnlyInFirstBuild, relPath)
+			continue
+		}
+
+		var differences *rpmDifferences
+		differences, err = compareRPMs(filepath.Join(firstBuildDir, relPath), filepath.Join(secondBuildDir, relPath))
+		if err != nil {
+			return
+		}
+
+		if differences.hasDifferences() {
+			differences.RPM = relPath
+			report.Differences = append(report.Differences, differences)
+		}
+	}
+
+	for relPath := range secondRPMs {
+		if !firstRPMs[relPath] {
+			report.OnlyInSecondBuild = append(report.OnlyInSecondBuild, relPath)
+		}
+	}
+
+	sort.Strings(report.OnlyInFirstBuild)
+	sort.Strings(report.OnlyInSecondBuild)
+	sort.Slice(report.Differences, func(i, j int) bool {
+		return report.Differences[i].RPM < report.Differences[j].RPM
+	})
+
+	report.Reproducible = len(report.OnlyInFirstBuild) == 0 && len(report.OnlyInSecondBuild) == 0 && len(report.Differences) == 0
+	return
+}
+
+// findRPMs returns the path of every RPM in dir, relative to dir.
+func findRPMs(dir string) (rpms map[string]bool, err error) {
+	const rpmExtension = ".rpm"
+
+	rpms = make(map[string]bool)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() || !strings.HasSuffix(info.Name(), rpmExtension) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		rpms[relPath] = true
+		return nil
+	})
+
+	return
+}
+
+// compareRPMs compares the header tags and payload files of two RPMs.
+func compareRPMs(firstRPM, secondRPM string) (differences *rpmDifferences, err error) {
+	differences = &rpmDifferences{}
+
+	firstTags, err := queryHeaderTags(firstRPM)
+	if err != nil {
+		return
+	}
+
+	secondTags, err := queryHeaderTags(secondRPM)
+	if err != nil {
+		return
+	}
+
+	for _, header := range comparedHeaderTags {
+		first, second := firstTags[header.tag], secondTags[header.tag]
+		if first == second {
+			continue
+		}
+
+		if header.tag == buildTimeTag {
+			differences.Timestamps = append(differences.Timestamps, timestampDifference{Name: header.tag, First: first, Second: second})
+		} else {
+			differences.HeaderTags = append(differences.HeaderTags, headerDifference{Tag: header.tag, First: first, Second: second})
+		}
+	}
+
+	firstFiles, err := queryPayloadFiles(firstRPM)
+	if err != nil {
+		return
+	}
+
+	secondFiles, err := queryPayloadFiles(secondRPM)
+	if err != nil {
+		return
+	}
+
+	allPaths := make(map[string]bool)
+	for path := range firstFiles {
+		allPaths[path] = true
+	}
+	for path := range secondFiles {
+		allPaths[path] = true
+	}
+
+	sortedPaths := make([]string, 0, len(allPaths))
+	for path := range allPaths {
+		sortedPaths = append(sortedPaths, path)
+	}
+	sort.Strings(sortedPaths)
+
+	for _, path := range sortedPaths {
+		first, inFirst := firstFiles[path]
+		second, inSecond := secondFiles[path]
+
+		if !inFirst || !inSecond {
+			difference := payloadDifference{File: path, Attribute: "File", First: missingValue, Second: missingValue}
+			if inFirst {
+				difference.First = presentValue
+			} else {
+				difference.Second = presentValue
+			}
+			differences.PayloadFiles = append(differences.PayloadFiles, difference)
+			continue
+		}
+
+		if first.digest != second.digest {
+			differences.PayloadFiles = append(differences.PayloadFiles, payloadDifference{File: path, Attribute: "Digest", First: first.digest, Second: second.digest})
+		}
+		if first.mode != second.mode {
+			differences.PayloadFiles = append(differences.PayloadFiles, payloadDifference{File: path, Attribute: "Mode", First: first.mode, Second: second.mode})
+		}
+		if first.owner != second.owner {
+			differences.PayloadFiles = append(differences.PayloadFiles, payloadDifference{File: path, Attribute: "Owner", First: first.owner, Second: second.owner})
+		}
+		if first.mtime != second.mtime {
+			differences.Timestamps = append(differences.Timestamps, timestampDifference{Name: path, First: first.mtime, Second: second.mtime})
+		}
+	}
+
+	return
+}
+
+// queryHeaderTags returns the value of every tag in comparedHeaderTags for an RPM.
+func queryHeaderTags(rpmFile string) (tags map[string]string, err error) {
+	const tagSeparator = "="
+
+	var queryFormat strings.Builder
+	for _, header := range comparedHeaderTags {
+		queryFormat.WriteString(fmt.Sprintf("%s%s%s\n", header.tag, tagSeparator, header.queryFormat))
+	}
+
+	results, err := rpm.QueryPackage(rpmFile, queryFormat.String(), nil, rpm.QueryPackageFileArgument)
+	if err != nil {
+		return
+	}
+
+	tags = make(map[string]string)
+	for _, result := range results {
+		tagValue := strings.SplitN(result, tagSeparator, 2)
+		if len(tagValue) != 2 {
+			err = fmt.Errorf("unexpected header query result (%s) for RPM (%s)", result, rpmFile)
+			return
+		}
+		tags[tagValue[0]] = tagValue[1]
+	}
+
+	return
+}
+
+// queryPayloadFiles returns the attributes of every file in the payload of an RPM, keyed by path.
+func queryPayloadFiles(rpmFile string) (files map[string]payloadFile, err error) {
+	// The mode is queried first since it is never empty, the digest of directories and links is.
+	const (
+		fieldSeparator = "\t"
+		queryFormat    = "[%{FILEMODES:octal}\t%{FILEUSERNAME}:%{FILEGROUPNAME}\t%{FILEMTIMES}\t%{FILEDIGESTS}\t%{FILENAMES}\n]"
+	)
+
+	const (
+		modeIndex         = iota
+		ownerIndex        = iota
+		mtimeIndex        = iota
+		digestIndex       = iota
+		pathIndex         = iota
+		expectedFieldsLen = iota
+	)
+
+	results, err := rpm.QueryPackage(rpmFile, queryFormat, nil, rpm.QueryPackageFileArgument)
+	if err != nil {
+		return
+	}
+
+	files = make(map[string]payloadFile)
+	for _, result := range results {
+		fields := strings.SplitN(result, fieldSeparator, expectedFieldsLen)
+		if len(fields) != expectedFieldsLen {
+			err = fmt.Errorf("unexpected payload query result (%s) for RPM (%s)", result, rpmFile)
+			return
+		}
+
+		files[fields[pathIndex]] = payloadFile{
+			mode:   fields[modeIndex],
+			owner:  fields[ownerIndex],
+			mtime:  fields[mtimeIndex],
+			digest: fields[digestIndex],
+		}
+	}
+
+	return
+}
+
+// logReproducibilityReport will log everything which differs between the two builds of an SRPM.
+func logReproducibilityReport(srpmFile string, report *reproducibilityReport) {
+	srpmName := filepath.Base(srpmFile)
+
+	for _, rpmFile := range report.OnlyInFirstBuild {
+		logger.Log.Warnf("(%s): only the first build produced (%s)", srpmName, rpmFile)
+	}
+
+	for _, rpmFile := range report.OnlyInSecondBuild {
+		logger.Log.Warnf("(%s): only the second build produced (%s)", srpmName, rpmFile)
+	}
+
+	for _, differences := range report.Differences {
+		for _, header := range differences.HeaderTags {
+			logger.Log.Warnf("(%s): header tag (%s) differs: (%s) != (%s)", differences.RPM, header.Tag, header.First, header.Second)
+		}
+
+		for _, payload := range differences.PayloadFiles {
+			logger.Log.Warnf("(%s): %s of payload file (%s) differs: (%s) != (%s)", differences.RPM, strings.ToLower(payload.Attribute), payload.File, payload.First, payload.Second)
+		}
+
+		for _, timestamp := range differences.Timestamps {
+			logger.Log.Warnf("(%s): timestamp of (%s) differs: (%s) != (%s)", differences.RPM, timestamp.Name, timestamp.First, timestamp.Second)
+		}
+	}
+}
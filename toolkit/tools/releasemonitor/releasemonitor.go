@@ -0,0 +1,314 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+	"microsoft.com/pkggen/internal/exe"
+	"microsoft.com/pkggen/internal/jsonutils"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/network"
+	"microsoft.com/pkggen/internal/retry"
+	"microsoft.com/pkggen/internal/rpm"
+	"microsoft.com/pkggen/internal/versioncompare"
+)
+
+const defaultWorkerCount = "10"
+
+// releaseFeed lists the released versions of upstream projects, keyed by the name of the SPEC packaging them.
+type releaseFeed struct {
+	Releases map[string][]string `json:"Releases"`
+}
+
+// latestRelease is the newest version of a package found in any feed.
+type latestRelease struct {
+	version *versioncompare.TolerantVersion
+	feed    string
+}
+
+// specInfo holds the version information read from a single SPEC file.
+type specInfo struct {
+	Spec    string `json:"Spec"`
+	Name    string `json:"Name"`
+	Version string `json:"Version"`
+	Source0 string `json:"Source0"`
+	Error   string `json:"Error,omitempty"`
+}
+
+// outdatedPackage is a SPEC for which a newer upstream release is available.
+type outdatedPackage struct {
+	specInfo
+	LatestVersion string `json:"LatestVersion"`
+	LatestSource0 string `json:"LatestSource0,omitempty"`
+	Feed          string `json:"Feed"`
+}
+
+// releaseReport is the report written to --output.
+type releaseReport struct {
+	Outdated    []*outdatedPackage `json:"Outdated"`
+	UpToDate    []string           `json:"UpToDate"`
+	Unmonitored []string           `json:"Unmonitored"`
+	Failed      []*specInfo        `json:"Failed"`
+}
+
+var (
+	app = kingpin.New("releasemonitor", "A tool to find SPECs for which a newer upstream release is available.")
+
+	specsDir = exe.InputDirFlag(app, "Path to the SPEC directory to check.")
+	output   = exe.OutputFlag(app, "File to write the JSON report of outdated packages to.")
+	feeds    = app.Flag("feed", "Path or http(s) URL of a JSON release feed. May be passed multiple times.").Required().Strings()
+
+	distTag  = app.Flag("dist-tag", "The distribution tag SPECs are queried with.").Required().String()
+	macroDir = app.Flag("macro-dir", "Directory containing rpm macros.").Default("").String()
+	workers  = app.Flag("workers", "Number of concurrent goroutines to query SPECs with.").Default(defaultWorkerCount).Int()
+
+	// Use String() and not ExistingFile() as the Makefile may pass an empty string if the user did not specify any of these options
+	caCertFile    = app.Flag("ca-cert", "Root certificate authority to use when downloading feeds.").String()
+	tlsClientCert = app.Flag("tls-cert", "TLS client certificate to use when downloading feeds.").String()
+	tlsClientKey  = app.Flag("tls-key", "TLS client key to use when downloading feeds.").String()
+
+	logFile  = exe.LogFileFlag(app)
+	logLevel = exe.LogLevelFlag(app)
+)
+
+func main() {
+	app.Version(exe.ToolkitVersion)
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+	logger.InitBestEffort(*logFile, *logLevel)
+
+	if *workers <= 0 {
+		logger.Log.Fatalf("Value in --workers must be greater than zero. Found %d", *workers)
+	}
+
+	_, err := rpm.SetMacroDir(*macroDir)
+	logger.PanicOnError(err, "Unable to set rpm macro directory (%s)", *macroDir)
+
+	caCerts, tlsCerts := loadCertificates()
+
+	latestReleases, err := readFeeds(*feeds, caCerts, tlsCerts)
+	logger.PanicOnError(err, "Failed to read the release feeds")
+
+	specs, err := querySPECs(*specsDir, *distTag, *workers)
+	logger.PanicOnError(err, "Failed to query the SPECs in (%s)", *specsDir)
+
+	report := buildReport(specs, latestReleases)
+	logger.Log.Infof("Found %d outdated, %d up to date and %d unmonitored SPECs, %d SPECs failed to be queried",
+		len(report.Outdated), len(report.UpToDate), len(report.Unmonitored), len(report.Failed))
+
+	err = jsonutils.WriteJSONFile(*output, report)
+	logger.PanicOnError(err, "Failed to write report to (%s)", *output)
+}
+
+// loadCertificates returns the certificates to download feeds with.
+func loadCertificates() (caCerts *x509.CertPool, tlsCerts []tls.Certificate) {
+	caCerts, err := x509.SystemCertPool()
+	logger.PanicOnError(err, "Received error calling x509.SystemCertPool()")
+
+	if *caCertFile != "" {
+		newCACert, err := ioutil.ReadFile(*caCertFile)
+		logger.PanicOnError(err, "Invalid CA certificate (%s)", *caCertFile)
+		caCerts.AppendCertsFromPEM(newCACert)
+	}
+
+	if *tlsClientCert != "" && *tlsClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsClientCert, *tlsClientKey)
+		logger.PanicOnError(err, "Invalid TLS client key pair (%s) (%s)", *tlsClientCert, *tlsClientKey)
+		tlsCerts = append(tlsCerts, cert)
+	}
+
+	return
+}
+
+// readFeeds will read every release feed and return the latest release of each package across all of them.
+// Feeds given as a URL are downloaded first.
+func readFeeds(feedPaths []string, caCerts *x509.CertPool, tlsCerts []tls.Certificate) (latestReleases map[string]*latestRelease, err error) {
+	const (
+		downloadRetryAttempts = 3
+		downloadRetryDuration = time.Second
+	)
+
+	downloadDir, err := ioutil.TempDir("", "releasemonitor")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(downloadDir)
+
+	latestReleases = make(map[string]*latestRelease)
+	for _, feedPath := range feedPaths {
+		var feed releaseFeed
+
+		localPath := feedPath
+		if strings.Contains(feedPath, "://") {
+			localPath = filepath.Join(downloadDir, filepath.Base(feedPath))
+			err = retry.Run(func() error {
+				downloadErr := network.DownloadFile(feedPath, localPath, caCerts, tlsCerts)
+				if downloadErr != nil {
+					logger.Log.Warnf("Failed to download (%s). Error: %s", feedPath, downloadErr)
+				}
+				return downloadErr
+			}, downloadRetryAttempts, downloadRetryDuration)
+			if err != nil {
+				return
+			}
+		}
+
+		err = jsonutils.ReadJSONFile(localPath, &feed)
+		if err != nil {
+			return
+		}
+
+		logger.Log.Infof("Read %d packages from feed (%s)", len(feed.Releases), feedPath)
+		for name, versions := range feed.Releases {
+			for _, version := range versions {
+				release := &latestRelease{
+					version: versioncompare.NewRPM(version),
+					feed:    feedPath,
+				}
+
+				current, found := latestReleases[name]
+				if !found || release.version.Compare(current.version) > 0 {
+					latestReleases[name] = release
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// querySPECs will read the name, version and Source0 of every SPEC file in specsDir.
+func querySPECs(specsDir, distTag string, workers int) (specs []*specInfo, err error) {
+	specSearch, err := filepath.Abs(filepath.Join(specsDir, "**/*.spec"))
+	if err != nil {
+		return
+	}
+
+	specFiles, err := filepath.Glob(specSearch)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Querying %d SPECs", len(specFiles))
+
+	allSpecFiles := make(chan string, len(specFiles))
+	results := make(chan *specInfo, len(specFiles))
+
+	// Start the workers now so they begin working as soon as a new job is buffered.
+	for i := 0; i < workers; i++ {
+		go querySPECWorker(allSpecFiles, results, distTag)
+	}
+
+	for _, specFile := range specFiles {
+		allSpecFiles <- specFile
+	}
+
+	// Signal to the workers that there are no more new spec files
+	close(allSpecFiles)
+
+	for i := 0; i < len(specFiles); i++ {
+		specs = append(specs, <-results)
+	}
+
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Spec < specs[j].Spec
+	})
+
+	return
+}
+
+// querySPECWorker will process a channel of spec files and read their version information.
+func querySPECWorker(allSpecFiles chan string, results chan *specInfo, distTag string) {
+	const queryFormat = "%{NAME}\n%{VERSION}\n"
+
+	const (
+		nameIndex               = iota
+		versionIndex            = iota
+		expectedQueryResultsLen = iota
+	)
+
+	for specFile := range allSpecFiles {
+		info := &specInfo{
+			Spec: specFile,
+		}
+
+		defines := rpm.DefaultDefines()
+		defines[rpm.DistTagDefine] = distTag
+		sourceDir := filepath.Dir(specFile)
+
+		queryResults, err := rpm.QuerySPEC(specFile, sourceDir, queryFormat, defines, rpm.QueryHeaderArgument)
+		if err == nil && len(queryResults) != expectedQueryResultsLen {
+			err = fmt.Errorf("unexpected query results, wanted (%d) results but got (%d), results: %v", expectedQueryResultsLen, len(queryResults), queryResults)
+		}
+		if err != nil {
+			info.Error = err.Error()
+			results <- info
+			continue
+		}
+
+		info.Name = queryResults[nameIndex]
+		info.Version = queryResults[versionIndex]
+
+		sources, err := rpm.QuerySPECSources(specFile, sourceDir, defines)
+		if err != nil {
+			logger.Log.Warnf("Unable to read Source0 of SPEC (%s). Error: %s", specFile, err)
+		}
+		info.Source0 = sources[0]
+
+		results <- info
+	}
+}
+
+// buildReport will compare the version of every SPEC with the latest release found in the feeds.
+func buildReport(specs []*specInfo, latestReleases map[string]*latestRelease) (report *releaseReport) {
+	report = &releaseReport{
+		Outdated:    []*outdatedPackage{},
+		UpToDate:    []string{},
+		Unmonitored: []string{},
+		Failed:      []*specInfo{},
+	}
+
+	for _, spec := range specs {
+		if spec.Error != "" {
+			report.Failed = append(report.Failed, spec)
+			continue
+		}
+
+		latest, found := latestReleases[spec.Name]
+		if !found {
+			report.Unmonitored = append(report.Unmonitored, spec.Name)
+			continue
+		}
+
+		if latest.version.Compare(versioncompare.NewRPM(spec.Version)) <= 0 {
+			report.UpToDate = append(report.UpToDate, spec.Name)
+			continue
+		}
+
+		outdated := &outdatedPackage{
+			specInfo:      *spec,
+			LatestVersion: latest.version.String(),
+			Feed:          latest.feed,
+		}
+
+		// Most Source0 URLs include the version, suggest the URL of the new release.
+		if strings.Contains(spec.Source0, spec.Version) {
+			outdated.LatestSource0 = strings.ReplaceAll(spec.Source0, spec.Version, outdated.LatestVersion)
+		}
+
+		logger.Log.Infof("%s (%s) is outdated, the latest release is (%s)", spec.Name, spec.Version, outdated.LatestVersion)
+		report.Outdated = append(report.Outdated, outdated)
+	}
+
+	return
+}
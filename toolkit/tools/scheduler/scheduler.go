@@ -0,0 +1,408 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// A tool to build all packages marked for build in a dependency graph
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/graph"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"microsoft.com/pkggen/internal/exe"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	defaultWorkerCount   = "10"
+	defaultRetryAttempts = "1"
+)
+
+// buildRequest holds the information needed for a worker to build a single SRPM, or a conditional build variant of it.
+type buildRequest struct {
+	buildKey         string
+	srpmPath         string
+	variant          string
+	variantBuildName string
+	defines          map[string]string
+	requiredVariants []string // Names of the variant builds whose RPMs the build depends on
+}
+
+// buildResult holds the outcome of a worker building a single SRPM.
+type buildResult struct {
+	buildKey string
+	err      error
+}
+
+var (
+	app = kingpin.New("scheduler", "A tool to build all packages marked for build in a dependency graph.")
+
+	inputGraphFile  = exe.InputFlag(app, "Path to the DOT graph file to build.")
+	outputGraphFile = exe.OutputFlag(app, "Path to save the updated DOT graph file.")
+
+	workers            = app.Flag("workers", "Number of concurrent pkgworker instances to run.").Default(defaultWorkerCount).Int()
+	stopOnFailure      = app.Flag("stop-on-failure", "Stop scheduling new builds after the first package build failure.").Bool()
+	failuresFile       = app.Flag("failures-file", "Optional file to record the name of every SRPM which failed to build.").String()
+	pkgWorkerPath      = app.Flag("pkgworker", "Full path to the pkgworker tool.").Required().ExistingFile()
+	buildLogsDir       = app.Flag("build-logs-dir", "Directory to store the log of each package build.").Required().String()
+	buildResultsDir    = app.Flag("build-results-dir", "Optional directory to store the JSON result file of each package build in.").String()
+	retryAttempts      = app.Flag("retry-attempts", "Sets the number of times pkgworker will retry building the package").Default(defaultRetryAttempts).Int()
+	runCheck           = app.Flag("run-check", "Run the check during package builds").Bool()
+	noCleanup          = app.Flag("no-cleanup", "Whether or not to delete the chroot folder after each build is done").Bool()
+	isolateNetwork     = app.Flag("isolate-network", "Have pkgworker build every SRPM without network access").Bool()
+	verifyReproducible = app.Flag("verify-reproducible", "Have pkgworker build every SRPM twice and fail the builds whose RPMs differ").Bool()
+	buildTimeout       = app.Flag("timeout", "Optional wall-clock limit for each rpmbuild run by pkgworker, such as 4h").Duration()
+	buildMemoryLimit   = app.Flag("memory-limit", "Optional memory limit for each rpmbuild run by pkgworker, such as 16GB").String()
+	buildCPUQuota      = app.Flag("cpu-quota", "Optional number of CPUs each rpmbuild run by pkgworker may use, such as 2.5").Float64()
+	buildCgroupParent  = app.Flag("cgroup-parent", "Optional empty, delegated cgroup v2 for pkgworker to enforce memory and CPU limits in. Required to limit builds while other processes share the cgroup of the scheduler").String()
+	buildLimitsFile    = app.Flag("limits-file", "Optional JSON file for pkgworker overriding the build limits of individual SPECs").ExistingFile()
+	workDir            = app.Flag("work-dir", "The directory to create the build folders").Required().String()
+	workerTar          = app.Flag("worker-tar", "Full path to worker_chroot.tar.gz").Required().ExistingFile()
+	chrootPoolDir      = app.Flag("chroot-pool-dir", "Optional directory for pkgworker to keep pre-extracted worker chroots in").String()
+	variantRpmsDir     = app.Flag("variant-rpms-dir", "Directory to build conditional build variants into, one subdirectory per variant build. Variant RPMs are only made available to the builds which depend on them. Required if the graph has variants to build").String()
+	repoFile           = app.Flag("repo-file", "Full path to local.repo").Required().ExistingFile()
+	rpmsDir            = app.Flag("rpms-dir", "The directory to use as the local repo and to submit RPM packages to").Required().ExistingDir()
+	srpmsDir           = app.Flag("srpms-dir", "The output directory for source RPM packages").Required().String()
+	cacheDir           = app.Flag("cache-dir", "The cache directory containing downloaded dependency RPMS from CBL-Mariner Base").Required().ExistingDir()
+	rpmmacrosFile      = app.Flag("rpmmacros-file", "Optional file path to an rpmmacros file for rpmbuild to use").ExistingFile()
+	distTag            = app.Flag("dist-tag", "The distribution tag the SPEC will be built with.").Required().String()
+	releaseVersion     = app.Flag("distro-release-version", "The distro release version that the SRPM will be built with").Required().String()
+	buildNumber        = app.Flag("distro-build-number", "The distro build number that the SRPM will be built with").Required().String()
+
+	logFile  = exe.LogFileFlag(app)
+	logLevel = exe.LogLevelFlag(app)
+)
+
+func main() {
+	app.Version(exe.ToolkitVersion)
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+	logger.InitBestEffort(*logFile, *logLevel)
+
+	if *workers <= 0 {
+		logger.Log.Panicf("Value in --workers must be greater than zero. Found %d", *workers)
+	}
+
+	err := os.MkdirAll(*buildLogsDir, os.ModePerm)
+	logger.PanicOnError(err, "Unable to create build logs directory '%s'", *buildLogsDir)
+
+	if *buildResultsDir != "" {
+		err = os.MkdirAll(*buildResultsDir, os.ModePerm)
+		logger.PanicOnError(err, "Unable to create build results directory '%s'", *buildResultsDir)
+	}
+
+	pkgGraph := pkggraph.NewPkgGraph()
+	err = pkggraph.ReadDOTGraphFile(pkgGraph, *inputGraphFile)
+	logger.PanicOnError(err, "Failed to read graph file '%s'.", *inputGraphFile)
+
+	if *variantRpmsDir == "" {
+		for _, node := range pkgGraph.AllBuildNodes() {
+			if node.State != pkggraph.StateBuild {
+				continue
+			}
+			if node.Variant != "" || len(pkgGraph.VariantsRequiredBy([]*pkggraph.PkgNode{node})) != 0 {
+				logger.Log.Panicf("--variant-rpms-dir is required to build (%s), which is or depends on a conditional build variant", node.BuildKey())
+			}
+		}
+	}
+
+	failedSRPMs, blockedSRPMs := buildAllSRPMs(pkgGraph, *workers, *stopOnFailure, buildSRPM)
+
+	err = pkggraph.WriteDOTGraphFile(pkgGraph, *outputGraphFile)
+	logger.PanicOnError(err, "Failed to write graph file '%s'.", *outputGraphFile)
+
+	if *failuresFile != "" && len(failedSRPMs) > 0 {
+		err = writeFailures(*failuresFile, failedSRPMs)
+		logger.PanicOnError(err, "Failed to write failures file '%s'.", *failuresFile)
+	}
+
+	for _, srpm := range blockedSRPMs {
+		logger.Log.Warnf("Unable to build (%s), a dependency failed to build", filepath.Base(srpm))
+	}
+
+	if len(failedSRPMs) > 0 {
+		logger.Log.Panicf("Failed to build %d SRPMs: %v", len(failedSRPMs), failedSRPMs)
+	}
+
+	logger.Log.Info("Finished building all packages.")
+}
+
+// buildAllSRPMs builds every SRPM with nodes in the build state, dispatching an SRPM to a worker
+// once all of its dependencies are satisfied. Nodes are marked as up-to-date as their SRPM finishes building.
+// Each conditional build variant of an SRPM is built separately, see PkgNode.BuildKey.
+// buildFunc is called by the workers to build each request.
+// Returns the SRPMs which failed to build and the SRPMs which were never built as a result.
+func buildAllSRPMs(pkgGraph *pkggraph.PkgGraph, workerCount int, stopOnFailure bool, buildFunc func(*buildRequest) error) (failedSRPMs, blockedSRPMs []string) {
+	srpmToNodes := make(map[string][]*pkggraph.PkgNode)
+	for _, node := range pkgGraph.AllBuildNodes() {
+		if node.State == pkggraph.StateBuild {
+			srpmToNodes[node.BuildKey()] = append(srpmToNodes[node.BuildKey()], node)
+		}
+	}
+
+	// Buffer every possible request and result so neither the scheduler nor the workers ever block on each other.
+	requests := make(chan *buildRequest, len(srpmToNodes))
+	results := make(chan *buildResult, len(srpmToNodes))
+	defer close(requests)
+
+	for i := 0; i < workerCount; i++ {
+		go buildWorker(requests, results, buildFunc)
+	}
+
+	logger.Log.Infof("Building %d SRPMs with %d workers", len(srpmToNodes), workerCount)
+
+	pendingSRPMs := make(map[string]bool)
+	for srpm := range srpmToNodes {
+		pendingSRPMs[srpm] = true
+	}
+
+	stopScheduling := false
+	activeBuilds := 0
+	for {
+		if !stopScheduling {
+			for _, srpm := range readySRPMs(pkgGraph, srpmToNodes, pendingSRPMs) {
+				logger.Log.Debugf("Scheduling (%s)", srpm)
+				delete(pendingSRPMs, srpm)
+
+				requests <- newBuildRequest(pkgGraph, srpm, srpmToNodes[srpm])
+				activeBuilds++
+			}
+		}
+
+		if activeBuilds == 0 {
+			break
+		}
+
+		result := <-results
+		activeBuilds--
+
+		if result.err != nil {
+			logger.Log.Errorf("Failed to build (%s). Error: %s", filepath.Base(result.buildKey), result.err)
+			failedSRPMs = append(failedSRPMs, result.buildKey)
+			if stopOnFailure {
+				logger.Log.Warn("--stop-on-failure set, waiting for active builds to finish")
+				stopScheduling = true
+			}
+			continue
+		}
+
+		logger.Log.Infof("Built (%s), %d remaining", filepath.Base(result.buildKey), len(pendingSRPMs)+activeBuilds)
+		for _, node := range srpmToNodes[result.buildKey] {
+			node.State = pkggraph.StateUpToDate
+		}
+	}
+
+	for srpm := range pendingSRPMs {
+		blockedSRPMs = append(blockedSRPMs, srpm)
+	}
+	sort.Strings(blockedSRPMs)
+	sort.Strings(failedSRPMs)
+
+	return
+}
+
+// readySRPMs returns the pending SRPMs whose dependencies are all satisfied, in a deterministic order.
+func readySRPMs(pkgGraph *pkggraph.PkgGraph, srpmToNodes map[string][]*pkggraph.PkgNode, pendingSRPMs map[string]bool) (ready []string) {
+	for srpm := range pendingSRPMs {
+		if srpmIsReady(pkgGraph, srpm, srpmToNodes[srpm]) {
+			ready = append(ready, srpm)
+		}
+	}
+	sort.Strings(ready)
+	return
+}
+
+// srpmIsReady returns true if every dependency of every build node from an SRPM is satisfied.
+func srpmIsReady(pkgGraph *pkggraph.PkgGraph, srpm string, buildNodes []*pkggraph.PkgNode) bool {
+	satisfiedCache := make(map[int64]bool)
+	for _, node := range buildNodes {
+		for _, dependency := range graph.NodesOf(pkgGraph.From(node.ID())) {
+			if !dependencySatisfied(pkgGraph, dependency.(*pkggraph.PkgNode).This, srpm, satisfiedCache) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// dependencySatisfied returns true if a node is available for a build of srpm.
+// Build nodes are satisfied once built, meta nodes once all their dependencies are satisfied.
+// Build nodes belonging to srpm itself are considered satisfied since they are built together.
+// Mirrors the makefile workplan, which only waits on build and meta nodes.
+func dependencySatisfied(pkgGraph *pkggraph.PkgGraph, node *pkggraph.PkgNode, srpm string, satisfiedCache map[int64]bool) (satisfied bool) {
+	if cached, found := satisfiedCache[node.ID()]; found {
+		return cached
+	}
+
+	switch node.State {
+	case pkggraph.StateBuild:
+		satisfied = node.BuildKey() == srpm
+	case pkggraph.StateMeta:
+		satisfied = true
+		for _, dependency := range graph.NodesOf(pkgGraph.From(node.ID())) {
+			if !dependencySatisfied(pkgGraph, dependency.(*pkggraph.PkgNode).This, srpm, satisfiedCache) {
+				satisfied = false
+				break
+			}
+		}
+	default:
+		satisfied = true
+	}
+
+	satisfiedCache[node.ID()] = satisfied
+	return
+}
+
+// buildWorker will process a channel of build requests, invoking buildFunc for each of them.
+func buildWorker(requests chan *buildRequest, results chan *buildResult, buildFunc func(*buildRequest) error) {
+	for request := range requests {
+		results <- &buildResult{
+			buildKey: request.buildKey,
+			err:      buildFunc(request),
+		}
+	}
+}
+
+// newBuildRequest returns the request to build the build nodes sharing a build key.
+func newBuildRequest(pkgGraph *pkggraph.PkgGraph, buildKey string, buildNodes []*pkggraph.PkgNode) (request *buildRequest) {
+	node := buildNodes[0]
+	request = &buildRequest{
+		buildKey:         buildKey,
+		srpmPath:         node.SrpmPath,
+		variant:          node.Variant,
+		variantBuildName: node.VariantBuildName(),
+		defines:          node.VariantDefines,
+	}
+
+	for _, variant := range pkgGraph.VariantsRequiredBy(buildNodes) {
+		request.requiredVariants = append(request.requiredVariants, variant.VariantBuildName())
+	}
+
+	return
+}
+
+// buildSRPM invokes pkgworker to build a single SRPM, applying the defines of the requested variant.
+func buildSRPM(request *buildRequest) (err error) {
+	srpmName := filepath.Base(request.srpmPath)
+	logName := srpmName
+	buildWorkDir := *workDir
+	if request.variant != "" {
+		// Keep the chroot and log of a variant separate from the default build of the SRPM
+		logName = fmt.Sprintf("%s-%s", srpmName, request.variant)
+		buildWorkDir = filepath.Join(*workDir, request.variant)
+	}
+	buildLogFile := filepath.Join(*buildLogsDir, fmt.Sprintf("%s.log", logName))
+
+	args := []string{
+		fmt.Sprintf("--input=%s", request.srpmPath),
+		fmt.Sprintf("--retry-attempts=%d", *retryAttempts),
+		fmt.Sprintf("--cache-dir=%s", *cacheDir),
+		fmt.Sprintf("--work-dir=%s", buildWorkDir),
+		fmt.Sprintf("--worker-tar=%s", *workerTar),
+		fmt.Sprintf("--repo-file=%s", *repoFile),
+		fmt.Sprintf("--rpms-dir=%s", *rpmsDir),
+		fmt.Sprintf("--srpms-dir=%s", *srpmsDir),
+		fmt.Sprintf("--dist-tag=%s", *distTag),
+		fmt.Sprintf("--distro-release-version=%s", *releaseVersion),
+		fmt.Sprintf("--distro-build-number=%s", *buildNumber),
+		fmt.Sprintf("--log-file=%s", buildLogFile),
+	}
+
+	if *rpmmacrosFile != "" {
+		args = append(args, fmt.Sprintf("--rpmmacros-file=%s", *rpmmacrosFile))
+	}
+
+	if *chrootPoolDir != "" {
+		args = append(args, fmt.Sprintf("--chroot-pool-dir=%s", *chrootPoolDir))
+	}
+
+	if *buildResultsDir != "" {
+		args = append(args, fmt.Sprintf("--result-file=%s", filepath.Join(*buildResultsDir, fmt.Sprintf("%s.json", logName))))
+	}
+
+	// Variants produce RPMs with the same names as the default build, keep them out of the final RPMs directory.
+	if request.variant != "" {
+		args = append(args, fmt.Sprintf("--output-rpms-dir=%s", filepath.Join(*variantRpmsDir, request.variantBuildName)))
+	}
+
+	for _, variantBuildName := range request.requiredVariants {
+		args = append(args, fmt.Sprintf("--variant-rpms-dir=%s", filepath.Join(*variantRpmsDir, variantBuildName)))
+	}
+
+	defineNames := make([]string, 0, len(request.defines))
+	for name := range request.defines {
+		defineNames = append(defineNames, name)
+	}
+	sort.Strings(defineNames)
+	for _, name := range defineNames {
+		args = append(args, fmt.Sprintf("--define=%s=%s", name, request.defines[name]))
+	}
+
+	if *runCheck {
+		args = append(args, "--run-check")
+	}
+
+	if *noCleanup {
+		args = append(args, "--no-cleanup")
+	}
+
+	if *isolateNetwork {
+		args = append(args, "--isolate-network")
+	}
+
+	if *verifyReproducible {
+		args = append(args, "--verify-reproducible")
+	}
+
+	if *buildTimeout > 0 {
+		args = append(args, fmt.Sprintf("--timeout=%s", *buildTimeout))
+	}
+
+	if *buildMemoryLimit != "" {
+		args = append(args, fmt.Sprintf("--memory-limit=%s", *buildMemoryLimit))
+	}
+
+	if *buildCPUQuota > 0 {
+		args = append(args, fmt.Sprintf("--cpu-quota=%g", *buildCPUQuota))
+	}
+
+	if *buildCgroupParent != "" {
+		args = append(args, fmt.Sprintf("--cgroup-parent=%s", *buildCgroupParent))
+	}
+
+	if *buildLimitsFile != "" {
+		args = append(args, fmt.Sprintf("--limits-file=%s", *buildLimitsFile))
+	}
+
+	if *logLevel != "" {
+		args = append(args, fmt.Sprintf("--log-level=%s", *logLevel))
+	}
+
+	logger.Log.Infof("Building (%s)", filepath.Base(request.buildKey))
+
+	_, stderr, err := shell.Execute(*pkgWorkerPath, args...)
+	if err != nil {
+		logger.Log.Debugf("pkgworker stderr for (%s): %s", filepath.Base(request.buildKey), strings.TrimSpace(stderr))
+		err = fmt.Errorf("%s. For details see log file: %s", err, buildLogFile)
+	}
+
+	return
+}
+
+// writeFailures records the name of each failed SRPM into a file, one per line.
+func writeFailures(failuresFilePath string, failedSRPMs []string) (err error) {
+	var names []string
+	for _, srpm := range failedSRPMs {
+		names = append(names, filepath.Base(srpm))
+	}
+
+	return file.Write(strings.Join(names, "\n")+"\n", failuresFilePath)
+}
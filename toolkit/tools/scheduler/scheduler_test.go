@@ -0,0 +1,191 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+	"microsoft.com/pkggen/internal/pkgjson"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+// buildRecorder is a build function for buildAllSRPMs which records the order builds are requested in,
+// failing the builds of the SRPMs in failingSRPMs.
+type buildRecorder struct {
+	mutex        sync.Mutex
+	built        []string
+	failingSRPMs map[string]bool
+}
+
+func (r *buildRecorder) build(request *buildRequest) (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.built = append(r.built, request.buildKey)
+	if r.failingSRPMs[request.buildKey] {
+		err = fmt.Errorf("failed to build %s", request.buildKey)
+	}
+	return
+}
+
+// indexOf returns the position of a build key in the recorded builds, or -1 if it was never built.
+func (r *buildRecorder) indexOf(buildKey string) int {
+	for i, built := range r.built {
+		if built == buildKey {
+			return i
+		}
+	}
+	return -1
+}
+
+// addTestPackage adds the run and build nodes of a package to the graph, the run node depending on the build node.
+func addTestPackage(t *testing.T, g *pkggraph.PkgGraph, name, srpm, variant string) (runNode, buildNode *pkggraph.PkgNode) {
+	var variantDefines map[string]string
+	if variant != "" {
+		variantDefines = map[string]string{fmt.Sprintf("_with_%s", variant): "1"}
+	}
+
+	pkgVer := &pkgjson.PackageVer{Name: name, Version: "1.0", Condition: "="}
+	runNode, err := g.AddVariantPkgNode(pkgVer, pkggraph.StateMeta, pkggraph.TypeRun, srpm, name+".spec", "SOURCES", "x86_64", "", variant, variantDefines)
+	assert.NoError(t, err)
+	buildNode, err = g.AddVariantPkgNode(pkgVer, pkggraph.StateBuild, pkggraph.TypeBuild, srpm, name+".spec", "SOURCES", "x86_64", "", variant, variantDefines)
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(runNode, buildNode))
+	return
+}
+
+// schedulerTestGraph returns a graph where a.src.rpm requires b.src.rpm, which requires c.src.rpm, and d.src.rpm has no
+// dependencies. a.src.rpm also produces a-devel, which requires a to build.
+func schedulerTestGraph(t *testing.T) (g *pkggraph.PkgGraph) {
+	g = pkggraph.NewPkgGraph()
+
+	aRun, aBuild := addTestPackage(t, g, "a", "a.src.rpm", "")
+	_, aDevelBuild := addTestPackage(t, g, "a-devel", "a.src.rpm", "")
+	bRun, bBuild := addTestPackage(t, g, "b", "b.src.rpm", "")
+	cRun, _ := addTestPackage(t, g, "c", "c.src.rpm", "")
+	addTestPackage(t, g, "d", "d.src.rpm", "")
+
+	g.SetEdge(g.NewEdge(aBuild, bRun))
+	g.SetEdge(g.NewEdge(aDevelBuild, aRun))
+	g.SetEdge(g.NewEdge(bBuild, cRun))
+	return
+}
+
+// assertBuildStates checks the state of every build node from an SRPM.
+func assertBuildStates(t *testing.T, g *pkggraph.PkgGraph, srpm string, expectedState pkggraph.NodeState) {
+	for _, node := range g.AllBuildNodes() {
+		if node.BuildKey() == srpm {
+			assert.Equal(t, expectedState, node.State, "state of %s", node)
+		}
+	}
+}
+
+func TestBuildAllSRPMsShouldBuildDependenciesFirst(t *testing.T) {
+	g := schedulerTestGraph(t)
+	recorder := &buildRecorder{}
+
+	failedSRPMs, blockedSRPMs := buildAllSRPMs(g, 4, false, recorder.build)
+	assert.Empty(t, failedSRPMs)
+	assert.Empty(t, blockedSRPMs)
+
+	// Each SRPM is built once, even though it produces several packages.
+	assert.ElementsMatch(t, []string{"a.src.rpm", "b.src.rpm", "c.src.rpm", "d.src.rpm"}, recorder.built)
+	assert.Less(t, recorder.indexOf("c.src.rpm"), recorder.indexOf("b.src.rpm"))
+	assert.Less(t, recorder.indexOf("b.src.rpm"), recorder.indexOf("a.src.rpm"))
+
+	for _, srpm := range []string{"a.src.rpm", "b.src.rpm", "c.src.rpm", "d.src.rpm"} {
+		assertBuildStates(t, g, srpm, pkggraph.StateUpToDate)
+	}
+}
+
+func TestBuildAllSRPMsShouldBuildVariantsBeforeTheirDependents(t *testing.T) {
+	g := pkggraph.NewPkgGraph()
+	_, aBuild := addTestPackage(t, g, "a", "a.src.rpm", "")
+	bootstrapRun, _ := addTestPackage(t, g, "a", "a.src.rpm", "bootstrap")
+	g.SetEdge(g.NewEdge(aBuild, bootstrapRun))
+
+	recorder := &buildRecorder{}
+	failedSRPMs, blockedSRPMs := buildAllSRPMs(g, 4, false, recorder.build)
+	assert.Empty(t, failedSRPMs)
+	assert.Empty(t, blockedSRPMs)
+
+	// The variant shares an SRPM with the default build but is a separate build which must finish first.
+	assert.Equal(t, []string{"a.src.rpm (bootstrap)", "a.src.rpm"}, recorder.built)
+}
+
+func TestBuildAllSRPMsShouldBlockDependentsOfFailedBuilds(t *testing.T) {
+	g := schedulerTestGraph(t)
+	recorder := &buildRecorder{failingSRPMs: map[string]bool{"b.src.rpm": true}}
+
+	failedSRPMs, blockedSRPMs := buildAllSRPMs(g, 4, false, recorder.build)
+	assert.Equal(t, []string{"b.src.rpm"}, failedSRPMs)
+	assert.Equal(t, []string{"a.src.rpm"}, blockedSRPMs)
+
+	// Builds which do not depend on the failure still run.
+	assert.ElementsMatch(t, []string{"b.src.rpm", "c.src.rpm", "d.src.rpm"}, recorder.built)
+
+	assertBuildStates(t, g, "a.src.rpm", pkggraph.StateBuild)
+	assertBuildStates(t, g, "b.src.rpm", pkggraph.StateBuild)
+	assertBuildStates(t, g, "c.src.rpm", pkggraph.StateUpToDate)
+	assertBuildStates(t, g, "d.src.rpm", pkggraph.StateUpToDate)
+}
+
+func TestBuildAllSRPMsShouldStopSchedulingOnFailure(t *testing.T) {
+	g := pkggraph.NewPkgGraph()
+	addTestPackage(t, g, "a", "a.src.rpm", "")
+	bRun, _ := addTestPackage(t, g, "b", "b.src.rpm", "")
+	_, cBuild := addTestPackage(t, g, "c", "c.src.rpm", "")
+	g.SetEdge(g.NewEdge(cBuild, bRun))
+
+	// a.src.rpm and b.src.rpm are both scheduled at once, a single worker builds them in order so the failure
+	// of a.src.rpm is processed before b.src.rpm finishes and unblocks c.src.rpm.
+	recorder := &buildRecorder{failingSRPMs: map[string]bool{"a.src.rpm": true}}
+	failedSRPMs, blockedSRPMs := buildAllSRPMs(g, 1, true, recorder.build)
+	assert.Equal(t, []string{"a.src.rpm"}, failedSRPMs)
+	assert.Equal(t, []string{"c.src.rpm"}, blockedSRPMs)
+
+	// Builds already scheduled are allowed to finish.
+	assert.Equal(t, []string{"a.src.rpm", "b.src.rpm"}, recorder.built)
+	assertBuildStates(t, g, "b.src.rpm", pkggraph.StateUpToDate)
+	assertBuildStates(t, g, "c.src.rpm", pkggraph.StateBuild)
+}
+
+func TestDependencySatisfied(t *testing.T) {
+	g := schedulerTestGraph(t)
+	aRun, err := g.FindExactPkgNodeFromPkg(&pkgjson.PackageVer{Name: "a", Version: "1.0", Condition: "="})
+	assert.NoError(t, err)
+	bRun, err := g.FindExactPkgNodeFromPkg(&pkgjson.PackageVer{Name: "b", Version: "1.0", Condition: "="})
+	assert.NoError(t, err)
+
+	remoteNode, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "gcc"}, pkggraph.StateUnresolved, pkggraph.TypeRemote, "<NO_SRPM_PATH>", "<NO_SPEC_PATH>", "<NO_SOURCE_PATH>", "<NO_ARCHITECTURE>", "<NO_REPO>")
+	assert.NoError(t, err)
+
+	// A run node is only satisfied once its SRPM is built, unless it is needed by the same SRPM.
+	assert.False(t, dependencySatisfied(g, aRun.RunNode, "d.src.rpm", make(map[int64]bool)))
+	assert.True(t, dependencySatisfied(g, aRun.RunNode, "a.src.rpm", make(map[int64]bool)))
+
+	// Meta nodes are only satisfied once all of their dependencies are, have b also require c at run-time.
+	cRun, err := g.FindExactPkgNodeFromPkg(&pkgjson.PackageVer{Name: "c", Version: "1.0", Condition: "="})
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(bRun.RunNode, cRun.RunNode))
+
+	bRun.BuildNode.State = pkggraph.StateUpToDate
+	assert.False(t, dependencySatisfied(g, bRun.RunNode, "a.src.rpm", make(map[int64]bool)))
+
+	cRun.BuildNode.State = pkggraph.StateUpToDate
+	assert.True(t, dependencySatisfied(g, bRun.RunNode, "a.src.rpm", make(map[int64]bool)))
+
+	// Nodes in any other state do not block builds.
+	assert.True(t, dependencySatisfied(g, remoteNode, "a.src.rpm", make(map[int64]bool)))
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/internal/jsonutils"
+	"microsoft.com/pkggen/internal/rpm"
+)
+
+// buildVariant describes one conditional build of a SPEC, such as a bootstrap build.
+// With and Without list %bcond options to enable or disable, Defines lists any other rpm defines to set.
+type buildVariant struct {
+	Name    string            `json:"Name"`
+	With    []string          `json:"With"`
+	Without []string          `json:"Without"`
+	Defines map[string]string `json:"Defines"`
+}
+
+// bcondMatrix lists the conditional build variants to evaluate in addition to the default build of each SPEC.
+// SPECs are keyed by their file name without the .spec extension.
+type bcondMatrix struct {
+	Specs map[string][]buildVariant `json:"Specs"`
+}
+
+// readBcondMatrix reads and validates a bcond matrix file.
+func readBcondMatrix(path string) (matrix *bcondMatrix, err error) {
+	matrix = &bcondMatrix{}
+	err = jsonutils.ReadJSONFile(path, matrix)
+	if err != nil {
+		return
+	}
+
+	for spec, variants := range matrix.Specs {
+		seenVariants := make(map[string]bool)
+		for _, variant := range variants {
+			switch {
+			case variant.Name == "" || strings.ContainsAny(variant.Name, " \t()"):
+				err = fmt.Errorf("variant (%s) of SPEC (%s) must have a name without whitespace or parentheses", variant.Name, spec)
+			case seenVariants[variant.Name]:
+				err = fmt.Errorf("variant (%s) of SPEC (%s) is listed more than once", variant.Name, spec)
+			case len(variant.With) == 0 && len(variant.Without) == 0 && len(variant.Defines) == 0:
+				err = fmt.Errorf("variant (%s) of SPEC (%s) does not change any build options", variant.Name, spec)
+			}
+			if err != nil {
+				return
+			}
+			seenVariants[variant.Name] = true
+		}
+	}
+
+	return
+}
+
+// variantsForSpec returns the conditional build variants of a SPEC, not including its default build.
+func (m *bcondMatrix) variantsForSpec(specfile string) (variants []buildVariant) {
+	if m == nil {
+		return
+	}
+	return m.Specs[strings.TrimSuffix(filepath.Base(specfile), ".spec")]
+}
+
+// rpmDefines returns the rpm defines a variant is built with, on top of the default defines.
+func (v *buildVariant) rpmDefines() (defines map[string]string) {
+	defines = rpm.BcondDefines(v.With, v.Without)
+	for name, value := range v.Defines {
+		defines[name] = value
+	}
+	return
+}
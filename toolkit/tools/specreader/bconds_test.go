@@ -0,0 +1,84 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readTestBcondMatrix writes contents to a temporary matrix file and reads it back.
+func readTestBcondMatrix(t *testing.T, contents string) (matrix *bcondMatrix, err error) {
+	dir, err := ioutil.TempDir("", "specreader")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "bconds.json")
+	writeTestFile(t, path, contents)
+	return readBcondMatrix(path)
+}
+
+func TestReadBcondMatrixShouldReadVariants(t *testing.T) {
+	matrix, err := readTestBcondMatrix(t, `{"Specs": {"b": [{"Name": "bootstrap", "Without": ["tests"]}]}}`)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []buildVariant{{Name: "bootstrap", Without: []string{"tests"}}}, matrix.variantsForSpec("/SPECS/b/b.spec"))
+	assert.Empty(t, matrix.variantsForSpec("/SPECS/a/a.spec"))
+}
+
+func TestReadBcondMatrixShouldRejectInvalidVariants(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{
+			name:     "missing name",
+			contents: `{"Specs": {"b": [{"With": ["bootstrap"]}]}}`,
+		},
+		{
+			name:     "name with parentheses",
+			contents: `{"Specs": {"b": [{"Name": "(bootstrap)", "With": ["bootstrap"]}]}}`,
+		},
+		{
+			name:     "duplicate name",
+			contents: `{"Specs": {"b": [{"Name": "bootstrap", "With": ["bootstrap"]}, {"Name": "bootstrap", "Without": ["tests"]}]}}`,
+		},
+		{
+			name:     "no build options",
+			contents: `{"Specs": {"b": [{"Name": "bootstrap"}]}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := readTestBcondMatrix(t, test.contents)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestVariantsForSpecShouldAllowMissingMatrix(t *testing.T) {
+	var matrix *bcondMatrix
+	assert.Empty(t, matrix.variantsForSpec("/SPECS/b/b.spec"))
+}
+
+func TestRpmDefinesShouldMergeBcondsAndDefines(t *testing.T) {
+	variant := buildVariant{
+		Name:    "bootstrap",
+		With:    []string{"bootstrap"},
+		Without: []string{"tests"},
+		Defines: map[string]string{"_without_tests": "1", "python3_pkgversion": "3"},
+	}
+
+	// Explicit defines take precedence over the %bcond options.
+	assert.Equal(t, map[string]string{
+		"_with_bootstrap":    "--with-bootstrap",
+		"_without_tests":     "1",
+		"python3_pkgversion": "3",
+	}, variant.rpmDefines())
+}
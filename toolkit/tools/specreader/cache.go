@@ -0,0 +1,200 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"microsoft.com/pkggen/internal/exe"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/jsonutils"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkgjson"
+)
+
+// specCacheVersion must be changed whenever the packages produced from a SPEC change, such as when a new field is parsed,
+// so results cached by an older specreader are not reused.
+const specCacheVersion = "3"
+
+// archiveExtensions are the extensions of source archives. rpmspec never reads source archives, so they are left out
+// of the cache key of a SPEC instead of hashing their, potentially very large, contents.
+var archiveExtensions = []string{".tar", ".tgz", ".tbz2", ".txz", ".gz", ".bz2", ".xz", ".zst", ".zip", ".7z", ".jar", ".gem", ".crate", ".rpm"}
+
+// specCacheEntry holds the packages parsed from a single SPEC file along with the key they were parsed with.
+type specCacheEntry struct {
+	Key      string             `json:"Key"`
+	Packages []*pkgjson.Package `json:"Packages"`
+}
+
+// specCache maps the full path of each SPEC file to its parsed packages.
+// All methods are safe to call concurrently.
+type specCache struct {
+	Entries map[string]*specCacheEntry `json:"Entries"`
+
+	hits   int
+	misses int
+	mutex  sync.Mutex
+}
+
+// loadSpecCache reads a cache file from a previous run. A missing file results in an empty cache.
+func loadSpecCache(path string) (cache *specCache, err error) {
+	cache = &specCache{Entries: make(map[string]*specCacheEntry)}
+
+	exists, err := file.PathExists(path)
+	if err != nil || !exists {
+		return
+	}
+
+	err = jsonutils.ReadJSONFile(path, cache)
+	if err != nil {
+		return
+	}
+
+	if cache.Entries == nil {
+		cache.Entries = make(map[string]*specCacheEntry)
+	}
+
+	return
+}
+
+// lookup returns the cached packages for a SPEC file if its key matches the one it was cached with.
+func (c *specCache) lookup(specfile, key string) (packages []*pkgjson.Package, found bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, exists := c.Entries[specfile]
+	if exists && entry.Key == key {
+		packages = entry.Packages
+		found = true
+		c.hits++
+	} else {
+		c.misses++
+	}
+
+	return
+}
+
+// store records the packages parsed from a SPEC file.
+func (c *specCache) store(specfile, key string, packages []*pkgjson.Package) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.Entries[specfile] = &specCacheEntry{Key: key, Packages: packages}
+}
+
+// write saves the entries for the given SPEC files to disk, dropping any SPEC files which no longer exist.
+func (c *specCache) write(path string, specFiles []string) (err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	current := &specCache{Entries: make(map[string]*specCacheEntry)}
+	for _, specfile := range specFiles {
+		if entry, exists := c.Entries[specfile]; exists {
+			current.Entries[specfile] = entry
+		}
+	}
+
+	logger.Log.Infof("SPEC cache: %d hits, %d misses", c.hits, c.misses)
+	return jsonutils.WriteJSONFile(path, current)
+}
+
+// specCacheKey returns the cache key of a SPEC file. The key changes whenever any file in the SPEC's directory other
+// than a source archive (the SPEC itself and every file it may include, such as patches or macro snippets), its
+// conditional build variants, or any of the settings shared by every SPEC (see sharedCacheKey) change.
+func specCacheKey(specfile, sharedKey string, variants []buildVariant) (key string, err error) {
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%s\n%s\n", filepath.Base(specfile), sharedKey)
+
+	err = hashDirectory(hasher, filepath.Dir(specfile))
+	if err != nil {
+		return
+	}
+
+	if len(variants) != 0 {
+		var encodedVariants []byte
+
+		encodedVariants, err = json.Marshal(variants)
+		if err != nil {
+			return
+		}
+		hasher.Write(encodedVariants)
+	}
+
+	key = fmt.Sprintf("%x", hasher.Sum(nil))
+	return
+}
+
+// sharedCacheKey hashes the parsing settings shared by every SPEC file: the dist tag, the SRPM directory,
+// the contents of the rpm macro directory, the machine architecture, the toolkit version and the cache version.
+func sharedCacheKey(distTag, srpmDir, macroDir string) (key string, err error) {
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%s\n%s\n%s\n%s\n%s\n", distTag, srpmDir, runtime.GOARCH, exe.ToolkitVersion, specCacheVersion)
+
+	if macroDir != "" {
+		err = hashDirectory(hasher, macroDir)
+		if err != nil {
+			return
+		}
+	}
+
+	key = fmt.Sprintf("%x", hasher.Sum(nil))
+	return
+}
+
+// hashDirectory writes the relative path and contents of every file under dir, other than source archives, into
+// hasher, in a stable order.
+func hashDirectory(hasher io.Writer, dir string) (err error) {
+	var files []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !info.IsDir() && !isArchive(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	sort.Strings(files)
+	for _, path := range files {
+		var relPath, fileHash string
+
+		relPath, err = filepath.Rel(dir, path)
+		if err != nil {
+			return
+		}
+
+		fileHash, err = file.GenerateSHA256(path)
+		if err != nil {
+			return
+		}
+
+		fmt.Fprintf(hasher, "%s %s\n", relPath, fileHash)
+	}
+
+	return
+}
+
+// isArchive returns true if path is a source archive, judging by its extension.
+func isArchive(path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	for _, extension := range archiveExtensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
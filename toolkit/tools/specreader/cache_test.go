@@ -0,0 +1,145 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+// writeTestFile writes contents to path, creating its directory if needed.
+func writeTestFile(t *testing.T, path, contents string) {
+	err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	assert.NoError(t, err)
+
+	err = ioutil.WriteFile(path, []byte(contents), os.ModePerm)
+	assert.NoError(t, err)
+}
+
+// testCacheKey returns the cache key of specfile parsed with the macros in macroDir.
+func testCacheKey(t *testing.T, specfile, macroDir string, variants []buildVariant) string {
+	sharedKey, err := sharedCacheKey(".cm1", "/SRPMS", macroDir)
+	assert.NoError(t, err)
+
+	key, err := specCacheKey(specfile, sharedKey, variants)
+	assert.NoError(t, err)
+	return key
+}
+
+func TestSpecCacheKeyShouldChange(t *testing.T) {
+	variants := []buildVariant{{Name: "bootstrap", Without: []string{"tests"}}}
+
+	tests := []struct {
+		name     string
+		variants []buildVariant
+		modify   func(specfile, macroDir string)
+	}{
+		{
+			name: "SPEC edit",
+			modify: func(specfile, macroDir string) {
+				writeTestFile(t, specfile, "Name: test\nVersion: 2.0\n%include %{SOURCE1}\n")
+			},
+		},
+		{
+			name: "included file edit",
+			modify: func(specfile, macroDir string) {
+				writeTestFile(t, filepath.Join(filepath.Dir(specfile), "test.inc"), "%global with_tests 0\n")
+			},
+		},
+		{
+			name: "file added next to the SPEC",
+			modify: func(specfile, macroDir string) {
+				writeTestFile(t, filepath.Join(filepath.Dir(specfile), "fix.patch"), "--- a\n+++ b\n")
+			},
+		},
+		{
+			name: "macro dir edit",
+			modify: func(specfile, macroDir string) {
+				writeTestFile(t, filepath.Join(macroDir, "macros.test"), "%dist .cm2\n")
+			},
+		},
+		{
+			name:     "variant change",
+			variants: []buildVariant{{Name: "bootstrap", Without: []string{"tests", "docs"}}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "specreader")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			specfile := filepath.Join(dir, "SPECS", "test", "test.spec")
+			macroDir := filepath.Join(dir, "macros")
+			writeTestFile(t, specfile, "Name: test\nVersion: 1.0\n%include %{SOURCE1}\n")
+			writeTestFile(t, filepath.Join(filepath.Dir(specfile), "test.inc"), "%global with_tests 1\n")
+			writeTestFile(t, filepath.Join(macroDir, "macros.test"), "%dist .cm1\n")
+
+			originalKey := testCacheKey(t, specfile, macroDir, variants)
+
+			newVariants := variants
+			if test.variants != nil {
+				newVariants = test.variants
+			}
+			if test.modify != nil {
+				test.modify(specfile, macroDir)
+			}
+
+			assert.NotEqual(t, originalKey, testCacheKey(t, specfile, macroDir, newVariants))
+		})
+	}
+}
+
+func TestSpecCacheKeyShouldBeStable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "specreader")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	specfile := filepath.Join(dir, "SPECS", "test", "test.spec")
+	writeTestFile(t, specfile, "Name: test\nVersion: 1.0\n")
+
+	// Files outside of the SPEC's directory, such as other SPECs, do not affect its key.
+	originalKey := testCacheKey(t, specfile, "", nil)
+	writeTestFile(t, filepath.Join(dir, "SPECS", "other", "other.spec"), "Name: other\n")
+
+	assert.Equal(t, originalKey, testCacheKey(t, specfile, "", nil))
+}
+
+func TestSpecCacheKeyShouldIgnoreSourceArchives(t *testing.T) {
+	dir, err := ioutil.TempDir("", "specreader")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	specfile := filepath.Join(dir, "SPECS", "test", "test.spec")
+	writeTestFile(t, specfile, "Name: test\nVersion: 1.0\n")
+	writeTestFile(t, filepath.Join(dir, "SPECS", "test", "test-1.0.tar.gz"), "archive")
+
+	// rpmspec never reads source archives, adding or changing them does not require parsing the SPEC again.
+	originalKey := testCacheKey(t, specfile, "", nil)
+	writeTestFile(t, filepath.Join(dir, "SPECS", "test", "test-1.0.tar.gz"), "new archive")
+	writeTestFile(t, filepath.Join(dir, "SPECS", "test", "vendor.TAR.XZ"), "vendored sources")
+	writeTestFile(t, filepath.Join(dir, "SPECS", "test", "extra.zip"), "extra sources")
+
+	assert.Equal(t, originalKey, testCacheKey(t, specfile, "", nil))
+}
+
+func TestIsArchiveShouldMatchArchiveExtensions(t *testing.T) {
+	for _, path := range []string{"a-1.0.tar.gz", "a-1.0.tgz", "a.tar.xz", "a.tar.zst", "a.zip", "vendor.TAR.BZ2", "a.crate"} {
+		assert.True(t, isArchive(path), path)
+	}
+	for _, path := range []string{"a.spec", "fix.patch", "a.signatures.json", "macros.inc", "tar"} {
+		assert.False(t, isArchive(path), path)
+	}
+}
@@ -27,15 +27,17 @@ const (
 )
 
 var (
-	app      = kingpin.New("specreader", "A tool to parse spec dependencies into JSON")
-	dir      = exe.InputDirFlag(app, "Directory to scan for SPECS")
-	output   = exe.OutputFlag(app, "Output file to export the JSON")
-	workers  = app.Flag("workers", "Number of concurrent goroutines to parse with").Default(defaultWorkerCount).Int()
-	srpmDir  = app.Flag("srpm-dir", "Directory containing SRPMs.").Required().ExistingDir()
-	macroDir = app.Flag("macro-dir", "Directory containing rpm macros.").Default("").String()
-	distTag  = app.Flag("dist-tag", "The distribution tag the SPEC will be built with.").Required().String()
-	logFile  = exe.LogFileFlag(app)
-	logLevel = exe.LogLevelFlag(app)
+	app       = kingpin.New("specreader", "A tool to parse spec dependencies into JSON")
+	dir       = exe.InputDirFlag(app, "Directory to scan for SPECS")
+	output    = exe.OutputFlag(app, "Output file to export the JSON")
+	workers   = app.Flag("workers", "Number of concurrent goroutines to parse with").Default(defaultWorkerCount).Int()
+	srpmDir   = app.Flag("srpm-dir", "Directory containing SRPMs.").Required().ExistingDir()
+	macroDir  = app.Flag("macro-dir", "Directory containing rpm macros.").Default("").String()
+	distTag   = app.Flag("dist-tag", "The distribution tag the SPEC will be built with.").Required().String()
+	cacheFile = app.Flag("cache-file", "Optional JSON file used to cache the results of previous runs, SPECs which did not change are not parsed again.").String()
+	bcondFile = app.Flag("bcond-matrix", "Optional JSON file listing the conditional build variants (such as bootstrap builds) to evaluate for each SPEC.").ExistingFile()
+	logFile   = exe.LogFileFlag(app)
+	logLevel  = exe.LogLevelFlag(app)
 )
 
 func main() {
@@ -48,6 +50,9 @@ func main() {
 		packageList []*pkgjson.Package
 		wg          sync.WaitGroup
 		specFiles   []string
+		cache       *specCache
+		matrix      *bcondMatrix
+		sharedKey   string
 		err         error
 	)
 
@@ -68,12 +73,25 @@ func main() {
 		logger.Log.Panicf("Failed to find *.spec files. Check that %s is the correct directory. Error: %v", *dir, err)
 	}
 
-	ch := make(chan []*pkgjson.Package)
+	if *bcondFile != "" {
+		matrix, err = readBcondMatrix(*bcondFile)
+		logger.PanicOnError(err, "Failed to read bcond matrix (%s).", *bcondFile)
+	}
+
+	if *cacheFile != "" {
+		cache, err = loadSpecCache(*cacheFile)
+		logger.PanicOnError(err, "Failed to read SPEC cache (%s).", *cacheFile)
+
+		sharedKey, err = sharedCacheKey(*distTag, *srpmDir, *macroDir)
+		logger.PanicOnError(err, "Failed to hash rpm macro directory (%s).", *macroDir)
+	}
+
+	ch := make(chan specResult)
 	sem := make(chan int, *workers)
 
-	for _, file := range specFiles {
+	for _, specfile := range specFiles {
 		wg.Add(1)
-		go readspec(file, *distTag, *srpmDir, &wg, ch, sem)
+		go readspec(specfile, *distTag, *srpmDir, matrix.variantsForSpec(specfile), cache, sharedKey, &wg, ch, sem)
 	}
 
 	// Set a goroutine to wait for all workers to finish so it can clean up the channel.
@@ -84,7 +102,10 @@ func main() {
 
 	// Receive the parsed spec structures from the workers and place them into a list.
 	for specparsed := range ch {
-		packageList = append(packageList, specparsed...)
+		packageList = append(packageList, specparsed.packages...)
+		if cache != nil && specparsed.cacheable && !specparsed.cached {
+			cache.store(specparsed.specfile, specparsed.cacheKey, specparsed.packages)
+		}
 	}
 
 	packageRepo.Repo = packageList
@@ -98,50 +119,53 @@ func main() {
 	if err != nil {
 		logger.Log.Panicf("Failed to write file (%s). Error: %v", *output, err)
 	}
+
+	if cache != nil {
+		err = cache.write(*cacheFile, specFiles)
+		logger.PanicOnError(err, "Failed to write SPEC cache (%s).", *cacheFile)
+	}
 }
 
 // sortPackages orders the package lists into reasonable and deterministic orders.
-// Sort the main package list by "Name", "Version", "SRPM"
-// Sort each nested Requires/BuildRequires by "Name", "Version"
+// Sort the main package list by "Name", "Version", "SRPM", "Variant"
+// Sort each nested Requires/BuildRequires/weak dependency list by "Name", "Version"
 func sortPackages(packageRepo *pkgjson.PackageRepo) {
 	sort.Slice(packageRepo.Repo, func(i, j int) bool {
-		iName := packageRepo.Repo[i].Provides.Name + packageRepo.Repo[i].Provides.Version + packageRepo.Repo[i].SrpmPath
-		jName := packageRepo.Repo[j].Provides.Name + packageRepo.Repo[j].Provides.Version + packageRepo.Repo[j].SrpmPath
+		iName := packageRepo.Repo[i].Provides.Name + packageRepo.Repo[i].Provides.Version + packageRepo.Repo[i].SrpmPath + packageRepo.Repo[i].Variant
+		jName := packageRepo.Repo[j].Provides.Name + packageRepo.Repo[j].Provides.Version + packageRepo.Repo[j].SrpmPath + packageRepo.Repo[j].Variant
 		return strings.Compare(iName, jName) < 0
 	})
 
 	for _, pkg := range packageRepo.Repo {
-		sort.Slice(pkg.Requires, func(i, j int) bool {
-			iName := pkg.Requires[i].Name + pkg.Requires[i].Version
-			jName := pkg.Requires[j].Name + pkg.Requires[j].Version
-			return strings.Compare(iName, jName) < 0
-		})
-		sort.Slice(pkg.BuildRequires, func(i, j int) bool {
-			iName := pkg.BuildRequires[i].Name + pkg.BuildRequires[i].Version
-			jName := pkg.BuildRequires[j].Name + pkg.BuildRequires[j].Version
-			return strings.Compare(iName, jName) < 0
-		})
+		for _, pkgVers := range [][]*pkgjson.PackageVer{pkg.Requires, pkg.BuildRequires, pkg.Recommends, pkg.Suggests, pkg.Supplements, pkg.Enhances} {
+			sort.Slice(pkgVers, func(i, j int) bool {
+				iName := pkgVers[i].Name + pkgVers[i].Version
+				jName := pkgVers[j].Name + pkgVers[j].Version
+				return strings.Compare(iName, jName) < 0
+			})
+		}
 	}
 }
 
+// specResult holds the packages parsed from a single spec file.
+// Results are only cacheable if rpmspec was able to parse the spec, cached results were read from the SPEC cache.
+type specResult struct {
+	specfile  string
+	cacheKey  string
+	packages  []*pkgjson.Package
+	cacheable bool
+	cached    bool
+}
+
 // readspec is a goroutine that takes a full filepath to a spec file and scrapes it into the Specdef structure
+// The default build of the spec is always read, followed by each of its conditional build variants.
+// If cache is not nil, the results cached for the spec are used instead as long as its cache key is unchanged.
 // Concurrency is limited by the size of the semaphore channel passed in. Too many goroutines at once can deplete
 // available filehandles.
-func readspec(specfile, distTag, srpmDir string, wg *sync.WaitGroup, ch chan []*pkgjson.Package, sem chan int) {
-	const (
-		emptyQueryFormat      = ""
-		queryProvidedPackages = `srpm %{NAME}-%{VERSION}-%{RELEASE}.src.rpm\n[provides %{PROVIDENEVRS}\n][requires %{REQUIRENEVRS}\n][arch %{ARCH}\n]`
-	)
-
-	var (
-		sourcedir         string
-		results           []string
-		providerList      []*pkgjson.Package
-		buildRequiresList []*pkgjson.PackageVer
-		err               error
-	)
+func readspec(specfile, distTag, srpmDir string, variants []buildVariant, cache *specCache, sharedKey string, wg *sync.WaitGroup, ch chan specResult, sem chan int) {
+	var cacheKey string
 
-	sourcedir = filepath.Dir(specfile)
+	sourcedir := filepath.Dir(specfile)
 	defines := rpm.DefaultDefines()
 	defines[rpm.DistTagDefine] = distTag
 
@@ -153,12 +177,84 @@ func readspec(specfile, distTag, srpmDir string, wg *sync.WaitGroup, ch chan []*
 		wg.Done()
 	}()
 
+	if cache != nil {
+		var err error
+
+		cacheKey, err = specCacheKey(specfile, sharedKey, variants)
+		if err != nil {
+			logger.Log.Warnf("Failed to hash %s, it will not be cached. Error: %v", specfile, err)
+			cache = nil
+		}
+	}
+
+	if cache != nil {
+		cachedPackages, found := cache.lookup(specfile, cacheKey)
+		if found {
+			logger.Log.Debugf("Using cached results for (%s)", specfile)
+			ch <- specResult{specfile: specfile, cacheKey: cacheKey, packages: cachedPackages, cached: true}
+			return
+		}
+	}
+
+	providerList, parsed := readSpecWithDefines(specfile, sourcedir, srpmDir, defines)
+	if !parsed {
+		logger.Log.Warnf(`rpmspec could not parse %s`, specfile)
+		ch <- specResult{specfile: specfile}
+		return
+	}
+
+	result := specResult{specfile: specfile, cacheKey: cacheKey, packages: providerList, cacheable: cache != nil}
+	for i := range variants {
+		variant := &variants[i]
+		variantDefines := variant.rpmDefines()
+
+		allDefines := make(map[string]string)
+		for name, value := range defines {
+			allDefines[name] = value
+		}
+		for name, value := range variantDefines {
+			allDefines[name] = value
+		}
+
+		providerList, parsed = readSpecWithDefines(specfile, sourcedir, srpmDir, allDefines)
+		if !parsed {
+			logger.Log.Warnf(`rpmspec could not parse %s with variant (%s)`, specfile, variant.Name)
+			result.cacheable = false
+			continue
+		}
+
+		for _, provider := range providerList {
+			provider.Variant = variant.Name
+			provider.VariantDefines = variantDefines
+		}
+		result.packages = append(result.packages, providerList...)
+	}
+
+	// Submit the result to the main thread, the defered function will clear the semaphore.
+	ch <- result
+}
+
+// readSpecWithDefines parses every package a spec file provides when it is built with the given defines.
+// Returns false if rpmspec was unable to parse the spec. Specs which can't be built on the current architecture
+// provide no packages.
+func readSpecWithDefines(specfile, sourcedir, srpmDir string, defines map[string]string) (providerList []*pkgjson.Package, parsed bool) {
+	const (
+		emptyQueryFormat      = ""
+		queryProvidedPackages = `srpm %{NAME}-%{VERSION}-%{RELEASE}.src.rpm\n[provides %{PROVIDENEVRS}\n][requires %{REQUIRENEVRS}\n][recommends %{RECOMMENDNEVRS}\n][suggests %{SUGGESTNEVRS}\n][supplements %{SUPPLEMENTNEVRS}\n][enhances %{ENHANCENEVRS}\n][arch %{ARCH}\n]`
+	)
+
+	var (
+		results           []string
+		buildRequiresList []*pkgjson.PackageVer
+		err               error
+	)
+
 	// Sanity check that rpmspec can read the spec file so we dont flood the log with warning if it cant.
 	_, err = rpm.QuerySPEC(specfile, sourcedir, emptyQueryFormat, defines)
 	if err != nil {
-		logger.Log.Warnf(`rpmspec could not parse %s`, specfile)
 		return
 	}
+	parsed = true
 
 	if !specArchMatchesBuild(specfile, sourcedir, defines) {
 		logger.Log.Debugf(`Skipping (%s) since it cannot be built on current architecture.`, specfile)
@@ -183,23 +279,27 @@ func readspec(specfile, distTag, srpmDir string, wg *sync.WaitGroup, ch chan []*
 		providerList[i].SourceDir = sourcedir
 		providerList[i].Requires = condensePackageVersionArray(providerList[i].Requires, specfile)
 		providerList[i].BuildRequires = condensePackageVersionArray(buildRequiresList, specfile)
+		providerList[i].Recommends = condensePackageVersionArray(providerList[i].Recommends, specfile)
+		providerList[i].Suggests = condensePackageVersionArray(providerList[i].Suggests, specfile)
+		providerList[i].Supplements = condensePackageVersionArray(providerList[i].Supplements, specfile)
+		providerList[i].Enhances = condensePackageVersionArray(providerList[i].Enhances, specfile)
 	}
 
-	// Submit the result to the main thread, the defered function will clear the semaphore.
-	ch <- providerList
+	return
 }
 
-// parseProvides parses a newline separated list of Provides, Requires, and Arch from a single spec file.
+// parseProvides parses a newline separated list of Provides, Requires, weak dependencies, and Arch from a single spec file.
 // Several Provides may be in a row, so for each Provide the parser needs to look ahead for the first line that starts
-// with a Require then ingest that line and every subsequent as a Requires until it sees a line that begins with Arch.
+// with a Require (or weak dependency) then ingest that line and every subsequent as a dependency until it sees a line
+// that begins with Arch.
 // Provide: package
 // Require: requiresa = 1.0
 // Require: requiresb
+// Recommends: recommendsa
 // Arch: noarch
 // The return is an array of Package structures, one for each Provides in the spec (implicit and explicit).
 func parseProvides(srpmDir string, list []string) (providerlist []*pkgjson.Package) {
 	var (
-		reqlist      []*pkgjson.PackageVer
 		packagearch  string
 		srpmPath     string
 		listEntry    []string
@@ -224,22 +324,31 @@ func parseProvides(srpmDir string, list []string) (providerlist []*pkgjson.Packa
 
 		if listEntry[tag] == "provides" {
 			logger.Log.Trace("provides ", listEntry[value])
+			newProvider := &pkgjson.Package{SrpmPath: srpmPath}
+			dependencyLists := map[string]*[]*pkgjson.PackageVer{
+				"requires":    &newProvider.Requires,
+				"recommends":  &newProvider.Recommends,
+				"suggests":    &newProvider.Suggests,
+				"supplements": &newProvider.Supplements,
+				"enhances":    &newProvider.Enhances,
+			}
+
 			for _, v := range list[i:] {
 				sublistEntry = minArrayLength(strings.SplitN(v, " ", 2), 2)
-				if sublistEntry[tag] == "requires" {
-					logger.Log.Trace("   requires ", sublistEntry[value])
-					requirePkgVers := parsePackageVersions(sublistEntry[value])
-					filteredRequirePkgVers := filterOutDynamicDependencies(requirePkgVers)
-					reqlist = append(reqlist, filteredRequirePkgVers...)
+				if dependencyList, found := dependencyLists[sublistEntry[tag]]; found {
+					logger.Log.Tracef("   %s %s", sublistEntry[tag], sublistEntry[value])
+					pkgVers := parsePackageVersions(sublistEntry[value])
+					filteredPkgVers := filterOutDynamicDependencies(pkgVers)
+					*dependencyList = append(*dependencyList, filteredPkgVers...)
 				} else if sublistEntry[tag] == "arch" {
 					logger.Log.Trace("   arch ", sublistEntry[value])
 					packagearch = sublistEntry[value]
 					break
 				}
 			}
-			newProviderVer := parsePackageVersions(listEntry[value])[0]
-			providerlist = append(providerlist, &pkgjson.Package{Provides: newProviderVer, SrpmPath: srpmPath, Architecture: packagearch, Requires: reqlist})
-			reqlist = nil
+			newProvider.Provides = parsePackageVersions(listEntry[value])[0]
+			newProvider.Architecture = packagearch
+			providerlist = append(providerlist, newProvider)
 		}
 	}
 
@@ -250,7 +359,8 @@ func parseProvides(srpmDir string, list []string) (providerlist []*pkgjson.Packa
 
 // parsePackageVersions takes a package name and splits it into a set of PackageVer structures.
 // Normally a list of length 1 is returned, however parsePackageVersions is also responsible for
-// identifying if the package name is an "or" condition and returning all options.
+// identifying if the package name is a rich dependency and returning it as a single PackageVer tree.
+// Rich dependencies which can't be parsed fall back to returning every "or" option.
 func parsePackageVersions(packagename string) (newpkgs []*pkgjson.PackageVer) {
 	const (
 		NameField      = iota
@@ -260,9 +370,14 @@ func parsePackageVersions(packagename string) (newpkgs []*pkgjson.PackageVer) {
 
 	packageSplit := minArrayLength(strings.Split(packagename, " "), 1)
 
-	// If first character of the packagename is a "(" then its an "or" condition
+	// If first character of the packagename is a "(" then its a rich dependency
 	if packagename[0] == '(' {
-		return parseOrCondition(packagename)
+		richPkg, err := pkgjson.ParseRichDependency(packagename)
+		if err != nil {
+			logger.Log.Warnf("Failed to parse rich dependency (%s), treating it as an 'OR' clause. Error: %v", packagename, err)
+			return parseOrCondition(packagename)
+		}
+		return append(newpkgs, richPkg)
 	}
 
 	newpkg := &pkgjson.PackageVer{Name: packageSplit[NameField]}
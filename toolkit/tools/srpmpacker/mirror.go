@@ -0,0 +1,443 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/network"
+	"microsoft.com/pkggen/internal/rpm"
+)
+
+// mirrorPartialSuffix is appended to sources while they are being fetched into the mirror.
+// They are only renamed to their final name once their signature has been verified.
+const mirrorPartialSuffix = ".partial"
+
+// mirrorSource is a single source file referenced by one or more SPECs.
+// SPECs may sign the same source with different hash algorithms, every signature must match.
+type mirrorSource struct {
+	fileName     string
+	signatures   []string
+	specFiles    []string
+	localPaths   []string
+	upstreamURLs []string
+}
+
+// specSourcesResult holds the worker results from reading the sources of a SPEC file.
+type specSourcesResult struct {
+	specFile string
+	sources  []*mirrorSource
+	err      error
+}
+
+// mirrorResult holds the worker results from mirroring a single source file.
+type mirrorResult struct {
+	source *mirrorSource
+	origin string
+	err    error
+}
+
+// mirrorAllSources will find all SPEC files in specsDir and place every source they use, with a verified
+// signature, into outDir. outDir is flat so it can be served by a static HTTP server as a --source-url.
+func mirrorAllSources(specsDir, distTag, outDir string, workers int, nestedSourcesDir bool, srcConfig sourceRetrievalConfiguration) (err error) {
+	logger.Log.Infof("Finding all SPEC files")
+	specSearch, err := filepath.Abs(filepath.Join(specsDir, "**/*.spec"))
+	if err != nil {
+		return
+	}
+
+	specFiles, err := filepath.Glob(specSearch)
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(outDir, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	sources, failures := readAllSources(specFiles, distTag, nestedSourcesDir, workers)
+	failures += mirrorSources(sources, outDir, srcConfig, workers)
+	evictSourceCache(srcConfig.sourceCache)
+
+	if failures > 0 {
+		err = fmt.Errorf("encountered %d errors while mirroring sources, see the log for details", failures)
+	}
+	return
+}
+
+// readAllSources will read the sources of every SPEC file and merge them by file name.
+// Returns the sources sorted by file name, along with the number of failures encountered.
+func readAllSources(specFiles []string, distTag string, nestedSourcesDir bool, workers int) (sources []*mirrorSource, failures int) {
+	logger.Log.Infof("Reading the sources of %d SPECs", len(specFiles))
+
+	allSpecFiles := make(chan string, len(specFiles))
+	results := make(chan *specSourcesResult, len(specFiles))
+
+	// Start the workers now so they begin working as soon as a new job is buffered.
+	for i := 0; i < workers; i++ {
+		go specSourcesWorker(allSpecFiles, results, distTag, nestedSourcesDir)
+	}
+
+	for _, specFile := range specFiles {
+		allSpecFiles <- specFile
+	}
+
+	// Signal to the workers that there are no more new spec files
+	close(allSpecFiles)
+
+	sourcesByName := make(map[string]*mirrorSource)
+	for i := 0; i < len(specFiles); i++ {
+		result := <-results
+		if result.err != nil {
+			logger.Log.Errorf("Failed to read the sources of SPEC (%s). Error: %s", result.specFile, result.err)
+			failures++
+			continue
+		}
+
+		for _, source := range result.sources {
+			existing, found := sourcesByName[source.fileName]
+			if !found {
+				sourcesByName[source.fileName] = source
+				continue
+			}
+
+			err := existing.mergeSignatures(source)
+			if err != nil {
+				logger.Log.Errorf("Source (%s) has conflicting signatures in SPECs (%s) and (%s). Error: %s", source.fileName, existing.specFiles[0], source.specFiles[0], err)
+				failures++
+				continue
+			}
+
+			existing.specFiles = append(existing.specFiles, source.specFiles...)
+			existing.localPaths = append(existing.localPaths, source.localPaths...)
+			existing.upstreamURLs = append(existing.upstreamURLs, source.upstreamURLs...)
+		}
+	}
+
+	for _, source := range sourcesByName {
+		sort.Strings(source.specFiles)
+		sort.Strings(source.localPaths)
+		sort.Strings(source.upstreamURLs)
+		sources = append(sources, source)
+	}
+
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].fileName < sources[j].fileName
+	})
+
+	return
+}
+
+// specSourcesWorker will process a channel of spec files and read the sources they use along with their signatures.
+func specSourcesWorker(allSpecFiles chan string, results chan *specSourcesResult, distTag string, nestedSourcesDir bool) {
+	const nestedSourceDirName = "SOURCES"
+
+	for specFile := range allSpecFiles {
+		result := &specSourcesResult{
+			specFile: specFile,
+		}
+
+		defines := rpm.DefaultDefines()
+		defines[rpm.DistTagDefine] = distTag
+
+		sourceDir := filepath.Dir(specFile)
+		if nestedSourcesDir {
+			sourceDir = filepath.Join(sourceDir, nestedSourceDirName)
+		}
+
+		result.sources, result.err = readSpecSources(specFile, sourceDir, defines)
+		results <- result
+	}
+}
+
+// readSpecSources will return every source used by a SPEC file, along with its signature, any local copy of it
+// and the upstream URL it is published at.
+func readSpecSources(specFile, sourceDir string, defines map[string]string) (sources []*mirrorSource, err error) {
+	fileNames, err := readSPECTagArray(specFile, sourceDir, "SOURCE", defines)
+	if err != nil {
+		if err.Error() == rpm.NoCompatibleArchError {
+			logger.Log.Infof("Skipping SPEC (%s) due to incompatible build architecture", specFile)
+			err = nil
+		}
+		return
+	}
+
+	if len(fileNames) == 0 {
+		return
+	}
+
+	signatures, err := readSignatures(specPathToSignaturesPath(specFile))
+	if err != nil {
+		return
+	}
+
+	upstreamURLs, err := readUpstreamURLs(specFile, sourceDir, defines)
+	if err != nil {
+		logger.Log.Warnf("Unable to read the upstream source URLs of SPEC (%s), only --source-url and local copies will be used. Error: %s", specFile, err)
+		err = nil
+	}
+
+	for _, fileName := range fileNames {
+		signature, found := signatures[fileName]
+		if !found {
+			err = fmt.Errorf("no signature for source (%s)", fileName)
+			return
+		}
+
+		source := &mirrorSource{
+			fileName:   fileName,
+			signatures: []string{signature},
+			specFiles:  []string{specFile},
+		}
+
+		localPath := filepath.Join(sourceDir, fileName)
+		if exists, _ := file.PathExists(localPath); exists {
+			source.localPaths = append(source.localPaths, localPath)
+		}
+
+		if url, found := upstreamURLs[fileName]; found {
+			source.upstreamURLs = append(source.upstreamURLs, url)
+		}
+
+		sources = append(sources, source)
+	}
+
+	return
+}
+
+// readUpstreamURLs will return the upstream URL of every remote source in a SPEC file, keyed by file name.
+func readUpstreamURLs(specFile, sourceDir string, defines map[string]string) (upstreamURLs map[string]string, err error) {
+	sources, err := rpm.QuerySPECSources(specFile, sourceDir, defines)
+	if err != nil {
+		return
+	}
+
+	upstreamURLs = make(map[string]string)
+	for _, source := range sources {
+		if strings.Contains(source, "://") {
+			upstreamURLs[filepath.Base(source)] = source
+		}
+	}
+
+	return
+}
+
+// mirrorSources will place every source into outDir. Returns the number of sources which could not be mirrored.
+func mirrorSources(sources []*mirrorSource, outDir string, srcConfig sourceRetrievalConfiguration, workers int) (failures int) {
+	logger.Log.Infof("Mirroring %d sources into (%s)", len(sources), outDir)
+
+	allSources := make(chan *mirrorSource, len(sources))
+	results := make(chan *mirrorResult, len(sources))
+
+	// Start the workers now so they begin working as soon as a new job is buffered.
+	for i := 0; i < workers; i++ {
+		go mirrorSourceWorker(allSources, results, outDir, srcConfig)
+	}
+
+	for _, source := range sources {
+		allSources <- source
+	}
+
+	// Signal to the workers that there are no more new sources
+	close(allSources)
+
+	originCounts := make(map[string]int)
+	for i := 0; i < len(sources); i++ {
+		result := <-results
+		if result.err != nil {
+			logger.Log.Errorf("Failed to mirror source (%s) used by %v. Error: %s", result.source.fileName, result.source.specFiles, result.err)
+			failures++
+			continue
+		}
+
+		logger.Log.Debugf("Mirrored source (%s) from (%s)", result.source.fileName, result.origin)
+		originCounts[originKind(result.origin)]++
+	}
+
+	logger.Log.Infof("Mirrored %d/%d sources: %d already present, %d placed from the source cache, %d copied from SPEC directories, %d downloaded",
+		len(sources)-failures, len(sources), originCounts[originMirror], originCounts[originCache], originCounts[originLocal], originCounts[originRemote])
+	return
+}
+
+// Kinds of origins a mirrored source can come from
+const (
+	originMirror = "mirror"
+	originCache  = "cache"
+	originLocal  = "local"
+	originRemote = "remote"
+)
+
+// originKind returns the kind of origin a source was mirrored from.
+func originKind(origin string) string {
+	switch {
+	case origin == originMirror, origin == originCache:
+		return origin
+	case strings.Contains(origin, "://"):
+		return originRemote
+	default:
+		return originLocal
+	}
+}
+
+// mirrorSourceWorker will process a channel of sources to mirror.
+func mirrorSourceWorker(allSources chan *mirrorSource, results chan *mirrorResult, outDir string, srcConfig sourceRetrievalConfiguration) {
+	for source := range allSources {
+		result := &mirrorResult{
+			source: source,
+		}
+
+		result.origin, result.err = mirrorSingleSource(source, outDir, srcConfig)
+		results <- result
+	}
+}
+
+// mirrorSingleSource will place a source into outDir, verifying its signature. An existing copy with a valid signature
+// is kept, otherwise the source is placed from the source cache, copied from a SPEC directory or downloaded from
+// --source-url, falling back to its upstream URL. Returns where the source was mirrored from.
+func mirrorSingleSource(source *mirrorSource, outDir string, srcConfig sourceRetrievalConfiguration) (origin string, err error) {
+	destinationFile := filepath.Join(outDir, source.fileName)
+
+	exists, err := file.PathExists(destinationFile)
+	if err != nil {
+		return
+	}
+
+	if exists {
+		if source.signaturesMatch(destinationFile) {
+			origin = originMirror
+			return
+		}
+		logger.Log.Warnf("Mirrored source (%s) does not match its signature, fetching it again", destinationFile)
+	}
+
+	var candidates []string
+	candidates = append(candidates, source.localPaths...)
+	if srcConfig.sourceURL != "" {
+		candidates = append(candidates, network.JoinURL(srcConfig.sourceURL, source.fileName))
+	}
+	candidates = append(candidates, source.upstreamURLs...)
+
+	partialFile := destinationFile + mirrorPartialSuffix
+	os.Remove(partialFile)
+	defer os.Remove(partialFile)
+
+	if srcConfig.sourceCache != nil {
+		if placeFromCache(source, partialFile, srcConfig) {
+			origin = originCache
+			err = os.Rename(partialFile, destinationFile)
+			return
+		}
+
+		// Never write other candidates into a file which may still be linked to the cache.
+		os.Remove(partialFile)
+	}
+
+	for _, candidate := range candidates {
+		var fetchErr error
+
+		if strings.Contains(candidate, "://") {
+			fetchErr = downloadFile(candidate, partialFile, srcConfig)
+		} else {
+			fetchErr = file.Copy(candidate, partialFile)
+		}
+
+		if fetchErr != nil {
+			logger.Log.Warnf("Failed to fetch source (%s) from (%s). Error: %s", source.fileName, candidate, fetchErr)
+			continue
+		}
+
+		if !source.signaturesMatch(partialFile) {
+			logger.Log.Warnf("Source (%s) fetched from (%s) does not match its signatures %v", source.fileName, candidate, source.signatures)
+			continue
+		}
+
+		origin = candidate
+		err = os.Rename(partialFile, destinationFile)
+		if err == nil && srcConfig.sourceCache != nil {
+			_, cacheErr := srcConfig.sourceCache.Add(destinationFile)
+			if cacheErr != nil {
+				logger.Log.Warnf("Failed to add (%s) to the source cache. Error: %s", destinationFile, cacheErr)
+			}
+		}
+		return
+	}
+
+	err = fmt.Errorf("no copy matching signatures %v found in %d locations", source.signatures, len(candidates))
+	return
+}
+
+// placeFromCache will place a source from the source cache at path. Returns true if it was found with valid signatures.
+// The cache is keyed by SHA256, so only sources with a SHA256 signature can be found in it.
+func placeFromCache(source *mirrorSource, path string, srcConfig sourceRetrievalConfiguration) (placed bool) {
+	for _, signature := range source.signatures {
+		algorithm, hash := parseSignature(signature)
+		if algorithm != file.HashSHA256 {
+			continue
+		}
+
+		found, err := srcConfig.sourceCache.Place(hash, path)
+		if err != nil {
+			logger.Log.Warnf("Failed to place source (%s) from the source cache. Error: %s", source.fileName, err)
+			return
+		}
+
+		placed = found && source.signaturesMatch(path)
+		return
+	}
+
+	return
+}
+
+// mergeSignatures will add the signatures of another SPEC's copy of the source.
+// Returns an error if both sign the source with the same algorithm but a different hash.
+func (m *mirrorSource) mergeSignatures(other *mirrorSource) (err error) {
+	for _, otherSignature := range other.signatures {
+		otherAlgorithm, _ := parseSignature(otherSignature)
+
+		isNew := true
+		for _, signature := range m.signatures {
+			algorithm, _ := parseSignature(signature)
+			if algorithm != otherAlgorithm {
+				continue
+			}
+
+			if !signaturesMatch(signature, otherSignature) {
+				return fmt.Errorf("signature (%s) does not match (%s)", signature, otherSignature)
+			}
+			isNew = false
+		}
+
+		if isNew {
+			m.signatures = append(m.signatures, otherSignature)
+		}
+	}
+
+	return
+}
+
+// signaturesMatch returns true if the file at path matches every signature of the source.
+func (m *mirrorSource) signaturesMatch(path string) bool {
+	for _, signature := range m.signatures {
+		algorithm, _ := parseSignature(signature)
+
+		actualSignature, err := fileSignature(path, algorithm)
+		if err != nil {
+			logger.Log.Warnf("Failed to hash (%s). Error: %s", path, err)
+			return false
+		}
+
+		if !signaturesMatch(signature, actualSignature) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,303 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"microsoft.com/pkggen/internal/jsonutils"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/rpm"
+	"microsoft.com/pkggen/internal/sliceutils"
+)
+
+// signatureMismatch is a source whose local copy does not match the signature recorded for it.
+type signatureMismatch struct {
+	File     string `json:"File"`
+	Expected string `json:"Expected"`
+	Actual   string `json:"Actual"`
+}
+
+// addedSignature is a local source without a signature which was added to the signatures file.
+type addedSignature struct {
+	File      string `json:"File"`
+	Signature string `json:"Signature"`
+}
+
+// specSignatureReport lists the problems found in the signatures file of a single SPEC.
+type specSignatureReport struct {
+	Spec           string              `json:"Spec"`
+	SignaturesFile string              `json:"SignaturesFile"`
+	Missing        []string            `json:"Missing,omitempty"`
+	Extra          []string            `json:"Extra,omitempty"`
+	Mismatched     []signatureMismatch `json:"Mismatched,omitempty"`
+	Added          []addedSignature    `json:"Added,omitempty"`
+	Updated        bool                `json:"Updated"`
+	Error          string              `json:"Error,omitempty"`
+}
+
+// signatureReport is the machine-readable report of the signatures command. Only SPECs with problems are listed.
+type signatureReport struct {
+	Specs []*specSignatureReport `json:"Specs"`
+}
+
+// hasProblems returns true if the SPEC still has problems after the command ran. Extra and mismatched entries are
+// fixed when updating and local sources without a signature are added, sources missing both a signature and a local
+// copy are not.
+func (r *specSignatureReport) hasProblems(update bool) bool {
+	if r.Error != "" || len(r.Missing) != 0 {
+		return true
+	}
+	return !update && (len(r.Extra) != 0 || len(r.Mismatched) != 0)
+}
+
+// hasFindings returns true if the report lists anything for the SPEC.
+func (r *specSignatureReport) hasFindings() bool {
+	return r.Error != "" || len(r.Missing) != 0 || len(r.Extra) != 0 || len(r.Mismatched) != 0 || len(r.Added) != 0
+}
+
+// auditAllSignatures will check the signatures file of every SPEC file in specsDir against the sources the SPEC uses.
+// If update is set, extra and mismatched entries are fixed by rewriting the signatures file. Returns the number of
+// SPECs which still have problems.
+func auditAllSignatures(specsDir, distTag, signatureAlgorithm string, workers int, nestedSourcesDir, update bool, reportFile string) (problems int, err error) {
+	logger.Log.Infof("Finding all SPEC files")
+	specSearch, err := filepath.Abs(filepath.Join(specsDir, "**/*.spec"))
+	if err != nil {
+		return
+	}
+
+	specFiles, err := filepath.Glob(specSearch)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Checking the signatures of %d SPECs", len(specFiles))
+
+	allSpecFiles := make(chan string, len(specFiles))
+	results := make(chan *specSignatureReport, len(specFiles))
+
+	// Start the workers now so they begin working as soon as a new job is buffered.
+	for i := 0; i < workers; i++ {
+		go auditSignaturesWorker(allSpecFiles, results, distTag, signatureAlgorithm, nestedSourcesDir, update)
+	}
+
+	for _, specFile := range specFiles {
+		allSpecFiles <- specFile
+	}
+
+	// Signal to the workers that there are no more new spec files
+	close(allSpecFiles)
+
+	report := signatureReport{
+		Specs: []*specSignatureReport{},
+	}
+	for i := 0; i < len(specFiles); i++ {
+		result := <-results
+		if !result.hasFindings() {
+			continue
+		}
+
+		logSignatureReport(result)
+		report.Specs = append(report.Specs, result)
+		if result.hasProblems(update) {
+			problems++
+		}
+	}
+
+	sort.Slice(report.Specs, func(i, j int) bool {
+		return report.Specs[i].Spec < report.Specs[j].Spec
+	})
+
+	if reportFile != "" {
+		err = jsonutils.WriteJSONFile(reportFile, report)
+		if err != nil {
+			return
+		}
+	}
+
+	logger.Log.Infof("Found signature problems in %d/%d SPECs", problems, len(specFiles))
+	return
+}
+
+// auditSignaturesWorker will process a channel of spec files and check their signatures files.
+func auditSignaturesWorker(allSpecFiles chan string, results chan *specSignatureReport, distTag, signatureAlgorithm string, nestedSourcesDir, update bool) {
+	const nestedSourceDirName = "SOURCES"
+
+	for specFile := range allSpecFiles {
+		report := &specSignatureReport{
+			Spec:           specFile,
+			SignaturesFile: specPathToSignaturesPath(specFile),
+		}
+
+		defines := rpm.DefaultDefines()
+		defines[rpm.DistTagDefine] = distTag
+
+		sourceDir := filepath.Dir(specFile)
+		if nestedSourcesDir {
+			sourceDir = filepath.Join(sourceDir, nestedSourceDirName)
+		}
+
+		err := auditSpecSignatures(report, sourceDir, signatureAlgorithm, defines, update)
+		if err != nil {
+			report.Error = err.Error()
+		}
+
+		results <- report
+	}
+}
+
+// auditSpecSignatures will compare the signatures file of a SPEC with the sources it uses and fill in report.
+// Sources are only hashed if a local copy exists, as done when packing. If update is set, the signatures file is
+// rewritten when any entry changed, new entries use signatureAlgorithm.
+func auditSpecSignatures(report *specSignatureReport, sourceDir, signatureAlgorithm string, defines map[string]string, update bool) (err error) {
+	fileNames, err := readSPECTagArray(report.Spec, sourceDir, "SOURCE", defines)
+	if err != nil {
+		if err.Error() == rpm.NoCompatibleArchError {
+			logger.Log.Infof("Skipping SPEC (%s) due to incompatible build architecture", report.Spec)
+			err = nil
+		}
+		return
+	}
+
+	signatures, err := readSignatures(report.SignaturesFile)
+	if err != nil {
+		return
+	}
+
+	localSources, err := findLocalSources(filepath.Dir(report.SignaturesFile), fileNames)
+	if err != nil {
+		return
+	}
+
+	newSignatures := make(map[string]string)
+	for _, fileName := range fileNames {
+		expectedSignature, found := signatures[fileName]
+
+		localPath, isLocal := localSources[fileName]
+		if !isLocal {
+			if found {
+				newSignatures[fileName] = expectedSignature
+			} else {
+				report.Missing = append(report.Missing, fileName)
+			}
+			continue
+		}
+
+		var actualSignature string
+
+		algorithm := signatureAlgorithm
+		if found {
+			algorithm, _ = parseSignature(expectedSignature)
+		}
+
+		actualSignature, err = fileSignature(localPath, algorithm)
+		if err != nil {
+			return
+		}
+
+		switch {
+		case !found && update:
+			newSignatures[fileName] = actualSignature
+			report.Added = append(report.Added, addedSignature{File: fileName, Signature: actualSignature})
+		case !found:
+			report.Missing = append(report.Missing, fileName)
+		case !signaturesMatch(expectedSignature, actualSignature):
+			newSignatures[fileName] = actualSignature
+			report.Mismatched = append(report.Mismatched, signatureMismatch{File: fileName, Expected: expectedSignature, Actual: actualSignature})
+		default:
+			newSignatures[fileName] = expectedSignature
+		}
+	}
+
+	for fileName := range signatures {
+		if sliceutils.Find(fileNames, fileName) == -1 {
+			report.Extra = append(report.Extra, fileName)
+		}
+	}
+
+	sort.Strings(report.Missing)
+	sort.Strings(report.Extra)
+	sort.Slice(report.Mismatched, func(i, j int) bool {
+		return report.Mismatched[i].File < report.Mismatched[j].File
+	})
+	sort.Slice(report.Added, func(i, j int) bool {
+		return report.Added[i].File < report.Added[j].File
+	})
+
+	if !update || reflect.DeepEqual(signatures, newSignatures) {
+		return
+	}
+
+	// Do not create a signatures file for SPECs without any signed sources.
+	if len(newSignatures) == 0 {
+		err = os.Remove(report.SignaturesFile)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		err = jsonutils.WriteJSONFile(report.SignaturesFile, fileSignaturesWrapper{FileSignatures: newSignatures})
+	}
+
+	report.Updated = err == nil
+	return
+}
+
+// findLocalSources will search dir for the given source files. Returns the path of the first copy found of each.
+func findLocalSources(dir string, fileNames []string) (localSources map[string]string, err error) {
+	wanted := make(map[string]bool)
+	for _, fileName := range fileNames {
+		wanted[fileName] = true
+	}
+
+	localSources = make(map[string]string)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() || !wanted[info.Name()] {
+			return nil
+		}
+
+		if _, found := localSources[info.Name()]; found {
+			logger.Log.Warnf("Duplicate matching file found at (%s), skipping", path)
+			return nil
+		}
+
+		localSources[info.Name()] = path
+		return nil
+	})
+
+	return
+}
+
+// logSignatureReport will log the findings for a single SPEC.
+func logSignatureReport(report *specSignatureReport) {
+	if report.Error != "" {
+		logger.Log.Errorf("Failed to check the signatures of SPEC (%s). Error: %s", report.Spec, report.Error)
+	}
+
+	for _, fileName := range report.Missing {
+		logger.Log.Errorf("(%s): no signature for source (%s)", report.SignaturesFile, fileName)
+	}
+
+	for _, fileName := range report.Extra {
+		logger.Log.Warnf("(%s): signature for unused source (%s)", report.SignaturesFile, fileName)
+	}
+
+	for _, added := range report.Added {
+		logger.Log.Warnf("(%s): adding signature (%s) for source (%s)", report.SignaturesFile, added.Signature, added.File)
+	}
+
+	for _, mismatch := range report.Mismatched {
+		logger.Log.Warnf("(%s): source (%s) has signature (%s), expected (%s)", report.SignaturesFile, mismatch.File, mismatch.Actual, mismatch.Expected)
+	}
+
+	if report.Updated {
+		logger.Log.Infof("Updated (%s)", report.SignaturesFile)
+	}
+}
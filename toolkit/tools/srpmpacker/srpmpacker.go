@@ -21,6 +21,7 @@ import (
 	"microsoft.com/pkggen/internal/jsonutils"
 	"microsoft.com/pkggen/internal/retry"
 	"microsoft.com/pkggen/internal/rpm"
+	"microsoft.com/pkggen/internal/sourcecache"
 
 	"microsoft.com/pkggen/internal/directory"
 	"microsoft.com/pkggen/internal/file"
@@ -72,8 +73,11 @@ type sourceRetrievalConfiguration struct {
 	caCerts        *x509.CertPool
 	tlsCerts       []tls.Certificate
 
-	signatureHandling signatureHandlingType
-	signatureLookup   map[string]string
+	signatureHandling  signatureHandlingType
+	signatureLookup    map[string]string
+	signatureAlgorithm string
+
+	sourceCache *sourcecache.Cache
 }
 
 // packResult holds the worker results from packing a SPEC file into an SRPM.
@@ -93,7 +97,8 @@ var (
 	app = kingpin.New("srpmpacker", "A tool to package a SRPM.")
 
 	specsDir = exe.InputDirFlag(app, "Path to the SPEC directory to create SRPMs from.")
-	outDir   = exe.OutputDirFlag(app, "Directory to place the output SRPM.")
+	// Not required as the signatures command does not produce any output, see main.
+	outDir   = app.Flag("output-dir", "Directory to place the output SRPMs, or the sources when mirroring. Required by the pack and mirror commands.").String()
 	logFile  = exe.LogFileFlag(app)
 	logLevel = exe.LogLevelFlag(app)
 
@@ -111,19 +116,35 @@ var (
 	tlsClientCert = app.Flag("tls-cert", "TLS client certificate to use when downloading files.").String()
 	tlsClientKey  = app.Flag("tls-key", "TLS client key to use when downloading files.").String()
 
+	sourceCacheDir  = app.Flag("source-cache-dir", "Directory of a cache of source files, keyed by their hash, shared between runs. Disabled if empty.").String()
+	sourceCacheSize = app.Flag("source-cache-size", "Size the source cache is trimmed to after each run, evicting the least recently used files first (e.g. 20GB). 0 keeps every file.").Default("0").Bytes()
+
 	validSignatureLevels = []string{signatureEnforceString, signatureSkipCheckString, signatureUpdateString}
 	signatureHandling    = app.Flag("signature-handling", "Specifies how to handle signature mismatches for source files.").Default(signatureEnforceString).PlaceHolder(exe.PlaceHolderize(validSignatureLevels)).Enum(validSignatureLevels...)
+	signatureAlgorithm   = app.Flag("signature-algorithm", "Hash algorithm used for new entries when updating signatures files. Existing entries keep their algorithm.").Default(file.HashSHA256).PlaceHolder(exe.PlaceHolderize(file.HashAlgorithms)).Enum(file.HashAlgorithms...)
+
+	packCmd   = app.Command("pack", "Pack SRPMs from the SPEC files in --dir into --output-dir. This is the default command.").Default()
+	mirrorCmd = app.Command("mirror", "Download and verify every source used by the SPEC files in --dir into --output-dir, which can then be served as a --source-url.")
+
+	signaturesCmd       = app.Command("signatures", "Check the signatures files of the SPEC files in --dir against their local sources without packing.")
+	signaturesVerifyCmd = signaturesCmd.Command("verify", "Report missing, extra and mismatched signatures. Exits with an error if any are found.")
+	signaturesUpdateCmd = signaturesCmd.Command("update", "Rewrite the signatures files whose entries are extra or do not match their local sources. Exits with an error if a source has neither a signature nor a local copy.")
+	signaturesReport    = signaturesCmd.Flag("report-file", "Path to write a JSON report of the SPECs with signature problems to.").String()
 )
 
 func main() {
 	app.Version(exe.ToolkitVersion)
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
 	logger.InitBestEffort(*logFile, *logLevel)
 
 	if *workers <= 0 {
 		logger.Log.Fatalf("Value in --workers must be greater than zero. Found %d", *workers)
 	}
 
+	if *outDir == "" && (command == packCmd.FullCommand() || command == mirrorCmd.FullCommand()) {
+		logger.Log.Fatalf("The %s command requires --output-dir", command)
+	}
+
 	// Override the host's RPM config dir
 	_, err := rpm.SetMacroDir(*macroDir)
 	logger.PanicOnError(err, "Unable to set rpm macro directory (%s). Error: %v", *macroDir, err)
@@ -131,6 +152,10 @@ func main() {
 	// Create a template configuration that all packed SRPM will be based on.
 	var templateSrcConfig sourceRetrievalConfiguration
 
+	if command == mirrorCmd.FullCommand() && *signatureHandling != signatureEnforceString {
+		logger.Log.Fatalf("The mirror command always enforces signatures, --signature-handling=%s is not supported", *signatureHandling)
+	}
+
 	switch *signatureHandling {
 	case signatureEnforceString:
 		templateSrcConfig.signatureHandling = signatureEnforce
@@ -144,6 +169,8 @@ func main() {
 		logger.Log.Fatalf("Invalid signature handling encountered: %s. Allowed: %s", *signatureHandling, validSignatureLevels)
 	}
 
+	templateSrcConfig.signatureAlgorithm = *signatureAlgorithm
+
 	// Setup remote source configuration
 	templateSrcConfig.sourceURL = *sourceURL
 	templateSrcConfig.caCerts, err = x509.SystemCertPool()
@@ -166,7 +193,23 @@ func main() {
 		templateSrcConfig.tlsCerts = append(templateSrcConfig.tlsCerts, cert)
 	}
 
-	err = createAllSRPMs(*specsDir, *distTag, *buildDir, *outDir, *workers, *nestedSourcesDir, *repackAll, templateSrcConfig)
+	if *sourceCacheDir != "" {
+		templateSrcConfig.sourceCache, err = sourcecache.New(*sourceCacheDir, int64(*sourceCacheSize))
+		logger.PanicOnError(err, "Unable to create source cache (%s)", *sourceCacheDir)
+	}
+
+	switch command {
+	case mirrorCmd.FullCommand():
+		err = mirrorAllSources(*specsDir, *distTag, *outDir, *workers, *nestedSourcesDir, templateSrcConfig)
+	case packCmd.FullCommand():
+		err = createAllSRPMs(*specsDir, *distTag, *buildDir, *outDir, *workers, *nestedSourcesDir, *repackAll, templateSrcConfig)
+	case signaturesVerifyCmd.FullCommand(), signaturesUpdateCmd.FullCommand():
+		var problems int
+		problems, err = auditAllSignatures(*specsDir, *distTag, *signatureAlgorithm, *workers, *nestedSourcesDir, command == signaturesUpdateCmd.FullCommand(), *signaturesReport)
+		if err == nil && problems > 0 {
+			logger.Log.Fatalf("Found signature problems in %d SPECs", problems)
+		}
+	}
 	if err != nil {
 		logger.Log.Panic(err)
 	}
@@ -191,9 +234,31 @@ func createAllSRPMs(specsDir, distTag, buildDir, outDir string, workers int, nes
 	}
 
 	err = packSRPMs(specStates, distTag, buildDir, templateSrcConfig, workers)
+	if err != nil {
+		return
+	}
+
+	evictSourceCache(templateSrcConfig.sourceCache)
 	return
 }
 
+// evictSourceCache will trim the source cache, if any, to its maximum size.
+func evictSourceCache(cache *sourcecache.Cache) {
+	if cache == nil {
+		return
+	}
+
+	removed, err := cache.Evict()
+	if err != nil {
+		logger.Log.Warnf("Failed to trim the source cache. Error: %s", err)
+		return
+	}
+
+	if removed > 0 {
+		logger.Log.Infof("Evicted %d files from the source cache", removed)
+	}
+}
+
 // calculateSPECsToRepack will check which SPECs should be packed.
 // If the resulting SRPM does not exist, or is older than a modification to
 // one of the files used by the SPEC then it is repacked.
@@ -548,9 +613,11 @@ func hydrateFiles(fileTypeToHydrate fileType, specFile, workingDir string, srcCo
 	const (
 		downloadMissingPatchFiles = false
 		skipPatchSignatures       = true
+		cachePatchFiles           = false
 
 		downloadMissingSourceFiles = true
 		skipSourceSignatures       = false
+		cacheSourceFiles           = true
 
 		patchTag  = "PATCH"
 		sourceTag = "SOURCE"
@@ -560,6 +627,7 @@ func hydrateFiles(fileTypeToHydrate fileType, specFile, workingDir string, srcCo
 		specTag               string
 		hydrateRemotely       bool
 		skipSignatureHandling bool
+		useCache              bool
 	)
 
 	switch fileTypeToHydrate {
@@ -567,10 +635,12 @@ func hydrateFiles(fileTypeToHydrate fileType, specFile, workingDir string, srcCo
 		specTag = patchTag
 		hydrateRemotely = downloadMissingPatchFiles
 		skipSignatureHandling = skipPatchSignatures
+		useCache = cachePatchFiles
 	case fileTypeSource:
 		specTag = sourceTag
 		hydrateRemotely = downloadMissingSourceFiles
 		skipSignatureHandling = skipSourceSignatures
+		useCache = cacheSourceFiles
 	default:
 		return fmt.Errorf("invalid filetype (%d)", fileTypeToHydrate)
 	}
@@ -588,6 +658,14 @@ func hydrateFiles(fileTypeToHydrate fileType, specFile, workingDir string, srcCo
 		fileHydrationState[fileNeeded] = false
 	}
 
+	useCache = useCache && srcConfig.sourceCache != nil
+
+	// Files found in the cache are placed without copying them. The signatures file decides which contents are
+	// expected, so the cache is only used when signatures are enforced.
+	if useCache && srcConfig.signatureHandling == signatureEnforce {
+		hydrateFromCache(fileHydrationState, newSourceDir, srcConfig, currentSignatures)
+	}
+
 	// If the user provided an existing source dir, prefer it over remote sources.
 	if srcConfig.localSourceDir != "" {
 		err = hydrateFromLocalSource(fileHydrationState, newSourceDir, srcConfig, skipSignatureHandling, currentSignatures)
@@ -607,9 +685,56 @@ func hydrateFiles(fileTypeToHydrate fileType, specFile, workingDir string, srcCo
 		}
 	}
 
+	if useCache {
+		addToCache(fileHydrationState, newSourceDir, srcConfig)
+	}
+
 	return nil
 }
 
+// hydrateFromCache will place any file found in the source cache into newSourceDir.
+// Files found are removed from fileHydrationState so no other source replaces them.
+// Will alter `currentSignatures`.
+func hydrateFromCache(fileHydrationState map[string]bool, newSourceDir string, srcConfig sourceRetrievalConfiguration, currentSignatures map[string]string) {
+	for fileName := range fileHydrationState {
+		signature, found := srcConfig.signatureLookup[fileName]
+		if !found {
+			continue
+		}
+
+		// The cache is keyed by SHA256, sources signed with other algorithms are always hydrated from elsewhere.
+		algorithm, hash := parseSignature(signature)
+		if algorithm != file.HashSHA256 {
+			continue
+		}
+
+		placed, err := srcConfig.sourceCache.Place(hash, filepath.Join(newSourceDir, fileName))
+		if err != nil {
+			logger.Log.Warnf("Failed to hydrate (%s) from the source cache. Error: %s", fileName, err)
+			continue
+		}
+
+		if !placed {
+			continue
+		}
+
+		currentSignatures[fileName] = signature
+		delete(fileHydrationState, fileName)
+		logger.Log.Debugf("Hydrated (%s) from the source cache", fileName)
+	}
+}
+
+// addToCache will add the hydrated files in newSourceDir to the source cache.
+func addToCache(fileHydrationState map[string]bool, newSourceDir string, srcConfig sourceRetrievalConfiguration) {
+	for fileName := range fileHydrationState {
+		path := filepath.Join(newSourceDir, fileName)
+		_, err := srcConfig.sourceCache.Add(path)
+		if err != nil {
+			logger.Log.Warnf("Failed to add (%s) to the source cache. Error: %s", path, err)
+		}
+	}
+}
+
 // hydrateFromLocalSource will update fileHydrationState.
 // Will alter currentSignatures.
 func hydrateFromLocalSource(fileHydrationState map[string]bool, newSourceDir string, srcConfig sourceRetrievalConfiguration, skipSignatureHandling bool, currentSignatures map[string]string) (err error) {
@@ -657,11 +782,6 @@ func hydrateFromLocalSource(fileHydrationState map[string]bool, newSourceDir str
 // hydrateFromRemoteSource will update fileHydrationState.
 // Will alter `currentSignatures`.
 func hydrateFromRemoteSource(fileHydrationState map[string]bool, newSourceDir string, srcConfig sourceRetrievalConfiguration, skipSignatureHandling bool, currentSignatures map[string]string) {
-	const (
-		downloadRetryAttempts = 3
-		downloadRetryDuration = time.Second
-	)
-
 	for fileName, alreadyHydrated := range fileHydrationState {
 		if alreadyHydrated {
 			continue
@@ -671,15 +791,7 @@ func hydrateFromRemoteSource(fileHydrationState map[string]bool, newSourceDir st
 
 		url := network.JoinURL(srcConfig.sourceURL, fileName)
 
-		err := retry.Run(func() error {
-			err := network.DownloadFile(url, destinationFile, srcConfig.caCerts, srcConfig.tlsCerts)
-			if err != nil {
-				logger.Log.Warnf("Failed to download (%s). Error: %s", url, err)
-			}
-
-			return err
-		}, downloadRetryAttempts, downloadRetryDuration)
-
+		err := downloadFile(url, destinationFile, srcConfig)
 		if err != nil {
 			continue
 		}
@@ -705,7 +817,64 @@ func hydrateFromRemoteSource(fileHydrationState map[string]bool, newSourceDir st
 	}
 }
 
-// validateSignature will compare the SHA256 of the file at path against the signature for it in srcConfig.signatureLookup
+// downloadFile will download url to destinationFile, retrying on failure.
+func downloadFile(url, destinationFile string, srcConfig sourceRetrievalConfiguration) (err error) {
+	const (
+		downloadRetryAttempts = 3
+		downloadRetryDuration = time.Second
+	)
+
+	return retry.Run(func() error {
+		err := network.DownloadFile(url, destinationFile, srcConfig.caCerts, srcConfig.tlsCerts)
+		if err != nil {
+			logger.Log.Warnf("Failed to download (%s). Error: %s", url, err)
+		}
+
+		return err
+	}, downloadRetryAttempts, downloadRetryDuration)
+}
+
+// parseSignature splits a signature into its hash algorithm and its hex encoded hash.
+// Signatures may carry their algorithm as a prefix (e.g. "sha512:<hash>"), signatures without one are SHA256.
+func parseSignature(signature string) (algorithm, hash string) {
+	const algorithmSeparator = ":"
+
+	algorithm = file.HashSHA256
+	hash = signature
+
+	separatorIndex := strings.Index(signature, algorithmSeparator)
+	if separatorIndex != -1 {
+		algorithm = strings.ToLower(signature[:separatorIndex])
+		hash = signature[separatorIndex+len(algorithmSeparator):]
+	}
+
+	return
+}
+
+// fileSignature returns the signature of the file at path using the given hash algorithm.
+// SHA256 signatures are written without an algorithm prefix so existing signatures files are unchanged.
+func fileSignature(path, algorithm string) (signature string, err error) {
+	hash, err := file.GenerateHash(path, algorithm)
+	if err != nil {
+		return
+	}
+
+	signature = hash
+	if algorithm != file.HashSHA256 {
+		signature = fmt.Sprintf("%s:%s", algorithm, hash)
+	}
+
+	return
+}
+
+// signaturesMatch returns true if both signatures use the same hash algorithm and hold the same hash.
+func signaturesMatch(signature, otherSignature string) bool {
+	algorithm, hash := parseSignature(signature)
+	otherAlgorithm, otherHash := parseSignature(otherSignature)
+	return algorithm == otherAlgorithm && strings.EqualFold(hash, otherHash)
+}
+
+// validateSignature will compare the hash of the file at path against the signature for it in srcConfig.signatureLookup
 // Will skip if signature handling is set to skip.
 // Will alter `currentSignatures`.
 func validateSignature(path string, srcConfig sourceRetrievalConfiguration, currentSignatures map[string]string) (err error) {
@@ -720,12 +889,17 @@ func validateSignature(path string, srcConfig sourceRetrievalConfiguration, curr
 		return
 	}
 
-	newSignature, err := file.GenerateSHA256(path)
+	algorithm := srcConfig.signatureAlgorithm
+	if found {
+		algorithm, _ = parseSignature(expectedSignature)
+	}
+
+	newSignature, err := fileSignature(path, algorithm)
 	if err != nil {
 		return
 	}
 
-	if strings.EqualFold(expectedSignature, newSignature) {
+	if signaturesMatch(expectedSignature, newSignature) {
 		currentSignatures[fileName] = newSignature
 	} else {
 		if srcConfig.signatureHandling == signatureUpdate {
@@ -31,6 +31,7 @@ type Unravel interface {
 // GraphToMaps takes PkgGraph and returns two blockPkgsLists,
 // The first one says what packages are blocking a queried package
 // The second one says what packages are blocked by a queried package
+// Packages are keyed by their build key, so conditional build variants are listed separately from their SRPM
 func GraphToMaps(g *pkggraph.PkgGraph) (blocking, blockedBy BlockPkgsList) {
 	blocking = make(BlockPkgsList)
 	blockedBy = make(BlockPkgsList)
@@ -40,31 +41,33 @@ func GraphToMaps(g *pkggraph.PkgGraph) (blocking, blockedBy BlockPkgsList) {
 
 	for potentialDependencies.Next() {
 		dep := potentialDependencies.Node().(*pkggraph.PkgNode)
+		depKey := dep.BuildKey()
 		logger.Log.Tracef("Processing depnode %s", dep.FriendlyName())
 		// All nodes need to be created in BlockedBy
 		// Since a node with no dependency represents a leaf node
 		// But wouldn't be created otherwise
-		if blockedBy[dep.SrpmPath] == nil {
-			blockedBy[dep.SrpmPath] = make(BlockingPkgs)
+		if blockedBy[depKey] == nil {
+			blockedBy[depKey] = make(BlockingPkgs)
 		}
 
 		dependants := g.To(dep.ID())
 
 		for dependants.Next() {
 			dependant := dependants.Node().(*pkggraph.PkgNode)
-			if dependant.SrpmPath == dep.SrpmPath {
+			dependantKey := dependant.BuildKey()
+			if dependantKey == depKey {
 				continue
 			}
-			if blockedBy[dependant.SrpmPath] == nil {
-				blockedBy[dependant.SrpmPath] = make(BlockingPkgs)
+			if blockedBy[dependantKey] == nil {
+				blockedBy[dependantKey] = make(BlockingPkgs)
 			}
-			if blocking[dep.SrpmPath] == nil {
-				blocking[dep.SrpmPath] = make(BlockingPkgs)
+			if blocking[depKey] == nil {
+				blocking[depKey] = make(BlockingPkgs)
 			}
 
-			blockedBy[dependant.SrpmPath][dep.SrpmPath] = true
-			blocking[dep.SrpmPath][dependant.SrpmPath] = true
-			logger.Log.Tracef("Dependency of %s -> %s", dependant.SrpmPath, dep.SrpmPath)
+			blockedBy[dependantKey][depKey] = true
+			blocking[depKey][dependantKey] = true
+			logger.Log.Tracef("Dependency of %s -> %s", dependantKey, depKey)
 		}
 	}
 	return blocking, blockedBy
@@ -0,0 +1,140 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package formats
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+	"microsoft.com/pkggen/internal/pkgjson"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
+// exportTestGraph returns a graph with a default and a variant build of the same package, a remote package,
+// a goal and a weak dependency.
+func exportTestGraph(t *testing.T) *pkggraph.PkgGraph {
+	g := pkggraph.NewPkgGraph()
+	pkgVer := &pkgjson.PackageVer{Name: "a", Version: "1.0", Condition: "="}
+
+	runNode, err := g.AddPkgNode(pkgVer, pkggraph.StateMeta, pkggraph.TypeRun, "a.src.rpm", "a.spec", "SOURCES", "x86_64", "")
+	assert.NoError(t, err)
+	buildNode, err := g.AddPkgNode(pkgVer, pkggraph.StateBuild, pkggraph.TypeBuild, "a.src.rpm", "a.spec", "SOURCES", "x86_64", "")
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(runNode, buildNode))
+
+	variantDefines := map[string]string{"_with_bootstrap": "1", "_without_tests": "1"}
+	variantRun, err := g.AddVariantPkgNode(pkgVer, pkggraph.StateMeta, pkggraph.TypeRun, "a.src.rpm", "a.spec", "SOURCES", "x86_64", "", "bootstrap", variantDefines)
+	assert.NoError(t, err)
+	variantBuild, err := g.AddVariantPkgNode(pkgVer, pkggraph.StateBuild, pkggraph.TypeBuild, "a.src.rpm", "a.spec", "SOURCES", "x86_64", "", "bootstrap", variantDefines)
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(variantRun, variantBuild))
+
+	remoteNode, err := g.AddPkgNode(&pkgjson.PackageVer{Name: "gcc"}, pkggraph.StateUnresolved, pkggraph.TypeRemote, "<NO_SRPM_PATH>", "<NO_SPEC_PATH>", "<NO_SOURCE_PATH>", "<NO_ARCHITECTURE>", "<NO_REPO>")
+	assert.NoError(t, err)
+	g.SetEdge(g.NewEdge(buildNode, remoteNode))
+	g.SetEdge(g.NewEdge(variantBuild, remoteNode))
+	g.AddWeakEdge(runNode, remoteNode, pkggraph.WeakRecommends)
+
+	_, err = g.AddGoalNode("ALL", nil, false)
+	assert.NoError(t, err)
+
+	return g
+}
+
+// findExportedNode returns the exported node with the given type and variant.
+func findExportedNode(t *testing.T, exported exportedGraph, nodeType, variant string) (found exportedNode) {
+	for _, n := range exported.Nodes {
+		if n.Type == nodeType && n.Variant == variant {
+			return n
+		}
+	}
+
+	assert.Failf(t, "missing exported node", "%s node of variant '%s'", nodeType, variant)
+	return
+}
+
+func TestExportGraphShouldIncludeVariants(t *testing.T) {
+	exported, err := exportGraph(exportTestGraph(t))
+	assert.NoError(t, err)
+
+	defaultBuild := findExportedNode(t, exported, "Build", "")
+	assert.Empty(t, defaultBuild.VariantDefines)
+
+	variantBuild := findExportedNode(t, exported, "Build", "bootstrap")
+	assert.Equal(t, map[string]string{"_with_bootstrap": "1", "_without_tests": "1"}, variantBuild.VariantDefines)
+	assert.NotEqual(t, defaultBuild.ID, variantBuild.ID)
+}
+
+func TestJSONShouldRoundTrip(t *testing.T) {
+	g := exportTestGraph(t)
+	expected, err := exportGraph(g)
+	assert.NoError(t, err)
+
+	var output strings.Builder
+	err = NewJSON(g).Save(&output)
+	assert.NoError(t, err)
+
+	var decoded exportedGraph
+	err = json.Unmarshal([]byte(output.String()), &decoded)
+	assert.NoError(t, err)
+
+	// The copy of the graph saved by NewJSON numbers its nodes in the same order, so both representations must be identical.
+	assert.Equal(t, expected, decoded)
+	assert.Contains(t, decoded.Edges, exportedEdge{
+		From: findExportedNode(t, decoded, "Run", "").ID,
+		To:   findExportedNode(t, decoded, "Remote", "").ID,
+		Kind: pkggraph.WeakRecommends.String(),
+	})
+}
+
+func TestGraphMLShouldRoundTrip(t *testing.T) {
+	g := exportTestGraph(t)
+	exported, err := exportGraph(g)
+	assert.NoError(t, err)
+	expected, err := newGraphMLDocument(exported)
+	assert.NoError(t, err)
+
+	var output strings.Builder
+	err = NewGraphML(g).Save(&output)
+	assert.NoError(t, err)
+
+	var decoded graphMLDocument
+	err = xml.Unmarshal([]byte(output.String()), &decoded)
+	assert.NoError(t, err)
+
+	expected.XMLName = decoded.XMLName
+	assert.Equal(t, expected, decoded)
+
+	// The variant and its defines must be readable back from the node's data.
+	variantBuild := findExportedNode(t, exported, "Build", "bootstrap")
+	found := false
+	for _, n := range decoded.Graph.Nodes {
+		if n.ID != graphMLNodeID(variantBuild.ID) {
+			continue
+		}
+		found = true
+
+		data := make(map[string]string)
+		for _, d := range n.Data {
+			data[d.Key] = d.Value
+		}
+		assert.Equal(t, "bootstrap", data["variant"])
+
+		var variantDefines map[string]string
+		err = json.Unmarshal([]byte(data["variantdefines"]), &variantDefines)
+		assert.NoError(t, err)
+		assert.Equal(t, variantBuild.VariantDefines, variantDefines)
+	}
+	assert.True(t, found)
+}
@@ -0,0 +1,149 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package formats
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+)
+
+const graphMLNamespace = "http://graphml.graphdrawing.org/xmlns"
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+// GraphML implements Unravel, producing a GraphML document with all nodes and edges of the graph
+type GraphML struct {
+	graph *pkggraph.PkgGraph
+}
+
+// NewGraphML returns new *GraphML, saving internally the graph representation
+// The original graph representation is not retained nor modified
+func NewGraphML(g *pkggraph.PkgGraph) *GraphML {
+	copyG, err := g.DeepCopy()
+	if err != nil {
+		logger.Log.Panic("Error when copying graph: ", err)
+	}
+
+	return &GraphML{
+		graph: copyG,
+	}
+}
+
+// Save saves the GraphML representation using the provided io.StringWriter
+func (gml *GraphML) Save(w io.StringWriter) (err error) {
+	const (
+		xmlPrefix = ""
+		xmlIndent = "  "
+	)
+
+	exported, err := exportGraph(gml.graph)
+	if err != nil {
+		return
+	}
+
+	document, err := newGraphMLDocument(exported)
+	if err != nil {
+		return
+	}
+
+	bytes, err := xml.MarshalIndent(document, xmlPrefix, xmlIndent)
+	if err != nil {
+		return
+	}
+
+	_, err = w.WriteString(xml.Header + string(bytes))
+	return
+}
+
+// newGraphMLDocument converts an exported graph into a GraphML document, storing each node attribute and the kind of
+// each edge as a data element. Variant defines are stored as a JSON object.
+func newGraphMLDocument(exported exportedGraph) (document graphMLDocument, err error) {
+	const edgeKindKey = "kind"
+
+	keys := []string{"name", "version", "condition", "sversion", "scondition", "state", "type", "srpm", "spec", "sourcedir", "arch", "sourcerepo", "goal", "variant", "variantdefines"}
+
+	document.XMLNS = graphMLNamespace
+	for _, key := range keys {
+		document.Keys = append(document.Keys, graphMLKey{ID: key, For: "node", AttrName: key, AttrType: "string"})
+	}
+	document.Keys = append(document.Keys, graphMLKey{ID: edgeKindKey, For: "edge", AttrName: edgeKindKey, AttrType: "string"})
+
+	document.Graph.ID = "G"
+	document.Graph.EdgeDefault = "directed"
+
+	for _, n := range exported.Nodes {
+		var variantDefines []byte
+
+		if len(n.VariantDefines) != 0 {
+			variantDefines, err = json.Marshal(n.VariantDefines)
+			if err != nil {
+				return
+			}
+		}
+
+		values := []string{n.Name, n.Version, n.Condition, n.SVersion, n.SCondition, n.State, n.Type, n.SrpmPath, n.SpecPath, n.SourceDir, n.Architecture, n.SourceRepo, n.GoalName, n.Variant, string(variantDefines)}
+
+		node := graphMLNode{ID: graphMLNodeID(n.ID)}
+		for i, value := range values {
+			if value != "" {
+				node.Data = append(node.Data, graphMLData{Key: keys[i], Value: value})
+			}
+		}
+		document.Graph.Nodes = append(document.Graph.Nodes, node)
+	}
+
+	for _, edge := range exported.Edges {
+		document.Graph.Edges = append(document.Graph.Edges, graphMLEdge{
+			Source: graphMLNodeID(edge.From),
+			Target: graphMLNodeID(edge.To),
+			Data:   []graphMLData{{Key: edgeKindKey, Value: edge.Kind}},
+		})
+	}
+
+	return
+}
+
+func graphMLNodeID(id int64) string {
+	return fmt.Sprintf("n%d", id)
+}
@@ -0,0 +1,156 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package formats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"gonum.org/v1/gonum/graph"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkggraph"
+)
+
+// exportedNode holds the attributes of a graph node in a format independent form
+type exportedNode struct {
+	ID           int64  `json:"ID"`
+	Name         string `json:"Name"`
+	Version      string `json:"Version"`
+	Condition    string `json:"Condition"`
+	SVersion     string `json:"SVersion"`
+	SCondition   string `json:"SCondition"`
+	State        string `json:"State"`
+	Type         string `json:"Type"`
+	SrpmPath     string `json:"SrpmPath"`
+	SpecPath     string `json:"SpecPath"`
+	SourceDir    string `json:"SourceDir"`
+	Architecture string `json:"Architecture"`
+	SourceRepo   string `json:"SourceRepo"`
+	GoalName     string `json:"GoalName"`
+
+	Variant        string            `json:"Variant"`
+	VariantDefines map[string]string `json:"VariantDefines"`
+}
+
+// Kind of dependency edges, weak edges use the name of their WeakDependencyKind
+const dependencyEdgeKind = "Dependency"
+
+// exportedEdge represents a dependency of the node From on the node To
+type exportedEdge struct {
+	From int64  `json:"From"`
+	To   int64  `json:"To"`
+	Kind string `json:"Kind"`
+}
+
+// exportedGraph holds all nodes and edges of a graph, sorted by ID
+type exportedGraph struct {
+	Nodes []exportedNode `json:"Nodes"`
+	Edges []exportedEdge `json:"Edges"`
+}
+
+// JSON implements Unravel, producing a JSON document with all nodes and edges of the graph
+type JSON struct {
+	graph *pkggraph.PkgGraph
+}
+
+// NewJSON returns new *JSON, saving internally the graph representation
+// The original graph representation is not retained nor modified
+func NewJSON(g *pkggraph.PkgGraph) *JSON {
+	copyG, err := g.DeepCopy()
+	if err != nil {
+		logger.Log.Panic("Error when copying graph: ", err)
+	}
+
+	return &JSON{
+		graph: copyG,
+	}
+}
+
+// Save saves the JSON representation using the provided io.StringWriter
+func (j *JSON) Save(w io.StringWriter) (err error) {
+	const (
+		jsonPrefix = ""
+		jsonIndent = "  "
+	)
+
+	exported, err := exportGraph(j.graph)
+	if err != nil {
+		return
+	}
+
+	bytes, err := json.MarshalIndent(exported, jsonPrefix, jsonIndent)
+	if err != nil {
+		return
+	}
+
+	_, err = w.WriteString(string(bytes))
+	return
+}
+
+// exportGraph converts a graph into its format independent representation.
+// Returns an error if an edge points from or to a node which is not part of the graph.
+func exportGraph(g *pkggraph.PkgGraph) (exported exportedGraph, err error) {
+	exported.Nodes = []exportedNode{}
+	exported.Edges = []exportedEdge{}
+
+	for _, n := range g.AllNodes() {
+		node := exportedNode{
+			ID:           n.ID(),
+			State:        n.State.String(),
+			Type:         n.Type.String(),
+			SrpmPath:     n.SrpmPath,
+			SpecPath:     n.SpecPath,
+			SourceDir:    n.SourceDir,
+			Architecture: n.Architecture,
+			SourceRepo:   n.SourceRepo,
+			GoalName:     n.GoalName,
+
+			Variant:        n.Variant,
+			VariantDefines: n.VariantDefines,
+		}
+
+		if n.VersionedPkg != nil {
+			node.Name = n.VersionedPkg.Name
+			node.Version = n.VersionedPkg.Version
+			node.Condition = n.VersionedPkg.Condition
+			node.SVersion = n.VersionedPkg.SVersion
+			node.SCondition = n.VersionedPkg.SCondition
+		}
+
+		exported.Nodes = append(exported.Nodes, node)
+	}
+
+	for _, edge := range graph.EdgesOf(g.Edges()) {
+		exported.Edges = append(exported.Edges, exportedEdge{From: edge.From().ID(), To: edge.To().ID(), Kind: dependencyEdgeKind})
+	}
+
+	for _, edge := range g.AllWeakEdges() {
+		exported.Edges = append(exported.Edges, exportedEdge{From: edge.From.ID(), To: edge.To.ID(), Kind: edge.Kind.String()})
+	}
+
+	// Readers of both formats reject edges to undeclared nodes, fail instead of writing an unreadable document.
+	for _, edge := range exported.Edges {
+		if g.Node(edge.From) == nil || g.Node(edge.To) == nil {
+			err = fmt.Errorf("%s edge from node %d to node %d references a node missing from the graph", edge.Kind, edge.From, edge.To)
+			return
+		}
+	}
+
+	sort.Slice(exported.Nodes, func(i, j int) bool {
+		return exported.Nodes[i].ID < exported.Nodes[j].ID
+	})
+	sort.Slice(exported.Edges, func(i, j int) bool {
+		if exported.Edges[i].From != exported.Edges[j].From {
+			return exported.Edges[i].From < exported.Edges[j].From
+		}
+		if exported.Edges[i].To != exported.Edges[j].To {
+			return exported.Edges[i].To < exported.Edges[j].To
+		}
+		return exported.Edges[i].Kind < exported.Edges[j].Kind
+	})
+
+	return
+}
@@ -12,7 +12,7 @@ import (
 	"microsoft.com/pkggen/internal/pkggraph"
 )
 
-type commandFormatter = func(string) string
+type commandFormatter = func(buildNode *pkggraph.PkgNode, requiredVariants []*pkggraph.PkgNode) string
 
 // Makefile implements Unravel, producing a Makefile which expresses the build order
 type Makefile struct {
@@ -22,9 +22,10 @@ type Makefile struct {
 
 // NewMakefile returns new *Makefile, saving internally the graph representation
 // The original graph representation is not retained nor modified
-// command is used to format SRPM into a worker invocation
-// Input to the command is going to be the package's SRPM name (with .src.rpm)
-func NewMakefile(g *pkggraph.PkgGraph, command func(string) string) *Makefile {
+// command is used to format a build into a worker invocation
+// Input to the command is going to be one of the build nodes of the build (which may be a conditional build variant),
+// along with the variants whose RPMs the build depends on (see PkgGraph.VariantsRequiredBy)
+func NewMakefile(g *pkggraph.PkgGraph, command commandFormatter) *Makefile {
 	copyG, err := g.DeepCopy()
 	if err != nil {
 		logger.Log.Panic("Error when copying graph: ", err)
@@ -45,6 +46,22 @@ func (m *Makefile) Save(w io.StringWriter) (err error) {
 
 	targetRecipeCreated := make(map[string]bool)
 
+	// A build may depend on a variant through any of its build nodes
+	buildNodesByTarget := make(map[string][]*pkggraph.PkgNode)
+	for _, buildNode := range m.graph.AllBuildNodes() {
+		var buildNodeAsTarget string
+
+		if buildNode.State != pkggraph.StateBuild {
+			continue
+		}
+
+		buildNodeAsTarget, err = formatNodeAsTarget(buildNode)
+		if err != nil {
+			return
+		}
+		buildNodesByTarget[buildNodeAsTarget] = append(buildNodesByTarget[buildNodeAsTarget], buildNode)
+	}
+
 	for _, currentNode := range m.graph.AllNodes() {
 		var currentNodeAsTarget string
 		logger.Log.Tracef("Processing depnode %s", currentNode)
@@ -69,7 +86,8 @@ func (m *Makefile) Save(w io.StringWriter) (err error) {
 				w.WriteString(fmt.Sprintf("%s: ;\n", currentNodeAsTarget))
 			case currentNode.State == pkggraph.StateBuild:
 				// Build node target should call the command according to the passed cmdFormat
-				w.WriteString(fmt.Sprintf("%s:\n\t%s\n", currentNodeAsTarget, m.cmdFormat(currentNode.SrpmPath)))
+				requiredVariants := m.graph.VariantsRequiredBy(buildNodesByTarget[currentNodeAsTarget])
+				w.WriteString(fmt.Sprintf("%s:\n\t%s\n", currentNodeAsTarget, m.cmdFormat(currentNode, requiredVariants)))
 			}
 
 			// Mark the target as created to avoid redefining targets
@@ -123,7 +141,11 @@ func formatNodeAsTarget(n *pkggraph.PkgNode) (target string, err error) {
 	case pkggraph.TypePureMeta:
 		target = fmt.Sprintf("PUREMETA_%d", n.ID())
 	case pkggraph.TypeBuild:
+		// Conditional build variants are built separately from the default build of the SRPM
 		target = fmt.Sprintf("BUILD_%s", n.SrpmPath)
+		if n.Variant != "" {
+			target = fmt.Sprintf("%s_%s", target, n.Variant)
+		}
 	case pkggraph.TypeRun:
 		target = fmt.Sprintf("RUN_%d_%s_%s", n.ID(), n.SrpmPath, n.VersionedPkg.Name)
 
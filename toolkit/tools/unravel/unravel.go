@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 	"microsoft.com/pkggen/internal/exe"
@@ -18,6 +20,8 @@ import (
 const (
 	formatLinear         = "linear"
 	formatMakefile       = "makefile"
+	formatJSON           = "json"
+	formatGraphML        = "graphml"
 	defaultRetryAttempts = "1"
 )
 
@@ -35,7 +39,7 @@ var (
 	distroBuildNumber    = app.Flag("distro-build-number", "The distro build number that the SRPM will be built with").Required().String()
 	retryAttempts        = app.Flag("retry-attempts", "Sets the number of times pkgworker will retry building the package").Default(defaultRetryAttempts).Int()
 
-	legalFormats = []string{formatLinear, formatMakefile}
+	legalFormats = []string{formatLinear, formatMakefile, formatJSON, formatGraphML}
 	format       = app.Flag("format", "Output format").PlaceHolder(exe.PlaceHolderize(legalFormats)).Required().Enum(legalFormats...)
 )
 
@@ -52,9 +56,13 @@ func main() {
 	switch *format {
 	case formatLinear:
 		u = formats.NewLinear(g)
+	case formatJSON:
+		u = formats.NewJSON(g)
+	case formatGraphML:
+		u = formats.NewGraphML(g)
 	case formatMakefile:
 		const (
-			pkgWorkerCommandFmt      = `MAKEFLAGS= $(go-pkgworker) --input=%s --retry-attempts=%d --cache-dir=%s %s --work-dir=$(CHROOT_DIR) --worker-tar=$(chroot_worker) --repo-file=$(pkggen_local_repo) --rpms-dir=$(RPMS_DIR) --srpms-dir=$(SRPMS_DIR) --rpmmacros-file=$(TOOLCHAIN_MANIFESTS_DIR)/macros.override --dist-tag=%s --distro-release-version=%s --distro-build-number=%s --log-file=$(LOGS_DIR)/pkggen/rpmbuilding/%s.log`
+			pkgWorkerCommandFmt      = `MAKEFLAGS= $(go-pkgworker) --input=%s --retry-attempts=%d --cache-dir=%s %s --work-dir=%s%s --worker-tar=$(chroot_worker) $(if $(CHROOT_POOL_DIR),--chroot-pool-dir=$(CHROOT_POOL_DIR)) $(if $(filter y,$(PACKAGE_BUILD_ISOLATE_NETWORK)),--isolate-network) $(if $(PACKAGE_BUILD_TIMEOUT),--timeout=$(PACKAGE_BUILD_TIMEOUT)) $(if $(PACKAGE_BUILD_MEMORY_LIMIT),--memory-limit=$(PACKAGE_BUILD_MEMORY_LIMIT)) $(if $(PACKAGE_BUILD_CPU_QUOTA),--cpu-quota=$(PACKAGE_BUILD_CPU_QUOTA)) $(if $(PACKAGE_BUILD_CGROUP_PARENT),--cgroup-parent=$(PACKAGE_BUILD_CGROUP_PARENT)) $(if $(PACKAGE_BUILD_LIMITS_FILE),--limits-file=$(PACKAGE_BUILD_LIMITS_FILE)) --repo-file=$(pkggen_local_repo) --rpms-dir=$(RPMS_DIR) --srpms-dir=$(SRPMS_DIR) --rpmmacros-file=$(TOOLCHAIN_MANIFESTS_DIR)/macros.override --dist-tag=%s --distro-release-version=%s --distro-build-number=%s --log-file=$(LOGS_DIR)/pkggen/rpmbuilding/%s.log --result-file=$(LOGS_DIR)/pkggen/results/%s.json`
 			continueOnFailurePostfix = ` || echo "%s" >> $(LOGS_DIR)/pkggen/failures.txt`
 			stopOnFailurePostfix     = ` || { echo "%s" >> $(LOGS_DIR)/pkggen/failures.txt ; echo "--stop-on-failure set, halting on package build failure" ; exit 1 ; }`
 		)
@@ -74,9 +82,17 @@ func main() {
 			checkSetting = " "
 		}
 
-		u = formats.NewMakefile(g, func(srpmPath string) string {
-			srpmName := filepath.Base(srpmPath)
-			return fmt.Sprintf(pkgWorkerCommandFmt+postfix, srpmPath, *retryAttempts, *cacheDir, checkSetting, *distTag, *distroReleaseVersion, *distroBuildNumber, srpmName, srpmName)
+		u = formats.NewMakefile(g, func(buildNode *pkggraph.PkgNode, requiredVariants []*pkggraph.PkgNode) string {
+			buildName := filepath.Base(buildNode.SrpmPath)
+			workDir := "$(CHROOT_DIR)"
+			if buildNode.Variant != "" {
+				// Keep the chroot and log of a variant separate from the default build of the SRPM
+				buildName = fmt.Sprintf("%s-%s", buildName, buildNode.Variant)
+				workDir = fmt.Sprintf("$(CHROOT_DIR)/%s", buildNode.Variant)
+			}
+
+			variantArgs := variantArguments(buildNode, requiredVariants)
+			return fmt.Sprintf(pkgWorkerCommandFmt+postfix, buildNode.SrpmPath, *retryAttempts, *cacheDir, checkSetting, workDir, variantArgs, *distTag, *distroReleaseVersion, *distroBuildNumber, buildName, buildName, buildName)
 		})
 	default:
 		logger.Log.Panicf("Wrong output format encountered: %s. Allowed: %s", *format, legalFormats)
@@ -91,3 +107,31 @@ func main() {
 
 	logger.Log.Infof(`Successfully finished converting to format "%s" - output file "%s".`, *format, *output)
 }
+
+// variantArguments returns the pkgworker arguments to build a conditional build variant with its defines, into its
+// own directory under $(VARIANT_RPMS_DIR) since its RPMs have the same names as those of the default build.
+// The RPMs of the variants the build depends on are made available to it from the same directories.
+func variantArguments(buildNode *pkggraph.PkgNode, requiredVariants []*pkggraph.PkgNode) string {
+	var builder strings.Builder
+
+	if buildNode.Variant != "" {
+		fmt.Fprintf(&builder, " --output-rpms-dir=$(VARIANT_RPMS_DIR)/%s", buildNode.VariantBuildName())
+
+		defineNames := make([]string, 0, len(buildNode.VariantDefines))
+		for name := range buildNode.VariantDefines {
+			defineNames = append(defineNames, name)
+		}
+		sort.Strings(defineNames)
+		for _, name := range defineNames {
+			// Quote the define for the shell and escape it for make
+			define := fmt.Sprintf("%s=%s", name, buildNode.VariantDefines[name])
+			fmt.Fprintf(&builder, " '--define=%s'", strings.ReplaceAll(define, "$", "$$"))
+		}
+	}
+
+	for _, variant := range requiredVariants {
+		fmt.Fprintf(&builder, " --variant-rpms-dir=$(VARIANT_RPMS_DIR)/%s", variant.VariantBuildName())
+	}
+
+	return builder.String()
+}